@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Rating updates are counted by where they came from, as attempted (every
+// update asked for, including ones that drew the same rating) and changed
+// (ratings that actually moved). In raft mode the origin only records its
+// attempts; ratings change when committed entries are applied, which every
+// node, the leader included, counts as changed under "replication", as do
+// read replicas applying the primary's stream. unchanged is the attempts
+// made here that changed nothing, so it reads zero on nodes that only
+// apply.
+//
+// /stats reports these under "updates", alongside what is waiting for the
+// next sort: pendingChanges (rating changes since the last sort, the
+// number the sort threshold is compared against) and pendingUsers (distinct
+// users among them).
+
+// updateSource says where a batch of rating updates came from.
+type updateSource string
+
+const (
+	sourceSimulator   updateSource = "simulator"   // Background ticker
+	sourceAPI         updateSource = "api"         // POST /update
+	sourceSchedule    updateSource = "schedule"    // Maintenance jobs
+	sourceMerge       updateSource = "merge"       // Merged users adopting a better rating
+	sourceReplication updateSource = "replication" // Raft log or primary stream
+)
+
+// updateCounts are one source's totals.
+type updateCounts struct {
+	Attempted int64 `json:"attempted"`
+	Changed   int64 `json:"changed"`
+}
+
+// updateAccounting tracks update totals per source, the recent change rate
+// and the last sort.
+type updateAccounting struct {
+	mu       sync.Mutex
+	bySource map[updateSource]*updateCounts
+	changes  rateWindow
+	sorts    int64
+	lastSort time.Time
+}
+
+func newUpdateAccounting() *updateAccounting {
+	return &updateAccounting{bySource: make(map[updateSource]*updateCounts)}
+}
+
+// record adds attempted and changed updates from source.
+func (a *updateAccounting) record(source updateSource, attempted, changed int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.bySource[source]
+	if c == nil {
+		c = &updateCounts{}
+		a.bySource[source] = c
+	}
+	c.Attempted += int64(attempted)
+	c.Changed += int64(changed)
+	if changed > 0 {
+		a.changes.add(time.Now(), int64(changed))
+	}
+}
+
+func (a *updateAccounting) sorted(at time.Time) {
+	a.mu.Lock()
+	a.sorts++
+	a.lastSort = at
+	a.mu.Unlock()
+}
+
+// report is the /stats "updates" object; pendingChanges and pendingUsers
+// come from the store.
+func (a *updateAccounting) report(pendingChanges int64, pendingUsers, threshold int) map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+
+	var total updateCounts
+	bySource := make(map[updateSource]updateCounts, len(a.bySource))
+	for source, c := range a.bySource {
+		bySource[source] = *c
+		total.Attempted += c.Attempted
+		total.Changed += c.Changed
+	}
+	unchanged := total.Attempted - total.Changed
+	if unchanged < 0 {
+		unchanged = 0 // Changes applied here were attempted elsewhere
+	}
+	out := map[string]interface{}{
+		"attempted":        total.Attempted,
+		"changed":          total.Changed,
+		"unchanged":        unchanged,
+		"bySource":         bySource,
+		"changedPerMinute": a.changes.perSecond(now) * 60,
+		"pendingChanges":   pendingChanges,
+		"pendingUsers":     pendingUsers,
+		"sortThreshold":    threshold,
+		"sorts":            a.sorts,
+	}
+	if !a.lastSort.IsZero() {
+		out["lastSort"] = a.lastSort.Unix()
+		out["sinceLastSortMs"] = now.Sub(a.lastSort).Milliseconds()
+	}
+	return out
+}
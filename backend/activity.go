@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each user's last activity is the latest of: a rating change from play
+// (the simulator, POST /update, merges and replicated updates; scheduled
+// soft resets are not the player's doing), an edit to their profile, and
+// any request made on their behalf. The API has no authentication of its
+// own, so requests count only when an authenticating gateway forwards the
+// caller's user ID in the header named by MATIKS_ACTIVITY_HEADER (off by
+// default). Profiles report it as lastActive, and inactivity expiry and
+// bulk delete's inactiveDays go by it.
+//
+// Like profiles, activity lives beside the store rather than on User and
+// is not replicated.
+
+// userTimes maps user IDs to one timestamp each under its own lock.
+type userTimes struct {
+	mu      sync.Mutex
+	at      map[string]int64 // UnixNano
+	version uint64           // Bumped on every change
+}
+
+func newUserTimes() *userTimes {
+	return &userTimes{at: make(map[string]int64)}
+}
+
+func (t *userTimes) set(userID string, now time.Time) {
+	t.mu.Lock()
+	t.at[userID] = now.UnixNano()
+	t.version++
+	t.mu.Unlock()
+}
+
+// setUpdates stamps the users a batch of rating updates changed.
+func (t *userTimes) setUpdates(updates []ratingUpdate, now time.Time) {
+	if len(updates) == 0 {
+		return
+	}
+	t.mu.Lock()
+	for _, u := range updates {
+		t.at[u.UserID] = now.UnixNano()
+	}
+	t.version++
+	t.mu.Unlock()
+}
+
+// Get is the user's time; zero if none was recorded since startup.
+func (t *userTimes) Get(userID string) time.Time {
+	t.mu.Lock()
+	at, ok := t.at[userID]
+	t.mu.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, at)
+}
+
+func (t *userTimes) Version() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.version
+}
+
+// withAll calls fn with every recorded time (UnixNano), which it must not
+// keep or modify.
+func (t *userTimes) withAll(fn func(at map[string]int64)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(t.at)
+}
+
+// keepLater gives keepID the later of both users' times.
+func (t *userTimes) keepLater(keepID, removeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.at[removeID] > t.at[keepID] {
+		t.at[keepID] = t.at[removeID]
+		t.version++
+	}
+}
+
+func (t *userTimes) Delete(userID string) {
+	t.mu.Lock()
+	delete(t.at, userID)
+	t.version++
+	t.mu.Unlock()
+}
+
+type activityTracker struct {
+	*userTimes
+	header string
+}
+
+var activity = &activityTracker{
+	userTimes: newUserTimes(),
+	header:    envString("MATIKS_ACTIVITY_HEADER", ""),
+}
+
+// record counts r as activity for the user named in the activity header,
+// if there is one and they exist.
+func (a *activityTracker) record(r *http.Request) {
+	if a.header == "" {
+		return
+	}
+	id := strings.TrimSpace(r.Header.Get(a.header))
+	if id == "" {
+		return
+	}
+	if _, err := userStore.userByID(id); err == nil {
+		a.set(id, time.Now())
+	}
+}
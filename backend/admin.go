@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// IndexSizes reports entry counts for every in-memory index.
+func (s *UserStore) IndexSizes() map[string]interface{} {
+	s.RLock()
+	defer s.RUnlock()
+
+	sizes := make(map[string]interface{})
+	for name, n := range s.IndexSizesLocked() {
+		sizes[name] = n
+	}
+	sizes["pendingUpdates"] = len(s.updatedUsers)
+	sizes["changeLogHead"] = s.changes.head()
+	return sizes
+}
+
+// CacheFootprint estimates the bytes held by cached leaderboard pages:
+// the User structs plus their string data. Pages kept in Redis are not
+// counted.
+func (s *UserStore) CacheFootprint() (entries, users int, bytes int64) {
+	if m, ok := s.pages.(*memoryPageCache); ok {
+		return m.footprint()
+	}
+	return 0, 0, 0
+}
+
+func adminRuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	response := runtimeReport()
+	response["success"] = true
+	response["timestamp"] = time.Now().Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runtimeReport gathers Go runtime, index and cache figures.
+func runtimeReport() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	// PauseNs is a circular buffer; walk back from the latest GC
+	var recentPauses []float64
+	for i := uint32(0); i < 10 && i < mem.NumGC; i++ {
+		pause := mem.PauseNs[(mem.NumGC-1-i)%uint32(len(mem.PauseNs))]
+		recentPauses = append(recentPauses, float64(pause)/1e6)
+	}
+
+	var lastGC interface{}
+	if mem.LastGC > 0 {
+		lastGC = time.Unix(0, int64(mem.LastGC)).Unix()
+	}
+
+	entries, cachedUsers, cacheBytes := userStore.CacheFootprint()
+
+	return map[string]interface{}{
+		"runtime": map[string]interface{}{
+			"goVersion":  runtime.Version(),
+			"goroutines": runtime.NumGoroutine(),
+			"gomaxprocs": runtime.GOMAXPROCS(0),
+			"numCPU":     runtime.NumCPU(),
+		},
+		"memory": map[string]interface{}{
+			"heapAllocBytes":  mem.HeapAlloc,
+			"heapInuseBytes":  mem.HeapInuse,
+			"heapIdleBytes":   mem.HeapIdle,
+			"heapObjects":     mem.HeapObjects,
+			"sysBytes":        mem.Sys,
+			"totalAllocBytes": mem.TotalAlloc,
+			"mallocs":         mem.Mallocs,
+			"frees":           mem.Frees,
+		},
+		"gc": map[string]interface{}{
+			"numGC":          mem.NumGC,
+			"lastGC":         lastGC,
+			"pauseTotalMs":   float64(mem.PauseTotalNs) / 1e6,
+			"recentPausesMs": recentPauses,
+			"cpuFraction":    mem.GCCPUFraction,
+			"nextGCBytes":    mem.NextGC,
+		},
+		"indexes": userStore.IndexSizes(),
+		"cache": map[string]interface{}{
+			"entries":        entries,
+			"cachedUsers":    cachedUsers,
+			"estimatedBytes": cacheBytes,
+		},
+		"websocketClients": wsHub.ClientCount(),
+		"eventsDropped":    userStore.events.Dropped(),
+	}
+}
+
+// adminReseedHandler serves POST /admin/seed and its older name
+// /admin/reseed. It starts the "reseed" job and returns 202 with the run's
+// status and its /admin/jobs/{id} link. The job generates ?count= users
+// in batches, reporting progress, then indexes them; reads keep being
+// served from the old indexes until the swap.
+func adminReseedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeLegacyError(w, r, fmt.Errorf("%w: use POST", errMethodNotAllowed))
+		return
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	if dryRun {
+		plan, err := planReseed(r)
+		if err != nil {
+			writeLegacyError(w, r, err)
+			return
+		}
+		plan["success"] = true
+		plan["timestamp"] = time.Now().Unix()
+		json.NewEncoder(w).Encode(plan)
+		return
+	}
+
+	started, err := startReseed(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+
+	started["success"] = true
+	started["timestamp"] = time.Now().Unix()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(started)
+}
+
+// reseedParams validates count and seed.
+func reseedParams(r *http.Request) (int, int64, error) {
+	count, err := intParam(r, "count", int(atomic.LoadInt64(&userStore.totalUsers)), 1, limits.MaxSeedCount)
+	if err != nil {
+		return 0, 0, err
+	}
+	seed := time.Now().UnixNano()
+	if raw := r.URL.Query().Get("seed"); raw != "" {
+		if seed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return 0, 0, &paramError{Param: "seed", Message: fmt.Sprintf("must be an integer, got %q", raw)}
+		}
+	}
+	return count, seed, nil
+}
+
+// planReseed answers ?dryRun=1: what the reseed would swap in.
+func planReseed(r *http.Request) (map[string]interface{}, error) {
+	count, seed, err := reseedParams(r)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := userStore.PlanReseed(r.Context(), newSeeder(seed), count)
+	if err != nil {
+		return nil, err
+	}
+	plan["count"], plan["seed"] = count, seed
+	return plan, nil
+}
+
+// startReseed validates count/seed and launches the rebuild goroutine.
+func startReseed(r *http.Request) (map[string]interface{}, error) {
+	count, seed, err := reseedParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// One reseed at a time; the job manager rejects a second start
+	id, err := jobs.RunFunc("reseed", func(ctx context.Context) error {
+		if err := userStore.Reseed(ctx, newSeeder(seed), count); err != nil {
+			return err
+		}
+		log.Printf("Reseed: swapped in %d users (seed %d)", count, seed)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	job, err := jobs.RunStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"message": fmt.Sprintf("Reseeding %d users in the background", count),
+		"count":   count,
+		"seed":    seed,
+		"job":     job,
+		"status":  jobStatusPath(r, id),
+	}, nil
+}
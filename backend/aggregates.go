@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// GET /stats/aggregates reports the mean, median, mode and standard
+// deviation of every rating on the leaderboard. None take a pass over the
+// users per request: the store keeps running sums and per-rating counts
+// up to date as ratings change and users come and go, and the median is
+// read from the middle of the sorted list.
+//
+// Sums are floating point, taken relative to the mean at the last full
+// load so that large int64 scores keep their precision. The mode is the
+// most common rating, the lowest one on ties, and is left out while no
+// two users share a rating.
+
+// ratingAggregates is guarded by the store's lock.
+type ratingAggregates struct {
+	count      int
+	shift      float64 // Sums are of rating - shift
+	sum, sumSq float64
+
+	counts   map[int]int          // Rating to users holding it
+	byCount  map[int]map[int]bool // Users holding a rating to those ratings
+	maxCount int
+}
+
+func newRatingAggregates(users []*User) *ratingAggregates {
+	a := &ratingAggregates{counts: make(map[int]int), byCount: make(map[int]map[int]bool)}
+	if len(users) > 0 {
+		var total float64
+		for _, u := range users {
+			total += float64(u.Rating)
+		}
+		a.shift = math.Round(total / float64(len(users)))
+	}
+	for _, u := range users {
+		a.add(u.Rating)
+	}
+	return a
+}
+
+func (a *ratingAggregates) add(rating int) {
+	d := float64(rating) - a.shift
+	a.count++
+	a.sum += d
+	a.sumSq += d * d
+
+	n := a.counts[rating]
+	a.move(rating, n, n+1)
+	if n+1 > a.maxCount {
+		a.maxCount = n + 1
+	}
+}
+
+func (a *ratingAggregates) remove(rating int) {
+	n := a.counts[rating]
+	if n == 0 {
+		return
+	}
+	d := float64(rating) - a.shift
+	a.count--
+	a.sum -= d
+	a.sumSq -= d * d
+	if a.count == 0 {
+		a.sum, a.sumSq = 0, 0 // Drop rounding drift
+	}
+
+	a.move(rating, n, n-1)
+	if n == a.maxCount && len(a.byCount[n]) == 0 {
+		a.maxCount-- // Counts move by one, so n-1 is held
+	}
+}
+
+func (a *ratingAggregates) change(oldRating, newRating int) {
+	a.remove(oldRating)
+	a.add(newRating)
+}
+
+// move moves rating from the users-holding-from set to the to set.
+func (a *ratingAggregates) move(rating, from, to int) {
+	if from > 0 {
+		if set := a.byCount[from]; set != nil {
+			delete(set, rating)
+			if len(set) == 0 {
+				delete(a.byCount, from)
+			}
+		}
+	}
+	if to == 0 {
+		delete(a.counts, rating)
+		return
+	}
+	a.counts[rating] = to
+	set := a.byCount[to]
+	if set == nil {
+		set = make(map[int]bool)
+		a.byCount[to] = set
+	}
+	set[rating] = true
+}
+
+// mode is the lowest of the most common ratings; ok is false while every
+// rating is unique.
+func (a *ratingAggregates) mode() (rating int, ok bool) {
+	if a.maxCount < 2 {
+		return 0, false
+	}
+	first := true
+	for r := range a.byCount[a.maxCount] {
+		if first || r < rating {
+			rating, first = r, false
+		}
+	}
+	return rating, true
+}
+
+// Aggregates reports the rating aggregates, in rating units.
+func (s *UserStore) Aggregates() map[string]interface{} {
+	s.RLock()
+	if s.StaleLocked() {
+		s.RUnlock()
+		s.Lock()
+		s.SortLocked()
+		s.Unlock()
+		s.RLock()
+	}
+	defer s.RUnlock()
+	a := s.aggregates
+	out := map[string]interface{}{"count": a.count}
+	if a.count == 0 {
+		return out
+	}
+	unit := float64(scores.unit)
+	n := float64(a.count)
+	mean := a.sum / n
+	variance := a.sumSq/n - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	out["mean"] = roundAggregate((a.shift + mean) / unit)
+	out["stdDev"] = roundAggregate(math.Sqrt(variance) / unit)
+
+	mid := len(s.RankedLocked()) / 2
+	median := float64(s.RankedLocked()[mid].Rating)
+	if len(s.RankedLocked())%2 == 0 {
+		median = (median + float64(s.RankedLocked()[mid-1].Rating)) / 2
+	}
+	out["median"] = roundAggregate(median / unit)
+	if rating, ok := a.mode(); ok {
+		out["mode"] = scores.Number(rating)
+		out["modeCount"] = a.maxCount
+	}
+	return out
+}
+
+// roundAggregate keeps two digits past the score's own.
+func roundAggregate(v float64) float64 {
+	scale := math.Pow10(scores.Decimals + 2)
+	return math.Round(v*scale) / scale
+}
+
+// aggregatesRequest serves GET /stats/aggregates; nil without an error
+// when the client's copy is current.
+func aggregatesRequest(w http.ResponseWriter, r *http.Request) (map[string]interface{}, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	if writeConditional(w, r, userStore.LastModified()) {
+		return nil, nil
+	}
+	return userStore.Aggregates(), nil
+}
+
+func aggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	aggregates, err := aggregatesRequest(w, r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	if aggregates == nil {
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"aggregates": aggregates,
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
+func v1AggregatesHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	aggregates, err := aggregatesRequest(w, r)
+	if err != nil || aggregates == nil {
+		return nil, err
+	}
+	return &v1Result{Data: aggregates}, nil
+}
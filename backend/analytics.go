@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Daily analytics tally each day (midnight to midnight in
+// MATIKS_SCHEDULE_TZ) from the event bus and the store's membership
+// counters, and the "daily-analytics" job (on
+// MATIKS_SCHEDULE_DAILY_ANALYTICS, 00:05 by default) stores the days that
+// have ended in MATIKS_DATA_DIR/analytics, kept for
+// MATIKS_ANALYTICS_RETAIN_DAYS (default 90). GET /analytics/daily?from=&to=
+// (dates, 2006-01-02, both optional and inclusive) returns them, oldest
+// first. Each day has:
+//
+//   - activeUsers: users whose last activity (see activity.go) fell in the
+//     day, counted as it ends.
+//   - ratingChanges, usersChanged: rating changes of any source, including
+//     scheduled soft resets, and how many users they touched.
+//   - biggestGainer, biggestLoser: the largest net change each way, where
+//     gaining follows the leaderboard direction.
+//   - joined, left, usersAtStart, usersAtEnd and churn (left over
+//     usersAtStart): membership changes, from creation, reactivation,
+//     deletion, expiry and merges.
+//
+// The day the server started on is marked partial.
+
+const dailyAnalyticsFile = "daily.json.gz"
+
+type dayMover struct {
+	UserID   string      `json:"userId"`
+	Username string      `json:"username"`
+	Change   scoreNumber `json:"change"`
+}
+
+type dayStats struct {
+	Date          string    `json:"date"`
+	Partial       bool      `json:"partial,omitempty"`
+	ActiveUsers   int       `json:"activeUsers"`
+	RatingChanges int       `json:"ratingChanges"`
+	UsersChanged  int       `json:"usersChanged"`
+	BiggestGainer *dayMover `json:"biggestGainer,omitempty"`
+	BiggestLoser  *dayMover `json:"biggestLoser,omitempty"`
+	Joined        int64     `json:"joined"`
+	Left          int64     `json:"left"`
+	UsersAtStart  int64     `json:"usersAtStart"`
+	UsersAtEnd    int64     `json:"usersAtEnd"`
+	Churn         float64   `json:"churn"`
+}
+
+// dayTally accumulates the current day.
+type dayTally struct {
+	date       string
+	start, end time.Time
+	partial    bool
+
+	changes int
+	gains   map[string]int // Net, in the leaderboard direction
+	names   map[string]string
+
+	usersAtStart, addedAtStart, removedAtStart int64
+}
+
+type AnalyticsTracker struct {
+	store  *UserStore
+	loc    *time.Location
+	dir    string
+	retain int // Days
+
+	mu      sync.Mutex
+	today   *dayTally
+	ended   []dayStats // Ended, not yet stored
+	loaded  bool
+	days    []dayStats // Stored, oldest first
+	writes  int64
+	written time.Time
+}
+
+var analytics *AnalyticsTracker
+
+func NewAnalyticsTracker(store *UserStore, loc *time.Location, dir string, retainDays int) *AnalyticsTracker {
+	return &AnalyticsTracker{store: store, loc: loc, dir: dir, retain: retainDays}
+}
+
+func (t *AnalyticsTracker) run(ctx context.Context) error {
+	events := t.store.events.Subscribe(4096)
+	defer t.store.events.Unsubscribe(events)
+	tick := time.NewTicker(time.Minute) // Ends quiet days on time
+	defer tick.Stop()
+	t.mu.Lock()
+	t.rollLocked(time.Now())
+	t.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-tick.C:
+			t.mu.Lock()
+			t.rollLocked(now)
+			t.mu.Unlock()
+		case ev := <-events:
+			if p, ok := ev.Payload.(RatingChangedPayload); ok {
+				t.record(ev.Timestamp, p)
+			}
+		}
+	}
+}
+
+func (t *AnalyticsTracker) record(at time.Time, p RatingChangedPayload) {
+	gain := p.NewRating - p.OldRating
+	if t.store.Direction() == rankAscending {
+		gain = -gain
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked(at)
+	t.today.changes++
+	t.today.gains[p.UserID] += gain
+	t.today.names[p.UserID] = p.Username
+}
+
+// rollLocked ends the current day if now is past it and starts the next.
+func (t *AnalyticsTracker) rollLocked(now time.Time) {
+	if t.today != nil && now.Before(t.today.end) {
+		return
+	}
+	if t.today != nil {
+		t.ended = append(t.ended, t.endLocked(t.today))
+	}
+	local := now.In(t.loc)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, t.loc)
+	t.today = &dayTally{
+		date:           start.Format("2006-01-02"),
+		start:          start,
+		end:            start.AddDate(0, 0, 1),
+		partial:        t.today == nil,
+		gains:          make(map[string]int),
+		names:          make(map[string]string),
+		usersAtStart:   atomic.LoadInt64(&t.store.totalUsers),
+		addedAtStart:   atomic.LoadInt64(&t.store.usersAdded),
+		removedAtStart: atomic.LoadInt64(&t.store.usersRemoved),
+	}
+}
+
+// endLocked turns a finished tally into its day's stats.
+func (t *AnalyticsTracker) endLocked(d *dayTally) dayStats {
+	day := dayStats{
+		Date:          d.date,
+		Partial:       d.partial,
+		RatingChanges: d.changes,
+		Joined:        atomic.LoadInt64(&t.store.usersAdded) - d.addedAtStart,
+		Left:          atomic.LoadInt64(&t.store.usersRemoved) - d.removedAtStart,
+		UsersAtStart:  d.usersAtStart,
+		UsersAtEnd:    atomic.LoadInt64(&t.store.totalUsers),
+	}
+	if day.UsersAtStart > 0 {
+		day.Churn = math.Round(float64(day.Left)/float64(day.UsersAtStart)*1e4) / 1e4
+	}
+	from, to := d.start.UnixNano(), d.end.UnixNano()
+	activity.withAll(func(all map[string]int64) {
+		for _, at := range all {
+			if at >= from && at < to {
+				day.ActiveUsers++
+			}
+		}
+	})
+	for id, gain := range d.gains {
+		if gain != 0 {
+			day.UsersChanged++
+		}
+		mover := &dayMover{UserID: id, Username: d.names[id], Change: scoreNumber(gain)}
+		if gain > 0 && (day.BiggestGainer == nil || moverBefore(mover, day.BiggestGainer, 1)) {
+			day.BiggestGainer = mover
+		}
+		if gain < 0 && (day.BiggestLoser == nil || moverBefore(mover, day.BiggestLoser, -1)) {
+			day.BiggestLoser = mover
+		}
+	}
+	return day
+}
+
+// moverBefore reports whether a moved further than b in sign's direction,
+// by user ID on ties.
+func moverBefore(a, b *dayMover, sign int) bool {
+	if a.Change != b.Change {
+		return int(a.Change)*sign > int(b.Change)*sign
+	}
+	return a.UserID < b.UserID
+}
+
+func (t *AnalyticsTracker) loadLocked() {
+	if t.loaded {
+		return
+	}
+	t.loaded = true
+	var stored struct {
+		Days []dayStats `json:"days"`
+	}
+	if err := readJSONFile(t.dir, dailyAnalyticsFile, &stored); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Analytics: %v", err)
+		}
+		return
+	}
+	t.days = stored.Days
+}
+
+// CloseDays stores the days that have ended by now.
+func (t *AnalyticsTracker) CloseDays(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked(now)
+	if len(t.ended) == 0 {
+		return errJobSkipped
+	}
+	t.loadLocked()
+	byDate := make(map[string]dayStats, len(t.days)+len(t.ended))
+	for _, day := range t.days {
+		byDate[day.Date] = day
+	}
+	for _, day := range t.ended {
+		byDate[day.Date] = day
+	}
+	cutoff := now.In(t.loc).AddDate(0, 0, -t.retain).Format("2006-01-02")
+	days := make([]dayStats, 0, len(byDate))
+	for date, day := range byDate {
+		if date >= cutoff {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	if err := writeJSONFile(t.dir, dailyAnalyticsFile, map[string]interface{}{"days": days}); err != nil {
+		return err
+	}
+	t.days, t.ended = days, nil
+	t.writes++
+	t.written = now
+	return nil
+}
+
+// Between returns the stored days from from to to, inclusive.
+func (t *AnalyticsTracker) Between(from, to string) []dayStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loadLocked()
+	out := []dayStats{}
+	for _, day := range t.days {
+		if (from == "" || day.Date >= from) && (to == "" || day.Date <= to) {
+			out = append(out, day)
+		}
+	}
+	return out
+}
+
+func (t *AnalyticsTracker) Stats() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loadLocked()
+	stats := map[string]interface{}{
+		"days":   len(t.days),
+		"ended":  len(t.ended),
+		"writes": t.writes,
+	}
+	if t.today != nil {
+		stats["today"] = map[string]interface{}{"date": t.today.date, "ratingChanges": t.today.changes}
+	}
+	if !t.written.IsZero() {
+		stats["lastWrite"] = t.written.Unix()
+	}
+	return stats
+}
+
+// startAnalytics tallies days as the "analytics" job.
+func startAnalytics(store *UserStore) {
+	analytics = NewAnalyticsTracker(store, scheduleLocation,
+		filepath.Join(envString("MATIKS_DATA_DIR", "data"), "analytics"),
+		envInt("MATIKS_ANALYTICS_RETAIN_DAYS", 90))
+	jobs.Register("analytics", analytics.run)
+	jobs.Run("analytics")
+}
+
+func dateParam(r *http.Request, name string) (string, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(name))
+	if raw == "" {
+		return "", nil
+	}
+	if _, err := time.Parse("2006-01-02", raw); err != nil {
+		return "", &paramError{Param: name, Message: fmt.Sprintf("must be a date (2006-01-02), got %q", raw)}
+	}
+	return raw, nil
+}
+
+// dailyAnalyticsRequest serves GET /analytics/daily.
+func dailyAnalyticsRequest(r *http.Request) ([]dayStats, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	from, err := dateParam(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := dateParam(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	if from != "" && to != "" && to < from {
+		return nil, &paramError{Param: "to", Message: "must not be before from"}
+	}
+	return analytics.Between(from, to), nil
+}
+
+func dailyAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	days, err := dailyAnalyticsRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"days":      days,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+func v1DailyAnalyticsHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	days, err := dailyAnalyticsRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: days}, nil
+}
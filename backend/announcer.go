@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The announcer posts to Discord and Slack incoming webhooks when the #1
+// spot changes hands or a user enters the top 10. Messages are rendered
+// from text/template strings whose data is an announcement. Posts are
+// spaced at least MATIKS_ANNOUNCE_INTERVAL apart; announcements arriving
+// faster are coalesced into the next post, and a user is announced at most
+// once per kind per MATIKS_ANNOUNCE_COOLDOWN so someone hovering around
+// #10 does not flood the channel.
+
+// Announcement kinds
+const (
+	announceNewLeader  = "new_leader"
+	announceTop10Entry = "top10_entry"
+)
+
+const (
+	announceMaxPending = 20   // Lines held for the next post; more are dropped
+	announceMaxLength  = 2000 // Discord's content limit
+)
+
+// announceFuncs are available in templates; score formats a rating like
+// the API does.
+var announceFuncs = template.FuncMap{"score": func(v int) string { return string(scores.Number(v)) }}
+
+var defaultAnnounceTemplates = map[string]string{
+	announceNewLeader:  `🏆 {{.User.Username}} is the new #1 with a rating of {{score .User.Rating}}{{with .Previous}}, taking the top spot from {{.Username}}{{end}}`,
+	announceTop10Entry: `📈 {{.User.Username}} entered the top 10 at #{{.Rank}} with a rating of {{score .User.Rating}}`,
+}
+
+// announcement is the data a template renders.
+type announcement struct {
+	Kind     string
+	User     User
+	Rank     int
+	Previous *User // new_leader only: who held #1 before
+}
+
+type announceTarget struct {
+	platform string // "discord" or "slack"
+	url      string
+}
+
+// Announcer watches top10_changed events and posts announcements.
+type Announcer struct {
+	store     *UserStore
+	targets   []announceTarget
+	templates map[string]*template.Template
+	interval  time.Duration
+	cooldown  time.Duration
+	client    *http.Client
+
+	mu       sync.Mutex
+	top      []User // Top 10 as of the last event
+	pending  []string
+	lastPost time.Time
+	lastSent map[string]time.Time // kind + userID -> last announcement
+
+	posted, failed, dropped, suppressed int64 // Guarded by mu
+}
+
+var announcer *Announcer
+
+// startAnnouncer reads webhook URLs and templates from the environment and
+// runs the announcer as the "announcer" job. It is a no-op without URLs.
+func startAnnouncer(store *UserStore) error {
+	var targets []announceTarget
+	for _, url := range envList("MATIKS_DISCORD_WEBHOOK_URLS") {
+		targets = append(targets, announceTarget{platform: "discord", url: url})
+	}
+	for _, url := range envList("MATIKS_SLACK_WEBHOOK_URLS") {
+		targets = append(targets, announceTarget{platform: "slack", url: url})
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	templates := make(map[string]*template.Template, len(defaultAnnounceTemplates))
+	for kind, text := range defaultAnnounceTemplates {
+		key := "MATIKS_ANNOUNCE_" + strings.ToUpper(kind) + "_TEMPLATE"
+		tmpl, err := template.New(kind).Option("missingkey=error").Funcs(announceFuncs).Parse(envString(key, text))
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		templates[kind] = tmpl
+	}
+
+	a := &Announcer{
+		store:     store,
+		targets:   targets,
+		templates: templates,
+		interval:  envDuration("MATIKS_ANNOUNCE_INTERVAL", 30*time.Second),
+		cooldown:  envDuration("MATIKS_ANNOUNCE_COOLDOWN", 10*time.Minute),
+		client:    &http.Client{Timeout: envDuration("MATIKS_ANNOUNCE_TIMEOUT", 5*time.Second)},
+		lastSent:  make(map[string]time.Time),
+	}
+	announcer = a
+
+	jobs.Register("announcer", a.run)
+	if err := jobs.Run("announcer"); err != nil {
+		return err
+	}
+	log.Printf("Announcer: posting to %d webhook(s) at most every %v", len(targets), a.interval)
+	return nil
+}
+
+func (a *Announcer) run(ctx context.Context) error {
+	events := a.store.events.Subscribe(64)
+	defer a.store.events.Unsubscribe(events)
+
+	top, _, _, _, err := a.store.GetLeaderboard(ctx, 1, 10)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.top = top
+	a.mu.Unlock()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if p, ok := ev.Payload.(Top10ChangedPayload); ok {
+				a.observe(p.Users)
+				a.flush(ctx, false)
+			}
+		case <-ticker.C:
+			a.flush(ctx, true)
+		}
+	}
+}
+
+// observe diffs a new top 10 against the previous one and queues the
+// rendered announcements.
+func (a *Announcer) observe(top []User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prev := a.top
+	a.top = top
+	if len(prev) == 0 || len(top) == 0 {
+		return
+	}
+
+	wasTop := make(map[string]bool, len(prev))
+	for _, u := range prev {
+		wasTop[u.ID] = true
+	}
+	stillFirst := false // Tied for #1 still counts as holding it
+	for _, u := range top {
+		if u.ID == prev[0].ID && u.Rank == 1 {
+			stillFirst = true
+		}
+	}
+
+	var found []announcement
+	if top[0].ID != prev[0].ID && !stillFirst {
+		previous := prev[0]
+		found = append(found, announcement{Kind: announceNewLeader, User: top[0], Rank: 1, Previous: &previous})
+	}
+	for i, u := range top {
+		// A straight jump to #1 is already the leader announcement
+		if !wasTop[u.ID] && !(i == 0 && len(found) > 0) {
+			found = append(found, announcement{Kind: announceTop10Entry, User: u, Rank: i + 1})
+		}
+	}
+
+	for _, ann := range found {
+		key := ann.Kind + "\x00" + ann.User.ID
+		if time.Since(a.lastSent[key]) < a.cooldown {
+			a.suppressed++
+			continue
+		}
+		var buf bytes.Buffer
+		if err := a.templates[ann.Kind].Execute(&buf, ann); err != nil {
+			log.Printf("Announcer: rendering %s: %v", ann.Kind, err)
+			continue
+		}
+		if len(a.pending) >= announceMaxPending {
+			a.dropped++
+			continue
+		}
+		a.lastSent[key] = time.Now()
+		a.pending = append(a.pending, buf.String())
+	}
+}
+
+// flush posts pending lines as one message if the rate limit allows. A
+// tick always may; an event only when the last post is old enough.
+func (a *Announcer) flush(ctx context.Context, tick bool) {
+	a.mu.Lock()
+	if len(a.pending) == 0 || (!tick && time.Since(a.lastPost) < a.interval) {
+		a.mu.Unlock()
+		return
+	}
+	text := strings.Join(a.pending, "\n")
+	a.pending = nil
+	a.lastPost = time.Now()
+	a.mu.Unlock()
+
+	// Only one instance of a cluster announces
+	if replica != nil || (simulatorLease != nil && !simulatorLease.Held()) {
+		return
+	}
+	if len(text) > announceMaxLength {
+		text = text[:strings.LastIndex(text[:announceMaxLength], "\n")+1] + "…"
+	}
+	for _, t := range a.targets {
+		err := a.post(ctx, t, text)
+		a.mu.Lock()
+		if err != nil {
+			a.failed++
+		} else {
+			a.posted++
+		}
+		a.mu.Unlock()
+		if err != nil {
+			log.Printf("Announcer: posting to %s failed: %v", t.platform, err)
+		}
+	}
+}
+
+func (a *Announcer) post(ctx context.Context, t announceTarget, text string) error {
+	payload := map[string]string{"text": text} // Slack
+	if t.platform == "discord" {
+		payload = map[string]string{"content": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Stats reports targets and delivery counters.
+func (a *Announcer) Stats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	platforms := make([]string, len(a.targets))
+	for i, t := range a.targets {
+		platforms[i] = t.platform
+	}
+	return map[string]interface{}{
+		"targets":    platforms,
+		"intervalMs": a.interval.Milliseconds(),
+		"pending":    len(a.pending),
+		"posted":     a.posted,
+		"failed":     a.failed,
+		"dropped":    a.dropped,
+		"suppressed": a.suppressed,
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/leaderboard"
+	"matiks-leaderboard/models"
+)
+
+// Finished seasons (the monthly standings the archive job writes) live on
+// disk as gzipped JSON under MATIKS_DATA_DIR/archive, not in memory.
+// GET /archives lists them and GET /archives/{YYYY-MM} pages through one
+// (?userId= looks up a single user's final standing instead). A season is
+// read from disk on first use and kept while it is one of the
+// MATIKS_ARCHIVE_CACHE (default 2) most recently read, so browsing a past
+// season costs one decode and an idle server holds none. Archives written
+// uncompressed by older builds are still read.
+
+var errArchiveNotFound = errors.New("no archived standings for that season")
+
+// archivedSeason is one season's final standings, in rank order.
+type archivedSeason struct {
+	Period     string
+	ArchivedAt int64
+	Users      []User
+	byID       map[string]int
+}
+
+type archiveStore struct {
+	dir string
+	max int
+
+	mu     sync.Mutex
+	loaded map[string]*archivedSeason
+	order  []string // Least recently read first
+	loads  int64
+	hits   int64
+}
+
+var archives = &archiveStore{
+	dir:    filepath.Join(envString("MATIKS_DATA_DIR", "data"), "archive"),
+	max:    envInt("MATIKS_ARCHIVE_CACHE", 2),
+	loaded: make(map[string]*archivedSeason),
+}
+
+func archiveName(period string) string {
+	return "leaderboard-" + period + ".json.gz"
+}
+
+// validPeriod reports whether period is a YYYY-MM season name.
+func validPeriod(period string) bool {
+	_, err := time.Parse("2006-01", period)
+	return err == nil && len(period) == 7
+}
+
+// List reports every archived season on disk, newest first.
+func (a *archiveStore) List() ([]map[string]interface{}, error) {
+	paths, err := filepath.Glob(filepath.Join(a.dir, "leaderboard-*.json*"))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var out []map[string]interface{}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, path := range paths {
+		base := filepath.Base(path)
+		period := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(base, "leaderboard-"), ".gz"), ".json")
+		if !validPeriod(period) || seen[period] {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		seen[period] = true
+		_, resident := a.loaded[period]
+		out = append(out, map[string]interface{}{
+			"period":     period,
+			"bytes":      info.Size(),
+			"compressed": strings.HasSuffix(base, ".gz"),
+			"resident":   resident,
+		})
+	}
+	return out, nil
+}
+
+// Get returns a season's standings, reading them from disk if they are not
+// among the recently read.
+func (a *archiveStore) Get(period string) (*archivedSeason, error) {
+	if !validPeriod(period) {
+		return nil, &paramError{Param: "period", Message: fmt.Sprintf("must be a YYYY-MM season, got %q", period)}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if season := a.loaded[period]; season != nil {
+		a.hits++
+		a.touchLocked(period)
+		return season, nil
+	}
+	season, err := a.read(period)
+	if err != nil {
+		return nil, err
+	}
+	a.loads++
+	for len(a.order) >= a.max && len(a.order) > 0 {
+		delete(a.loaded, a.order[0])
+		a.order = a.order[1:]
+	}
+	if a.max > 0 {
+		a.loaded[period] = season
+		a.order = append(a.order, period)
+	}
+	return season, nil
+}
+
+func (a *archiveStore) touchLocked(period string) {
+	for i, p := range a.order {
+		if p == period {
+			a.order = append(append(a.order[:i:i], a.order[i+1:]...), period)
+			return
+		}
+	}
+}
+
+// forget drops a season from memory after the archive job rewrites it.
+func (a *archiveStore) forget(period string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.loaded[period]; !ok {
+		return
+	}
+	delete(a.loaded, period)
+	for i, p := range a.order {
+		if p == period {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (a *archiveStore) read(period string) (*archivedSeason, error) {
+	var r io.Reader
+	f, err := os.Open(filepath.Join(a.dir, archiveName(period)))
+	if err == nil {
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("archive %s: %w", period, err)
+		}
+		defer gz.Close()
+		r = gz
+	} else if errors.Is(err, os.ErrNotExist) {
+		if f, err = os.Open(filepath.Join(a.dir, "leaderboard-"+period+".json")); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errArchiveNotFound
+			}
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		return nil, err
+	}
+
+	var file struct {
+		Period     string `json:"period"`
+		ArchivedAt int64  `json:"archivedAt"`
+		Users      []struct {
+			ID       string      `json:"id"`
+			Username string      `json:"username"`
+			Rating   scoreNumber `json:"rating"`
+			Rank     int         `json:"rank"`
+		} `json:"users"`
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("archive %s: %w", period, err)
+	}
+	season := &archivedSeason{
+		Period:     period,
+		ArchivedAt: file.ArchivedAt,
+		Users:      make([]User, len(file.Users)),
+		byID:       make(map[string]int, len(file.Users)),
+	}
+	for i, u := range file.Users {
+		season.Users[i] = User{ID: u.ID, Username: u.Username, Rating: int(u.Rating), Member: leaderboard.Member{Rank: u.Rank}}
+		season.byID[u.ID] = i
+	}
+	return season, nil
+}
+
+func (a *archiveStore) Stats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return map[string]interface{}{
+		"resident":    append([]string{}, a.order...),
+		"maxResident": a.max,
+		"loads":       a.loads,
+		"hits":        a.hits,
+	}
+}
+
+// archiveRequest serves GET /archives and GET /archives/{period}.
+func archiveRequest(r *http.Request) (data interface{}, pagination *models.Pagination, err error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	period := strings.Trim(strings.TrimPrefix(r.URL.Path, "/archives"), "/")
+	if period == "" {
+		seasons, err := archives.List()
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{"seasons": seasons}, nil, nil
+	}
+	season, err := archives.Get(period)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id := r.URL.Query().Get("userId"); id != "" {
+		i, ok := season.byID[id]
+		if !ok {
+			return nil, nil, ErrUserNotFound
+		}
+		return map[string]interface{}{"period": period, "archivedAt": season.ArchivedAt, "user": season.Users[i]}, nil, nil
+	}
+
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	total := len(season.Users)
+	start, end := (page-1)*limit, page*limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return season.Users[start:end], models.NewPagination(page, limit, total), nil
+}
+
+func archivesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	data, pagination, err := archiveRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	response := map[string]interface{}{"success": true, "timestamp": time.Now().Unix()}
+	if pagination == nil {
+		response["result"] = data
+	} else {
+		response["period"] = strings.Trim(strings.TrimPrefix(r.URL.Path, "/archives"), "/")
+		response["users"] = data
+		response["page"] = pagination.Page
+		response["limit"] = pagination.Limit
+		response["total"] = pagination.Total
+		response["totalPages"] = pagination.TotalPages
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func v1ArchivesHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+	data, pagination, err := archiveRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: data, Pagination: pagination}, nil
+}
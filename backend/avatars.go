@@ -0,0 +1,325 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Avatar URLs are checked when they are written: the scheme must be https
+// (or http with MATIKS_AVATAR_ALLOW_HTTP), the host must be on
+// MATIKS_AVATAR_HOSTS when that is set, and a HEAD request must find an
+// image no larger than MATIKS_AVATAR_MAX_BYTES. With MATIKS_AVATAR_PROXY,
+// GET /avatars/{id} also serves the image through a small in-memory cache,
+// so the UI never has to load third-party URLs directly.
+//
+// Outgoing requests refuse loopback, private and link-local addresses
+// (unless MATIKS_AVATAR_ALLOW_PRIVATE), so avatars cannot be used to probe
+// the internal network.
+
+var avatarImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/avif": true,
+}
+
+var (
+	errAvatarPrivateAddress = errors.New("address is not publicly routable")
+	errAvatarNotSet         = errors.New("user has no avatar")
+)
+
+// AvatarService validates avatar URLs and proxies their images.
+type AvatarService struct {
+	hosts     []string // Exact hosts, or ".example.com" for any subdomain
+	allowHTTP bool
+	headCheck bool
+	maxBytes  int64
+	proxy     bool
+	cacheTTL  time.Duration
+	cacheSize int
+	client    *http.Client
+
+	mu           sync.Mutex
+	lru          *list.List               // Front is most recently used
+	cache        map[string]*list.Element // URL -> *avatarImage element
+	hits, misses int64                    // Guarded by mu
+}
+
+// avatarImage is one cached image.
+type avatarImage struct {
+	url         string
+	contentType string
+	body        []byte
+	etag        string
+	fetchedAt   time.Time
+}
+
+var avatars = newAvatarService()
+
+func newAvatarService() *AvatarService {
+	a := &AvatarService{
+		hosts:     envList("MATIKS_AVATAR_HOSTS"),
+		allowHTTP: envBool("MATIKS_AVATAR_ALLOW_HTTP", false),
+		headCheck: envBool("MATIKS_AVATAR_HEAD_CHECK", true),
+		maxBytes:  int64(envInt("MATIKS_AVATAR_MAX_BYTES", 1<<20)),
+		proxy:     envBool("MATIKS_AVATAR_PROXY", false),
+		cacheTTL:  envDuration("MATIKS_AVATAR_CACHE_TTL", time.Hour),
+		cacheSize: envInt("MATIKS_AVATAR_CACHE_SIZE", 1000),
+		lru:       list.New(),
+		cache:     make(map[string]*list.Element),
+	}
+	for i, h := range a.hosts {
+		a.hosts[i] = strings.ToLower(h)
+	}
+
+	allowPrivate := envBool("MATIKS_AVATAR_ALLOW_PRIVATE", false)
+	dialer := &net.Dialer{
+		Timeout: 3 * time.Second,
+		// Checked after DNS resolution, on the address actually dialled
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if !allowPrivate && (ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()) {
+				return fmt.Errorf("%s: %w", host, errAvatarPrivateAddress)
+			}
+			return nil
+		},
+	}
+	a.client = &http.Client{
+		Timeout: envDuration("MATIKS_AVATAR_TIMEOUT", 5*time.Second),
+		// No Proxy: the dialer must see the real destination
+		Transport: &http.Transport{DialContext: dialer.DialContext, MaxIdleConns: 16, IdleConnTimeout: time.Minute},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return a.checkURL(req.URL)
+		},
+	}
+	return a
+}
+
+func (a *AvatarService) hostAllowed(host string) bool {
+	if len(a.hosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range a.hosts {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL applies the scheme and host rules.
+func (a *AvatarService) checkURL(u *url.URL) error {
+	if u.Scheme != "https" && !(a.allowHTTP && u.Scheme == "http") {
+		if a.allowHTTP {
+			return errors.New("must be an http or https URL")
+		}
+		return errors.New("must be an https URL")
+	}
+	if u.User != nil {
+		return errors.New("must not contain credentials")
+	}
+	if !a.hostAllowed(u.Hostname()) {
+		return fmt.Errorf("host %q is not an allowed avatar host", u.Hostname())
+	}
+	return nil
+}
+
+// checkImage validates response headers as an acceptable avatar.
+func (a *AvatarService) checkImage(resp *http.Response) (string, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !avatarImageTypes[contentType] {
+		return "", fmt.Errorf("is not a PNG, JPEG, GIF, WebP or AVIF image (got %q)", contentType)
+	}
+	if resp.ContentLength > a.maxBytes {
+		return "", fmt.Errorf("is %d bytes, over the %d byte limit", resp.ContentLength, a.maxBytes)
+	}
+	return contentType, nil
+}
+
+// Validate checks an avatar URL before it is stored.
+func (a *AvatarService) Validate(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return &paramError{Param: "avatarUrl", Message: "must be a valid URL"}
+	}
+	if err := a.checkURL(u); err != nil {
+		return &paramError{Param: "avatarUrl", Message: err.Error()}
+	}
+	if !a.headCheck {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, raw, nil)
+	if err != nil {
+		return &paramError{Param: "avatarUrl", Message: err.Error()}
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return &paramError{Param: "avatarUrl", Message: "could not be checked: " + err.Error()}
+	}
+	resp.Body.Close()
+	if _, err := a.checkImage(resp); err != nil {
+		return &paramError{Param: "avatarUrl", Message: err.Error()}
+	}
+	return nil
+}
+
+// Image returns the image at raw, from the cache when it is fresh.
+func (a *AvatarService) Image(ctx context.Context, raw string) (*avatarImage, error) {
+	a.mu.Lock()
+	if el, ok := a.cache[raw]; ok {
+		img := el.Value.(*avatarImage)
+		if time.Since(img.fetchedAt) < a.cacheTTL {
+			a.lru.MoveToFront(el)
+			a.hits++
+			a.mu.Unlock()
+			return img, nil
+		}
+		a.lru.Remove(el)
+		delete(a.cache, raw)
+	}
+	a.misses++
+	a.mu.Unlock()
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkURL(u); err != nil { // The allowlist may have changed
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contentType, err := a.checkImage(resp)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, a.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > a.maxBytes {
+		return nil, fmt.Errorf("is over the %d byte limit", a.maxBytes)
+	}
+
+	sum := sha256.Sum256(body)
+	img := &avatarImage{
+		url:         raw,
+		contentType: contentType,
+		body:        body,
+		etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		fetchedAt:   time.Now(),
+	}
+	a.mu.Lock()
+	if el, ok := a.cache[raw]; ok { // Fetched concurrently
+		a.lru.Remove(el)
+	}
+	a.cache[raw] = a.lru.PushFront(img)
+	for a.lru.Len() > a.cacheSize {
+		oldest := a.lru.Back()
+		a.lru.Remove(oldest)
+		delete(a.cache, oldest.Value.(*avatarImage).url)
+	}
+	a.mu.Unlock()
+	return img, nil
+}
+
+// Stats reports cache counters.
+func (a *AvatarService) Stats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var bytes int
+	for el := a.lru.Front(); el != nil; el = el.Next() {
+		bytes += len(el.Value.(*avatarImage).body)
+	}
+	return map[string]interface{}{
+		"proxy":   a.proxy,
+		"cached":  a.lru.Len(),
+		"bytes":   bytes,
+		"hits":    a.hits,
+		"misses":  a.misses,
+		"maxSize": a.cacheSize,
+	}
+}
+
+// avatarHandler serves GET /avatars/{userId} when the proxy is enabled.
+func avatarHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/avatars/")
+	if !avatars.proxy || id == "" || strings.Contains(id, "/") {
+		writeLegacyError(w, r, errRouteNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeLegacyError(w, r, fmt.Errorf("%w: use GET", errMethodNotAllowed))
+		return
+	}
+	if _, err := userStore.userByID(id); err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	raw := profiles.Get(id).AvatarURL
+	if raw == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "{\"success\":false,\"error\":%q}\n", errAvatarNotSet.Error())
+		return
+	}
+
+	img, err := avatars.Image(r.Context(), raw)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w, "{\"success\":false,\"error\":%q}\n", "avatar could not be fetched: "+err.Error())
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", img.etag)
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(avatars.cacheTTL.Seconds())))
+	// Only ever an image, never a document
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if etagMatches(r.Header.Get("If-None-Match"), img.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", img.contentType)
+	h.Set("Content-Length", fmt.Sprint(len(img.body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(img.body)
+}
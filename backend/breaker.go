@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Calls to Redis go through a circuit breaker. After
+// MATIKS_BREAKER_FAILURES consecutive failures (default 5) it opens: calls
+// fail at once instead of waiting on a dead server, and the instance drops
+// to read-only degraded mode, serving reads from memory and refusing rating
+// and membership changes with 503, since other instances could not be told
+// to drop their cached pages. The simulator and scheduled jobs pause with
+// it, as the simulator lease can no longer be proven. Every
+// MATIKS_BREAKER_COOLDOWN (default 30s) one probe is let through; the
+// breaker closes, and writes resume, as soon as one succeeds.
+//
+// There is no other external storage: ratings live in memory, so nothing
+// beyond Redis needs a breaker.
+
+var errBackendDegraded = errors.New("storage backend unavailable: serving read-only from memory until it recovers")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open" // One probe in flight
+)
+
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int // Consecutive
+	openedAt  time.Time
+	trips     int64
+	rejected  int64
+	lastError string
+}
+
+// redisBreaker is nil when Redis is not configured.
+var redisBreaker *circuitBreaker
+
+func newCircuitBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{
+		name:      name,
+		threshold: envInt("MATIKS_BREAKER_FAILURES", 5),
+		cooldown:  envDuration("MATIKS_BREAKER_COOLDOWN", 30*time.Second),
+		state:     breakerClosed,
+	}
+}
+
+// Do runs fn unless the breaker is open, returning errBackendDegraded
+// without calling it then.
+func (b *circuitBreaker) Do(fn func() error) error {
+	b.mu.Lock()
+	switch b.state {
+	case breakerHalfOpen:
+		b.rejected++
+		b.mu.Unlock()
+		return errBackendDegraded
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			b.mu.Unlock()
+			return errBackendDegraded
+		}
+		b.state = breakerHalfOpen
+	}
+	b.mu.Unlock()
+
+	err := fn()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen // The caller gave up; try again next time
+		}
+		return err
+	}
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Printf("Breaker %s: closed, backend recovered", b.name)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return nil
+	}
+	b.lastError = err.Error()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state == breakerClosed {
+			b.trips++
+			log.Printf("Breaker %s: open after %d failures: %v", b.name, b.failures, err)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+	return err
+}
+
+// Open reports whether calls are being refused; a nil breaker never is.
+func (b *circuitBreaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+// watch probes the backend every cooldown while the breaker is open, so it
+// recovers even when nothing else is calling through it.
+func (b *circuitBreaker) watch(ctx context.Context, probe func(context.Context) error) {
+	ticker := time.NewTicker(b.cooldown)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if b.Open() {
+			b.Do(func() error { return probe(ctx) })
+		}
+	}
+}
+
+func (b *circuitBreaker) Status() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := map[string]interface{}{
+		"state":               b.state,
+		"consecutiveFailures": b.failures,
+		"threshold":           b.threshold,
+		"cooldownSeconds":     int(b.cooldown.Seconds()),
+		"trips":               b.trips,
+		"rejected":            b.rejected,
+	}
+	if b.lastError != "" {
+		status["lastError"] = b.lastError
+	}
+	if b.state != breakerClosed {
+		status["openSince"] = b.openedAt.Unix()
+	}
+	return status
+}
+
+// checkWritable refuses writes while a backend's breaker is open.
+func checkWritable() error {
+	if redisBreaker.Open() {
+		return errBackendDegraded
+	}
+	return nil
+}
+
+func healthStatus() string {
+	if redisBreaker.Open() {
+		return "degraded"
+	}
+	return "healthy"
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// POST /admin/users/delete soft-deletes every user matching a filter, as
+// the "bulk-delete" job:
+//
+//	{"ratingBelow": 500, "inactiveDays": 90, "usernamePrefix": "bot_", "batchSize": 500}
+//
+// Filters combine (a user must match all given) and at least one is
+// required. inactiveDays matches users with no activity (see activity.go)
+// for that long; users with none recorded since startup never match it. Matches are taken
+// once at the start and deleted batchSize at a time (default 500), each
+// batch under one lock with one re-rank, re-checking the filter so a user
+// who changed in between is skipped. Deleted users become tombstones like a
+// single DELETE and can be restored until the purge job drops them.
+//
+// GET /admin/users/delete reports the running or last run: matched,
+// deleted, skipped and batches so far, and the final summary once done.
+// ?dryRun=1 on the POST reports what would match without deleting.
+
+const defaultBulkDeleteBatch = 500
+
+// userFilter selects users for bulk operations.
+type userFilter struct {
+	RatingBelow    *scoreNumber `json:"ratingBelow,omitempty"`
+	InactiveDays   int          `json:"inactiveDays,omitempty"`
+	UsernamePrefix string       `json:"usernamePrefix,omitempty"`
+}
+
+func (f *userFilter) validate() error {
+	if f.RatingBelow == nil && f.InactiveDays == 0 && f.UsernamePrefix == "" {
+		return &paramError{Param: "body", Message: "needs at least one of ratingBelow, inactiveDays or usernamePrefix"}
+	}
+	if f.InactiveDays < 0 {
+		return &paramError{Param: "inactiveDays", Message: fmt.Sprintf("must be positive, got %d", f.InactiveDays)}
+	}
+	f.UsernamePrefix = foldUsername(normalizeUsername(f.UsernamePrefix))
+	return nil
+}
+
+func (f *userFilter) matches(u *User, now time.Time) bool {
+	if f.RatingBelow != nil && u.Rating >= int(*f.RatingBelow) {
+		return false
+	}
+	if f.InactiveDays > 0 {
+		cutoff := now.Add(-time.Duration(f.InactiveDays) * 24 * time.Hour)
+		if last := activity.Get(u.ID); last.IsZero() || !last.Before(cutoff) {
+			return false
+		}
+	}
+	return strings.HasPrefix(u.UsernameLower, f.UsernamePrefix)
+}
+
+// matchingIDsLocked lists the users f selects.
+func (s *UserStore) matchingIDsLocked(f *userFilter, now time.Time) []string {
+	ids := []string{}
+	for _, u := range s.RankedLocked() {
+		if f.matches(u, now) {
+			ids = append(ids, u.ID)
+		}
+	}
+	return ids
+}
+
+// SoftDeleteBatch soft-deletes the users among ids that still match f,
+// re-ranking once, and returns how many it deleted.
+func (s *UserStore) SoftDeleteBatch(ctx context.Context, ids []string, f *userFilter) (int, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	gone := make(map[*User]bool, len(ids))
+	for _, id := range ids {
+		u, exists := s.GetLocked(id)
+		if !exists || !f.matches(u, now) {
+			continue
+		}
+		s.deleted[id] = &deletedUser{User: *u, DeletedAt: now, PurgeAfter: now.Add(deleteRetention), user: u}
+		gone[u] = true
+	}
+	s.removeUsersLocked(gone)
+	return len(gone), nil
+}
+
+// bulkDeletion is the progress of the running or last bulk delete.
+type bulkDeletion struct {
+	mu       sync.Mutex
+	filter   userFilter
+	state    string
+	matched  int
+	deleted  int
+	skipped  int
+	batches  int
+	started  time.Time
+	finished time.Time
+	lastErr  string
+}
+
+var bulkDeletes = &bulkDeletion{state: jobIdle}
+
+func (b *bulkDeletion) run(ctx context.Context, f userFilter, ids []string, batchSize int) error {
+	b.mu.Lock()
+	b.filter, b.state, b.matched = f, jobRunning, len(ids)
+	b.deleted, b.skipped, b.batches = 0, 0, 0
+	b.started, b.finished, b.lastErr = time.Now(), time.Time{}, ""
+	b.mu.Unlock()
+
+	for start := 0; start < len(ids); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			b.finish(jobCancelled, nil)
+			return err
+		}
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		n, err := userStore.SoftDeleteBatch(ctx, ids[start:end], &f)
+		if err != nil {
+			b.finish(jobFailed, err)
+			return err
+		}
+		b.mu.Lock()
+		b.deleted += n
+		b.skipped += end - start - n
+		b.batches++
+		b.mu.Unlock()
+		jobProgress(ctx, int64(end), int64(len(ids)))
+	}
+	b.finish(jobSucceeded, nil)
+	b.mu.Lock()
+	log.Printf("Bulk delete: deleted %d of %d matched users in %d batches", b.deleted, b.matched, b.batches)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *bulkDeletion) finish(state string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = state
+	b.finished = time.Now()
+	if err != nil {
+		b.lastErr = err.Error()
+	}
+}
+
+func (b *bulkDeletion) Status() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := map[string]interface{}{
+		"state":   b.state,
+		"filter":  b.filter,
+		"matched": b.matched,
+		"deleted": b.deleted,
+		"skipped": b.skipped,
+		"batches": b.batches,
+	}
+	if !b.started.IsZero() {
+		status["startedAt"] = b.started.Unix()
+		end := b.finished
+		if end.IsZero() {
+			end = time.Now()
+		}
+		status["durationMs"] = float64(end.Sub(b.started).Microseconds()) / 1000
+	}
+	if !b.finished.IsZero() {
+		status["finishedAt"] = b.finished.Unix()
+	}
+	if b.lastErr != "" {
+		status["error"] = b.lastErr
+	}
+	return status
+}
+
+// bulkDeleteRequest serves GET and POST /admin/users/delete.
+func bulkDeleteRequest(r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return bulkDeletes.Status(), nil
+	case http.MethodPost:
+	default:
+		return nil, fmt.Errorf("%w: use GET or POST", errMethodNotAllowed)
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		userFilter
+		BatchSize int `json:"batchSize"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+	}
+	f := body.userFilter
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	if body.BatchSize == 0 {
+		body.BatchSize = defaultBulkDeleteBatch
+	}
+	if body.BatchSize < 1 || body.BatchSize > 10000 {
+		return nil, &paramError{Param: "batchSize", Message: fmt.Sprintf("must be between 1 and 10000, got %d", body.BatchSize)}
+	}
+	if err := userStore.checkMembershipChange(r.Context()); err != nil {
+		return nil, err
+	}
+
+	userStore.RLock()
+	ids := userStore.matchingIDsLocked(&f, time.Now())
+	userStore.RUnlock()
+	if dryRun {
+		sample := ids
+		if len(sample) > dryRunSampleSize {
+			sample = sample[:dryRunSampleSize]
+		}
+		return map[string]interface{}{"dryRun": true, "filter": f, "matched": len(ids), "sample": sample}, nil
+	}
+
+	_, err = jobs.RunFunc("bulk-delete", func(ctx context.Context) error {
+		return bulkDeletes.run(ctx, f, ids, body.BatchSize)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"state": jobRunning, "filter": f, "matched": len(ids), "batchSize": body.BatchSize}, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// /stats "cache" breaks leaderboard cache lookups down by page bucket, to
+// show whether the TTL earns its keep under real traffic:
+//
+//   - hits: served from a live entry
+//   - misses: no entry for the page (never cached, or cleared by a write)
+//   - expired: an entry older than the TTL
+//   - bypass: requests that never consult the cache (cursor pages)
+//
+// clears counts the times the whole cache was dropped, on the first write
+// after each sort and on invalidations from other instances. A high miss
+// count next to clears means writes empty the cache before the TTL matters; a high expired count
+// means the TTL is what is turning hits away.
+
+// cacheBucket groups cache lookups by how deep the page is.
+type cacheBucket int
+
+const (
+	cacheFirstPage cacheBucket = iota
+	cacheEarlyPages
+	cacheMidPages
+	cacheDeepPages
+	cacheCursor
+	cacheBucketCount
+)
+
+var cacheBucketNames = [cacheBucketCount]string{"page1", "pages2-5", "pages6-20", "pages21+", "cursor"}
+
+func pageBucket(page int) cacheBucket {
+	switch {
+	case page <= 1:
+		return cacheFirstPage
+	case page <= 5:
+		return cacheEarlyPages
+	case page <= 20:
+		return cacheMidPages
+	}
+	return cacheDeepPages
+}
+
+type cacheOutcome = leaderboard.CacheOutcome
+
+const (
+	cacheHit          = leaderboard.CacheHit
+	cacheMiss         = leaderboard.CacheMiss
+	cacheExpired      = leaderboard.CacheExpired
+	cacheBypass       = leaderboard.CacheBypass
+	cacheOutcomeCount = cacheBypass + 1
+)
+
+// cacheCounters are atomic so the hit path stays free of locks.
+type cacheCounters struct {
+	counts [cacheBucketCount][cacheOutcomeCount]int64
+	clears int64
+}
+
+func (c *cacheCounters) record(b cacheBucket, o cacheOutcome) {
+	atomic.AddInt64(&c.counts[b][o], 1)
+}
+
+func (c *cacheCounters) cleared() {
+	atomic.AddInt64(&c.clears, 1)
+}
+
+func (c *cacheCounters) Stats(ttlMs int64, entries int) map[string]interface{} {
+	var total [cacheOutcomeCount]int64
+	buckets := make(map[string]interface{}, cacheBucketCount)
+	for b := cacheBucket(0); b < cacheBucketCount; b++ {
+		var n [cacheOutcomeCount]int64
+		for o := cacheOutcome(0); o < cacheOutcomeCount; o++ {
+			n[o] = atomic.LoadInt64(&c.counts[b][o])
+			total[o] += n[o]
+		}
+		buckets[cacheBucketNames[b]] = cacheOutcomeStats(n)
+	}
+	out := cacheOutcomeStats(total)
+	out["ttlMs"] = ttlMs
+	out["entries"] = entries
+	out["clears"] = atomic.LoadInt64(&c.clears)
+	out["byBucket"] = buckets
+	return out
+}
+
+// cacheOutcomeStats reports n with the hit ratio of the lookups that
+// consulted the cache.
+func cacheOutcomeStats(n [cacheOutcomeCount]int64) map[string]interface{} {
+	lookups := n[cacheHit] + n[cacheMiss] + n[cacheExpired]
+	hitRatio := 0.0
+	if lookups > 0 {
+		hitRatio = float64(n[cacheHit]) / float64(lookups)
+	}
+	return map[string]interface{}{
+		"hits":     n[cacheHit],
+		"misses":   n[cacheMiss],
+		"expired":  n[cacheExpired],
+		"bypass":   n[cacheBypass],
+		"hitRatio": hitRatio,
+	}
+}
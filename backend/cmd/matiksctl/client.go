@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// apiUser mirrors the user object returned by the leaderboard API.
+type apiUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	Rank     int    `json:"rank"`
+}
+
+// pageResponse covers /leaderboard and /search.
+type pageResponse struct {
+	Success    bool      `json:"success"`
+	Error      string    `json:"error"`
+	Users      []apiUser `json:"users"`
+	Total      int       `json:"total"`
+	Page       int       `json:"page"`
+	Limit      int       `json:"limit"`
+	TotalPages int       `json:"totalPages"`
+}
+
+// jobResponse covers POST /admin/seed and GET /admin/jobs/{id}. Status
+// is the run's /admin/jobs/{id} link.
+type jobResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Job     apiJob `json:"job"`
+}
+
+type apiJob struct {
+	ID         string  `json:"id"`
+	State      string  `json:"state"`
+	Stage      string  `json:"stage"`
+	Error      string  `json:"error"`
+	DurationMs float64 `json:"durationMs"`
+	Progress   *struct {
+		Done    int64   `json:"done"`
+		Total   int64   `json:"total"`
+		Percent float64 `json:"percent"`
+	} `json:"progress"`
+}
+
+type rankResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Data    struct {
+		User       apiUser `json:"user"`
+		TieCount   int     `json:"tieCount"`
+		TotalUsers int64   `json:"totalUsers"`
+		Percentile float64 `json:"percentile"`
+	} `json:"data"`
+}
+
+// client is a thin wrapper over the HTTP API. token, when set, is sent
+// as the admin bearer token.
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string, timeout time.Duration) *client {
+	return &client{
+		baseURL: baseURL,
+		token:   token,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *client) get(path string, params url.Values, out interface{}) error {
+	return c.do(http.MethodGet, path, params, out)
+}
+
+func (c *client) do(method, path string, params url.Values, out interface{}) error {
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s: server returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decoding response: %w", path, err)
+	}
+	return nil
+}
+
+func (c *client) leaderboard(page, limit int) (*pageResponse, error) {
+	var out pageResponse
+	params := url.Values{}
+	params.Set("page", fmt.Sprint(page))
+	params.Set("limit", fmt.Sprint(limit))
+	if err := c.get("/leaderboard", params, &out); err != nil {
+		return nil, err
+	}
+	if !out.Success {
+		return nil, fmt.Errorf("leaderboard: %s", out.Error)
+	}
+	return &out, nil
+}
+
+func (c *client) search(query string, page, limit int) (*pageResponse, error) {
+	var out pageResponse
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("page", fmt.Sprint(page))
+	params.Set("limit", fmt.Sprint(limit))
+	if err := c.get("/search", params, &out); err != nil {
+		return nil, err
+	}
+	if !out.Success {
+		return nil, fmt.Errorf("search: %s", out.Error)
+	}
+	return &out, nil
+}
+
+func (c *client) rank(username string) (*rankResponse, error) {
+	var out rankResponse
+	params := url.Values{}
+	params.Set("username", username)
+	if err := c.get("/user/rank", params, &out); err != nil {
+		return nil, err
+	}
+	if !out.Success {
+		return nil, fmt.Errorf("%s: %s", username, out.Error)
+	}
+	return &out, nil
+}
+
+// seed starts a server-side reseed and returns the run's status link.
+func (c *client) seed(count int, seed int64) (*jobResponse, error) {
+	var out jobResponse
+	params := url.Values{}
+	params.Set("count", fmt.Sprint(count))
+	params.Set("seed", fmt.Sprint(seed))
+	if err := c.do(http.MethodPost, "/admin/seed", params, &out); err != nil {
+		return nil, err
+	}
+	if !out.Success {
+		return nil, fmt.Errorf("seed: %s", out.Error)
+	}
+	return &out, nil
+}
+
+// job reads a run's status from its /admin/jobs/{id} link.
+func (c *client) job(path string) (*apiJob, error) {
+	var out jobResponse
+	if err := c.get(path, nil, &out); err != nil {
+		return nil, err
+	}
+	if !out.Success {
+		return nil, fmt.Errorf("%s: %s", path, out.Error)
+	}
+	return &out.Job, nil
+}
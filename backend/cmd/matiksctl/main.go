@@ -0,0 +1,310 @@
+// Command matiksctl is an operator CLI for the leaderboard HTTP API.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"matiks-leaderboard/leaderboardpb"
+)
+
+var (
+	serverURL  string
+	adminToken string
+	timeout    time.Duration
+)
+
+func main() {
+	root := &cobra.Command{
+		Use:           "matiksctl",
+		Short:         "Operate a Matiks leaderboard server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.PersistentFlags().StringVar(&serverURL, "server", envOr("MATIKS_SERVER", "http://localhost:8080"), "leaderboard server base URL")
+	root.PersistentFlags().StringVar(&adminToken, "token", os.Getenv("MATIKS_ADMIN_TOKEN"), "admin token for admin commands")
+	root.PersistentFlags().DurationVar(&timeout, "timeout", 10*time.Second, "HTTP request timeout")
+
+	root.AddCommand(topCmd(), rankCmd(), searchCmd(), seedCmd(), exportCmd(), watchCmd(), streamCmd())
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func topCmd() *cobra.Command {
+	var n int
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Show the top N users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := newClient(serverURL, adminToken, timeout).leaderboard(1, n)
+			if err != nil {
+				return err
+			}
+			printUsers(cmd.OutOrStdout(), resp.Users)
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&n, "number", "n", 10, "number of users to show")
+	return cmd
+}
+
+func rankCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rank <username>",
+		Short: "Show a user's rank",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := newClient(serverURL, adminToken, timeout).rank(args[0])
+			if err != nil {
+				return err
+			}
+			d := resp.Data
+			fmt.Fprintf(cmd.OutOrStdout(), "%s (%s)\n  rank:       %d of %d\n  rating:     %d\n  tied with:  %d\n  percentile: %.2f\n",
+				d.User.Username, d.User.ID, d.User.Rank, d.TotalUsers, d.User.Rating, d.TieCount, d.Percentile)
+			return nil
+		},
+	}
+}
+
+func searchCmd() *cobra.Command {
+	var page, limit int
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search users by username prefix",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := newClient(serverURL, adminToken, timeout).search(args[0], page, limit)
+			if err != nil {
+				return err
+			}
+			printUsers(cmd.OutOrStdout(), resp.Users)
+			fmt.Fprintf(cmd.OutOrStdout(), "\n%d matches, page %d of %d\n", resp.Total, resp.Page, resp.TotalPages)
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&page, "page", 1, "result page")
+	cmd.Flags().IntVar(&limit, "limit", 20, "results per page")
+	return cmd
+}
+
+func seedCmd() *cobra.Command {
+	var (
+		count    int
+		seed     int64
+		interval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Reseed the server with a deterministic user dataset",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := newClient(serverURL, adminToken, timeout)
+			started, err := c.seed(count, seed)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.ErrOrStderr(), started.Message)
+
+			// Poll the run until the job manager says it is over
+			var last string
+			for {
+				job, err := c.job(started.Status)
+				if err != nil {
+					return err
+				}
+				switch job.State {
+				case "running":
+					if job.Progress != nil {
+						line := fmt.Sprintf("%s  %d of %d (%.1f%%)", job.Stage, job.Progress.Done, job.Progress.Total, job.Progress.Percent)
+						if line != last {
+							fmt.Fprintln(cmd.ErrOrStderr(), line)
+							last = line
+						}
+					}
+					time.Sleep(interval)
+				case "succeeded":
+					fmt.Fprintf(cmd.OutOrStdout(), "Seeded %d users (seed %d) in %.1fs\n", count, seed, job.DurationMs/1000)
+					return nil
+				default:
+					return fmt.Errorf("seed %s %s: %s", job.ID, job.State, job.Error)
+				}
+			}
+		},
+	}
+	cmd.Flags().IntVar(&count, "count", 1000, "number of users to generate")
+	cmd.Flags().Int64Var(&seed, "seed", 1, "random seed")
+	cmd.Flags().DurationVar(&interval, "interval", 500*time.Millisecond, "job status poll interval")
+	return cmd
+}
+
+func exportCmd() *cobra.Command {
+	var (
+		format   string
+		pageSize int
+		output   string
+	)
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the full leaderboard",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := newClient(serverURL, adminToken, timeout)
+
+			var users []apiUser
+			for page := 1; ; page++ {
+				resp, err := c.leaderboard(page, pageSize)
+				if err != nil {
+					return err
+				}
+				users = append(users, resp.Users...)
+				if page >= resp.TotalPages || len(resp.Users) == 0 {
+					break
+				}
+			}
+
+			w := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			return writeUsers(w, format, users)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "csv", "output format (csv or json)")
+	cmd.Flags().IntVar(&pageSize, "page-size", 100, "users fetched per request")
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "output file (- for stdout)")
+	return cmd
+}
+
+func watchCmd() *cobra.Command {
+	var (
+		n        int
+		interval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Poll the top N users until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := newClient(serverURL, adminToken, timeout)
+			w := cmd.OutOrStdout()
+			for {
+				resp, err := c.leaderboard(1, n)
+				if err != nil {
+					fmt.Fprintln(cmd.ErrOrStderr(), "error:", err)
+				} else {
+					// Clear screen and home the cursor between frames
+					fmt.Fprint(w, "\033[H\033[2J")
+					fmt.Fprintf(w, "%s  top %d of %d\n\n", time.Now().Format("15:04:05"), n, resp.Total)
+					printUsers(w, resp.Users)
+				}
+				time.Sleep(interval)
+			}
+		},
+	}
+	cmd.Flags().IntVarP(&n, "number", "n", 10, "number of users to show")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "poll interval")
+	return cmd
+}
+
+func streamCmd() *cobra.Command {
+	var (
+		addr      string
+		maxRank   int
+		usernames []string
+		ratings   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "stream",
+		Short: "Stream rank changes over gRPC until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			stream, err := leaderboardpb.NewLeaderboardClient(conn).StreamRankChanges(cmd.Context(), &leaderboardpb.StreamRankChangesRequest{
+				MaxRank:              int32(maxRank),
+				Usernames:            usernames,
+				IncludeRatingChanges: ratings,
+			})
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			for {
+				d, err := stream.Recv()
+				if err != nil {
+					return err
+				}
+				at := time.UnixMilli(d.TimestampMs).Format("15:04:05.000")
+				switch d.Kind {
+				case leaderboardpb.RankDelta_RANK_CHANGED:
+					fmt.Fprintf(w, "%s  rank    %-24s %d -> %d\n", at, d.Username, d.OldRank, d.NewRank)
+				case leaderboardpb.RankDelta_RATING_CHANGED:
+					fmt.Fprintf(w, "%s  rating  %-24s %d -> %d\n", at, d.Username, d.OldRating, d.NewRating)
+				}
+			}
+		},
+	}
+	cmd.Flags().StringVar(&addr, "grpc", envOr("MATIKS_GRPC", "localhost:9090"), "gRPC server address")
+	cmd.Flags().IntVar(&maxRank, "max-rank", 0, "only changes within the top N (0 = all)")
+	cmd.Flags().StringSliceVar(&usernames, "user", nil, "only changes for these users (repeatable)")
+	cmd.Flags().BoolVar(&ratings, "ratings", false, "include individual rating changes")
+	return cmd
+}
+
+func printUsers(w io.Writer, users []apiUser) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tUSERNAME\tRATING\tID")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", u.Rank, u.Username, u.Rating, u.ID)
+	}
+	tw.Flush()
+}
+
+func writeUsers(w io.Writer, format string, users []apiUser) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"rank", "id", "username", "rating"})
+		for _, u := range users {
+			cw.Write([]string{strconv.Itoa(u.Rank), u.ID, u.Username, strconv.Itoa(u.Rating)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q (want csv or json)", format)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Replicas sharing Redis agree on a cluster-wide leaderboard version, a
+// counter at MATIKS_CACHE_VERSION_KEY (default "matiks:cache-version"). An
+// instance bumps it when it publishes an invalidation (see redis.go), the
+// message carries the new version, and every instance also reads the
+// counter each MATIKS_CACHE_VERSION_POLL (default 1s) in case a message is
+// lost.
+//
+// Cached pages, in either backend, are stamped with the version their
+// instance knew when it built them, and a page stamped below the version
+// known now is stale. So a replica serves a page at most a poll interval
+// behind the cluster; if it can't read the counter for a whole page cache
+// TTL, it stops using the cache until it can. A replica still catching up
+// with its primary or Raft log keeps its pages out of a shared cache, since
+// its data hasn't reached the version it would stamp them with.
+
+type cacheVersion struct {
+	rdb   *redis.Client
+	key   string
+	poll  time.Duration
+	trust time.Duration // How long a read of the counter stays good
+
+	version  int64 // Atomic: the newest version seen
+	syncedAt int64 // Atomic: UnixNano of the last read or bump
+	bumps    int64
+	stale    int64 // Atomic: cached pages turned away as older than version
+	failures int64
+}
+
+// clusterVersion is nil without Redis, and then every page is current.
+var clusterVersion *cacheVersion
+
+func newCacheVersion(rdb *redis.Client, pageTTL time.Duration) *cacheVersion {
+	v := &cacheVersion{
+		rdb:  rdb,
+		key:  envString("MATIKS_CACHE_VERSION_KEY", "matiks:cache-version"),
+		poll: envDuration("MATIKS_CACHE_VERSION_POLL", time.Second),
+	}
+	if v.poll <= 0 {
+		v.poll = time.Second
+	}
+	// Memory pages expire within their TTL anyway; the floor keeps a short
+	// TTL from distrusting the counter between polls.
+	v.trust = pageTTL
+	if v.trust < 2*v.poll {
+		v.trust = 2 * v.poll
+	}
+	return v
+}
+
+// current returns the version to check and stamp pages with, and false
+// when the counter hasn't been read recently enough to trust the cache.
+func (v *cacheVersion) current() (int64, bool) {
+	if v == nil {
+		return 0, true
+	}
+	synced := atomic.LoadInt64(&v.syncedAt)
+	return atomic.LoadInt64(&v.version), synced != 0 && time.Since(time.Unix(0, synced)) <= v.trust
+}
+
+// observe records a version seen elsewhere; versions only move forward.
+func (v *cacheVersion) observe(version int64) {
+	if v == nil {
+		return
+	}
+	for {
+		seen := atomic.LoadInt64(&v.version)
+		if version <= seen || atomic.CompareAndSwapInt64(&v.version, seen, version) {
+			return
+		}
+	}
+}
+
+func (v *cacheVersion) synced(version int64) {
+	v.observe(version)
+	atomic.StoreInt64(&v.syncedAt, time.Now().UnixNano())
+}
+
+// bump starts a new cluster version for a local change.
+func (v *cacheVersion) bump(ctx context.Context) (int64, error) {
+	var version int64
+	err := redisBreaker.Do(func() error {
+		var err error
+		version, err = v.rdb.Incr(ctx, v.key).Result()
+		return err
+	})
+	if err != nil {
+		atomic.AddInt64(&v.failures, 1)
+		return 0, err
+	}
+	atomic.AddInt64(&v.bumps, 1)
+	v.synced(version)
+	return version, nil
+}
+
+func (v *cacheVersion) read(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, v.poll)
+	defer cancel()
+	var raw string
+	err := redisBreaker.Do(func() error {
+		var err error
+		raw, err = v.rdb.Get(ctx, v.key).Result()
+		if err == redis.Nil {
+			raw, err = "0", nil // No instance has bumped it yet
+		}
+		return err
+	})
+	if err != nil {
+		atomic.AddInt64(&v.failures, 1)
+		return err
+	}
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return err
+	}
+	v.synced(version)
+	return nil
+}
+
+// run polls the counter until ctx ends.
+func (v *cacheVersion) run(ctx context.Context) {
+	ticker := time.NewTicker(v.poll)
+	defer ticker.Stop()
+	failing := false
+	for {
+		err := v.read(ctx)
+		if err != nil && !failing && err != errBackendDegraded {
+			log.Printf("Cache version: reading %s: %v", v.key, err)
+		}
+		failing = err != nil
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// admit reports whether a cached page stamped with version may be served.
+func (v *cacheVersion) admit(stamped, version int64, trusted bool) bool {
+	if v == nil {
+		return true
+	}
+	if !trusted || stamped < version {
+		atomic.AddInt64(&v.stale, 1)
+		return false
+	}
+	return true
+}
+
+// replicaBehind reports whether this instance is still applying changes
+// its primary or Raft leader already has.
+func replicaBehind() bool {
+	if replica != nil {
+		replica.mu.Lock()
+		behind := replica.appliedSeq < replica.primarySeq
+		replica.mu.Unlock()
+		if behind {
+			return true
+		}
+	}
+	return raftNode != nil && raftNode.AppliedIndex() < raftNode.LastIndex()
+}
+
+func (v *cacheVersion) Stats() map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	version, trusted := v.current()
+	stats := map[string]interface{}{
+		"key":      v.key,
+		"version":  version,
+		"trusted":  trusted,
+		"bumps":    atomic.LoadInt64(&v.bumps),
+		"stale":    atomic.LoadInt64(&v.stale),
+		"failures": atomic.LoadInt64(&v.failures),
+	}
+	if synced := atomic.LoadInt64(&v.syncedAt); synced != 0 {
+		stats["syncedAgoSec"] = time.Since(time.Unix(0, synced)).Seconds()
+	}
+	return stats
+}
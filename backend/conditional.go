@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// LastModified is when ratings or the user set last changed.
+func (s *UserStore) LastModified() time.Time {
+	s.RLock()
+	defer s.RUnlock()
+	return s.lastUpdate
+}
+
+// writeConditional handles the freshness part of a read endpoint: it sets
+// Last-Modified, answers 304 when If-Modified-Since is still current, and
+// answers HEAD with headers only. It returns true when the response is
+// complete and the handler should not write a body.
+func writeConditional(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	// HTTP dates have one-second resolution
+	modified = modified.Truncate(time.Second)
+
+	h := w.Header()
+	h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	// Let clients keep the body but revalidate every time
+	h.Set("Cache-Control", "no-cache")
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if t, err := http.ParseTime(ims); err == nil && !modified.After(t) {
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+	return false
+}
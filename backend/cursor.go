@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"matiks-leaderboard/utils"
+)
+
+// /leaderboard?cursor= pages by position instead of by page number: each
+// response carries a nextCursor naming the last user shown by their rating
+// and tie-break key, and the next page starts strictly after that point in
+// (rating, tie break) order. Offsets shift whenever someone above the page
+// moves, so page N+1 can repeat or skip users at the page boundary, most
+// visibly inside a block of tied ratings; a cursor only shifts for users
+// whose own rating crossed it. Pass an empty cursor to start at the top.
+
+// leaderboardCursor is a position in the sorted leaderboard.
+type leaderboardCursor struct {
+	Rating        int           `json:"r"`
+	ID            string        `json:"id"`
+	UsernameLower string        `json:"u,omitempty"`
+	ReachedAt     int64         `json:"t,omitempty"`
+	TieMode       utils.TieMode `json:"m"` // Cursors do not carry over a change of tie break
+}
+
+func cursorAt(u *User, mode utils.TieMode) *leaderboardCursor {
+	c := &leaderboardCursor{Rating: u.Rating, ID: u.ID, TieMode: mode}
+	switch mode {
+	case utils.TieByUsername:
+		c.UsernameLower = u.UsernameLower
+	case utils.TieByEarliest:
+		c.ReachedAt = u.ReachedAt()
+	}
+	return c
+}
+
+func (c *leaderboardCursor) String() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func (c *leaderboardCursor) tieKey() utils.TieKey {
+	return utils.TieKey{ID: c.ID, UsernameLower: c.UsernameLower, ReachedAt: c.ReachedAt}
+}
+
+// cursorParam reads ?cursor=. present is false without one; a present but
+// empty cursor starts from the top (nil).
+func cursorParam(r *http.Request) (c *leaderboardCursor, present bool, err error) {
+	q := r.URL.Query()
+	raw, present := q["cursor"]
+	if !present {
+		return nil, false, nil
+	}
+	if !featureCursorPagination.Enabled() {
+		return nil, true, featureParamError("cursor", featureCursorPagination)
+	}
+	if _, paged := q["page"]; paged {
+		return nil, true, &paramError{Param: "cursor", Message: "pass either cursor or page, not both"}
+	}
+	if raw[0] == "" {
+		return nil, true, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(raw[0])
+	c = &leaderboardCursor{}
+	if err != nil || json.Unmarshal(decoded, c) != nil || c.ID == "" {
+		return nil, true, &paramError{Param: "cursor", Message: "is not a cursor from this server"}
+	}
+	if c.TieMode != userStore.TieBreak().Mode {
+		return nil, true, &paramError{Param: "cursor", Message: "is from a different tie-break order; start again without one"}
+	}
+	return c, true, nil
+}
+
+// GetLeaderboardAfter returns up to limit users in band after the cursor
+// (from the top with nil), the band's total, and the cursor for the next
+// page (nil on the last).
+func (s *UserStore) GetLeaderboardAfter(ctx context.Context, band ratingBand, after *leaderboardCursor, limit int) ([]User, int, *leaderboardCursor, int64, error) {
+	s.cacheStats.record(cacheCursor, cacheBypass)
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, nil, 0, err
+	}
+	defer s.RUnlock()
+
+	bandStart, bandEnd := s.BandLocked(band)
+	start := bandStart
+	if after != nil {
+		key := after.tieKey()
+		start += sort.Search(bandEnd-bandStart, func(i int) bool {
+			u := s.RankedLocked()[bandStart+i]
+			if u.Rating != after.Rating {
+				return s.Direction().Better(after.Rating, u.Rating)
+			}
+			return s.TieBreak().Less(key, u.tieKey())
+		})
+	}
+	end := start + limit
+	if end > bandEnd {
+		end = bandEnd
+	}
+
+	users := make([]User, end-start)
+	for i := start; i < end; i++ {
+		users[i-start] = *s.RankedLocked()[i]
+	}
+	var next *leaderboardCursor
+	if end < bandEnd && end > start {
+		next = cursorAt(s.RankedLocked()[end-1], s.TieBreak().Mode)
+	}
+	return users, bandEnd - bandStart, next, s.updateCount, nil
+}
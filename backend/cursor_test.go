@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"matiks-leaderboard/utils"
+)
+
+// tiedUsers returns n users spread over a handful of ratings, so most of
+// them share theirs with dozens of others.
+func tiedUsers(n int) []utils.SeedUser {
+	seeded := make([]utils.SeedUser, n)
+	for i := range seeded {
+		seeded[i] = utils.SeedUser{
+			ID:       fmt.Sprintf("u%04d", (i*7919)%n),
+			Username: fmt.Sprintf("player_%03d", (i*104729)%n),
+			Rating:   scores.Units(1000 + 100*(i%6)),
+		}
+	}
+	return seeded
+}
+
+// TestCursorWalk pages through the leaderboard by cursor under each tie
+// break while ratings change between pages. A user whose rating stays put
+// must appear exactly once, in leaderboard order.
+func TestCursorWalk(t *testing.T) {
+	for _, tie := range []utils.TieBreak{
+		{Mode: utils.TieByID},
+		{Mode: utils.TieByUsername},
+		{Mode: utils.TieByEarliest},
+		{Mode: utils.TieByRandom, Seed: 7},
+	} {
+		t.Run(string(tie.Mode), func(t *testing.T) {
+			saved := leaderboardTieBreak
+			leaderboardTieBreak = tie
+			s := seededStore(tiedUsers(600))
+			leaderboardTieBreak = saved
+
+			ctx := context.Background()
+			rng := rand.New(rand.NewSource(1))
+			moved := make(map[string]bool)
+			seen := make(map[string]int)
+			var walked []string
+			var after *leaderboardCursor
+			for pages := 0; ; pages++ {
+				if pages > 600 {
+					t.Fatal("the walk never ended")
+				}
+				users, _, next, _, err := s.GetLeaderboardAfter(ctx, allRatings, after, 25)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, u := range users {
+					seen[u.ID]++
+					walked = append(walked, u.ID)
+				}
+				if next == nil {
+					break
+				}
+				after = next
+
+				// Move a few users, in both directions and within ties
+				var updates []ratingUpdate
+				for i := 0; i < 5; i++ {
+					id := fmt.Sprintf("u%04d", rng.Intn(600))
+					moved[id] = true
+					updates = append(updates, ratingUpdate{UserID: id, Rating: scores.Units(1000 + 100*rng.Intn(6))})
+				}
+				s.applyRatingUpdates(updates, sourceSchedule)
+			}
+
+			// Users that never moved keep their relative order
+			if err := s.RLockSorted(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, u := range s.RankedLocked() {
+				if !moved[u.ID] {
+					want = append(want, u.ID)
+				}
+			}
+			s.RUnlock()
+			var got []string
+			for _, id := range walked {
+				if !moved[id] {
+					got = append(got, id)
+				}
+			}
+			for _, id := range want {
+				if seen[id] != 1 {
+					t.Errorf("%s, which never moved, was listed %d times", id, seen[id])
+				}
+			}
+			if len(got) == len(want) {
+				for i := range got {
+					if got[i] != want[i] {
+						t.Fatalf("position %d of the unmoved users: walked %s, leaderboard has %s", i, got[i], want[i])
+					}
+				}
+			}
+			if len(moved) == len(want) {
+				t.Fatal("every user moved; the walk checked nothing")
+			}
+		})
+	}
+}
+
+func TestCursorParamRejected(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	other := utils.TieByUsername
+	if userStore.TieBreak().Mode == other {
+		other = utils.TieByID
+	}
+	tests := []struct {
+		name, query string
+	}{
+		{"not base64", "cursor=!!!"},
+		{"not JSON", "cursor=" + encode("rating 1000")},
+		{"no ID", "cursor=" + encode(`{"r":1000,"m":"id"}`)},
+		{"wrong type", "cursor=" + encode(`{"r":"high","id":"u1","m":"id"}`)},
+		{"another tie break", "cursor=" + (&leaderboardCursor{Rating: 1000, ID: "u1", TieMode: other}).String()},
+		{"with a page", "cursor=&page=2"},
+	}
+	for _, tt := range tests {
+		for route, handler := range map[string]http.HandlerFunc{
+			"/leaderboard":    leaderboardHandler,
+			"/v1/leaderboard": v1(v1LeaderboardHandler),
+		} {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, route+"?"+tt.query, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: %s?%s = %d, want 400: %s", tt.name, route, tt.query, rec.Code, rec.Body)
+			}
+		}
+	}
+}
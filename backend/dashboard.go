@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GET /admin/dashboard gathers what an ops dashboard polls for in one
+// payload: request rates, cache hit ratio, sort frequency and duration,
+// update throughput and the biggest rank movers. Rates cover the last
+// minute; movers cover MATIKS_DASHBOARD_MOVER_WINDOW (default 5m).
+
+const (
+	rateWindowSeconds = 60
+	sortSampleSize    = 100
+)
+
+// rateWindow counts events in one-second buckets over the last minute.
+type rateWindow struct {
+	buckets [rateWindowSeconds]int64
+	seconds [rateWindowSeconds]int64 // Unix second each bucket is counting
+}
+
+func (w *rateWindow) add(now time.Time, n int64) {
+	sec := now.Unix()
+	i := sec % rateWindowSeconds
+	if w.seconds[i] != sec {
+		w.seconds[i], w.buckets[i] = sec, 0
+	}
+	w.buckets[i] += n
+}
+
+// perSecond averages the last minute.
+func (w *rateWindow) perSecond(now time.Time) float64 {
+	var sum int64
+	cutoff := now.Unix() - rateWindowSeconds
+	for i, sec := range w.seconds {
+		if sec > cutoff {
+			sum += w.buckets[i]
+		}
+	}
+	return float64(sum) / rateWindowSeconds
+}
+
+type routeCounter struct {
+	total  int64
+	window rateWindow
+}
+
+// rankMover is one user's rank movement within the mover window.
+type rankMover struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	FromRank int    `json:"fromRank"`
+	ToRank   int    `json:"toRank"`
+	Change   int    `json:"change"` // Positive means up
+
+	since time.Time
+}
+
+// DashboardMetrics keeps the counters that are not already in the store.
+type DashboardMetrics struct {
+	moverWindow time.Duration
+
+	mu       sync.Mutex
+	requests rateWindow
+	total    int64
+	routes   map[string]*routeCounter // By mux pattern
+
+	sorts         rateWindow
+	sortCount     int64
+	sortDurations []float64 // Ring of the latest sortSampleSize, in ms
+	sortPos       int
+	lastSortMs    float64
+
+	updates     rateWindow
+	lastUpdates int64 // store.ratingUpdates at the last sample
+
+	movers map[string]*rankMover
+}
+
+var dashboard = &DashboardMetrics{
+	moverWindow: envDuration("MATIKS_DASHBOARD_MOVER_WINDOW", 5*time.Minute),
+	routes:      make(map[string]*routeCounter),
+	movers:      make(map[string]*rankMover),
+}
+
+// recordRequest counts a request under the pattern it is routed to, which
+// keeps paths like /users/{id}/digest to a single entry.
+func (d *DashboardMetrics) recordRequest(pattern string) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.total++
+	d.requests.add(now, 1)
+	rc := d.routes[pattern]
+	if rc == nil {
+		rc = &routeCounter{}
+		d.routes[pattern] = rc
+	}
+	rc.total++
+	rc.window.add(now, 1)
+}
+
+func (d *DashboardMetrics) recordSort(p SortCompletedPayload, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sortCount++
+	d.sorts.add(now, 1)
+	d.lastSortMs = p.DurationMs
+	if len(d.sortDurations) < sortSampleSize {
+		d.sortDurations = append(d.sortDurations, p.DurationMs)
+	} else {
+		d.sortDurations[d.sortPos] = p.DurationMs
+		d.sortPos = (d.sortPos + 1) % sortSampleSize
+	}
+}
+
+// recordRanks folds one sort's rank changes into the movers. A user whose
+// movement started before the window starts over.
+func (d *DashboardMetrics) recordRanks(changes []RankChange, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range changes {
+		m := d.movers[c.UserID]
+		if m == nil || now.Sub(m.since) > d.moverWindow {
+			m = &rankMover{UserID: c.UserID, Username: c.Username, FromRank: c.OldRank, since: now}
+			d.movers[c.UserID] = m
+		}
+		m.ToRank = c.NewRank
+		m.Change = m.FromRank - m.ToRank
+	}
+}
+
+// sampleUpdates records the update counter's growth since the last call.
+func (d *DashboardMetrics) sampleUpdates(store *UserStore, now time.Time) {
+	current := atomic.LoadInt64(&store.ratingUpdates)
+	d.mu.Lock()
+	d.updates.add(now, current-d.lastUpdates)
+	d.lastUpdates = current
+	for id, m := range d.movers {
+		if now.Sub(m.since) > d.moverWindow {
+			delete(d.movers, id)
+		}
+	}
+	d.mu.Unlock()
+}
+
+func (d *DashboardMetrics) run(ctx context.Context, store *UserStore) error {
+	events := store.events.Subscribe(256)
+	defer store.events.Unsubscribe(events)
+
+	d.mu.Lock()
+	d.lastUpdates = atomic.LoadInt64(&store.ratingUpdates)
+	d.mu.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			switch p := ev.Payload.(type) {
+			case SortCompletedPayload:
+				d.recordSort(p, ev.Timestamp)
+			case RanksChangedPayload:
+				d.recordRanks(p.Changes, ev.Timestamp)
+			}
+		case now := <-ticker.C:
+			d.sampleUpdates(store, now)
+		}
+	}
+}
+
+// startDashboard runs metric collection as the "dashboard" job.
+func startDashboard(store *UserStore) {
+	jobs.Register("dashboard", func(ctx context.Context) error {
+		return dashboard.run(ctx, store)
+	})
+	jobs.Run("dashboard")
+}
+
+// percentileOf returns the p-th percentile (0-100) of sorted values.
+func percentileOf(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+// topMoversLocked returns the n biggest climbers and fallers in the window.
+func (d *DashboardMetrics) topMoversLocked(now time.Time, n int) (up, down []rankMover) {
+	var all []rankMover
+	for _, m := range d.movers {
+		if m.Change != 0 && now.Sub(m.since) <= d.moverWindow {
+			all = append(all, *m)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Change != all[j].Change {
+			return all[i].Change > all[j].Change
+		}
+		return all[i].UserID < all[j].UserID
+	})
+	up, down = []rankMover{}, []rankMover{}
+	for i := 0; i < len(all) && i < n && all[i].Change > 0; i++ {
+		up = append(up, all[i])
+	}
+	for i := len(all) - 1; i >= 0 && len(down) < n && all[i].Change < 0; i-- {
+		down = append(down, all[i])
+	}
+	return up, down
+}
+
+// Report assembles the dashboard payload.
+func (d *DashboardMetrics) Report(store *UserStore, movers int) map[string]interface{} {
+	now := time.Now()
+	hits := atomic.LoadInt64(&store.cacheHits)
+	misses := atomic.LoadInt64(&store.cacheMisses)
+	hitRatio := 0.0
+	if hits+misses > 0 {
+		hitRatio = float64(hits) / float64(hits+misses)
+	}
+	entries, _, cacheBytes := store.CacheFootprint()
+	store.RLock()
+	pending := store.updateCount
+	store.RUnlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	routes := make(map[string]interface{}, len(d.routes))
+	for pattern, rc := range d.routes {
+		routes[pattern] = map[string]interface{}{
+			"total":     rc.total,
+			"perSecond": rc.window.perSecond(now),
+		}
+	}
+
+	durations := append([]float64(nil), d.sortDurations...)
+	sort.Float64s(durations)
+	var sum, max float64
+	for _, v := range durations {
+		sum += v
+		if v > max {
+			max = v
+		}
+	}
+	avg := 0.0
+	if len(durations) > 0 {
+		avg = sum / float64(len(durations))
+	}
+
+	up, down := d.topMoversLocked(now, movers)
+
+	return map[string]interface{}{
+		"requests": map[string]interface{}{
+			"total":     d.total,
+			"perSecond": d.requests.perSecond(now),
+			"byRoute":   routes,
+		},
+		"cache": map[string]interface{}{
+			"hits":           hits,
+			"misses":         misses,
+			"hitRatio":       hitRatio,
+			"entries":        entries,
+			"estimatedBytes": cacheBytes,
+		},
+		"sorts": map[string]interface{}{
+			"total":          d.sortCount,
+			"perMinute":      d.sorts.perSecond(now) * 60,
+			"lastDurationMs": d.lastSortMs,
+			"avgDurationMs":  avg,
+			"p50DurationMs":  percentileOf(durations, 50),
+			"p95DurationMs":  percentileOf(durations, 95),
+			"maxDurationMs":  max,
+			"sampled":        len(durations),
+		},
+		"updates": map[string]interface{}{
+			"total":        atomic.LoadInt64(&store.ratingUpdates),
+			"perSecond":    d.updates.perSecond(now),
+			"pendingSorts": pending,
+		},
+		"topMovers": map[string]interface{}{
+			"windowSeconds": int(d.moverWindow.Seconds()),
+			"up":            up,
+			"down":          down,
+		},
+		"users":            atomic.LoadInt64(&store.totalUsers),
+		"websocketClients": wsHub.ClientCount(),
+		"windowSeconds":    rateWindowSeconds,
+	}
+}
+
+// adminDashboardHandler serves GET /admin/dashboard?movers=5.
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	movers, err := intParam(r, "movers", 5, 1, 50)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	response := dashboard.Report(userStore, movers)
+	response["success"] = true
+	response["timestamp"] = time.Now().Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Soft deletion takes a user out of every ranking and search index at
+// once, so ranks below close the gap immediately, but keeps the record as a
+// tombstone for MATIKS_DELETE_RETENTION_DAYS (default 30). Until then an
+// admin can restore it; afterwards the "purge" job drops the tombstone and
+// the user's profile and metadata for good.
+//
+// Deletes reset the change log like a reseed does, so read replicas pick
+// them up by re-snapshotting. Raft clusters only replicate rating updates
+// and full loads, so deletion is refused there, as is every other
+// membership change: creating, importing, merging, renaming and expiring
+// users.
+
+var (
+	errUserNotDeleted          = errors.New("user is not deleted")
+	errMembershipNotReplicated = errors.New("membership changes are not replicated through raft")
+)
+
+// deletedUser is a soft-deleted user's tombstone.
+type deletedUser struct {
+	User       User      `json:"user"` // As of deletion
+	DeletedAt  time.Time `json:"deletedAt"`
+	PurgeAfter time.Time `json:"purgeAfter"`
+
+	user *User // Re-inserted by Restore
+}
+
+var deleteRetention = time.Duration(envInt("MATIKS_DELETE_RETENTION_DAYS", 30)) * 24 * time.Hour
+
+// checkMembershipChange refuses membership changes that could not reach
+// the rest of the cluster, or whose caller has already gone.
+func (s *UserStore) checkMembershipChange(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if replica != nil {
+		return errReadOnlyReplica
+	}
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if s.replicator != nil {
+		return errMembershipNotReplicated
+	}
+	return nil
+}
+
+// removeUserLocked takes u out of the ranking and every index and
+// re-ranks.
+func (s *UserStore) removeUserLocked(u *User) {
+	s.RemoveLocked(u)
+	s.aggregates.remove(u.Rating)
+	delete(s.updatedUsers, u.ID)
+	s.membershipChangedLocked(-1)
+}
+
+// removeUsersLocked takes many users out with one pass over the ranking
+// and one re-rank.
+func (s *UserStore) removeUsersLocked(gone map[*User]bool) {
+	if len(gone) == 0 {
+		return
+	}
+	for u := range gone {
+		delete(s.updatedUsers, u.ID)
+		s.aggregates.remove(u.Rating)
+	}
+	s.RemoveAllLocked(gone)
+	s.membershipChangedLocked(-int64(len(gone)))
+}
+
+// insertUserLocked puts back a user removeUserLocked took out, and
+// re-ranks.
+func (s *UserStore) insertUserLocked(u *User) {
+	s.RestoreLocked(u)
+	s.aggregates.add(u.Rating)
+	s.membershipChangedLocked(1)
+}
+
+// addUserLocked adds a new user, leaving the re-rank to the caller.
+func (s *UserStore) addUserLocked(u *User) error {
+	if err := s.AddLocked(u); err != nil {
+		return err
+	}
+	s.aggregates.add(u.Rating)
+	return nil
+}
+
+// membershipChangedLocked re-ranks everyone after users were added or
+// removed, and makes followers re-snapshot.
+func (s *UserStore) membershipChangedLocked(delta int64) {
+	atomic.AddInt64(&s.totalUsers, delta)
+	if delta > 0 {
+		atomic.AddInt64(&s.usersAdded, delta)
+	} else {
+		atomic.AddInt64(&s.usersRemoved, -delta)
+	}
+	s.SortLocked()
+	s.lastUpdate = time.Now()
+	s.changes.reset()
+}
+
+// SoftDelete hides a user until Restore or the purge job.
+func (s *UserStore) SoftDelete(ctx context.Context, userID string) (*deletedUser, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	u, exists := s.GetLocked(userID)
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	now := time.Now()
+	tomb := &deletedUser{User: *u, DeletedAt: now, PurgeAfter: now.Add(deleteRetention), user: u}
+	s.removeUserLocked(u)
+	s.deleted[userID] = tomb
+	return tomb, nil
+}
+
+// Restore puts a soft-deleted user back.
+func (s *UserStore) Restore(ctx context.Context, userID string) (User, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return User{}, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	tomb, exists := s.deleted[userID]
+	if !exists {
+		return User{}, errUserNotDeleted
+	}
+	if _, taken := s.lookupUserLocked("", tomb.user.Username); taken || s.nameHeldLocked(tomb.user.Username, userID) {
+		return User{}, ErrDuplicateUsername
+	}
+	delete(s.deleted, userID)
+	s.insertUserLocked(tomb.user)
+	return *tomb.user, nil
+}
+
+// Deleted lists tombstones, oldest deletion first.
+func (s *UserStore) Deleted() []deletedUser {
+	s.RLock()
+	defer s.RUnlock()
+	out := make([]deletedUser, 0, len(s.deleted))
+	for _, tomb := range s.deleted {
+		out = append(out, *tomb)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeletedAt.Before(out[j].DeletedAt) })
+	return out
+}
+
+// PurgeDeleted drops tombstones past their retention, with the profile and
+// metadata of each.
+func (s *UserStore) PurgeDeleted(now time.Time) int {
+	s.Lock()
+	var purged []string
+	for id, tomb := range s.deleted {
+		if !now.Before(tomb.PurgeAfter) {
+			delete(s.deleted, id)
+			purged = append(purged, id)
+		}
+	}
+	s.Unlock()
+
+	dropUserData(purged)
+	if len(purged) > 0 {
+		log.Printf("Purge: removed %d deleted user(s)", len(purged))
+	}
+	return len(purged)
+}
+
+// dropUserData forgets everything kept about ids outside the ranking
+// structures. Those stores have their own locks, and the metric boards call
+// into the user store while holding theirs, so callers must not hold s.mu.
+func dropUserData(ids []string) {
+	for _, id := range ids {
+		profiles.Delete(id)
+		activity.Delete(id)
+		joins.Delete(id)
+		metadata.Delete(id)
+		metrics.Delete(id)
+	}
+}
+
+// departedLocked lists the users that don't survive a swap to next: gone
+// from it, or whose ID now belongs to another username, as after a reseed.
+// Tombstones and demoted users go with the old dataset too.
+func (s *UserStore) departedLocked(next *UserStore) []string {
+	var gone []string
+	check := func(id, username string) {
+		if u, ok := next.GetLocked(id); !ok || u.Username != username {
+			gone = append(gone, id)
+		}
+	}
+	s.EachLocked(func(u *User) { check(u.ID, u.Username) })
+	for id, tomb := range s.deleted {
+		check(id, tomb.User.Username)
+	}
+	for id, demoted := range s.inactive {
+		check(id, demoted.User.Username)
+	}
+	return gone
+}
+
+// adminUsersRequest serves POST /admin/users, DELETE /admin/users/{id},
+// POST /admin/users/{id}/restore, POST /admin/users/{id}/rename,
+// GET /admin/users/deleted, GET /admin/users/policy,
+// POST /admin/users/merge, POST /admin/users/import,
+// GET/POST /admin/users/delete,
+// GET /admin/users/inactive and POST /admin/users/{id}/reactivate.
+func adminUsersRequest(r *http.Request) (interface{}, error) {
+	if r.URL.Path == "/admin/users" {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return listUsersRequest(r)
+		}
+		return createUserRequest(r)
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/admin/users/")
+	parts := strings.Split(rest, "/")
+	switch {
+	case rest == "policy":
+		return usernamePolicy.Describe(), nil
+	case rest == "merge":
+		return mergeUsersRequest(r)
+	case rest == "import":
+		return importUsersRequest(r)
+	case rest == "delete":
+		return bulkDeleteRequest(r)
+	case rest == "inactive":
+		return inactiveUsersRequest(r)
+	case rest == "deleted":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+		}
+		return map[string]interface{}{
+			"retentionDays": int(deleteRetention.Hours() / 24),
+			"users":         userStore.Deleted(),
+		}, nil
+	case len(parts) == 1 && parts[0] != "":
+		if r.Method != http.MethodDelete {
+			return nil, fmt.Errorf("%w: use DELETE", errMethodNotAllowed)
+		}
+		return userStore.SoftDelete(r.Context(), parts[0])
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "restore":
+		if r.Method != http.MethodPost {
+			return nil, fmt.Errorf("%w: use POST", errMethodNotAllowed)
+		}
+		user, err := userStore.Restore(r.Context(), parts[0])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"user": user}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "reactivate":
+		if r.Method != http.MethodPost {
+			return nil, fmt.Errorf("%w: use POST", errMethodNotAllowed)
+		}
+		user, err := userStore.Reactivate(r.Context(), parts[0])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"user": user}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "rename":
+		return renameUserRequest(r, parts[0])
+	}
+	return nil, errRouteNotFound
+}
+
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := adminUsersRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"result":  result,
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Weekly digests aggregate each user's rating and rank movement per ISO
+// week (UTC) from the event bus. Only users that changed during a week get
+// an entry; for the rest, a week's values are inferred from the next week
+// they did change in, or from their current standing.
+
+// weekStats is one user's movement within one week.
+type weekStats struct {
+	StartRating, EndRating, PeakRating int
+	StartRank, EndRank, BestRank       int // 0 until a rank change is seen
+	Updates                            int
+}
+
+type digestWeek struct {
+	key   string
+	users map[string]*weekStats
+}
+
+// DigestTracker keeps the most recent weeks of per-user stats.
+type DigestTracker struct {
+	store  *UserStore
+	retain int
+
+	mu    sync.RWMutex
+	weeks []*digestWeek // Oldest first
+}
+
+var digests *DigestTracker
+
+func NewDigestTracker(store *UserStore, retain int) *DigestTracker {
+	return &DigestTracker{store: store, retain: retain}
+}
+
+// isoWeekKey formats t's ISO week, e.g. "2026-W07".
+func isoWeekKey(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// isoWeekStart returns the Monday 00:00 UTC starting the given ISO week.
+func isoWeekStart(year, week int) time.Time {
+	// January 4th is always in week 1
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	return monday.AddDate(0, 0, (week-1)*7)
+}
+
+// parseWeek validates a "YYYY-Www" key.
+func parseWeek(raw string) (string, time.Time, error) {
+	var year, week int
+	if _, err := fmt.Sscanf(raw, "%d-W%d", &year, &week); err != nil || week < 1 || week > 53 {
+		return "", time.Time{}, &paramError{Param: "week", Message: fmt.Sprintf("must look like 2026-W07, got %q", raw)}
+	}
+	start := isoWeekStart(year, week)
+	if key := isoWeekKey(start); key != fmt.Sprintf("%d-W%02d", year, week) {
+		return "", time.Time{}, &paramError{Param: "week", Message: fmt.Sprintf("%d has no week %d", year, week)}
+	}
+	return isoWeekKey(start), start, nil
+}
+
+// weekLocked returns the week for key, starting it (and dropping the oldest
+// beyond retain) when it is new.
+func (d *DigestTracker) weekLocked(key string) *digestWeek {
+	if n := len(d.weeks); n > 0 && d.weeks[n-1].key == key {
+		return d.weeks[n-1]
+	}
+	w := &digestWeek{key: key, users: make(map[string]*weekStats)}
+	d.weeks = append(d.weeks, w)
+	if len(d.weeks) > d.retain {
+		d.weeks = d.weeks[len(d.weeks)-d.retain:]
+	}
+	return w
+}
+
+func (d *DigestTracker) run(ctx context.Context) error {
+	events := d.store.events.Subscribe(4096)
+	defer d.store.events.Unsubscribe(events)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			d.record(ev)
+		}
+	}
+}
+
+func (d *DigestTracker) record(ev Event) {
+	switch p := ev.Payload.(type) {
+	case RatingChangedPayload:
+		d.mu.Lock()
+		w := d.weekLocked(isoWeekKey(ev.Timestamp))
+		st := w.users[p.UserID]
+		if st == nil {
+			st = &weekStats{StartRating: p.OldRating, PeakRating: p.OldRating}
+			w.users[p.UserID] = st
+		}
+		st.EndRating = p.NewRating
+		if d.store.Direction().Better(p.NewRating, st.PeakRating) {
+			st.PeakRating = p.NewRating
+		}
+		st.Updates++
+		d.mu.Unlock()
+	case RanksChangedPayload:
+		d.mu.Lock()
+		w := d.weekLocked(isoWeekKey(ev.Timestamp))
+		for _, c := range p.Changes {
+			st := w.users[c.UserID]
+			if st == nil {
+				// Moved only because others did: rating is unchanged
+				rating, _ := d.store.ratingOf(c.UserID)
+				st = &weekStats{StartRating: rating, EndRating: rating, PeakRating: rating}
+				w.users[c.UserID] = st
+			}
+			if st.StartRank == 0 {
+				st.StartRank, st.BestRank = c.OldRank, c.OldRank
+			}
+			st.EndRank = c.NewRank
+			if c.NewRank < st.BestRank {
+				st.BestRank = c.NewRank
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// ratingOf returns a user's current rating.
+func (s *UserStore) ratingOf(userID string) (int, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	user, exists := s.GetLocked(userID)
+	if !exists {
+		return 0, false
+	}
+	return user.Rating, true
+}
+
+var errWeekNotTracked = errors.New("week is not tracked")
+
+// Digest summarizes one user's week.
+func (d *DigestTracker) Digest(userID, week string, weekStart time.Time) (map[string]interface{}, error) {
+	d.store.RLock()
+	user, exists := d.store.GetLocked(userID)
+	var current User
+	if exists {
+		current = *user
+	}
+	d.store.RUnlock()
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	thisWeek := isoWeekKey(time.Now())
+	if week > thisWeek || (len(d.weeks) > 0 && week < d.weeks[0].key) || (len(d.weeks) == 0 && week != thisWeek) {
+		return nil, fmt.Errorf("%w: %s (digests cover the last %d weeks since startup)", errWeekNotTracked, week, d.retain)
+	}
+
+	// The week's own stats, or the start of the next week the user moved in
+	var st *weekStats
+	var next *weekStats
+	for _, w := range d.weeks {
+		if w.key == week {
+			st = w.users[userID]
+		} else if w.key > week && w.users[userID] != nil {
+			next = w.users[userID]
+			break
+		}
+	}
+	rating, rank := current.Rating, current.Rank // Standing at the end of the week
+	if next != nil {
+		rating = next.StartRating
+		if next.StartRank != 0 {
+			rank = next.StartRank
+		}
+	}
+	if st == nil {
+		st = &weekStats{StartRating: rating, EndRating: rating, PeakRating: rating}
+	}
+	if st.StartRank == 0 {
+		// No rank change seen this week: rank held all week
+		copied := *st
+		copied.StartRank, copied.EndRank, copied.BestRank = rank, rank, rank
+		st = &copied
+	}
+
+	return map[string]interface{}{
+		"userId":   current.ID,
+		"username": current.Username,
+		"week":     week,
+		"from":     weekStart.Format("2006-01-02"),
+		"to":       weekStart.AddDate(0, 0, 6).Format("2006-01-02"),
+		"current":  week == thisWeek,
+		"updates":  st.Updates,
+		"rating": map[string]interface{}{
+			"start":  scores.Number(st.StartRating),
+			"end":    scores.Number(st.EndRating),
+			"change": scores.Number(st.EndRating - st.StartRating),
+			"peak":   scores.Number(st.PeakRating),
+		},
+		// change > 0 means the user moved up
+		"rank": map[string]interface{}{
+			"start":  st.StartRank,
+			"end":    st.EndRank,
+			"change": st.StartRank - st.EndRank,
+			"best":   st.BestRank,
+		},
+	}, nil
+}
+
+// startDigests runs weekly digest tracking as the "digest" job.
+func startDigests(store *UserStore) {
+	digests = NewDigestTracker(store, envInt("MATIKS_DIGEST_WEEKS", 8))
+	digests.mu.Lock()
+	digests.weekLocked(isoWeekKey(time.Now()))
+	digests.mu.Unlock()
+
+	jobs.Register("digest", digests.run)
+	jobs.Run("digest")
+}
+
+// digestRequest parses /users/{id}/digest?week=.
+func digestRequest(r *http.Request) (map[string]interface{}, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/users/")
+	id := strings.TrimSuffix(rest, "/digest")
+	if id == rest || id == "" || strings.Contains(id, "/") {
+		return nil, errRouteNotFound
+	}
+
+	week, start := isoWeekKey(time.Now()), time.Time{}
+	if raw := strings.TrimSpace(r.URL.Query().Get("week")); raw != "" {
+		var err error
+		if week, start, err = parseWeek(raw); err != nil {
+			return nil, err
+		}
+	} else {
+		year, w := time.Now().UTC().ISOWeek()
+		start = isoWeekStart(year, w)
+	}
+	return digests.Digest(id, week, start)
+}
+
+var errRouteNotFound = errors.New("not found")
+
+// userDigestHandler serves GET /users/{id}/digest?week=2026-W07.
+func userDigestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	digest, err := digestRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"digest":  digest,
+	})
+}
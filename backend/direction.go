@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// MATIKS_LEADERBOARD_DIRECTION=asc ranks lower ratings first, for metrics
+// like solve time or error count. Everything that walks the ranking or
+// compares ratings for rank goes through the store's direction: the sort
+// key, the partial re-rank band, rating-band bounds, above/below counts for
+// liveRank and percentile, digest peaks and merges. Ties are unaffected;
+// they follow the tie break (see ties.go).
+//
+// It is the rating leaderboard's direction. Filtered boards and the
+// improvement board rank subsets of it and follow it. Each metric board
+// has its own in MATIKS_LEADERBOARD_DIRECTION_<BOARD>, defaulting to the
+// metric's ":asc" suffix, or highest first for a composite (see
+// metrics.go).
+
+// rankDirection says which ratings rank first.
+type rankDirection = leaderboard.Direction
+
+const (
+	rankDescending = leaderboard.Descending // Higher is better (default)
+	rankAscending  = leaderboard.Ascending  // Lower is better
+)
+
+var leaderboardDirection = envDirection("MATIKS_LEADERBOARD_DIRECTION", rankDescending)
+
+func envDirection(key string, def rankDirection) rankDirection {
+	d, err := leaderboard.ParseDirection(envString(key, string(def)))
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return d
+}
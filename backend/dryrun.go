@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"matiks-leaderboard/utils"
+)
+
+// ?dryRun=1 on POST /update and POST /admin/reseed validates the request
+// and reports what it would change without touching the store.
+//
+// /update draws its changes at random, so a dry run reports one
+// representative draw of the same size (from its own random source, so
+// seeded runs stay reproducible): how many ratings would move, the spread
+// of the deltas and how far the affected users' ranks would move. Ranks
+// are compared in competition order ("1224") whatever MATIKS_RANK_MODE
+// says. /admin/reseed is deterministic for a given seed, so its dry run
+// generates the exact dataset that seed would produce (pass one; without it
+// the dry run and a later reseed draw different seeds) and compares it with
+// the current one.
+
+const dryRunSampleSize = 10
+
+func dryRunParam(r *http.Request) (bool, error) {
+	return boolParam(r, "dryRun", false)
+}
+
+// dryRunChange is one user's rating change in a dry-run report.
+type dryRunChange struct {
+	UserID    string `json:"userId"`
+	Username  string `json:"username"`
+	OldRating int    `json:"oldRating"`
+	NewRating int    `json:"newRating"`
+	OldRank   int    `json:"oldRank"`
+	NewRank   int    `json:"newRank"`
+}
+
+// PlanRandomScores reports what updateRandomScores(count) could do.
+func (s *UserStore) PlanRandomScores(ctx context.Context, count int) (map[string]interface{}, error) {
+	if err := checkWritable(); err != nil {
+		return nil, err
+	}
+	if replica != nil {
+		return nil, errReadOnlyReplica
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	s.RLock()
+	defer s.RUnlock()
+	updates, sameRating, err := s.randomRatingUpdatesLocked(ctx, rng, count)
+	if err != nil {
+		return nil, err
+	}
+
+	// A user drawn twice ends on their last rating
+	final := make(map[string]int, len(updates))
+	for _, u := range updates {
+		final[u.UserID] = u.Rating
+	}
+	before := make([]int, 0, len(s.RankedLocked()))
+	after := make([]int, 0, len(s.RankedLocked()))
+	for _, u := range s.RankedLocked() {
+		before = append(before, u.Rating)
+		if rating, ok := final[u.ID]; ok {
+			after = append(after, rating)
+		} else {
+			after = append(after, u.Rating)
+		}
+	}
+	rankIn := s.competitionRanker(before)
+	rankAfter := s.competitionRanker(after)
+
+	changes := make([]dryRunChange, 0, len(final))
+	var up, down, sumAbs, minDelta, maxDelta int
+	var rankMoved, maxRise, maxFall int
+	for id, rating := range final {
+		u, _ := s.GetLocked(id)
+		c := dryRunChange{
+			UserID: id, Username: u.Username,
+			OldRating: u.Rating, NewRating: rating,
+			OldRank: rankIn(u.Rating), NewRank: rankAfter(rating),
+		}
+		changes = append(changes, c)
+
+		delta := rating - u.Rating
+		if delta > 0 {
+			up++
+		} else if delta < 0 {
+			down++
+		}
+		if delta < 0 {
+			sumAbs -= delta
+		} else {
+			sumAbs += delta
+		}
+		if len(changes) == 1 || delta < minDelta {
+			minDelta = delta
+		}
+		if len(changes) == 1 || delta > maxDelta {
+			maxDelta = delta
+		}
+		if moved := c.OldRank - c.NewRank; moved != 0 {
+			rankMoved++
+			if moved > maxRise {
+				maxRise = moved
+			}
+			if -moved > maxFall {
+				maxFall = -moved
+			}
+		}
+	}
+	// Biggest rank moves first in the sample
+	sort.Slice(changes, func(i, j int) bool {
+		mi, mj := abs(changes[i].OldRank-changes[i].NewRank), abs(changes[j].OldRank-changes[j].NewRank)
+		if mi != mj {
+			return mi > mj
+		}
+		return changes[i].UserID < changes[j].UserID
+	})
+	sample := changes
+	if len(sample) > dryRunSampleSize {
+		sample = sample[:dryRunSampleSize]
+	}
+	meanAbs := 0.0
+	if len(changes) > 0 {
+		meanAbs = scores.Float(sumAbs) / float64(len(changes))
+	}
+
+	return map[string]interface{}{
+		"dryRun":      true,
+		"attempted":   count,
+		"wouldChange": len(final),
+		"unchanged":   sameRating,
+		"deltas": map[string]interface{}{
+			"up":      up,
+			"down":    down,
+			"min":     scores.Number(minDelta),
+			"max":     scores.Number(maxDelta),
+			"meanAbs": meanAbs,
+		},
+		"ranks": map[string]interface{}{
+			"moved":   rankMoved,
+			"maxRise": maxRise,
+			"maxFall": maxFall,
+		},
+		"wouldSort": s.updateCount+int64(len(updates)) >= int64(s.sortThreshold),
+		"sample":    sample,
+	}, nil
+}
+
+// competitionRanker returns a rank lookup over ratings: one plus the number
+// of ratings better than the one asked about.
+func (s *UserStore) competitionRanker(ratings []int) func(int) int {
+	d := s.Direction()
+	sorted := append([]int(nil), ratings...)
+	sort.Slice(sorted, func(i, j int) bool { return d.Better(sorted[i], sorted[j]) })
+	return func(rating int) int {
+		return 1 + sort.Search(len(sorted), func(i int) bool { return !d.Better(sorted[i], rating) })
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// PlanReseed generates the dataset Reseed(seeder, count) would swap in and
+// compares it with the current one.
+func (s *UserStore) PlanReseed(ctx context.Context, seeder *utils.Seeder, count int) (map[string]interface{}, error) {
+	if err := checkWritable(); err != nil {
+		return nil, err
+	}
+	if replica != nil {
+		return nil, errReadOnlyReplica
+	}
+	users := make([]utils.SeedUser, 0, count)
+	for len(users) < count {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		batch := count - len(users)
+		if batch > reseedBatchSize {
+			batch = reseedBatchSize
+		}
+		users = append(users, seeder.Users(batch)...)
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	current := make([]int, 0, len(s.RankedLocked()))
+	for _, u := range s.RankedLocked() {
+		current = append(current, u.Rating)
+	}
+	next := make([]int, len(users))
+	kept, ratingChanged := 0, 0
+	for i, u := range users {
+		next[i] = u.Rating
+		if old, exists := s.GetLocked(u.ID); exists {
+			kept++
+			if old.Rating != u.Rating {
+				ratingChanged++
+			}
+		}
+	}
+	return map[string]interface{}{
+		"dryRun":  true,
+		"current": ratingSummary(current),
+		"next":    ratingSummary(next),
+		"ids": map[string]interface{}{
+			"kept":          kept,
+			"ratingChanged": ratingChanged,
+			"added":         len(users) - kept,
+			"removed":       len(current) - kept,
+		},
+		"deletedDropped": len(s.deleted), // Reseeding purges tombstones with the old users
+	}, nil
+}
+
+// ratingSummary is the count, range and mean of ratings.
+func ratingSummary(ratings []int) map[string]interface{} {
+	summary := map[string]interface{}{"users": len(ratings)}
+	if len(ratings) == 0 {
+		return summary
+	}
+	lo, hi, sum := ratings[0], ratings[0], 0
+	for _, r := range ratings {
+		if r < lo {
+			lo = r
+		}
+		if r > hi {
+			hi = r
+		}
+		sum += r
+	}
+	summary["minRating"] = scores.Number(lo)
+	summary["maxRating"] = scores.Number(hi)
+	summary["meanRating"] = scores.Float(sum) / float64(len(ratings))
+	return summary
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// Event types published on the store's event bus
+const (
+	EventRatingChanged = "rating_changed"
+	EventSortCompleted = "sort_completed"
+	EventRanksChanged  = "ranks_changed"
+	EventTop10Changed  = "top10_changed"
+)
+
+// Event uses the same {type, payload} shape the frontend websocket expects.
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Payload   interface{} `json:"payload"`
+}
+
+type RatingChangedPayload struct {
+	UserID    string `json:"userId"`
+	Username  string `json:"username"`
+	OldRating int    `json:"oldRating"`
+	NewRating int    `json:"newRating"`
+}
+
+// ratingChangedWire is RatingChangedPayload with scores as they are shown.
+type ratingChangedWire struct {
+	UserID    string      `json:"userId"`
+	Username  string      `json:"username"`
+	OldRating scoreNumber `json:"oldRating"`
+	NewRating scoreNumber `json:"newRating"`
+}
+
+func (p RatingChangedPayload) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ratingChangedWire{p.UserID, p.Username, scoreNumber(p.OldRating), scoreNumber(p.NewRating)})
+}
+
+func (p *RatingChangedPayload) UnmarshalJSON(data []byte) error {
+	var w ratingChangedWire
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*p = RatingChangedPayload{w.UserID, w.Username, int(w.OldRating), int(w.NewRating)}
+	return nil
+}
+
+type SortCompletedPayload struct {
+	Users      int     `json:"users"`
+	Reranked   int     `json:"reranked"` // Users inside the re-sorted rating band
+	DurationMs float64 `json:"durationMs"`
+}
+
+type RankChange = leaderboard.RankChange
+
+// RanksChangedPayload batches every rank movement from one sort.
+type RanksChangedPayload struct {
+	Changes []RankChange `json:"changes"`
+}
+
+type Top10ChangedPayload struct {
+	Users []User `json:"users"`
+}
+
+// EventBus fans events out to subscriber channels. Publish never blocks:
+// the store publishes while holding its write lock, so a slow subscriber
+// loses events (counted in Dropped) rather than stalling updates.
+type EventBus struct {
+	mu      sync.RWMutex
+	subs    map[chan Event]struct{}
+	dropped int64
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving every event published from now on.
+func (b *EventBus) Subscribe(buffer int) chan Event {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivery and closes the channel.
+func (b *EventBus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	b.mu.Unlock()
+}
+
+func (b *EventBus) Publish(eventType string, payload interface{}) {
+	if b == nil {
+		return
+	}
+	ev := Event{Type: eventType, Timestamp: time.Now(), Payload: payload}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			atomic.AddInt64(&b.dropped, 1)
+		}
+	}
+}
+
+func (b *EventBus) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// With MATIKS_INACTIVE_DAYS set, the "expire-inactive" job (on
+// MATIKS_SCHEDULE_EXPIRE_INACTIVE, hourly by default) takes users with no
+// activity (see activity.go) in that many days out of the leaderboard, so
+// the sorted slice and search indexes only hold active players. Users with
+// none since startup count from server start. What happens to
+// them is MATIKS_INACTIVE_ACTION:
+//
+//   - demote (default): the user moves to an inactive index, keeping their
+//     ID and rating, until POST /admin/users/{id}/reactivate puts them back
+//     (as active from then on). GET /admin/users/inactive lists them.
+//   - delete: the user is soft-deleted like DELETE /admin/users/{id}, and
+//     dropped for good by the purge job after the delete retention.
+//
+// Like other membership changes, expiry runs only where users can be
+// deleted: not on read replicas or raft clusters.
+
+var errUserNotInactive = errors.New("user is not inactive")
+
+type expiryPolicy struct {
+	days   int
+	action string // demote or delete
+}
+
+var inactivePolicy = loadExpiryPolicy()
+
+// expiryStart stands in for the last activity of users who have had none
+// since they were loaded.
+var expiryStart = time.Now()
+
+func loadExpiryPolicy() expiryPolicy {
+	p := expiryPolicy{
+		days:   envInt("MATIKS_INACTIVE_DAYS", 0),
+		action: strings.ToLower(envString("MATIKS_INACTIVE_ACTION", "demote")),
+	}
+	if p.action != "demote" && p.action != "delete" {
+		log.Printf("Expiry: unknown MATIKS_INACTIVE_ACTION %q, using demote", p.action)
+		p.action = "demote"
+	}
+	return p
+}
+
+func (p expiryPolicy) Describe() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":      p.days > 0,
+		"inactiveDays": p.days,
+		"action":       p.action,
+	}
+}
+
+// inactiveUser is a user the expiry policy demoted.
+type inactiveUser struct {
+	User         User      `json:"user"` // As of demotion
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	DemotedAt    time.Time `json:"demotedAt"`
+
+	user *User // Re-inserted by Reactivate
+}
+
+func lastActive(u *User) time.Time {
+	if last := activity.Get(u.ID); !last.IsZero() {
+		return last
+	}
+	return expiryStart
+}
+
+// ExpireInactive applies the policy to every user idle since before
+// now minus its days, and returns how many it removed.
+func (s *UserStore) ExpireInactive(ctx context.Context, p expiryPolicy, now time.Time) (int, error) {
+	if p.days <= 0 {
+		return 0, errJobSkipped
+	}
+	if err := s.checkMembershipChange(ctx); err != nil {
+		if errors.Is(err, errReadOnlyReplica) || errors.Is(err, errMembershipNotReplicated) {
+			return 0, errJobSkipped
+		}
+		return 0, err
+	}
+	cutoff := now.Add(-time.Duration(p.days) * 24 * time.Hour)
+
+	s.Lock()
+	defer s.Unlock()
+	gone := make(map[*User]bool)
+	for _, u := range s.RankedLocked() {
+		active := lastActive(u)
+		if !active.Before(cutoff) {
+			continue
+		}
+		gone[u] = true
+		if p.action == "delete" {
+			s.deleted[u.ID] = &deletedUser{User: *u, DeletedAt: now, PurgeAfter: now.Add(deleteRetention), user: u}
+		} else {
+			s.inactive[u.ID] = &inactiveUser{User: *u, LastActiveAt: active, DemotedAt: now, user: u}
+		}
+	}
+	s.removeUsersLocked(gone)
+	if len(gone) > 0 {
+		log.Printf("Expiry: %sd %d user(s) inactive for %d days", p.action, len(gone), p.days)
+	}
+	return len(gone), nil
+}
+
+// Reactivate puts a demoted user back on the leaderboard.
+func (s *UserStore) Reactivate(ctx context.Context, userID string) (User, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return User{}, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	demoted, exists := s.inactive[userID]
+	if !exists {
+		return User{}, errUserNotInactive
+	}
+	if _, taken := s.lookupUserLocked("", demoted.user.Username); taken || s.nameHeldLocked(demoted.user.Username, userID) {
+		return User{}, ErrDuplicateUsername
+	}
+	delete(s.inactive, userID)
+	activity.set(userID, time.Now()) // Or the next run demotes them again
+	s.insertUserLocked(demoted.user)
+	return *demoted.user, nil
+}
+
+// Inactive lists demoted users, longest idle first.
+func (s *UserStore) Inactive() []inactiveUser {
+	s.RLock()
+	defer s.RUnlock()
+	out := make([]inactiveUser, 0, len(s.inactive))
+	for _, demoted := range s.inactive {
+		out = append(out, *demoted)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].LastActiveAt.Equal(out[j].LastActiveAt) {
+			return out[i].LastActiveAt.Before(out[j].LastActiveAt)
+		}
+		return out[i].User.ID < out[j].User.ID
+	})
+	return out
+}
+
+// inactiveUsersRequest serves GET /admin/users/inactive.
+func inactiveUsersRequest(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+	users := userStore.Inactive()
+	total := len(users)
+	start, end := (page-1)*limit, page*limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return map[string]interface{}{
+		"policy": inactivePolicy.Describe(),
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+		"users":  users[start:end],
+	}, nil
+}
+
+func (s *UserStore) inactiveCount() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.inactive)
+}
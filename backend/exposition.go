@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync/atomic"
+)
+
+// GET /metrics exposes the counters behind /stats in the Prometheus text
+// format, for scrapers that can't read JSON. It belongs to the admin group,
+// so with MATIKS_ADMIN_ADDR set it is served on the admin listener only.
+
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		if s.label == "" {
+			fmt.Fprintf(w, "%s %v\n", name, s.value)
+		} else {
+			fmt.Fprintf(w, "%s{%s=%q} %v\n", name, s.label, s.labelValue, s.value)
+		}
+	}
+}
+
+type metricSample struct {
+	label, labelValue string
+	value             interface{}
+}
+
+func labeled(label string, counts map[string]int64) []metricSample {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]metricSample, len(keys))
+	for i, k := range keys {
+		samples[i] = metricSample{label, k, counts[k]}
+	}
+	return samples
+}
+
+func metricsExpositionHandler(w http.ResponseWriter, r *http.Request) {
+	byRoute, byProto := make(map[string]int64), make(map[string]int64)
+	latencies.mu.Lock()
+	for pattern, rl := range latencies.routes {
+		byRoute[pattern] = rl.count
+	}
+	for proto, pl := range latencies.protos {
+		byProto[proto] = pl.count
+	}
+	inFlight := latencies.inFlight
+	latencies.mu.Unlock()
+
+	limiter.mu.Lock()
+	rejected := limiter.rejected
+	limiter.mu.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "matiks_users", "gauge", "Users on the leaderboard.",
+		metricSample{value: atomic.LoadInt64(&userStore.totalUsers)})
+	writeMetric(w, "matiks_page_cache_hits_total", "counter", "Leaderboard pages served from the cache.",
+		metricSample{value: atomic.LoadInt64(&userStore.cacheHits)})
+	writeMetric(w, "matiks_page_cache_misses_total", "counter", "Leaderboard pages built from the store.",
+		metricSample{value: atomic.LoadInt64(&userStore.cacheMisses)})
+	writeMetric(w, "matiks_http_requests_total", "counter", "Requests handled, by route.", labeled("route", byRoute)...)
+	writeMetric(w, "matiks_http_protocol_requests_total", "counter", "Requests handled, by protocol.", labeled("proto", byProto)...)
+	writeMetric(w, "matiks_http_requests_in_flight", "gauge", "Requests being handled.", metricSample{value: inFlight})
+	writeMetric(w, "matiks_rate_limited_total", "counter", "Requests rejected by the rate limiter.", metricSample{value: rejected})
+	writeMetric(w, "matiks_go_goroutines", "gauge", "Goroutines.", metricSample{value: runtime.NumGoroutine()})
+	writeMetric(w, "matiks_go_heap_bytes", "gauge", "Bytes of allocated heap objects.", metricSample{value: mem.HeapAlloc})
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Feature flags gate newer request behaviors so they can be turned off, and
+// back on, without a redeploy:
+//
+//	cursor-pagination   ?cursor= on the leaderboards
+//	snapshot-reads      ?snapshot= on the leaderboards
+//	search-algorithms   ?algo= and the trie rollout on search (see searchalgos.go)
+//	response-projection ?project= on every route (see projection.go)
+//
+// A disabled flag makes its parameter a 400, except the trie rollout, which
+// falls back to the default algorithm. Each flag starts from its default
+// (all on), then MATIKS_FEATURES, e.g.
+// "snapshot-reads=off,cursor-pagination=on", then the runtime overrides set
+// at PUT /admin/features/{name} {"enabled": false}, which are kept in
+// MATIKS_DATA_DIR/features.json so a restart does not undo them. DELETE /admin/features/{name} drops the
+// override, and GET /admin/features lists every flag and where its state
+// came from.
+
+const featuresFile = "features.json"
+
+var errFeatureNotFound = errors.New("no such feature flag")
+
+type featureFlag struct {
+	name        string
+	description string
+	standard    bool
+	configured  *bool // From MATIKS_FEATURES
+
+	enabled   int32 // Atomic; read on every request
+	override  *bool // Guarded by features.mu
+	changedAt time.Time
+}
+
+// Enabled reports whether the flag is on.
+func (f *featureFlag) Enabled() bool {
+	return atomic.LoadInt32(&f.enabled) == 1
+}
+
+type featureFlags struct {
+	dir string
+
+	mu     sync.Mutex
+	byName map[string]*featureFlag
+}
+
+var features = &featureFlags{
+	dir:    envString("MATIKS_DATA_DIR", "data"),
+	byName: make(map[string]*featureFlag),
+}
+
+var (
+	featureCursorPagination   = features.register("cursor-pagination", true, "?cursor= on the leaderboards")
+	featureSnapshotReads      = features.register("snapshot-reads", true, "?snapshot= on the leaderboards")
+	featureSearchAlgorithms   = features.register("search-algorithms", true, "?algo= and the trie rollout on search")
+	featureResponseProjection = features.register("response-projection", true, "?project= on every route")
+)
+
+func (ff *featureFlags) register(name string, standard bool, description string) *featureFlag {
+	f := &featureFlag{name: name, description: description, standard: standard}
+	ff.byName[name] = f
+	f.applyLocked()
+	return f
+}
+
+// applyLocked sets enabled from the override, the config or the default.
+func (f *featureFlag) applyLocked() {
+	on := f.standard
+	if f.override != nil {
+		on = *f.override
+	} else if f.configured != nil {
+		on = *f.configured
+	}
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&f.enabled, v)
+}
+
+func parseFeatureState(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return strconv.ParseBool(raw)
+}
+
+type storedFeature struct {
+	Enabled   bool  `json:"enabled"`
+	ChangedAt int64 `json:"changedAt"`
+}
+
+// loadFeatures applies MATIKS_FEATURES and the stored runtime overrides.
+func loadFeatures() {
+	ff := features
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	for _, entry := range envList("MATIKS_FEATURES") {
+		name, raw, _ := strings.Cut(entry, "=")
+		f := ff.byName[strings.TrimSpace(name)]
+		on, err := parseFeatureState(strings.TrimSpace(raw))
+		if f == nil || err != nil {
+			log.Printf("Features: ignoring MATIKS_FEATURES entry %q", entry)
+			continue
+		}
+		f.configured = &on
+		f.applyLocked()
+	}
+
+	var stored struct {
+		Overrides map[string]storedFeature `json:"overrides"`
+	}
+	if err := readJSONFile(ff.dir, featuresFile, &stored); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Features: %v", err)
+		}
+		return
+	}
+	for name, o := range stored.Overrides {
+		f := ff.byName[name]
+		if f == nil {
+			log.Printf("Features: dropping override for unknown flag %q", name)
+			continue
+		}
+		on := o.Enabled
+		f.override, f.changedAt = &on, time.Unix(o.ChangedAt, 0)
+		f.applyLocked()
+	}
+}
+
+// saveLocked writes the runtime overrides.
+func (ff *featureFlags) saveLocked() error {
+	overrides := make(map[string]storedFeature)
+	for name, f := range ff.byName {
+		if f.override != nil {
+			overrides[name] = storedFeature{Enabled: *f.override, ChangedAt: f.changedAt.Unix()}
+		}
+	}
+	return writeJSONFile(ff.dir, featuresFile, map[string]interface{}{"overrides": overrides})
+}
+
+func (ff *featureFlags) describeLocked(f *featureFlag) map[string]interface{} {
+	source := "default"
+	if f.override != nil {
+		source = "runtime"
+	} else if f.configured != nil {
+		source = "config"
+	}
+	out := map[string]interface{}{
+		"name":        f.name,
+		"description": f.description,
+		"enabled":     f.Enabled(),
+		"default":     f.standard,
+		"source":      source,
+	}
+	if f.configured != nil {
+		out["configured"] = *f.configured
+	}
+	if f.override != nil {
+		out["changedAt"] = f.changedAt.Unix()
+	}
+	return out
+}
+
+func (ff *featureFlags) List() []map[string]interface{} {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	names := make([]string, 0, len(ff.byName))
+	for name := range ff.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]map[string]interface{}, len(names))
+	for i, name := range names {
+		out[i] = ff.describeLocked(ff.byName[name])
+	}
+	return out
+}
+
+// Set overrides a flag at runtime; nil drops the override.
+func (ff *featureFlags) Set(name string, on *bool) (map[string]interface{}, error) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	f := ff.byName[name]
+	if f == nil {
+		return nil, fmt.Errorf("%w: %q", errFeatureNotFound, name)
+	}
+	prev, prevAt := f.override, f.changedAt
+	f.override, f.changedAt = on, time.Now()
+	if err := ff.saveLocked(); err != nil {
+		f.override, f.changedAt = prev, prevAt
+		return nil, err
+	}
+	f.applyLocked()
+	state := "reset"
+	if on != nil {
+		state = strconv.FormatBool(*on)
+	}
+	log.Printf("Features: %s set to %s, now enabled=%v", name, state, f.Enabled())
+	return ff.describeLocked(f), nil
+}
+
+func (ff *featureFlags) Get(name string) (map[string]interface{}, error) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	f := ff.byName[name]
+	if f == nil {
+		return nil, fmt.Errorf("%w: %q", errFeatureNotFound, name)
+	}
+	return ff.describeLocked(f), nil
+}
+
+// featureParamError is the 400 for a parameter whose flag is off.
+func featureParamError(param string, f *featureFlag) error {
+	return &paramError{Param: param, Message: fmt.Sprintf("is disabled (feature flag %s)", f.name)}
+}
+
+// featuresRequest serves /admin/features and /admin/features/{name}.
+func featuresRequest(r *http.Request) (interface{}, error) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/features"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+		}
+		return map[string]interface{}{"features": features.List()}, nil
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return features.Get(name)
+	case http.MethodPut, http.MethodPost:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+			return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+		}
+		if body.Enabled == nil {
+			return nil, &paramError{Param: "enabled", Message: "is required"}
+		}
+		return features.Set(name, body.Enabled)
+	case http.MethodDelete:
+		return features.Set(name, nil)
+	default:
+		return nil, fmt.Errorf("%w: use GET, PUT or DELETE", errMethodNotAllowed)
+	}
+}
+
+func adminFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := featuresRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"result":    result,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+func v1AdminFeaturesHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+	data, err := featuresRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: data}, nil
+}
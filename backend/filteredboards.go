@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Filtered boards (?activeWithin=, ?joinedWithin=, ?maxVolatility=) rank a
+// subset of the leaderboard among itself. Each filter value keeps a copy
+// of its users in leaderboard order, re-ranked in the rank mode, that
+// requests page through by binary search. A copy is rebuilt with one pass
+// over the sorted list when ratings or the filter's own data have changed,
+// at most once per page-cache TTL, or when the filter says it goes stale;
+// requests in between do no scanning. Each filter keeps at most
+// maxBoardWindows copies, the least recently used dropped first. Filtered
+// boards page by number and combine with rating bands, but not with
+// cursors, snapshots or each other.
+
+const maxBoardWindows = 4
+
+// filteredBoard is one filter value's users, copied and re-ranked.
+type filteredBoard struct {
+	users        []*User
+	pendingSorts int64
+
+	head, resets uint64 // Change log position it was built at
+	version      uint64 // Version of the filter's data it was built at
+	builtAt      time.Time
+	expiresAt    time.Time // Rebuilt from then on; zero for never
+	usedAt       time.Time
+}
+
+// filteredBoards is one filter's boards by value. version reports when the
+// data the filter reads has changed.
+type filteredBoards struct {
+	param   string
+	version func() uint64
+
+	mu       sync.Mutex
+	boards   map[string]*filteredBoard
+	builds   int64
+	requests int64
+}
+
+func newFilteredBoards(param string, version func() uint64) *filteredBoards {
+	return &filteredBoards{param: param, version: version, boards: make(map[string]*filteredBoard)}
+}
+
+// boardFilter is one request's filter: the boards it pages and the value.
+type boardFilter struct {
+	*filteredBoards
+	raw string // As passed, for the response
+	key string
+
+	// build runs under the store's read lock and returns copies of the
+	// users to keep, in leaderboard order, and when they go stale.
+	build func(s *UserStore, now time.Time) ([]User, time.Time)
+}
+
+// filteredBoardParam reads ?activeWithin=, ?joinedWithin= or
+// ?maxVolatility=: the filter to page, or nil without one.
+func filteredBoardParam(r *http.Request) (*boardFilter, error) {
+	q := r.URL.Query()
+	param := ""
+	for _, name := range []string{"activeWithin", "joinedWithin", "maxVolatility"} {
+		if strings.TrimSpace(q.Get(name)) == "" {
+			continue
+		}
+		if param != "" {
+			return nil, &paramError{Param: name, Message: "pass only one of activeWithin, joinedWithin and maxVolatility"}
+		}
+		param = name
+	}
+	if param == "" {
+		return nil, nil
+	}
+	if _, cursored := q["cursor"]; cursored {
+		return nil, &paramError{Param: param, Message: "pages by number; pass either " + param + " or cursor, not both"}
+	}
+	if snap := q.Get("snapshot"); snap != "" && snap != "0" {
+		return nil, &paramError{Param: param, Message: "cannot be combined with snapshot"}
+	}
+	switch param {
+	case "maxVolatility":
+		return volatilityParam(r)
+	case "joinedWithin":
+		return newcomerBoards.filterParam(r)
+	default:
+		return activeBoards.filterParam(r)
+	}
+}
+
+// Page returns one page of band from the filter's board, building it if
+// needed.
+func (f *boardFilter) Page(ctx context.Context, s *UserStore, band ratingBand, page, limit int) ([]User, int, int, int64, error) {
+	board, err := f.get(ctx, s, f.key, func(now time.Time) ([]User, time.Time) { return f.build(s, now) })
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	users, total, totalPages := pageOfCopies(board.users, band, page, limit)
+	return users, total, totalPages, board.pendingSorts, nil
+}
+
+func (set *filteredBoards) get(ctx context.Context, s *UserStore, key string, build func(now time.Time) ([]User, time.Time)) (*filteredBoard, error) {
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+	head, resets := s.changes.head(), s.changes.resetCount()
+	version := set.version()
+	now := time.Now()
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.requests++
+	board := set.boards[key]
+	if board != nil && (board.expiresAt.IsZero() || now.Before(board.expiresAt)) {
+		unchanged := board.head == head && board.resets == resets && board.version == version
+		if unchanged || now.Sub(board.builtAt) < s.cacheTTL {
+			board.usedAt = now
+			return board, nil
+		}
+	}
+
+	copies, expiresAt := build(now)
+	board = &filteredBoard{
+		users:        make([]*User, len(copies)),
+		pendingSorts: s.updateCount,
+		head:         head,
+		resets:       resets,
+		version:      version,
+		builtAt:      now,
+		expiresAt:    expiresAt,
+		usedAt:       now,
+	}
+	prevRank := 0
+	for i := range copies {
+		u := &copies[i]
+		tied := i > 0 && copies[i-1].Rating == u.Rating
+		u.Rank = s.RankMode().Next(prevRank, i, tied)
+		prevRank = u.Rank
+		board.users[i] = u
+	}
+	set.builds++
+
+	if _, exists := set.boards[key]; !exists && len(set.boards) >= maxBoardWindows {
+		var lru *filteredBoard
+		var lruKey string
+		for k, b := range set.boards {
+			if lru == nil || b.usedAt.Before(lru.usedAt) {
+				lru, lruKey = b, k
+			}
+		}
+		delete(set.boards, lruKey)
+	}
+	set.boards[key] = board
+	return board, nil
+}
+
+func (set *filteredBoards) Stats() map[string]interface{} {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	sizes := make(map[string]int, len(set.boards))
+	for k, b := range set.boards {
+		sizes[k] = len(b.users)
+	}
+	return map[string]interface{}{
+		"boards":   sizes,
+		"builds":   set.builds,
+		"requests": set.requests,
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"matiks-leaderboard/leaderboardpb"
+)
+
+// grpcStreamBuffer is each stream's event buffer. A stream that falls this
+// far behind loses events (counted in the bus's Dropped) rather than
+// stalling the store.
+const grpcStreamBuffer = 1024
+
+// leaderboardServer implements the gRPC Leaderboard service.
+type leaderboardServer struct {
+	leaderboardpb.UnimplementedLeaderboardServer
+	store *UserStore
+}
+
+// StreamRankChanges sends deltas from the store's event bus, filtered the
+// same way as websocket subscriptions.
+func (s *leaderboardServer) StreamRankChanges(req *leaderboardpb.StreamRankChangesRequest, stream leaderboardpb.Leaderboard_StreamRankChangesServer) error {
+	filter := &wsFilter{MaxRank: int(req.MaxRank), Usernames: req.Usernames}
+	if err := filter.normalize(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	events := s.store.events.Subscribe(grpcStreamBuffer)
+	defer s.store.events.Unsubscribe(events)
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-events:
+			if ev.Type == EventRatingChanged && !req.IncludeRatingChanges && len(filter.watched) == 0 {
+				continue
+			}
+			if !filter.empty() {
+				var ok bool
+				if ev, ok = filter.apply(ev); !ok {
+					continue
+				}
+			}
+			for _, delta := range rankDeltas(ev) {
+				if err := stream.Send(delta); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// rankDeltas flattens a bus event into protobuf deltas.
+func rankDeltas(ev Event) []*leaderboardpb.RankDelta {
+	ts := ev.Timestamp.UnixMilli()
+	switch p := ev.Payload.(type) {
+	case RanksChangedPayload:
+		deltas := make([]*leaderboardpb.RankDelta, len(p.Changes))
+		for i, c := range p.Changes {
+			deltas[i] = &leaderboardpb.RankDelta{
+				Kind:        leaderboardpb.RankDelta_RANK_CHANGED,
+				UserId:      c.UserID,
+				Username:    c.Username,
+				OldRank:     int32(c.OldRank),
+				NewRank:     int32(c.NewRank),
+				TimestampMs: ts,
+			}
+		}
+		return deltas
+	case RatingChangedPayload:
+		return []*leaderboardpb.RankDelta{{
+			Kind:        leaderboardpb.RankDelta_RATING_CHANGED,
+			UserId:      p.UserID,
+			Username:    p.Username,
+			OldRating:   clampInt32(p.OldRating),
+			NewRating:   clampInt32(p.NewRating),
+			TimestampMs: ts,
+		}}
+	}
+	return nil
+}
+
+// clampInt32 fits a stored score into the protobuf's int32 fields. Float
+// scores arrive in stored units (score times 10^decimals).
+func clampInt32(v int) int32 {
+	switch {
+	case v > math.MaxInt32:
+		return math.MaxInt32
+	case v < math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(v)
+}
+
+// startGRPC serves the Leaderboard service on addr in the background.
+func startGRPC(store *UserStore, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer()
+	leaderboardpb.RegisterLeaderboardServer(srv, &leaderboardServer{store: store})
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("gRPC: server stopped: %v", err)
+		}
+	}()
+	log.Printf("gRPC: serving Leaderboard on %s", lis.Addr())
+	return nil
+}
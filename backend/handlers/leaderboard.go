@@ -1,10 +1,20 @@
 package handlers
 
 import (
-    "net/http"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"matiks-leaderboard/models"
 )
 
 func LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
-    w.Write([]byte(`{"message": "Leaderboard endpoint - use main service"}`))
-}
\ No newline at end of file
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Envelope{
+		Data: map[string]string{"message": "Leaderboard endpoint - use main service"},
+		Meta: map[string]interface{}{
+			"apiVersion": models.APIVersion,
+			"timestamp":  time.Now().Unix(),
+		},
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The "distribution-history" job (on MATIKS_SCHEDULE_DISTRIBUTION_HISTORY,
+// hourly by default) samples the rating histogram, in buckets
+// MATIKS_HISTORY_BUCKET_WIDTH rating units wide (default 100), and keeps
+// samples for MATIKS_HISTORY_RETAIN (default 30d) in
+// MATIKS_DATA_DIR/history, so a restart does not lose them.
+// GET /stats/history?metric=distribution&from=&to= returns the samples
+// taken between from and to (Unix seconds or RFC 3339, both optional),
+// oldest first, each with the users in every non-empty bucket.
+//
+// Sampling reads the per-rating counts of the aggregates (see
+// aggregates.go), not the users.
+
+const distributionHistoryFile = "distribution.json.gz"
+
+type histogramBucket struct {
+	Min   scoreNumber `json:"min"`
+	Count int         `json:"count"`
+}
+
+type distributionSample struct {
+	At      int64             `json:"at"`
+	Width   scoreNumber       `json:"width"`
+	Users   int               `json:"users"`
+	Buckets []histogramBucket `json:"buckets"`
+}
+
+type distributionHistory struct {
+	dir    string
+	width  int // Stored units
+	retain time.Duration
+
+	mu      sync.Mutex
+	loaded  bool
+	samples []distributionSample // Oldest first
+}
+
+var histories = &distributionHistory{
+	dir:    filepath.Join(envString("MATIKS_DATA_DIR", "data"), "history"),
+	width:  scores.Units(envInt("MATIKS_HISTORY_BUCKET_WIDTH", 100)),
+	retain: envDuration("MATIKS_HISTORY_RETAIN", 30*24*time.Hour),
+}
+
+// loadLocked reads the samples kept by earlier runs, once.
+func (h *distributionHistory) loadLocked() {
+	if h.loaded {
+		return
+	}
+	h.loaded = true
+	var stored struct {
+		Samples []distributionSample `json:"samples"`
+	}
+	if err := readJSONFile(h.dir, distributionHistoryFile, &stored); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("History: %v", err)
+		}
+		return
+	}
+	h.samples = stored.Samples
+}
+
+// Histogram counts users by bucket of width stored units.
+func (s *UserStore) Histogram(width int) (int, []histogramBucket) {
+	if width < 1 {
+		width = 1
+	}
+	s.RLock()
+	byMin := make(map[int]int)
+	for rating, n := range s.aggregates.counts {
+		min := rating - rating%width
+		if rating < 0 && rating%width != 0 {
+			min -= width
+		}
+		byMin[min] += n
+	}
+	users := s.aggregates.count
+	s.RUnlock()
+
+	buckets := make([]histogramBucket, 0, len(byMin))
+	for min, n := range byMin {
+		buckets = append(buckets, histogramBucket{Min: scoreNumber(min), Count: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Min < buckets[j].Min })
+	return users, buckets
+}
+
+// SampleDistribution records the current histogram and drops samples past
+// the retention.
+func (s *UserStore) SampleDistribution(h *distributionHistory, now time.Time) error {
+	users, buckets := s.Histogram(h.width)
+	sample := distributionSample{At: now.Unix(), Width: scoreNumber(h.width), Users: users, Buckets: buckets}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loadLocked()
+	cutoff := now.Add(-h.retain).Unix()
+	kept := h.samples[:0]
+	for _, old := range h.samples {
+		if old.At >= cutoff {
+			kept = append(kept, old)
+		}
+	}
+	h.samples = append(kept, sample)
+	return writeJSONFile(h.dir, distributionHistoryFile, map[string]interface{}{"samples": h.samples})
+}
+
+// Between returns the samples taken in [from, to].
+func (h *distributionHistory) Between(from, to time.Time) []distributionSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loadLocked()
+	out := []distributionSample{}
+	for _, sample := range h.samples {
+		if sample.At >= from.Unix() && sample.At <= to.Unix() {
+			out = append(out, sample)
+		}
+	}
+	return out
+}
+
+func (h *distributionHistory) Stats() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loadLocked()
+	stats := map[string]interface{}{
+		"samples": len(h.samples),
+		"retain":  h.retain.String(),
+	}
+	if len(h.samples) > 0 {
+		stats["oldest"] = h.samples[0].At
+		stats["newest"] = h.samples[len(h.samples)-1].At
+	}
+	return stats
+}
+
+// historyMetrics are the series GET /stats/history serves.
+var historyMetrics = []string{"distribution"}
+
+// historyRequest serves GET /stats/history.
+func historyRequest(r *http.Request) (map[string]interface{}, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	metric := strings.TrimSpace(r.URL.Query().Get("metric"))
+	if metric != "distribution" {
+		return nil, &paramError{Param: "metric", Message: fmt.Sprintf("must be one of %s, got %q", strings.Join(historyMetrics, ", "), metric)}
+	}
+	from, _, err := timeParam(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, bounded, err := timeParam(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	if !bounded {
+		to = time.Now()
+	}
+	if to.Before(from) {
+		return nil, &paramError{Param: "to", Message: "must not be before from"}
+	}
+	return map[string]interface{}{
+		"metric":  metric,
+		"samples": histories.Between(from, to),
+	}, nil
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	history, err := historyRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	history["success"] = true
+	history["timestamp"] = time.Now().Unix()
+	json.NewEncoder(w).Encode(history)
+}
+
+func v1HistoryHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	history, err := historyRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: history}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// Hooks let code built into the server react to the store's lifecycle
+// without forking it, for achievements, audit logs or syncing to another
+// system:
+//
+//	hooks.OnRatingChange(func(ctx context.Context, user User, oldRating, newRating int) {
+//		if oldRating < 3000 && newRating >= 3000 {
+//			grantBadge(ctx, user.ID, "grandmaster")
+//		}
+//	})
+//
+// OnUserCreate hooks see users added at /admin/users or imported (not
+// seeded or restored ones), before they are ranked; OnRatingChange hooks
+// every rating change whatever its source; and OnSort hooks each sort with
+// the ranks it changed. Register
+// hooks before the server starts; registering later is safe but misses
+// what already happened.
+//
+// The hooks live in the ranking engine (see leaderboard/hooks.go), which
+// queues a call only while it holds its lock. The "hooks" job runs them
+// one at a time, in the order the changes happened, so hooks may read the
+// store and a slow hook delays later hooks but never an update. Each call
+// gets a context that ends after MATIKS_HOOK_TIMEOUT (default 5s) or at
+// shutdown, and a panicking hook is logged and skipped. At most
+// MATIKS_HOOK_QUEUE calls (default 100000) wait; more are dropped and
+// counted. MATIKS_LOG_HOOKS=true registers hooks that log every call.
+
+type UserCreateHook = leaderboard.UserCreateHook[User]
+
+type RatingChangeHook = leaderboard.RatingChangeHook[User]
+
+// SortInfo describes one completed sort.
+type SortInfo = leaderboard.SortInfo
+
+type SortHook = leaderboard.SortHook
+
+type HookRegistry = leaderboard.Hooks[User]
+
+var hooks = leaderboard.NewHooks[User](
+	envDuration("MATIKS_HOOK_TIMEOUT", 5*time.Second),
+	envInt("MATIKS_HOOK_QUEUE", 100000),
+	func(p any, stack []byte) { log.Printf("Hooks: hook panicked: %v\n%s", p, stack) },
+)
+
+// startHooks runs queued hook calls as the "hooks" job.
+func startHooks() {
+	if envBool("MATIKS_LOG_HOOKS", false) {
+		registerLoggingHooks(hooks)
+	}
+	jobs.Register("hooks", hooks.Run)
+	jobs.Run("hooks")
+}
+
+func registerLoggingHooks(h *HookRegistry) {
+	h.OnUserCreate(func(ctx context.Context, user User) {
+		log.Printf("Hook: user %s (%s) created at %s", user.ID, user.Username, scores.Number(user.Rating))
+	})
+	h.OnRatingChange(func(ctx context.Context, user User, oldRating, newRating int) {
+		log.Printf("Hook: %s rating %s -> %s", user.ID, scores.Number(oldRating), scores.Number(newRating))
+	})
+	h.OnSort(func(ctx context.Context, info SortInfo) {
+		log.Printf("Hook: sorted %d users (%d reranked) in %v, %d rank changes", info.Users, info.Reranked, info.Duration, len(info.RankChanges))
+	})
+}
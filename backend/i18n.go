@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+
+	"matiks-leaderboard/models"
+)
+
+// Error messages and push notification text follow the client's
+// Accept-Language. A catalog maps message keys to text in one language:
+// error codes ("user_not_found"), field error codes ("too_short", see
+// validation.go) and push templates ("push.dropped_tier.title"), where
+// {name} stands for an argument. English is what the code writes; a key
+// missing from the negotiated catalog keeps the English text, and a
+// response with anything translated says so in Content-Language.
+//
+// Translated messages are generic: a range error no longer spells out the
+// range. The code, param, fields and details still carry the specifics,
+// untranslated.
+//
+// Catalogs for es, fr, de and pt are built in. MATIKS_I18N_DIR adds or
+// overrides catalogs from <language>.json files there, e.g. es.json:
+//
+//	{"user_not_found": "No encontramos a ese usuario"}
+//
+// Push devices register in a language (?lang=, or the Accept-Language of
+// the registration) and get their notifications in it.
+
+type catalog map[string]string
+
+var builtinCatalogs = map[string]catalog{
+	"es": {
+		"validation_failed":    "Algunos datos no son válidos",
+		"invalid_parameter":    "El parámetro {param} no es válido",
+		"username_rejected":    "Ese nombre de usuario no está permitido",
+		"user_not_found":       "Usuario no encontrado",
+		"rating_out_of_range":  "La puntuación está fuera del rango permitido",
+		"not_found":            "No existe ese recurso",
+		"method_not_allowed":   "Método no permitido",
+		"read_only_replica":    "Esta réplica es de solo lectura",
+		"user_exists":          "Ya existe un usuario con ese ID",
+		"username_taken":       "Ese nombre de usuario ya está en uso",
+		"unauthorized":         "Falta el token de administrador o no es correcto",
+		"forbidden":            "Esta ruta solo responde a peticiones locales",
+		"rate_limited":         "Demasiadas peticiones; vuelve a intentarlo en {retryAfter} s",
+		"degraded":             "El servicio no está disponible temporalmente",
+		"search_timeout":       "La búsqueda ha tardado demasiado",
+		"job_not_found":        "No existe ese trabajo",
+		"job_running":          "El trabajo ya se está ejecutando",
+		"job_not_running":      "El trabajo no se está ejecutando",
+		"job_needs_parameters": "Ese trabajo necesita parámetros y no se puede lanzar directamente",
+		"not_leader":           "Este nodo no es el líder; envía las escrituras al líder",
+		"push_disabled":        "Las notificaciones push no están configuradas",
+		"internal":             "Error interno del servidor",
+		"invalid":              "No es válido",
+		"too_short":            "Es demasiado corto",
+		"too_long":             "Es demasiado largo",
+		"bad_character":        "Contiene caracteres no permitidos",
+		"bad_start":            "Debe empezar por una letra o un número",
+		"reserved":             "Está reservado",
+		"profanity":            "Contiene lenguaje ofensivo",
+		"confusable":           "Se confunde con otro nombre",
+		"mixed_script":         "Mezcla letras de distintos alfabetos",
+
+		"push.dropped_tier.title": "Has salido del top {tier}",
+		"push.dropped_tier.body":  "Ahora estás en el puesto #{rank}.",
+		"push.overtaken.title":    "{username} te ha adelantado",
+		"push.overtaken.body":     "{username} está en el puesto #{theirRank}; tú, en el #{rank}.",
+	},
+	"fr": {
+		"validation_failed":    "Certaines données sont invalides",
+		"invalid_parameter":    "Le paramètre {param} est invalide",
+		"username_rejected":    "Ce nom d'utilisateur n'est pas autorisé",
+		"user_not_found":       "Utilisateur introuvable",
+		"rating_out_of_range":  "Le score est hors de la plage autorisée",
+		"not_found":            "Cette ressource n'existe pas",
+		"method_not_allowed":   "Méthode non autorisée",
+		"read_only_replica":    "Cette réplique est en lecture seule",
+		"user_exists":          "Un utilisateur avec cet ID existe déjà",
+		"username_taken":       "Ce nom d'utilisateur est déjà pris",
+		"unauthorized":         "Jeton d'administration absent ou incorrect",
+		"forbidden":            "Cette route ne répond qu'aux requêtes locales",
+		"rate_limited":         "Trop de requêtes ; réessayez dans {retryAfter} s",
+		"degraded":             "Le service est temporairement indisponible",
+		"search_timeout":       "La recherche a pris trop de temps",
+		"job_not_found":        "Cette tâche n'existe pas",
+		"job_running":          "La tâche est déjà en cours",
+		"job_not_running":      "La tâche n'est pas en cours",
+		"job_needs_parameters": "Cette tâche a besoin de paramètres et ne peut pas être lancée directement",
+		"not_leader":           "Ce nœud n'est pas le leader ; envoyez les écritures au leader",
+		"push_disabled":        "Les notifications push ne sont pas configurées",
+		"internal":             "Erreur interne du serveur",
+		"invalid":              "N'est pas valide",
+		"too_short":            "Est trop court",
+		"too_long":             "Est trop long",
+		"bad_character":        "Contient des caractères non autorisés",
+		"bad_start":            "Doit commencer par une lettre ou un chiffre",
+		"reserved":             "Est réservé",
+		"profanity":            "Contient un langage offensant",
+		"confusable":           "Peut être confondu avec un autre nom",
+		"mixed_script":         "Mélange des lettres de plusieurs alphabets",
+
+		"push.dropped_tier.title": "Vous êtes sorti du top {tier}",
+		"push.dropped_tier.body":  "Vous êtes maintenant classé n°{rank}.",
+		"push.overtaken.title":    "{username} vous a dépassé",
+		"push.overtaken.body":     "{username} est maintenant n°{theirRank} ; vous êtes n°{rank}.",
+	},
+	"de": {
+		"validation_failed":    "Einige Angaben sind ungültig",
+		"invalid_parameter":    "Der Parameter {param} ist ungültig",
+		"username_rejected":    "Dieser Benutzername ist nicht erlaubt",
+		"user_not_found":       "Benutzer nicht gefunden",
+		"rating_out_of_range":  "Die Wertung liegt außerhalb des erlaubten Bereichs",
+		"not_found":            "Diese Ressource gibt es nicht",
+		"method_not_allowed":   "Methode nicht erlaubt",
+		"read_only_replica":    "Dieses Replikat ist schreibgeschützt",
+		"user_exists":          "Ein Benutzer mit dieser ID existiert bereits",
+		"username_taken":       "Dieser Benutzername ist bereits vergeben",
+		"unauthorized":         "Admin-Token fehlt oder ist falsch",
+		"forbidden":            "Diese Route beantwortet nur lokale Anfragen",
+		"rate_limited":         "Zu viele Anfragen; bitte in {retryAfter} s erneut versuchen",
+		"degraded":             "Der Dienst ist vorübergehend nicht verfügbar",
+		"search_timeout":       "Die Suche hat zu lange gedauert",
+		"job_not_found":        "Diesen Job gibt es nicht",
+		"job_running":          "Der Job läuft bereits",
+		"job_not_running":      "Der Job läuft nicht",
+		"job_needs_parameters": "Dieser Job braucht Parameter und kann nicht direkt gestartet werden",
+		"not_leader":           "Dieser Knoten ist nicht der Leader; Schreibzugriffe an den Leader senden",
+		"push_disabled":        "Push-Benachrichtigungen sind nicht eingerichtet",
+		"internal":             "Interner Serverfehler",
+		"invalid":              "Ist ungültig",
+		"too_short":            "Ist zu kurz",
+		"too_long":             "Ist zu lang",
+		"bad_character":        "Enthält nicht erlaubte Zeichen",
+		"bad_start":            "Muss mit einem Buchstaben oder einer Ziffer beginnen",
+		"reserved":             "Ist reserviert",
+		"profanity":            "Enthält anstößige Wörter",
+		"confusable":           "Ist mit einem anderen Namen verwechselbar",
+		"mixed_script":         "Mischt Buchstaben verschiedener Schriften",
+
+		"push.dropped_tier.title": "Du bist aus den Top {tier} gefallen",
+		"push.dropped_tier.body":  "Du bist jetzt auf Platz {rank}.",
+		"push.overtaken.title":    "{username} hat dich überholt",
+		"push.overtaken.body":     "{username} ist jetzt auf Platz {theirRank}, du auf Platz {rank}.",
+	},
+	"pt": {
+		"validation_failed":    "Alguns dados são inválidos",
+		"invalid_parameter":    "O parâmetro {param} é inválido",
+		"username_rejected":    "Esse nome de usuário não é permitido",
+		"user_not_found":       "Usuário não encontrado",
+		"rating_out_of_range":  "A pontuação está fora do intervalo permitido",
+		"not_found":            "Esse recurso não existe",
+		"method_not_allowed":   "Método não permitido",
+		"read_only_replica":    "Esta réplica é somente leitura",
+		"user_exists":          "Já existe um usuário com esse ID",
+		"username_taken":       "Esse nome de usuário já está em uso",
+		"unauthorized":         "Token de administrador ausente ou incorreto",
+		"forbidden":            "Esta rota só responde a requisições locais",
+		"rate_limited":         "Requisições demais; tente novamente em {retryAfter} s",
+		"degraded":             "O serviço está temporariamente indisponível",
+		"search_timeout":       "A busca demorou demais",
+		"job_not_found":        "Essa tarefa não existe",
+		"job_running":          "A tarefa já está em execução",
+		"job_not_running":      "A tarefa não está em execução",
+		"job_needs_parameters": "Essa tarefa precisa de parâmetros e não pode ser iniciada diretamente",
+		"not_leader":           "Este nó não é o líder; envie as gravações ao líder",
+		"push_disabled":        "As notificações push não estão configuradas",
+		"internal":             "Erro interno do servidor",
+		"invalid":              "Não é válido",
+		"too_short":            "É curto demais",
+		"too_long":             "É longo demais",
+		"bad_character":        "Contém caracteres não permitidos",
+		"bad_start":            "Deve começar com uma letra ou um número",
+		"reserved":             "É reservado",
+		"profanity":            "Contém linguagem ofensiva",
+		"confusable":           "Pode ser confundido com outro nome",
+		"mixed_script":         "Mistura letras de alfabetos diferentes",
+
+		"push.dropped_tier.title": "Você saiu do top {tier}",
+		"push.dropped_tier.body":  "Agora você está em #{rank}.",
+		"push.overtaken.title":    "{username} passou você",
+		"push.overtaken.body":     "{username} agora está em #{theirRank}; você está em #{rank}.",
+	},
+}
+
+// localeSet holds the catalogs and the matcher over their languages.
+type localeSet struct {
+	names    []string // names[0] is "en", which has no catalog
+	catalogs map[string]catalog
+	matcher  language.Matcher
+}
+
+var locales = loadLocales(envString("MATIKS_I18N_DIR", ""))
+
+func loadLocales(dir string) *localeSet {
+	catalogs := make(map[string]catalog, len(builtinCatalogs))
+	for name, c := range builtinCatalogs {
+		catalogs[name] = c
+	}
+	if dir != "" {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		for _, path := range paths {
+			name, c, err := readCatalog(path)
+			if err != nil {
+				log.Printf("i18n: skipping %s: %v", path, err)
+				continue
+			}
+			merged := make(catalog, len(catalogs[name])+len(c))
+			for k, v := range catalogs[name] {
+				merged[k] = v
+			}
+			for k, v := range c {
+				merged[k] = v
+			}
+			catalogs[name] = merged
+		}
+	}
+
+	l := &localeSet{names: []string{"en"}, catalogs: catalogs}
+	for name := range catalogs {
+		if name != "en" {
+			l.names = append(l.names, name)
+		}
+	}
+	sort.Strings(l.names[1:])
+	tags := make([]language.Tag, len(l.names))
+	for i, name := range l.names {
+		tags[i] = language.Make(name)
+	}
+	l.matcher = language.NewMatcher(tags)
+	return l
+}
+
+// readCatalog reads one <language>.json file.
+func readCatalog(path string) (string, catalog, error) {
+	tag, err := language.Parse(strings.TrimSuffix(filepath.Base(path), ".json"))
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", nil, err
+	}
+	return tag.String(), c, nil
+}
+
+// negotiate picks a catalog for an Accept-Language value or ?lang=, or
+// returns "en".
+func (l *localeSet) negotiate(accept string) string {
+	_, i := language.MatchStrings(l.matcher, accept)
+	return l.names[i]
+}
+
+// text renders key in lang with args filled in, if the catalog has it.
+func (l *localeSet) text(lang, key string, args map[string]string) (string, bool) {
+	text, ok := l.catalogs[lang][key]
+	if !ok {
+		return "", false
+	}
+	for name, value := range args {
+		text = strings.ReplaceAll(text, "{"+name+"}", value)
+	}
+	return text, true
+}
+
+// translate returns key in the language r asks for, or fallback.
+func translate(w http.ResponseWriter, r *http.Request, key, fallback string, args map[string]string) string {
+	lang := locales.negotiate(r.Header.Get("Accept-Language"))
+	if text, ok := locales.text(lang, key, args); ok {
+		w.Header().Set("Content-Language", lang)
+		return text
+	}
+	return fallback
+}
+
+// localizeError translates an error's message and its field messages.
+func localizeError(w http.ResponseWriter, r *http.Request, apiErr *models.APIError) {
+	if r.Header.Get("Accept-Language") == "" {
+		return
+	}
+	apiErr.Message = translate(w, r, apiErr.Code, apiErr.Message, map[string]string{"param": apiErr.Param})
+	for i, f := range apiErr.Fields {
+		apiErr.Fields[i].Message = translate(w, r, f.Code, f.Message, map[string]string{"param": f.Field})
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// POST /admin/users/import creates users from a CSV file, sent either as
+// the "file" field of a multipart/form-data upload or as a text/csv body:
+//
+//	id,username,rating
+//	u-1001,alice,1840
+//	u-1002,bob,
+//
+// The header names the columns, in any order; id and username are
+// required and a missing or empty rating gets the default. Rows are
+// checked like POST /admin/users (ID format, rating range, the username
+// policy, IDs and names already taken, including earlier in the file), and
+// a failed row lists its offending fields as well as the reason.
+//
+// The upload is read as it arrives, never held whole: rows are parsed one
+// at a time and applied ?chunkSize= at a time (default 1000, at most
+// 10000), each chunk under one lock with one re-rank. A bad row doesn't
+// stop the import; the response counts the rows read, imported and
+// failed, and lists each failure with its line number and reason, the
+// first MATIKS_IMPORT_MAX_ERRORS (default 1000) of them. If the store stops
+// taking changes part way, chunks already applied stay and the response
+// says where it stopped. Uploads are capped at MATIKS_IMPORT_MAX_BYTES
+// (default 1GiB).
+//
+// ?dryRun=1 runs the same checks chunk by chunk under the read lock,
+// counting a row's ID and name as taken for the rows after it, and reports
+// how many rows would be imported, a sample of them and the failures,
+// without creating anyone.
+
+const (
+	defaultImportChunk = 1000
+	maxImportChunk     = 10000
+)
+
+var (
+	importMaxErrors = envInt("MATIKS_IMPORT_MAX_ERRORS", 1000)
+	importMaxBytes  = int64(envInt("MATIKS_IMPORT_MAX_BYTES", 1<<30))
+)
+
+// importRow is one parsed line of an import.
+type importRow struct {
+	line     int
+	id       string
+	username string
+	rating   int
+}
+
+// importError is a row that was not imported.
+type importError struct {
+	Line   int                 `json:"line"`
+	ID     string              `json:"id,omitempty"`
+	Reason string              `json:"reason"`
+	Fields []models.FieldError `json:"fields,omitempty"`
+}
+
+func rowError(line int, id string, err error) importError {
+	return importError{Line: line, ID: id, Reason: err.Error(), Fields: fieldErrors(err)}
+}
+
+// importClaims are the IDs and folded names a dry run's earlier rows would
+// have taken.
+type importClaims struct {
+	ids   map[string]bool
+	names map[string]bool
+}
+
+// checkImportNames normalizes the rows' usernames and turns away those the
+// policy rejects. It runs before taking the lock, since the confusable rule
+// reads the leaders.
+func (s *UserStore) checkImportNames(rows []importRow) ([]importRow, []importError) {
+	var rejected []importError
+	valid := make([]importRow, 0, len(rows))
+	for _, row := range rows {
+		row.username = normalizeUsername(row.username)
+		if err := usernamePolicy.Check(s, "", row.username); err != nil {
+			rejected = append(rejected, rowError(row.line, row.id, err))
+			continue
+		}
+		valid = append(valid, row)
+	}
+	return valid, rejected
+}
+
+// importConflictLocked reports whether row's ID or name is taken, by a
+// user in any state or by an earlier row in claimed.
+func (s *UserStore) importConflictLocked(row importRow, claimed importClaims) error {
+	_, active := s.GetLocked(row.id)
+	_, deleted := s.deleted[row.id]
+	_, inactive := s.inactive[row.id]
+	if active || deleted || inactive || claimed.ids[row.id] {
+		return ErrDuplicateID
+	}
+	if _, taken := s.lookupUserLocked("", row.username); taken || s.nameHeldLocked(row.username, "") || claimed.names[foldUsername(row.username)] {
+		return ErrDuplicateUsername
+	}
+	return nil
+}
+
+// ImportUsers creates the users in rows with one re-rank, and returns the
+// rows it turned away.
+func (s *UserStore) ImportUsers(ctx context.Context, rows []importRow) (int, []importError, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return 0, nil, err
+	}
+	valid, rejected := s.checkImportNames(rows)
+
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	var created []*User
+	for _, row := range valid {
+		if err := s.importConflictLocked(row, importClaims{}); err != nil {
+			rejected = append(rejected, rowError(row.line, row.id, err))
+			continue
+		}
+		user := &User{ID: row.id, Username: row.username, Rating: row.rating}
+		if err := s.addUserLocked(user); err != nil {
+			rejected = append(rejected, rowError(row.line, row.id, err))
+			continue
+		}
+		created = append(created, user)
+	}
+	if len(created) > 0 {
+		s.membershipChangedLocked(int64(len(created)))
+	}
+	for _, user := range created {
+		joins.set(user.ID, now)
+	}
+	return len(created), rejected, nil
+}
+
+// PlanImport is ImportUsers without the write: the same checks under the
+// read lock, returning the rows that would be created and adding them to
+// claimed for the chunks after.
+func (s *UserStore) PlanImport(ctx context.Context, rows []importRow, claimed importClaims) ([]importRow, []importError, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	valid, rejected := s.checkImportNames(rows)
+
+	s.RLock()
+	defer s.RUnlock()
+	var accepted []importRow
+	for _, row := range valid {
+		if err := s.importConflictLocked(row, claimed); err != nil {
+			rejected = append(rejected, rowError(row.line, row.id, err))
+			continue
+		}
+		claimed.ids[row.id] = true
+		claimed.names[foldUsername(row.username)] = true
+		accepted = append(accepted, row)
+	}
+	return accepted, rejected, nil
+}
+
+// userImport tallies one import request.
+type userImport struct {
+	rows      int
+	imported  int
+	failed    int
+	chunks    int
+	errors    []importError
+	truncated bool
+
+	dryRun  bool
+	claimed importClaims
+	sample  []map[string]interface{}
+}
+
+func (im *userImport) reject(errs ...importError) {
+	im.failed += len(errs)
+	for _, e := range errs {
+		if len(im.errors) >= importMaxErrors {
+			im.truncated = true
+			return
+		}
+		im.errors = append(im.errors, e)
+	}
+}
+
+func (im *userImport) apply(ctx context.Context, chunk []importRow) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+	if im.dryRun {
+		return im.plan(ctx, chunk)
+	}
+	n, rejected, err := userStore.ImportUsers(ctx, chunk)
+	if err != nil {
+		return err
+	}
+	im.imported += n
+	im.chunks++
+	im.reject(rejected...)
+	return nil
+}
+
+func (im *userImport) plan(ctx context.Context, chunk []importRow) error {
+	if im.claimed.ids == nil {
+		im.claimed = importClaims{ids: make(map[string]bool), names: make(map[string]bool)}
+	}
+	accepted, rejected, err := userStore.PlanImport(ctx, chunk, im.claimed)
+	if err != nil {
+		return err
+	}
+	im.imported += len(accepted)
+	im.chunks++
+	im.reject(rejected...)
+	for _, row := range accepted {
+		if len(im.sample) == dryRunSampleSize {
+			break
+		}
+		im.sample = append(im.sample, map[string]interface{}{
+			"line": row.line, "id": row.id, "username": row.username, "rating": scores.Number(row.rating),
+		})
+	}
+	return nil
+}
+
+// importColumns maps the header to column indexes; rating is -1 if absent.
+func importColumns(header []string) (id, username, rating int, err error) {
+	id, username, rating = -1, -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "id":
+			id = i
+		case "username":
+			username = i
+		case "rating":
+			rating = i
+		default:
+			return 0, 0, 0, &paramError{Param: "file", Message: fmt.Sprintf("unknown column %q in the header; use id, username and rating", name)}
+		}
+	}
+	if id < 0 || username < 0 {
+		return 0, 0, 0, &paramError{Param: "file", Message: "header must name the id and username columns"}
+	}
+	return id, username, rating, nil
+}
+
+// parseImportRow checks one record against the columns.
+func parseImportRow(record []string, line, idCol, nameCol, ratingCol int) (importRow, error) {
+	row := importRow{line: line, rating: scores.Default}
+	field := func(col int) string {
+		if col < 0 || col >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[col])
+	}
+	row.id, row.username = field(idCol), field(nameCol)
+	invalid := &validationError{}
+	invalid.add(checkUserID(row.id))
+	if raw := field(ratingCol); raw != "" {
+		rating, err := scores.Parse(raw)
+		if err != nil {
+			invalid.add(&paramError{Param: "rating", Message: err.Error()})
+		} else {
+			invalid.add(checkRating(rating))
+			row.rating = rating
+		}
+	}
+	return row, invalid.orNil()
+}
+
+// importFile finds the CSV in the request body without buffering it.
+func importFile(r *http.Request) (io.Reader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil && r.Header.Get("Content-Type") != "" {
+		return nil, &paramError{Param: "Content-Type", Message: err.Error()}
+	}
+	switch mediaType {
+	case "text/csv", "":
+		return r.Body, nil
+	case "multipart/form-data":
+	default:
+		return nil, &paramError{Param: "Content-Type", Message: fmt.Sprintf("must be multipart/form-data or text/csv, got %q", mediaType)}
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, &paramError{Param: "body", Message: err.Error()}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, &paramError{Param: "file", Message: "the upload has no \"file\" field"}
+		}
+		if err != nil {
+			return nil, &paramError{Param: "body", Message: err.Error()}
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+func importReadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &paramError{Param: "file", Message: fmt.Sprintf("is larger than %d bytes", tooLarge.Limit)}
+	}
+	return &paramError{Param: "file", Message: "reading the upload: " + err.Error()}
+}
+
+// importUsersRequest serves POST /admin/users/import.
+func importUsersRequest(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("%w: use POST", errMethodNotAllowed)
+	}
+	chunkSize, err := intParam(r, "chunkSize", defaultImportChunk, 1, maxImportChunk)
+	if err != nil {
+		return nil, err
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := userStore.checkMembershipChange(r.Context()); err != nil {
+		return nil, err
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, importMaxBytes)
+	file, err := importFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &paramError{Param: "file", Message: "is empty; the first line must be a header"}
+	}
+	if err != nil {
+		return nil, importReadError(err)
+	}
+	idCol, nameCol, ratingCol, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	im := &userImport{dryRun: dryRun}
+	chunk := make([]importRow, 0, chunkSize)
+	var stopped error
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			im.rows++
+			im.reject(importError{Line: parseErr.Line, Reason: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			stopped = importReadError(err) // The upload itself broke off
+			break
+		}
+		im.rows++
+		line, _ := reader.FieldPos(0)
+		row, err := parseImportRow(record, line, idCol, nameCol, ratingCol)
+		if err != nil {
+			im.reject(rowError(line, row.id, err))
+			continue
+		}
+		if chunk = append(chunk, row); len(chunk) == chunkSize {
+			if stopped = im.apply(r.Context(), chunk); stopped != nil {
+				break
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if stopped == nil {
+		stopped = im.apply(r.Context(), chunk)
+	}
+
+	sort.Slice(im.errors, func(i, j int) bool { return im.errors[i].Line < im.errors[j].Line })
+	result := map[string]interface{}{
+		"rows":            im.rows,
+		"imported":        im.imported,
+		"failed":          im.failed,
+		"chunks":          im.chunks,
+		"chunkSize":       chunkSize,
+		"errors":          im.errors,
+		"errorsTruncated": im.truncated,
+		"durationMs":      float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if im.errors == nil {
+		result["errors"] = []importError{}
+	}
+	if stopped != nil {
+		if im.imported == 0 {
+			return nil, stopped
+		}
+		result["stopped"] = stopped.Error()
+	}
+	if dryRun {
+		delete(result, "imported")
+		result["dryRun"] = true
+		result["wouldImport"] = im.imported
+		result["sample"] = im.sample
+		if im.sample == nil {
+			result["sample"] = []map[string]interface{}{}
+		}
+		return result, nil
+	}
+	log.Printf("Import: %d of %d rows imported in %d chunks, %d failed", im.imported, im.rows, im.chunks, im.failed)
+	return result, nil
+}
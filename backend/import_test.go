@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// swapUserStore points the handlers at s until the test ends.
+func swapUserStore(t *testing.T, s *UserStore) {
+	saved := userStore
+	userStore = s
+	t.Cleanup(func() { userStore = saved })
+}
+
+// importCSV posts body to the import endpoint.
+func importCSV(t *testing.T, query, body string) (map[string]interface{}, error) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/import?"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	res, err := importUsersRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.(map[string]interface{}), nil
+}
+
+func TestImportColumns(t *testing.T) {
+	tests := []struct {
+		header                 []string
+		wantID, wantName, want int
+	}{
+		{[]string{"id", "username", "rating"}, 0, 1, 2},
+		{[]string{"rating", "username", "id"}, 2, 1, 0},
+		{[]string{"\ufeffID", " Username ", "RATING"}, 0, 1, 2},
+		{[]string{"username", "id"}, 1, 0, -1},
+	}
+	for _, tt := range tests {
+		id, name, rating, err := importColumns(tt.header)
+		if err != nil || id != tt.wantID || name != tt.wantName || rating != tt.want {
+			t.Errorf("importColumns(%q) = %d, %d, %d, %v; want %d, %d, %d",
+				tt.header, id, name, rating, err, tt.wantID, tt.wantName, tt.want)
+		}
+	}
+
+	for _, header := range [][]string{
+		{"id", "username", "score"},
+		{"id", "rating"},
+		{"username"},
+		{""},
+	} {
+		var perr *paramError
+		if _, _, _, err := importColumns(header); !errors.As(err, &perr) || perr.Param != "file" {
+			t.Errorf("importColumns(%q) = %v, want a file paramError", header, err)
+		}
+	}
+
+	swapUserStore(t, seededStore(newSeeder(11).Users(10)))
+	for _, body := range []string{"", "id,name\nu1,alice\n"} {
+		if _, err := importCSV(t, "", body); err == nil {
+			t.Errorf("imported %q", body)
+		}
+	}
+	res, err := importCSV(t, "", "username,id\n")
+	if err != nil || res["rows"] != 0 || res["imported"] != 0 {
+		t.Errorf("header only = %v, %v; want nothing read", res, err)
+	}
+}
+
+func TestImportRowErrors(t *testing.T) {
+	ctx := context.Background()
+	s := seededStore(newSeeder(11).Users(100))
+	swapUserStore(t, s)
+
+	s.RLock()
+	ranked := s.RankedLocked()
+	active, deleted, inactive := *ranked[0], *ranked[1], *ranked[2]
+	s.RUnlock()
+	if _, err := s.SoftDelete(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	u, _ := s.GetLocked(inactive.ID)
+	s.inactive[u.ID] = &inactiveUser{User: *u, user: u}
+	s.removeUsersLocked(map[*User]bool{u: true})
+	s.Unlock()
+
+	body := strings.Join([]string{
+		"username,id,rating",
+		"importer_one,imp-1,1500",                         // 2
+		"importer_two,imp-2,abc",                          // 3: bad rating
+		"importer_three,imp-3,99999999",                   // 4: out of range
+		"importer_four,imp-1,1400",                        // 5: ID earlier in the file
+		"IMPORTER_ONE,imp-5,1400",                         // 6: name earlier in the file, folded
+		"importer_six," + active.ID + ",1000",             // 7: active ID
+		"importer_seven," + deleted.ID + ",1000",          // 8: deleted ID
+		"importer_eight," + inactive.ID + ",1000",         // 9: inactive ID
+		strings.ToUpper(active.Username) + ",imp-10,1000", // 10: active name, folded
+		deleted.Username + ",imp-11,1000",                 // 11: a deleted user's name is free
+		"importer_twelve,imp 12,1000",                     // 12: bad ID
+		"importer_thirteen,imp-13,",                       // 13: default rating
+	}, "\n")
+	res, err := importCSV(t, "", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["rows"] != 12 || res["imported"] != 3 || res["failed"] != 9 {
+		t.Errorf("rows %v, imported %v, failed %v; want 12, 3, 9", res["rows"], res["imported"], res["failed"])
+	}
+
+	want := map[int]string{
+		3:  "rating",
+		4:  ErrRatingOutOfRange.Error(),
+		5:  ErrDuplicateID.Error(),
+		6:  ErrDuplicateUsername.Error(),
+		7:  ErrDuplicateID.Error(),
+		8:  ErrDuplicateID.Error(),
+		9:  ErrDuplicateID.Error(),
+		10: ErrDuplicateUsername.Error(),
+		12: "id",
+	}
+	errs := res["errors"].([]importError)
+	for i, e := range errs {
+		if i > 0 && errs[i-1].Line >= e.Line {
+			t.Errorf("errors are not in line order: %d after %d", e.Line, errs[i-1].Line)
+		}
+		reason, ok := want[e.Line]
+		if !ok {
+			t.Errorf("line %d failed: %s", e.Line, e.Reason)
+			continue
+		}
+		delete(want, e.Line)
+		if !strings.Contains(e.Reason, reason) {
+			t.Errorf("line %d: %q, want %q", e.Line, e.Reason, reason)
+		}
+	}
+	for line, reason := range want {
+		t.Errorf("line %d was not reported (%s)", line, reason)
+	}
+
+	if u, err := s.Get("imp-13"); err != nil || u.Rating != scores.Default {
+		t.Errorf("imp-13 = %+v, %v; want the default rating", u, err)
+	}
+	if u, err := s.Get("imp-1"); err != nil || u.Username != "importer_one" {
+		t.Errorf("imp-1 = %+v, %v; want the first row", u, err)
+	}
+	if u, err := s.Get("imp-11"); err != nil || u.Username != deleted.Username {
+		t.Errorf("imp-11 = %+v, %v; want the deleted user's name", u, err)
+	}
+	if _, still := s.deleted[deleted.ID]; !still {
+		t.Error("importing over a deleted ID restored or purged it")
+	}
+}
+
+// TestImportChunks imports in chunks of two with failing rows spread among
+// them, and checks every index agrees afterwards.
+func TestImportChunks(t *testing.T) {
+	ctx := context.Background()
+	s := seededStore(newSeeder(11).Users(100))
+	swapUserStore(t, s)
+	before := s.IndexSizes()
+
+	lines := []string{"id,username,rating"}
+	var wantIDs []string
+	for i := 0; i < 25; i++ {
+		id, name := "imp-"+strconv.Itoa(i), "chunked_"+strconv.Itoa(i)
+		switch i % 5 {
+		case 1:
+			name = "chunked_0" // Taken by the first row
+		case 3:
+			lines = append(lines, id+","+name+",oops")
+			continue
+		default:
+			wantIDs = append(wantIDs, id)
+		}
+		lines = append(lines, id+","+name+","+strconv.Itoa(1000+37*i%400))
+	}
+	res, err := importCSV(t, "chunkSize=2", strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["imported"] != len(wantIDs) || res["failed"] != 25-len(wantIDs) {
+		t.Fatalf("imported %v, failed %v; want %d, %d", res["imported"], res["failed"], len(wantIDs), 25-len(wantIDs))
+	}
+	if res["chunks"] != 10 { // 20 rows parse; 5 have bad ratings
+		t.Errorf("applied in %v chunks, want 10", res["chunks"])
+	}
+
+	after := s.IndexSizes()
+	for _, index := range []string{"usersByID", "usersByName", "sortedUsers", "sortedByName", "sortedByID"} {
+		if got, want := after[index], before[index].(int)+len(wantIDs); got != want {
+			t.Errorf("%s holds %v, want %d", index, got, want)
+		}
+	}
+	for _, id := range wantIDs {
+		u, err := s.Get(id)
+		if err != nil {
+			t.Errorf("%s: %v", id, err)
+			continue
+		}
+		if byName, err := s.GetByName(strings.ToUpper(u.Username)); err != nil || byName.ID != id {
+			t.Errorf("%s by name %q = %+v, %v", id, u.Username, byName, err)
+		}
+		if ranked, err := s.Rank(ctx, id); err != nil || ranked.Rank == 0 {
+			t.Errorf("%s rank = %+v, %v", id, ranked, err)
+		}
+	}
+
+	users, total, _, _, err := s.GetLeaderboard(ctx, 1, 1000)
+	if err != nil || total != 100+len(wantIDs) {
+		t.Fatalf("leaderboard holds %d, %v; want %d", total, err, 100+len(wantIDs))
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i].Rating > users[i-1].Rating || users[i].Rank < users[i-1].Rank {
+			t.Fatalf("out of order at %d: %+v after %+v", i, users[i], users[i-1])
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// GET /leaderboard/improvement?window=7d ranks users by rating gained over
+// the window (default 7d; "gained" follows the leaderboard direction), from
+// rating changes on the event bus since startup. Changes are summed into
+// MATIKS_IMPROVEMENT_BUCKET buckets (default 6h) kept for
+// MATIKS_IMPROVEMENT_RETAIN (default 14d), so windows round up to whole
+// buckets and include the current one.
+//
+// Each window asked for keeps running per-user totals: a change adds to
+// every window at once, and a bucket falling out of a window is subtracted
+// from it, so no request re-diffs history. The ranked board is re-sorted
+// from the totals at most once per page-cache TTL after they change. Only
+// users with a positive gain who are still on the leaderboard are listed;
+// at most maxBoardWindows windows are kept, the least recently used dropped
+// first.
+
+const defaultImprovementWindow = 7 * 24 * time.Hour
+
+type gainBucket struct {
+	start time.Time
+	gains map[string]int
+}
+
+// gainWindow keeps totals over the newest n buckets.
+type gainWindow struct {
+	n       int
+	totals  map[string]int
+	dirty   bool
+	board   []improvementEntry
+	builtAt time.Time
+	usedAt  time.Time
+}
+
+type improvementEntry struct {
+	UserID   string      `json:"userId"`
+	Username string      `json:"username"`
+	Rating   scoreNumber `json:"rating"`
+	Gain     scoreNumber `json:"gain"`
+	Rank     int         `json:"rank"`
+}
+
+type ImprovementTracker struct {
+	store  *UserStore
+	bucket time.Duration
+	retain int // Buckets
+
+	mu      sync.Mutex
+	buckets []*gainBucket // Oldest first; the last is current
+	windows map[int]*gainWindow
+	builds  int64
+}
+
+var improvement *ImprovementTracker
+
+func NewImprovementTracker(store *UserStore, bucket, retain time.Duration) *ImprovementTracker {
+	n := int((retain + bucket - 1) / bucket)
+	if n < 1 {
+		n = 1
+	}
+	return &ImprovementTracker{store: store, bucket: bucket, retain: n, windows: make(map[int]*gainWindow)}
+}
+
+func (t *ImprovementTracker) run(ctx context.Context) error {
+	events := t.store.events.Subscribe(4096)
+	defer t.store.events.Unsubscribe(events)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if p, ok := ev.Payload.(RatingChangedPayload); ok {
+				t.record(ev.Timestamp, p)
+			}
+		}
+	}
+}
+
+func (t *ImprovementTracker) record(at time.Time, p RatingChangedPayload) {
+	gain := p.NewRating - p.OldRating
+	if t.store.Direction() == rankAscending {
+		gain = -gain
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.advanceLocked(at)
+	t.buckets[len(t.buckets)-1].gains[p.UserID] += gain
+	for _, w := range t.windows {
+		w.totals[p.UserID] += gain
+		w.dirty = true
+	}
+}
+
+// advanceLocked starts buckets up to the one holding now, subtracting
+// those that leave each window.
+func (t *ImprovementTracker) advanceLocked(now time.Time) {
+	if n := len(t.buckets); n > 0 && now.Sub(t.buckets[n-1].start) > time.Duration(t.retain)*t.bucket {
+		// Idle past all retained history: start over
+		t.buckets = nil
+		for _, w := range t.windows {
+			w.totals, w.dirty = make(map[string]int), true
+		}
+	}
+	if len(t.buckets) == 0 {
+		t.buckets = append(t.buckets, &gainBucket{start: now.Truncate(t.bucket), gains: make(map[string]int)})
+		return
+	}
+	for now.Sub(t.buckets[len(t.buckets)-1].start) >= t.bucket {
+		next := t.buckets[len(t.buckets)-1].start.Add(t.bucket)
+		t.buckets = append(t.buckets, &gainBucket{start: next, gains: make(map[string]int)})
+		for _, w := range t.windows {
+			if len(t.buckets) <= w.n {
+				continue
+			}
+			for id, gain := range t.buckets[len(t.buckets)-1-w.n].gains {
+				if w.totals[id] -= gain; w.totals[id] == 0 {
+					delete(w.totals, id)
+				}
+				w.dirty = true
+			}
+		}
+		if len(t.buckets) > t.retain {
+			t.buckets = t.buckets[len(t.buckets)-t.retain:]
+		}
+	}
+}
+
+// windowLocked returns the totals over the newest n buckets, summing them
+// once when the window is new.
+func (t *ImprovementTracker) windowLocked(n int, now time.Time) *gainWindow {
+	if w := t.windows[n]; w != nil {
+		w.usedAt = now
+		return w
+	}
+	if len(t.windows) >= maxBoardWindows {
+		var lru *gainWindow
+		for _, w := range t.windows {
+			if lru == nil || w.usedAt.Before(lru.usedAt) {
+				lru = w
+			}
+		}
+		delete(t.windows, lru.n)
+	}
+	w := &gainWindow{n: n, totals: make(map[string]int), dirty: true, usedAt: now}
+	from := len(t.buckets) - n
+	if from < 0 {
+		from = 0
+	}
+	for _, b := range t.buckets[from:] {
+		for id, gain := range b.gains {
+			w.totals[id] += gain
+		}
+	}
+	t.windows[n] = w
+	return w
+}
+
+// Page returns one page of the most improved users over window.
+func (t *ImprovementTracker) Page(window time.Duration, page, limit int) ([]improvementEntry, int) {
+	n := int((window + t.bucket - 1) / t.bucket)
+	now := time.Now()
+
+	t.mu.Lock()
+	t.advanceLocked(now)
+	w := t.windowLocked(n, now)
+	if w.dirty && now.Sub(w.builtAt) >= t.store.cacheTTL {
+		w.board = t.buildLocked(w)
+		w.dirty, w.builtAt = false, now
+		t.builds++
+	}
+	board := w.board
+	t.mu.Unlock()
+
+	total := len(board)
+	start, end := (page-1)*limit, page*limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return board[start:end], total
+}
+
+// buildLocked ranks a window's positive gains.
+func (t *ImprovementTracker) buildLocked(w *gainWindow) []improvementEntry {
+	board := make([]improvementEntry, 0, len(w.totals))
+	t.store.RLock()
+	for id, gain := range w.totals {
+		if gain <= 0 {
+			continue
+		}
+		u, exists := t.store.GetLocked(id)
+		if !exists {
+			continue
+		}
+		board = append(board, improvementEntry{UserID: id, Username: u.Username, Rating: scoreNumber(u.Rating), Gain: scoreNumber(gain)})
+	}
+	t.store.RUnlock()
+
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Gain != board[j].Gain {
+			return board[i].Gain > board[j].Gain
+		}
+		return board[i].UserID < board[j].UserID
+	})
+	prevRank := 0
+	for i := range board {
+		tied := i > 0 && board[i-1].Gain == board[i].Gain
+		board[i].Rank = t.store.RankMode().Next(prevRank, i, tied)
+		prevRank = board[i].Rank
+	}
+	return board
+}
+
+func (t *ImprovementTracker) Stats() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	windows := make(map[string]int, len(t.windows))
+	for n, w := range t.windows {
+		windows[(time.Duration(n) * t.bucket).String()] = len(w.totals)
+	}
+	return map[string]interface{}{
+		"bucket":  t.bucket.String(),
+		"buckets": len(t.buckets),
+		"windows": windows,
+		"builds":  t.builds,
+	}
+}
+
+// startImprovement tracks rating gains as the "improvement" job.
+func startImprovement(store *UserStore) {
+	improvement = NewImprovementTracker(store,
+		envDuration("MATIKS_IMPROVEMENT_BUCKET", 6*time.Hour),
+		envDuration("MATIKS_IMPROVEMENT_RETAIN", 14*24*time.Hour))
+	jobs.Register("improvement", improvement.run)
+	jobs.Run("improvement")
+}
+
+// improvementRequest serves GET /leaderboard/improvement.
+func improvementRequest(r *http.Request) ([]improvementEntry, *models.Pagination, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	window := defaultImprovementWindow
+	if strings.TrimSpace(r.URL.Query().Get("window")) != "" {
+		var err error
+		if window, err = windowParam(r, "window"); err != nil {
+			return nil, nil, err
+		}
+	}
+	if max := time.Duration(improvement.retain) * improvement.bucket; window > max {
+		return nil, nil, &paramError{Param: "window", Message: fmt.Sprintf("must be at most %s, the rating history kept", max)}
+	}
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, total := improvement.Page(window, page, limit)
+	return entries, models.NewPagination(page, limit, total), nil
+}
+
+func improvementHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	entries, pagination, err := improvementRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "7d"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"window":     window,
+		"entries":    entries,
+		"page":       pagination.Page,
+		"limit":      pagination.Limit,
+		"total":      pagination.Total,
+		"totalPages": pagination.TotalPages,
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
+func v1ImprovementHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	entries, pagination, err := improvementRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: entries, Pagination: pagination}, nil
+}
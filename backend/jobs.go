@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Background work (the simulator, scheduled maintenance, reseeds) runs as
+// named jobs under one JobManager, so each one can be started, cancelled
+// and observed the same way.
+//
+// Each run gets an ID, "<name>-<run number>". GET /admin/jobs/{id} reports
+// one run: its state, start and finish times, duration, error and, for
+// long operations that report it (reseeds, bulk deletes), progress as
+// done, total and a percentage, and the stage it has reached. GET /admin/jobs lists the jobs with their
+// latest runs, and GET /admin/jobs/history the last MATIKS_JOB_HISTORY
+// finished runs (default 100), newest first, filtered by ?name= and
+// ?state= when given.
+
+var (
+	errJobNotFound = errors.New("no such job")
+	errJobRunning  = errors.New("job is already running")
+	errJobIdle     = errors.New("job is not running")
+	errJobNoRunner = errors.New("job needs parameters and can't be run directly")
+)
+
+// errJobSkipped marks a run that had nothing to do on this instance, e.g. a
+// write job on a replica.
+var errJobSkipped = errors.New("skipped")
+
+// Job states
+const (
+	jobIdle      = "idle"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobSkipped   = "skipped"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// job is one named unit of background work and its run history.
+type job struct {
+	name    string
+	run     func(ctx context.Context) error
+	history *jobHistory
+
+	mu       sync.Mutex
+	state    string
+	cancel   context.CancelFunc
+	latest   *jobRun
+	runs     int
+	failures int
+}
+
+// jobRun is one run of a job. Its state, finish time and error are set
+// under the job's lock and never change once it is in the history.
+type jobRun struct {
+	id      string
+	name    string
+	started time.Time
+
+	done, total int64        // Atomic progress; total is 0 until reported
+	stage       atomic.Value // string: what the run is doing now, if it says
+
+	state    string
+	finished time.Time
+	err      string
+}
+
+// jobHistory keeps the latest finished runs.
+type jobHistory struct {
+	max int
+
+	mu   sync.Mutex
+	runs []*jobRun // Oldest first
+}
+
+func (h *jobHistory) add(run *jobRun) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs = append(h.runs, run)
+	if len(h.runs) > h.max {
+		h.runs = append(h.runs[:0], h.runs[len(h.runs)-h.max:]...)
+	}
+}
+
+// JobManager registers, runs, cancels and reports on jobs.
+type JobManager struct {
+	mu      sync.RWMutex
+	jobs    map[string]*job
+	history *jobHistory
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs:    make(map[string]*job),
+		history: &jobHistory{max: envInt("MATIKS_JOB_HISTORY", 100)},
+	}
+}
+
+type jobRunKey struct{}
+
+// jobProgress reports that the run behind ctx has done of total units of
+// work. Outside a job it does nothing.
+func jobProgress(ctx context.Context, done, total int64) {
+	if run, ok := ctx.Value(jobRunKey{}).(*jobRun); ok {
+		atomic.StoreInt64(&run.total, total)
+		atomic.StoreInt64(&run.done, done)
+	}
+}
+
+// jobStage names what the run behind ctx is doing, e.g. "swapping".
+func jobStage(ctx context.Context, stage string) {
+	if run, ok := ctx.Value(jobRunKey{}).(*jobRun); ok {
+		run.stage.Store(stage)
+	}
+}
+
+// Register adds a job. Registering a name again replaces its run function
+// but keeps its history.
+func (m *JobManager) Register(name string, run func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, exists := m.jobs[name]; exists {
+		j.mu.Lock()
+		j.run = run
+		j.mu.Unlock()
+		return
+	}
+	m.jobs[name] = &job{name: name, run: run, state: jobIdle, history: m.history}
+}
+
+func (m *JobManager) get(name string) (*job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, exists := m.jobs[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", errJobNotFound, name)
+	}
+	return j, nil
+}
+
+// Run starts a registered job in the background. A job runs at most once
+// at a time.
+func (m *JobManager) Run(name string) error {
+	j, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	return j.start(nil)
+}
+
+// RunFunc starts a job with a one-off run function, for jobs that take
+// per-run parameters such as a reseed's count and seed, and returns the
+// run's ID.
+func (m *JobManager) RunFunc(name string, run func(ctx context.Context) error) (string, error) {
+	m.mu.Lock()
+	j, exists := m.jobs[name]
+	if !exists {
+		j = &job{name: name, state: jobIdle, history: m.history}
+		m.jobs[name] = j
+	}
+	m.mu.Unlock()
+	if err := j.start(run); err != nil {
+		return "", err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.latest.id, nil
+}
+
+// Cancel stops a running job through its context.
+func (m *JobManager) Cancel(name string) error {
+	j, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != jobRunning {
+		return fmt.Errorf("%w: %s", errJobIdle, name)
+	}
+	j.cancel()
+	return nil
+}
+
+func (j *job) start(run func(ctx context.Context) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state == jobRunning {
+		return fmt.Errorf("%w: %s", errJobRunning, j.name)
+	}
+	if run == nil {
+		run = j.run
+	}
+	if run == nil {
+		return fmt.Errorf("%w: %s", errJobNoRunner, j.name)
+	}
+
+	j.runs++
+	j.state = jobRunning
+	j.latest = &jobRun{
+		id:      fmt.Sprintf("%s-%d", j.name, j.runs),
+		name:    j.name,
+		started: time.Now(),
+		state:   jobRunning,
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), jobRunKey{}, j.latest))
+	j.cancel = cancel
+
+	go j.execute(ctx, j.latest, run)
+	return nil
+}
+
+func (j *job) execute(ctx context.Context, r *jobRun, run func(ctx context.Context) error) {
+	err := run(ctx)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cancel()
+	r.finished = time.Now()
+	duration := r.finished.Sub(r.started)
+	switch {
+	case err == nil:
+		j.state = jobSucceeded
+		log.Printf("Job %s: finished in %v", j.name, duration)
+	case errors.Is(err, errJobSkipped):
+		j.state = jobSkipped
+	case ctx.Err() != nil:
+		j.state = jobCancelled
+		log.Printf("Job %s: cancelled after %v", j.name, duration)
+	default:
+		j.state = jobFailed
+		j.failures++
+		r.err = err.Error()
+		log.Printf("Job %s: failed after %v: %v", j.name, duration, err)
+	}
+	r.state = j.state
+	j.history.add(r)
+}
+
+// JobStatus reports one job.
+func (m *JobManager) JobStatus(name string) (map[string]interface{}, error) {
+	j, err := m.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return j.status(), nil
+}
+
+// RunStatus reports the run id, while it is running or in the history.
+func (m *JobManager) RunStatus(id string) (map[string]interface{}, error) {
+	name := id // Job names may contain dashes; the run number can't
+	if i := strings.LastIndex(id, "-"); i > 0 {
+		name = id[:i]
+	}
+	if j, err := m.get(name); err == nil {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.latest != nil && j.latest.id == id {
+			return j.latest.statusLocked(), nil
+		}
+	}
+	m.history.mu.Lock()
+	defer m.history.mu.Unlock()
+	for _, run := range m.history.runs {
+		if run.id == id {
+			return run.statusLocked(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errJobNotFound, id)
+}
+
+// Runs reports the finished runs in the history, newest first, keeping
+// those matching name and state when they are set.
+func (m *JobManager) Runs(name, state string) []map[string]interface{} {
+	m.history.mu.Lock()
+	defer m.history.mu.Unlock()
+	out := make([]map[string]interface{}, 0, len(m.history.runs))
+	for i := len(m.history.runs) - 1; i >= 0; i-- {
+		run := m.history.runs[i]
+		if (name == "" || run.name == name) && (state == "" || run.state == state) {
+			out = append(out, run.statusLocked())
+		}
+	}
+	return out
+}
+
+// Status reports every job, sorted by name.
+func (m *JobManager) Status() []map[string]interface{} {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		names = append(names, name)
+	}
+	m.mu.RUnlock()
+	sort.Strings(names)
+
+	out := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		if status, err := m.JobStatus(name); err == nil {
+			out = append(out, status)
+		}
+	}
+	return out
+}
+
+func (j *job) status() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := map[string]interface{}{
+		"name":     j.name,
+		"state":    j.state,
+		"runs":     j.runs,
+		"failures": j.failures,
+	}
+	if j.latest != nil {
+		for k, v := range j.latest.statusLocked() {
+			if k != "name" && k != "state" {
+				status[k] = v
+			}
+		}
+	}
+	return status
+}
+
+// statusLocked reports the run; the caller holds its job's lock, or the
+// history's once it is there.
+func (r *jobRun) statusLocked() map[string]interface{} {
+	end := r.finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+	status := map[string]interface{}{
+		"id":         r.id,
+		"name":       r.name,
+		"state":      r.state,
+		"startedAt":  r.started.Unix(),
+		"durationMs": float64(end.Sub(r.started).Microseconds()) / 1000,
+	}
+	if !r.finished.IsZero() {
+		status["finishedAt"] = r.finished.Unix()
+	}
+	if r.err != "" {
+		status["error"] = r.err
+	}
+	if stage, _ := r.stage.Load().(string); stage != "" && r.finished.IsZero() {
+		status["stage"] = stage
+	}
+	if total := atomic.LoadInt64(&r.total); total > 0 {
+		done := atomic.LoadInt64(&r.done)
+		status["progress"] = map[string]interface{}{
+			"done":    done,
+			"total":   total,
+			"percent": math.Round(float64(done)*1000/float64(total)) / 10,
+		}
+	}
+	return status
+}
+
+var jobs = NewJobManager()
+
+// jobAction applies ?action=run|cancel to the job named by ?name=.
+func jobAction(r *http.Request) (map[string]interface{}, error) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return nil, &paramError{Param: "name", Message: "is required"}
+	}
+
+	var err error
+	switch action := r.URL.Query().Get("action"); action {
+	case "run":
+		err = jobs.Run(name)
+	case "cancel":
+		err = jobs.Cancel(name)
+	default:
+		return nil, &paramError{Param: "action", Message: fmt.Sprintf("must be run or cancel, got %q", action)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jobs.JobStatus(name)
+}
+
+// jobStatusPath links to run id's status in r's API version.
+func jobStatusPath(r *http.Request, id string) string {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		return "/v1/admin/jobs/" + id
+	}
+	return "/admin/jobs/" + id
+}
+
+// jobRunID is the {id} of /admin/jobs/{id}, "history" for the history or
+// "" for /admin/jobs.
+func jobRunID(r *http.Request) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1"), "/admin/jobs"), "/")
+}
+
+// adminJobsHandler lists jobs on GET /admin/jobs, reports runs on GET
+// /admin/jobs/{id} and /admin/jobs/history, and runs or cancels a job on
+// POST /admin/jobs.
+func adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if id := jobRunID(r); id != "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeLegacyError(w, r, fmt.Errorf("%w: use GET", errMethodNotAllowed))
+			return
+		}
+		if id == "history" {
+			q := r.URL.Query()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":   true,
+				"runs":      jobs.Runs(q.Get("name"), q.Get("state")),
+				"timestamp": time.Now().Unix(),
+			})
+			return
+		}
+		status, err := jobs.RunStatus(id)
+		if err != nil {
+			writeLegacyError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"job":       status,
+			"timestamp": time.Now().Unix(),
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"jobs":      jobs.Status(),
+			"timestamp": time.Now().Unix(),
+		})
+		return
+	}
+
+	status, err := jobAction(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"job":       status,
+		"timestamp": time.Now().Unix(),
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+
+	"matiks-leaderboard/models"
+)
+
+// Hand-written marshalers for the hot response types. On large pages,
+// reflection in encoding/json dominated handler CPU; these append straight
+// into a byte slice and produce the same JSON encoding/json would.
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string literal, escaping the same
+// characters as encoding/json (including <, > and & for HTML safety).
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028/U+2029 break JavaScript string literals
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// appendJSON appends u with the same fields and order as its struct tags.
+func (u *User) appendJSON(buf []byte) []byte {
+	buf = append(buf, `{"id":`...)
+	buf = appendJSONString(buf, u.ID)
+	buf = append(buf, `,"username":`...)
+	buf = appendJSONString(buf, u.Username)
+	buf = append(buf, `,"rating":`...)
+	buf = scores.Append(buf, u.Rating)
+	buf = append(buf, `,"rank":`...)
+	buf = strconv.AppendInt(buf, int64(u.Rank), 10)
+	return append(buf, '}')
+}
+
+func (u User) MarshalJSON() ([]byte, error) {
+	return u.appendJSON(make([]byte, 0, 80)), nil
+}
+
+func appendUsersJSON(buf []byte, users []User) []byte {
+	buf = append(buf, '[')
+	for i := range users {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = users[i].appendJSON(buf)
+	}
+	return append(buf, ']')
+}
+
+// appendMetadataJSON appends a "metadata" member when it was requested.
+// It is off the common path, so encoding/json is fine here.
+func appendMetadataJSON(buf []byte, md map[string]map[string]json.RawMessage) []byte {
+	if md == nil {
+		return buf
+	}
+	encoded, _ := json.Marshal(md)
+	buf = append(buf, `,"metadata":`...)
+	return append(buf, encoded...)
+}
+
+// appendLinksJSON adds a "links" member, if there are links.
+func appendLinksJSON(buf []byte, links *models.Links) []byte {
+	if links == nil {
+		return buf
+	}
+	buf = append(buf, `,"links":{"first":`...)
+	buf = appendJSONString(buf, links.First)
+	buf = appendOptionalLink(append(buf, `,"prev":`...), links.Prev)
+	buf = appendOptionalLink(append(buf, `,"next":`...), links.Next)
+	buf = appendOptionalLink(append(buf, `,"last":`...), links.Last)
+	return append(buf, '}')
+}
+
+func appendOptionalLink(buf []byte, link *string) []byte {
+	if link == nil {
+		return append(buf, "null"...)
+	}
+	return appendJSONString(buf, *link)
+}
+
+// leaderboardResponse is the /leaderboard body.
+type leaderboardResponse struct {
+	Users        []User
+	Metadata     map[string]map[string]json.RawMessage // With ?include=metadata
+	Total        int
+	Page         int
+	Limit        int
+	TotalPages   int
+	PendingSorts int64
+	Timestamp    int64
+
+	// With ?cursor=: nextCursor replaces page and totalPages
+	Cursored   bool
+	NextCursor *leaderboardCursor
+
+	// With ?snapshot=: the token for the next page and when it expires
+	Snapshot *leaderboardSnapshot
+
+	// With ?activeWithin= or ?joinedWithin=: which, and the window as
+	// requested
+	WindowParam, Window string
+
+	Links *models.Links
+}
+
+// appendJSON keeps the key order encoding/json used for the old map body.
+func (r *leaderboardResponse) appendJSON(buf []byte) []byte {
+	buf = append(buf, '{')
+	if r.WindowParam != "" {
+		buf = appendJSONString(buf, r.WindowParam)
+		buf = append(buf, ':')
+		buf = appendJSONString(buf, r.Window)
+		buf = append(buf, ',')
+	}
+	buf = append(buf, `"limit":`...)
+	buf = strconv.AppendInt(buf, int64(r.Limit), 10)
+	buf = appendLinksJSON(buf, r.Links)
+	buf = appendMetadataJSON(buf, r.Metadata)
+	if r.Cursored {
+		buf = append(buf, `,"nextCursor":`...)
+		if r.NextCursor == nil {
+			buf = append(buf, "null"...)
+		} else {
+			buf = strconv.AppendQuote(buf, r.NextCursor.String()) // base64url: no escaping needed
+		}
+	} else {
+		buf = append(buf, `,"page":`...)
+		buf = strconv.AppendInt(buf, int64(r.Page), 10)
+	}
+	buf = append(buf, `,"pendingSorts":`...)
+	buf = strconv.AppendInt(buf, r.PendingSorts, 10)
+	if r.Snapshot != nil {
+		buf = append(buf, `,"snapshot":`...)
+		buf = strconv.AppendQuote(buf, r.Snapshot.id) // Hex: no escaping needed
+		buf = append(buf, `,"snapshotExpiresAt":`...)
+		buf = strconv.AppendInt(buf, r.Snapshot.expires.Unix(), 10)
+	}
+	buf = append(buf, `,"success":true,"timestamp":`...)
+	buf = strconv.AppendInt(buf, r.Timestamp, 10)
+	buf = append(buf, `,"total":`...)
+	buf = strconv.AppendInt(buf, int64(r.Total), 10)
+	if !r.Cursored {
+		buf = append(buf, `,"totalPages":`...)
+		buf = strconv.AppendInt(buf, int64(r.TotalPages), 10)
+	}
+	buf = append(buf, `,"users":`...)
+	buf = appendUsersJSON(buf, r.Users)
+	return append(buf, '}', '\n')
+}
+
+func (r leaderboardResponse) MarshalJSON() ([]byte, error) {
+	return r.appendJSON(make([]byte, 0, 128+len(r.Users)*80)), nil
+}
+
+// searchResponse is the /search body.
+type searchResponse struct {
+	Users       []User
+	Metadata    map[string]map[string]json.RawMessage // With ?include=metadata
+	Total       int
+	Page        int
+	Limit       int
+	TotalPages  int
+	Timestamp   int64
+	Links       *models.Links
+	Suggestions []string // Did-you-mean names when nothing matched
+}
+
+func (r *searchResponse) appendJSON(buf []byte) []byte {
+	buf = append(buf, `{"limit":`...)
+	buf = strconv.AppendInt(buf, int64(r.Limit), 10)
+	buf = appendLinksJSON(buf, r.Links)
+	buf = appendMetadataJSON(buf, r.Metadata)
+	buf = append(buf, `,"page":`...)
+	buf = strconv.AppendInt(buf, int64(r.Page), 10)
+	buf = append(buf, `,"success":true`...)
+	if r.Suggestions != nil {
+		buf = append(buf, `,"suggestions":[`...)
+		for i, name := range r.Suggestions {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, name)
+		}
+		buf = append(buf, ']')
+	}
+	buf = append(buf, `,"timestamp":`...)
+	buf = strconv.AppendInt(buf, r.Timestamp, 10)
+	buf = append(buf, `,"total":`...)
+	buf = strconv.AppendInt(buf, int64(r.Total), 10)
+	buf = append(buf, `,"totalPages":`...)
+	buf = strconv.AppendInt(buf, int64(r.TotalPages), 10)
+	buf = append(buf, `,"users":`...)
+	buf = appendUsersJSON(buf, r.Users)
+	return append(buf, '}', '\n')
+}
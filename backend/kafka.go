@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// startKafkaPublisher forwards every bus event to a Kafka topic. Rating
+// changes are keyed by user ID so a user's changes stay ordered within
+// one partition.
+func startKafkaPublisher(bus *EventBus, brokers []string, topic string) {
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 100 * time.Millisecond,
+		Async:        true,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				log.Printf("Kafka: failed to deliver %d events: %v", len(messages), err)
+			}
+		},
+	}
+
+	addReadinessCheck("kafka", func(ctx context.Context) error {
+		var err error
+		for _, broker := range brokers {
+			var conn *kafka.Conn
+			if conn, err = kafka.DialContext(ctx, "tcp", broker); err == nil {
+				return conn.Close() // One reachable broker is enough
+			}
+		}
+		return err
+	})
+
+	events := bus.Subscribe(4096)
+	go func() {
+		for ev := range events {
+			value, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("Kafka: encoding %s event: %v", ev.Type, err)
+				continue
+			}
+
+			msg := kafka.Message{Value: value, Time: ev.Timestamp}
+			if p, ok := ev.Payload.(RatingChangedPayload); ok {
+				msg.Key = []byte(p.UserID)
+			}
+
+			// Async writer: this only enqueues, delivery errors go to Completion
+			if err := writer.WriteMessages(context.Background(), msg); err != nil {
+				log.Printf("Kafka: enqueue %s event: %v", ev.Type, err)
+			}
+		}
+		writer.Close()
+	}()
+
+	log.Printf("Kafka: publishing events to topic %q on %v", topic, brokers)
+}
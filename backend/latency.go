@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// /stats "latency" reports handler latency per route from the latest
+// MATIKS_LATENCY_SAMPLES requests (default 1000) on each, as p50, p95 and
+// p99 in milliseconds, along with the requests currently being handled.
+// Routes are keyed by mux pattern like the dashboard's request counts, and
+// the time is measured around the handler alone, so it leaves out the
+// client's network but includes writing the response. "byProtocol" tracks
+// the same per protocol (HTTP/1.1, HTTP/2.0, HTTP/3.0).
+
+// routeLatency is one route's sample ring and in-flight count.
+type routeLatency struct {
+	count    int64
+	inFlight int64
+	samples  []float64 // In ms
+	pos      int
+	max      float64
+}
+
+type latencyTracker struct {
+	size int
+
+	mu       sync.Mutex
+	inFlight int64
+	routes   map[string]*routeLatency
+	protos   map[string]*routeLatency
+}
+
+var latencies = &latencyTracker{
+	size:   envInt("MATIKS_LATENCY_SAMPLES", 1000),
+	routes: make(map[string]*routeLatency),
+	protos: make(map[string]*routeLatency),
+}
+
+// begin marks a request on pattern over proto as in flight; calling the
+// returned func records how long it took.
+func (t *latencyTracker) begin(pattern, proto string) func() {
+	start := time.Now()
+	t.mu.Lock()
+	rl, pl := t.routes[pattern], t.protos[proto]
+	if rl == nil {
+		rl = &routeLatency{}
+		t.routes[pattern] = rl
+	}
+	if pl == nil {
+		pl = &routeLatency{}
+		t.protos[proto] = pl
+	}
+	rl.inFlight++
+	pl.inFlight++
+	t.inFlight++
+	t.mu.Unlock()
+
+	return func() {
+		ms := float64(time.Since(start).Microseconds()) / 1000
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.inFlight--
+		t.recordLocked(rl, ms)
+		t.recordLocked(pl, ms)
+	}
+}
+
+func (t *latencyTracker) recordLocked(rl *routeLatency, ms float64) {
+	rl.inFlight--
+	rl.count++
+	if ms > rl.max {
+		rl.max = ms
+	}
+	if t.size <= 0 {
+		return
+	}
+	if len(rl.samples) < t.size {
+		rl.samples = append(rl.samples, ms)
+	} else {
+		rl.samples[rl.pos] = ms
+		rl.pos = (rl.pos + 1) % t.size
+	}
+}
+
+func (rl *routeLatency) stats() map[string]interface{} {
+	sorted := append([]float64(nil), rl.samples...)
+	sort.Float64s(sorted)
+	return map[string]interface{}{
+		"count":    rl.count,
+		"inFlight": rl.inFlight,
+		"samples":  len(sorted),
+		"p50Ms":    percentileOf(sorted, 50),
+		"p95Ms":    percentileOf(sorted, 95),
+		"p99Ms":    percentileOf(sorted, 99),
+		"maxMs":    rl.max,
+	}
+}
+
+func (t *latencyTracker) Stats() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	routes := make(map[string]interface{}, len(t.routes))
+	for pattern, rl := range t.routes {
+		routes[pattern] = rl.stats()
+	}
+	protos := make(map[string]interface{}, len(t.protos))
+	for proto, pl := range t.protos {
+		protos[proto] = pl.stats()
+	}
+	return map[string]interface{}{
+		"inFlight":   t.inFlight,
+		"byRoute":    routes,
+		"byProtocol": protos,
+	}
+}
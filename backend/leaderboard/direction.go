@@ -0,0 +1,35 @@
+package leaderboard
+
+import "fmt"
+
+// Direction says which ratings rank first.
+type Direction string
+
+const (
+	Descending Direction = "desc" // Higher is better (default)
+	Ascending  Direction = "asc"  // Lower is better, for times or error counts
+)
+
+func ParseDirection(raw string) (Direction, error) {
+	switch d := Direction(raw); d {
+	case Descending, Ascending:
+		return d, nil
+	}
+	return "", fmt.Errorf("direction must be %s or %s, got %q", Descending, Ascending, raw)
+}
+
+// Better reports whether rating a ranks ahead of rating b.
+func (d Direction) Better(a, b int) bool {
+	if d == Ascending {
+		return a < b
+	}
+	return a > b
+}
+
+// Key maps a rating to a value that is larger the better it ranks.
+func (d Direction) Key(rating int) int {
+	if d == Ascending {
+		return -rating
+	}
+	return rating
+}
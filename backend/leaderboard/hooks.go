@@ -0,0 +1,212 @@
+package leaderboard
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Store built WithHooks queues its registered hooks on every write and
+// sort: OnUserCreate for AddLocked (not LoadLocked or RestoreLocked), with
+// the entrant as added and not yet ranked; OnRatingChange for every score
+// SetScoreLocked changes, however it was reached; and OnSort for each sort
+// with the ranks it moved.
+//
+// The store only queues a call while it holds its lock. Run makes the
+// calls one at a time, in the order the changes happened, so hooks may
+// read the store and a slow hook delays later hooks but never a write.
+// Each call gets a context that ends after the timeout or when Run's does,
+// and a panicking hook is reported to onPanic and skipped. At most
+// maxQueue calls wait; more are dropped and counted.
+
+type UserCreateHook[T any] func(ctx context.Context, entrant T)
+
+type RatingChangeHook[T any] func(ctx context.Context, entrant T, oldScore, newScore int)
+
+type SortHook func(ctx context.Context, info SortInfo)
+
+// Hooks is a registry of hooks on one store's entrants of type T.
+type Hooks[T any] struct {
+	timeout  time.Duration
+	maxQueue int
+	onPanic  func(p any, stack []byte)
+
+	registered int32 // Any hooks at all; lets the store skip queueing
+
+	mu           sync.Mutex
+	userCreate   []UserCreateHook[T]
+	ratingChange []RatingChangeHook[T]
+	sort         []SortHook
+	queue        []func(ctx context.Context)
+	wake         chan struct{}
+
+	ran, dropped, panics, timeouts int64
+}
+
+// NewHooks returns an empty registry whose calls each get timeout and that
+// holds at most maxQueue waiting calls. onPanic, if not nil, is told about
+// every hook that panics.
+func NewHooks[T any](timeout time.Duration, maxQueue int, onPanic func(p any, stack []byte)) *Hooks[T] {
+	return &Hooks[T]{
+		timeout:  timeout,
+		maxQueue: maxQueue,
+		onPanic:  onPanic,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (h *Hooks[T]) OnUserCreate(fn UserCreateHook[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.userCreate = append(h.userCreate, fn)
+	atomic.StoreInt32(&h.registered, 1)
+}
+
+func (h *Hooks[T]) OnRatingChange(fn RatingChangeHook[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ratingChange = append(h.ratingChange, fn)
+	atomic.StoreInt32(&h.registered, 1)
+}
+
+func (h *Hooks[T]) OnSort(fn SortHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sort = append(h.sort, fn)
+	atomic.StoreInt32(&h.registered, 1)
+}
+
+// idle reports whether there is nothing to queue, as for a store built
+// without hooks.
+func (h *Hooks[T]) idle() bool {
+	return h == nil || atomic.LoadInt32(&h.registered) == 0
+}
+
+// enqueueLocked queues one call per hook; the caller holds h.mu.
+func (h *Hooks[T]) enqueueLocked(calls ...func(ctx context.Context)) {
+	if len(calls) == 0 {
+		return
+	}
+	for _, call := range calls {
+		if len(h.queue) >= h.maxQueue {
+			h.dropped++
+			continue
+		}
+		h.queue = append(h.queue, call)
+	}
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// userCreated queues the OnUserCreate hooks with a copy of u.
+func (h *Hooks[T]) userCreated(u *T) {
+	if h.idle() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.userCreate) == 0 {
+		return
+	}
+	snapshot := *u
+	calls := make([]func(ctx context.Context), len(h.userCreate))
+	for i, fn := range h.userCreate {
+		fn := fn
+		calls[i] = func(ctx context.Context) { fn(ctx, snapshot) }
+	}
+	h.enqueueLocked(calls...)
+}
+
+// ratingChanged queues the OnRatingChange hooks with a copy of u.
+func (h *Hooks[T]) ratingChanged(u *T, oldScore, newScore int) {
+	if h.idle() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.ratingChange) == 0 {
+		return
+	}
+	snapshot := *u
+	calls := make([]func(ctx context.Context), len(h.ratingChange))
+	for i, fn := range h.ratingChange {
+		fn := fn
+		calls[i] = func(ctx context.Context) { fn(ctx, snapshot, oldScore, newScore) }
+	}
+	h.enqueueLocked(calls...)
+}
+
+// sorted queues the OnSort hooks.
+func (h *Hooks[T]) sorted(info SortInfo) {
+	if h.idle() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	calls := make([]func(ctx context.Context), len(h.sort))
+	for i, fn := range h.sort {
+		fn := fn
+		calls[i] = func(ctx context.Context) { fn(ctx, info) }
+	}
+	h.enqueueLocked(calls...)
+}
+
+// Run makes the queued calls until ctx ends.
+func (h *Hooks[T]) Run(ctx context.Context) error {
+	for {
+		h.mu.Lock()
+		batch := h.queue
+		h.queue = nil
+		h.mu.Unlock()
+		for _, call := range batch {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			h.call(ctx, call)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.wake:
+		}
+	}
+}
+
+func (h *Hooks[T]) call(parent context.Context, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(parent, h.timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			atomic.AddInt64(&h.panics, 1)
+			if h.onPanic != nil {
+				h.onPanic(p, debug.Stack())
+			}
+		}
+	}()
+	fn(ctx)
+	atomic.AddInt64(&h.ran, 1)
+	if ctx.Err() == context.DeadlineExceeded {
+		atomic.AddInt64(&h.timeouts, 1)
+	}
+}
+
+func (h *Hooks[T]) Stats() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return map[string]interface{}{
+		"registered": map[string]int{
+			"userCreate":   len(h.userCreate),
+			"ratingChange": len(h.ratingChange),
+			"sort":         len(h.sort),
+		},
+		"queued":   len(h.queue),
+		"ran":      atomic.LoadInt64(&h.ran),
+		"dropped":  h.dropped,
+		"panics":   atomic.LoadInt64(&h.panics),
+		"timeouts": atomic.LoadInt64(&h.timeouts),
+	}
+}
@@ -0,0 +1,603 @@
+// Package leaderboard is the ranking engine behind the Matiks server,
+// without its HTTP API, persistence or logging. The server runs on it, and
+// Go programs can embed it for a leaderboard in-process. A Store ranks any
+// struct that embeds Member and implements Entrant; User is the built-in
+// one:
+//
+//	board := leaderboard.New[leaderboard.User](leaderboard.WithRankMode(utils.RankDense))
+//	board.Add(leaderboard.User{ID: "u1", Username: "alice", Rating: 1500})
+//	board.Update("u1", 1620)
+//	top, total, pages, err := board.Page(ctx, 1, 10)
+//	me, err := board.Rank(ctx, "u1")
+//	found, _, _, err := board.Search(ctx, "ali", 1, 20)
+//
+// A Store is safe for concurrent use. Writes only mark the ranking stale;
+// the next read sorts once, so a burst of updates costs one sort, and that
+// sort only re-sorts the band of scores the writes touched (see
+// ranking.go). Reads take a context: a caller whose context ends while it
+// waits for the lock gets ctx.Err() rather than starting that sort. Ranks
+// follow the store's RankMode and ties its TieBreak, and names are unique
+// and matched case-insensitively in any script (see FoldUsername). Reads
+// hand back copies.
+//
+// The *Locked methods are for code that keeps state of its own beside a
+// Store under the same lock, as the server does for deletion, renames and
+// expiry. The caller holds Lock, or RLock (RLockSorted for an up-to-date
+// ranking) for the read-only ones. Entrant pointers they hand out belong
+// to the store: read them under the lock, and change them only through
+// its methods.
+//
+// Failures are the Err values below, possibly wrapped with detail, so
+// callers test them with errors.Is.
+package leaderboard
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/utils"
+)
+
+var (
+	ErrEmptyID           = errors.New("user ID is empty")
+	ErrEmptyUsername     = errors.New("username is empty")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrDuplicateID       = errors.New("user ID already exists")
+	ErrDuplicateUsername = errors.New("username is taken by another user")
+	ErrRatingOutOfRange  = errors.New("rating out of range")
+)
+
+// DefaultSearchLimit caps the matches one Search collects.
+const DefaultSearchLimit = 1000
+
+// Member is the store's bookkeeping for one entrant, embedded in the
+// entrant's struct. Rank and UsernameLower are set by the store; treat
+// them as read-only.
+type Member struct {
+	Rank          int    `json:"rank"` // As of the last sort; 0 until then
+	UsernameLower string `json:"-"`    // FoldUsername of the name, as indexed
+
+	tieOrd    uint32 // Position in tie-break order; the low half of the sort key
+	reachedAt int64  // UnixNano when the score was last set, for utils.TieByEarliest
+}
+
+func (m *Member) member() *Member { return m }
+
+// ReachedAt is when the entrant's score was last set, in UnixNano, or 0 if
+// it was loaded with it.
+func (m *Member) ReachedAt() int64 { return m.reachedAt }
+
+// Entrant is a pointer to anything a Store can rank: a struct embedding
+// Member, with an ID, a name and a score.
+type Entrant[T any] interface {
+	*T
+	LeaderboardID() string   // Unique and non-empty
+	LeaderboardName() string // Unique ignoring case; searched by prefix
+	LeaderboardScore() int   // Ranked by the store's Direction
+	SetLeaderboardName(name string)
+	SetLeaderboardScore(score int)
+	member() *Member
+}
+
+// User is the built-in entrant, with the server's user fields.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	Member
+}
+
+func (u *User) LeaderboardID() string          { return u.ID }
+func (u *User) LeaderboardName() string        { return u.Username }
+func (u *User) LeaderboardScore() int          { return u.Rating }
+func (u *User) SetLeaderboardName(name string) { u.Username = name }
+func (u *User) SetLeaderboardScore(score int)  { u.Rating = score }
+
+type config struct {
+	mode        utils.RankMode
+	tieBreak    utils.TieBreak
+	direction   Direction
+	searchLimit int
+	trie        bool
+	tokenIndex  bool
+	minScore    int
+	maxScore    int
+	capacity    int
+	now         func() int64
+	onSort      func(SortInfo)
+
+	// A PageCache[T] and *Hooks[T], checked against the store's type in New
+	pageCache any
+	hookSet   any
+}
+
+// Option configures a Store in New.
+type Option func(*config)
+
+// WithRankMode numbers ranks by m (default utils.RankStandard).
+func WithRankMode(m utils.RankMode) Option {
+	return func(c *config) { c.mode = m }
+}
+
+// WithTieBreak orders equal scores by t (default utils.TieByID);
+// utils.TieByUsername compares folded names.
+func WithTieBreak(t utils.TieBreak) Option {
+	return func(c *config) { c.tieBreak = t }
+}
+
+// WithDirection picks whether higher (Descending, the default) or lower
+// scores rank first.
+func WithDirection(d Direction) Option {
+	return func(c *config) { c.direction = d }
+}
+
+// WithSearchLimit caps the matches one Search collects (default
+// DefaultSearchLimit).
+func WithSearchLimit(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.searchLimit = n
+		}
+	}
+}
+
+// WithTrie keeps the byte trie SearchTrie walks (default true). Without
+// it SearchTrie matches nothing.
+func WithTrie(on bool) Option {
+	return func(c *config) { c.trie = on }
+}
+
+// WithTokens indexes the later components of each name, so Search also
+// finds "alex_sharma" for "shar" (default true; see search.go).
+func WithTokens(on bool) Option {
+	return func(c *config) { c.tokenIndex = on }
+}
+
+// WithSortListener calls fn after every sort, under the write lock, so it
+// may read the store's *Locked views but must not block.
+func WithSortListener(fn func(SortInfo)) Option {
+	return func(c *config) { c.onSort = fn }
+}
+
+// WithPageCache serves Page and PageBand from c between writes (default:
+// no cache; see pagecache.go). Its type parameter must be the store's.
+func WithPageCache[T any](c PageCache[T]) Option {
+	return func(cfg *config) { cfg.pageCache = c }
+}
+
+// WithHooks queues h's hooks on the store's writes and sorts (see
+// hooks.go). Its type parameter must be the store's.
+func WithHooks[T any](h *Hooks[T]) Option {
+	return func(c *config) { c.hookSet = h }
+}
+
+// WithRatingRange makes Add and Update refuse scores outside [min, max]
+// with ErrRatingOutOfRange (default: any int).
+func WithRatingRange(min, max int) Option {
+	return func(c *config) { c.minScore, c.maxScore = min, max }
+}
+
+// WithCapacity sizes the store for n entrants up front.
+func WithCapacity(n int) Option {
+	return func(c *config) { c.capacity = n }
+}
+
+// WithClock replaces the clock utils.TieByEarliest reads, in UnixNano.
+func WithClock(now func() int64) Option {
+	return func(c *config) { c.now = now }
+}
+
+// Store holds entrants of type T and ranks them by score.
+type Store[T any, P Entrant[T]] struct {
+	config
+
+	mu     sync.RWMutex
+	byID   map[string]*T
+	byName map[string]*T // By folded name
+	ranked []*T          // Best first once sorted
+
+	// Search indexes, by folded name and then ID (see search.go)
+	names   []*T
+	ids     []*T // By ID
+	buckets map[rune][]*T
+	trieIdx *nameTrie[T]
+	tokens  []nameToken[T]
+
+	// Packed-key sort and partial re-rank state (see ranking.go)
+	sortKeys         sortKeySlice
+	byTieOrd         []*T
+	tieOrderDirty    bool
+	dirtyLo, dirtyHi int
+	dirtyAll         bool
+	stale            bool
+
+	pages PageCache[T]
+	hooks *Hooks[T]
+}
+
+// New returns an empty Store. It panics if a WithPageCache or WithHooks
+// option was built for another entrant type.
+func New[T any, P Entrant[T]](opts ...Option) *Store[T, P] {
+	c := config{
+		mode:        utils.RankStandard,
+		tieBreak:    utils.TieBreak{Mode: utils.TieByID},
+		direction:   Descending,
+		searchLimit: DefaultSearchLimit,
+		trie:        true,
+		tokenIndex:  true,
+		minScore:    math.MinInt,
+		maxScore:    math.MaxInt,
+		now:         monotonicNow,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	s := &Store[T, P]{config: c}
+	if c.pageCache != nil {
+		pages, ok := c.pageCache.(PageCache[T])
+		if !ok {
+			panic(fmt.Sprintf("leaderboard: WithPageCache got a %T for a store of %T", c.pageCache, *new(T)))
+		}
+		s.pages = pages
+	}
+	if c.hookSet != nil {
+		hooks, ok := c.hookSet.(*Hooks[T])
+		if !ok {
+			panic(fmt.Sprintf("leaderboard: WithHooks got a %T for a store of %T", c.hookSet, *new(T)))
+		}
+		s.hooks = hooks
+	}
+	s.resetLocked(c.capacity)
+	return s
+}
+
+// resetLocked empties every index.
+func (s *Store[T, P]) resetLocked(capacity int) {
+	s.byID = make(map[string]*T, capacity)
+	s.byName = make(map[string]*T, capacity)
+	s.ranked = make([]*T, 0, capacity)
+	s.names = make([]*T, 0, capacity)
+	s.ids = make([]*T, 0, capacity)
+	s.buckets = make(map[rune][]*T)
+	s.trieIdx = nil
+	if s.trie {
+		s.trieIdx = &nameTrie[T]{}
+	}
+	s.tokens = nil
+	s.sortKeys, s.byTieOrd = nil, nil
+	s.tieOrderDirty = true
+	s.dirtyLo, s.dirtyHi, s.dirtyAll = math.MaxInt, math.MinInt, true
+	s.stale = false
+}
+
+func (s *Store[T, P]) Lock()    { s.mu.Lock() }
+func (s *Store[T, P]) Unlock()  { s.mu.Unlock() }
+func (s *Store[T, P]) RLock()   { s.mu.RLock() }
+func (s *Store[T, P]) RUnlock() { s.mu.RUnlock() }
+
+func (s *Store[T, P]) Direction() Direction     { return s.direction }
+func (s *Store[T, P]) TieBreak() utils.TieBreak { return s.tieBreak }
+func (s *Store[T, P]) RankMode() utils.RankMode { return s.mode }
+func (s *Store[T, P]) SearchLimit() int         { return s.searchLimit }
+
+// Len is the number of entrants.
+func (s *Store[T, P]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byID)
+}
+
+// LenLocked is Len for a caller holding the lock.
+func (s *Store[T, P]) LenLocked() int { return len(s.byID) }
+
+// validate checks an entrant and returns its normalized name.
+func (s *Store[T, P]) validate(u *T) (string, error) {
+	p := P(u)
+	if p.LeaderboardID() == "" {
+		return "", ErrEmptyID
+	}
+	name := NormalizeUsername(p.LeaderboardName())
+	if name == "" {
+		return "", ErrEmptyUsername
+	}
+	if err := s.checkScore(p.LeaderboardScore()); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (s *Store[T, P]) checkScore(score int) error {
+	if score < s.minScore || score > s.maxScore {
+		return fmt.Errorf("%w: %d is not between %d and %d", ErrRatingOutOfRange, score, s.minScore, s.maxScore)
+	}
+	return nil
+}
+
+// Add inserts a copy of u, whose ID must be new and whose name must not
+// fold to another entrant's.
+func (s *Store[T, P]) Add(u T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.AddLocked(&u)
+}
+
+// AddLocked inserts u itself, stamping when it reached its score, and
+// queues the OnUserCreate hooks.
+func (s *Store[T, P]) AddLocked(u *T) error {
+	name, err := s.validate(u)
+	if err != nil {
+		return err
+	}
+	p := P(u)
+	if _, exists := s.byID[p.LeaderboardID()]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicateID, p.LeaderboardID())
+	}
+	if _, taken := s.byName[FoldUsername(name)]; taken {
+		return fmt.Errorf("%w: %q", ErrDuplicateUsername, name)
+	}
+	p.SetLeaderboardName(name)
+	p.member().reachedAt = s.now()
+	s.RestoreLocked(u)
+	s.hooks.userCreated(u)
+	return nil
+}
+
+// RestoreLocked puts back an entrant taken out with RemoveLocked, as it
+// was, without hooks. The caller has checked that its ID and name are
+// free.
+func (s *Store[T, P]) RestoreLocked(u *T) {
+	p := P(u)
+	m := p.member()
+	m.Rank = 0 // Re-entering entrants do not report a rank change
+	m.UsernameLower = FoldUsername(p.LeaderboardName())
+	s.byID[p.LeaderboardID()] = u
+	s.ranked = append(s.ranked, u)
+	s.ids = insertByID[T, P](s.ids, u)
+	s.insertNameLocked(u)
+	s.membershipChangedLocked()
+}
+
+// Update sets an entrant's score.
+func (s *Store[T, P]) Update(id string, score int) error {
+	if err := s.checkScore(score); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	s.SetScoreLocked(u, score, s.now())
+	return nil
+}
+
+// SetScoreLocked sets u's score, reached at at (UnixNano), widens the
+// re-rank band to cover the move and queues the OnRatingChange hooks. An
+// unchanged score is a no-op.
+func (s *Store[T, P]) SetScoreLocked(u *T, score int, at int64) {
+	p := P(u)
+	old := p.LeaderboardScore()
+	if old == score {
+		return
+	}
+	p.SetLeaderboardScore(score)
+	p.member().reachedAt = at
+	s.markDirtyLocked(old, score)
+	s.hooks.ratingChanged(u, old, score)
+}
+
+// Rename changes an entrant's name.
+func (s *Store[T, P]) Rename(id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	return s.RenameLocked(u, name)
+}
+
+// RenameLocked re-indexes u under name, which must not fold to another
+// entrant's.
+func (s *Store[T, P]) RenameLocked(u *T, name string) error {
+	name = NormalizeUsername(name)
+	if name == "" {
+		return ErrEmptyUsername
+	}
+	lower := FoldUsername(name)
+	if other, taken := s.byName[lower]; taken && other != u {
+		return fmt.Errorf("%w: %q", ErrDuplicateUsername, name)
+	}
+	m := P(u).member()
+	s.removeNameLocked(u)
+	P(u).SetLeaderboardName(name)
+	m.UsernameLower = lower
+	s.insertNameLocked(u)
+	if s.tieBreak.Mode == utils.TieByUsername {
+		s.tieOrderDirty = true // Ties go by name
+	}
+	s.markStaleLocked() // Cached pages show the old name
+	return nil
+}
+
+// Remove deletes an entrant.
+func (s *Store[T, P]) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	s.RemoveLocked(u)
+	return nil
+}
+
+// RemoveLocked takes u out of every index.
+func (s *Store[T, P]) RemoveLocked(u *T) {
+	delete(s.byID, P(u).LeaderboardID())
+	s.ids = removeByID[T, P](s.ids, u)
+	for i, other := range s.ranked {
+		if other == u {
+			s.ranked = append(s.ranked[:i], s.ranked[i+1:]...)
+			break
+		}
+	}
+	s.removeNameLocked(u)
+	s.membershipChangedLocked()
+}
+
+// RemoveAllLocked takes many entrants out with one pass over the ranking.
+func (s *Store[T, P]) RemoveAllLocked(gone map[*T]bool) {
+	if len(gone) == 0 {
+		return
+	}
+	for u := range gone {
+		delete(s.byID, P(u).LeaderboardID())
+		s.removeNameLocked(u)
+	}
+	s.ranked = keepUnless(s.ranked, gone)
+	s.ids = keepUnless(s.ids, gone)
+	s.membershipChangedLocked()
+}
+
+// keepUnless filters list in place, clearing the tail for the collector.
+func keepUnless[T any](list []*T, gone map[*T]bool) []*T {
+	kept := list[:0]
+	for _, u := range list {
+		if !gone[u] {
+			kept = append(kept, u)
+		}
+	}
+	for i := len(kept); i < len(list); i++ {
+		list[i] = nil
+	}
+	return kept
+}
+
+// membershipChangedLocked renumbers the tie order and re-ranks everyone
+// on the next sort.
+func (s *Store[T, P]) membershipChangedLocked() {
+	s.tieOrderDirty = true
+	s.markStaleLocked()
+}
+
+// LoadLocked replaces every entrant with entrants and sorts. Their scores
+// count as loaded, with no time reached.
+func (s *Store[T, P]) LoadLocked(entrants []*T) {
+	s.resetLocked(len(entrants))
+	for _, u := range entrants {
+		p := P(u)
+		name := NormalizeUsername(p.LeaderboardName())
+		p.SetLeaderboardName(name)
+		m := p.member()
+		m.UsernameLower = FoldUsername(name)
+		m.Rank = 0
+		s.byID[p.LeaderboardID()] = u
+		s.byName[m.UsernameLower] = u
+		s.ranked = append(s.ranked, u)
+		s.names = append(s.names, u)
+		s.ids = append(s.ids, u)
+	}
+	s.buildSearchLocked()
+	s.markStaleLocked()
+	s.sortLocked()
+}
+
+// ReplaceLocked swaps in next's entrants and indexes, built off the lock
+// with LoadLocked; next must not be used afterwards. The store keeps its
+// own options, hooks and cache, which it clears.
+func (s *Store[T, P]) ReplaceLocked(next *Store[T, P]) {
+	s.byID, s.byName, s.ranked = next.byID, next.byName, next.ranked
+	s.names, s.ids, s.buckets = next.names, next.ids, next.buckets
+	s.trieIdx, s.tokens = next.trieIdx, next.tokens
+	s.sortKeys, s.byTieOrd, s.tieOrderDirty = next.sortKeys, next.byTieOrd, next.tieOrderDirty
+	s.dirtyLo, s.dirtyHi, s.dirtyAll = next.dirtyLo, next.dirtyHi, next.dirtyAll
+	s.stale = next.stale
+	if s.pages != nil {
+		s.pages.Clear()
+	}
+}
+
+// Get returns an entrant by ID, ranked as of the last sort.
+func (s *Store[T, P]) Get(id string) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.byID[id]
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	return *u, nil
+}
+
+// GetByName returns an entrant by name, matched case-insensitively.
+func (s *Store[T, P]) GetByName(name string) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.GetByNameLocked(name)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("%w: %q", ErrUserNotFound, name)
+	}
+	return *u, nil
+}
+
+// GetLocked finds an entrant by ID.
+func (s *Store[T, P]) GetLocked(id string) (*T, bool) {
+	u, ok := s.byID[id]
+	return u, ok
+}
+
+// GetByNameLocked finds an entrant by name, matched case-insensitively.
+func (s *Store[T, P]) GetByNameLocked(name string) (*T, bool) {
+	u, ok := s.byName[FoldUsername(NormalizeUsername(name))]
+	return u, ok
+}
+
+// EachLocked calls fn for every entrant, in no particular order.
+func (s *Store[T, P]) EachLocked(fn func(*T)) {
+	for _, u := range s.byID {
+		fn(u)
+	}
+}
+
+// RankedLocked is every entrant, best first as of the last sort; between a
+// write and the next sort only the dirty band is out of order.
+func (s *Store[T, P]) RankedLocked() []*T { return s.ranked }
+
+// NamesLocked is every entrant by folded name.
+func (s *Store[T, P]) NamesLocked() []*T { return s.names }
+
+// IDsLocked is every entrant by ID.
+func (s *Store[T, P]) IDsLocked() []*T { return s.ids }
+
+func (s *Store[T, P]) markStaleLocked() {
+	if !s.stale {
+		s.stale = true
+		if s.pages != nil {
+			s.pages.Clear() // Pages built since the last write are still good
+		}
+	}
+}
+
+var clock struct {
+	sync.Mutex
+	last int64
+}
+
+// monotonicNow is time.Now in UnixNano, never repeating, so entrants that
+// reach a score in the same nanosecond still order by who came first.
+func monotonicNow() int64 {
+	clock.Lock()
+	defer clock.Unlock()
+	now := time.Now().UnixNano()
+	if now <= clock.last {
+		now = clock.last + 1
+	}
+	clock.last = now
+	return now
+}
@@ -0,0 +1,118 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// newBoard returns a store holding users, failing t if any is refused.
+func newBoard(t *testing.T, users []User, opts ...Option) *Store[User, *User] {
+	t.Helper()
+	s := New[User](opts...)
+	for _, u := range users {
+		if err := s.Add(u); err != nil {
+			t.Fatalf("Add(%s): %v", u.ID, err)
+		}
+	}
+	return s
+}
+
+func TestStoreAdd(t *testing.T) {
+	s := newBoard(t, []User{{ID: "u1", Username: "Alice", Rating: 1500}})
+	tests := []struct {
+		name string
+		u    User
+		want error
+	}{
+		{"duplicate ID", User{ID: "u1", Username: "bob", Rating: 1400}, ErrDuplicateID},
+		{"same name", User{ID: "u2", Username: "Alice", Rating: 1400}, ErrDuplicateUsername},
+		{"name folds the same", User{ID: "u2", Username: "ALICE", Rating: 1400}, ErrDuplicateUsername},
+		{"name clashes once trimmed", User{ID: "u2", Username: " alice ", Rating: 1400}, ErrDuplicateUsername},
+		{"empty ID", User{Username: "carol", Rating: 1400}, ErrEmptyID},
+		{"empty name", User{ID: "u2", Username: "  ", Rating: 1400}, ErrEmptyUsername},
+	}
+	for _, tt := range tests {
+		if err := s.Add(tt.u); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Add = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("a refused Add changed the store: Len = %d", n)
+	}
+
+	if err := s.Add(User{ID: "u2", Username: "Bob", Rating: 1400}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.GetByName("BOB")
+	if err != nil || u.ID != "u2" || u.UsernameLower != "bob" {
+		t.Errorf("GetByName(BOB) = %+v, %v", u, err)
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := newBoard(t, []User{
+		{ID: "u1", Username: "alice", Rating: 1500},
+		{ID: "u2", Username: "bob", Rating: 1400},
+	})
+	if err := s.Update("u2", 1600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.Rank(ctx, "u2")
+	if err != nil || u.Rating != 1600 || u.Rank != 1 {
+		t.Errorf("after Update, Rank(u2) = %+v, %v; want rating 1600, rank 1", u, err)
+	}
+	if err := s.Update("nobody", 1000); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Update(nobody) = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestStoreRemove(t *testing.T) {
+	ctx := context.Background()
+	s := newBoard(t, []User{
+		{ID: "u1", Username: "alice", Rating: 1500},
+		{ID: "u2", Username: "bob", Rating: 1400},
+		{ID: "u3", Username: "carol", Rating: 1300},
+	})
+	if err := s.Remove("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove("u1"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("second Remove = %v, want %v", err, ErrUserNotFound)
+	}
+	if _, err := s.Get("u1"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Get after Remove = %v, want %v", err, ErrUserNotFound)
+	}
+	if u, err := s.Rank(ctx, "u2"); err != nil || u.Rank != 1 {
+		t.Errorf("Rank(u2) after removing the leader = %d, %v; want 1", u.Rank, err)
+	}
+	if found, _, _, _ := s.Search(ctx, "alice", 1, 10); len(found) != 0 {
+		t.Errorf("Search still finds the removed user: %+v", found)
+	}
+
+	// The ID and name are free again
+	if err := s.Add(User{ID: "u1", Username: "ALICE", Rating: 1000}); err != nil {
+		t.Errorf("re-adding a removed user: %v", err)
+	}
+}
+
+func TestWithRatingRange(t *testing.T) {
+	s := newBoard(t, []User{{ID: "u1", Username: "alice", Rating: 1500}}, WithRatingRange(0, 3000))
+	for _, rating := range []int{-1, 3001} {
+		if err := s.Add(User{ID: "u2", Username: "bob", Rating: rating}); !errors.Is(err, ErrRatingOutOfRange) {
+			t.Errorf("Add at %d = %v, want %v", rating, err, ErrRatingOutOfRange)
+		}
+		if err := s.Update("u1", rating); !errors.Is(err, ErrRatingOutOfRange) {
+			t.Errorf("Update to %d = %v, want %v", rating, err, ErrRatingOutOfRange)
+		}
+	}
+	if u, _ := s.Get("u1"); u.Rating != 1500 {
+		t.Errorf("a refused Update changed the rating to %d", u.Rating)
+	}
+	for _, rating := range []int{0, 3000} {
+		if err := s.Update("u1", rating); err != nil {
+			t.Errorf("Update to the bound %d: %v", rating, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package leaderboard
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"matiks-leaderboard/utils"
+)
+
+// NormalizeUsername trims a name and puts it in NFC, so the same name typed
+// with precomposed or combining accents is one name.
+func NormalizeUsername(name string) string {
+	return utils.NormalizeUsername(name)
+}
+
+// FoldUsername is the case-insensitive form of a name or search query,
+// which usernames are compared and searched in. The seeder folds with the
+// same function (utils.FoldUsername), so seeded names never clash here.
+func FoldUsername(name string) string {
+	return utils.FoldUsername(name)
+}
+
+// UsernameWidth counts a name's characters, with each CJK character
+// counting twice: two-character Chinese or Korean names are common and as
+// distinctive as four Latin letters.
+func UsernameWidth(name string) int {
+	width := 0
+	for _, r := range name {
+		width++
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo) {
+			width++
+		}
+	}
+	return width
+}
+
+// UsernameBucket is the search bucket for a folded name or query: its first
+// rune, so "é…" and "李…" names get buckets of their own instead of sharing
+// one per lead byte.
+func UsernameBucket(folded string) rune {
+	r, _ := utf8.DecodeRuneInString(folded)
+	return r
+}
@@ -0,0 +1,109 @@
+package leaderboard
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Page and PageBand can serve pages from a PageCache between writes. The
+// store clears it on the first write after a sort, since until the next
+// sort nothing new can be cached, and on ReplaceLocked. Each page is
+// stamped with the cache generation its miss reported, before the page was
+// built, and a clear starts a new generation, so a page built before a
+// write but stored after it is never served.
+//
+// MemoryPageCache keeps pages in this process for a TTL. Other backends,
+// such as one shared through Redis, implement the same three methods.
+
+// CacheOutcome is how a page lookup went.
+type CacheOutcome int
+
+const (
+	CacheHit     CacheOutcome = iota
+	CacheMiss                 // No page for the key
+	CacheExpired              // A page older than the TTL or generation
+	CacheBypass               // The store has no cache
+)
+
+// CachedPage is one cached page.
+type CachedPage[T any] struct {
+	Entrants   []T
+	Total      int // Entrants in the page's band
+	Built      time.Time
+	Generation int64
+	Version    int64 // Free for the cache's owner, e.g. a cluster version
+}
+
+// PageCache holds pages by key.
+type PageCache[T any] interface {
+	// Get returns a fresh page, or on a miss a page carrying only the
+	// current generation, which the store stamps on the page it builds.
+	Get(ctx context.Context, key string) (CachedPage[T], CacheOutcome)
+	// Set stores page unless its generation has been retired.
+	Set(ctx context.Context, key string, page CachedPage[T])
+	// Clear retires every cached page. It is called under the store's
+	// write lock and must not block.
+	Clear()
+}
+
+// MemoryPageCache is a PageCache in this process.
+type MemoryPageCache[T any] struct {
+	ttl time.Duration
+
+	mu         sync.RWMutex
+	pages      map[string]CachedPage[T]
+	generation int64
+}
+
+// NewMemoryPageCache keeps pages for ttl after they were built.
+func NewMemoryPageCache[T any](ttl time.Duration) *MemoryPageCache[T] {
+	return &MemoryPageCache[T]{ttl: ttl, pages: make(map[string]CachedPage[T])}
+}
+
+func (c *MemoryPageCache[T]) Get(ctx context.Context, key string) (CachedPage[T], CacheOutcome) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	page, exists := c.pages[key]
+	if !exists {
+		return CachedPage[T]{Generation: c.generation}, CacheMiss
+	}
+	if time.Since(page.Built) > c.ttl {
+		return CachedPage[T]{Generation: c.generation}, CacheExpired
+	}
+	return page, CacheHit
+}
+
+func (c *MemoryPageCache[T]) Set(ctx context.Context, key string, page CachedPage[T]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if page.Generation == c.generation {
+		c.pages[key] = page
+	}
+}
+
+func (c *MemoryPageCache[T]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pages = make(map[string]CachedPage[T])
+	c.generation++
+}
+
+// Len is the number of pages held, expired ones included.
+func (c *MemoryPageCache[T]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.pages)
+}
+
+// TTL is how long a page may be served after it was built.
+func (c *MemoryPageCache[T]) TTL() time.Duration { return c.ttl }
+
+// Each calls fn for every page held, under the cache's read lock.
+func (c *MemoryPageCache[T]) Each(fn func(key string, page CachedPage[T])) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, page := range c.pages {
+		fn(key, page)
+	}
+}
@@ -0,0 +1,439 @@
+package leaderboard
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"matiks-leaderboard/utils"
+)
+
+// Sorting is lazy and partial. A score change only widens the dirty band,
+// the range of direction keys (see Direction.Key) between the old and new
+// scores of everything changed since the last sort. Outside the band the
+// ranking is still in order, so the sort finds the band by binary search
+// and re-sorts just that slice; entrants above it keep their ranks, and
+// below it only dense ranks can move. Adding or removing entrants re-sorts
+// everyone.
+//
+// The sort compares packed uint64 keys, the score's distance from the
+// band's best in the high half and the entrant's position in tie-break
+// order (tieOrd) in the low half, instead of chasing pointers and string
+// IDs. Bands wider than 32 bits and earliest-first ties, which change with
+// every update, compare entrants directly.
+
+// SortInfo describes one completed sort.
+type SortInfo struct {
+	Users       int
+	Reranked    int // Entrants inside the re-sorted band
+	Duration    time.Duration
+	RankChanges []RankChange
+}
+
+// RankChange is one entrant whose rank a sort moved.
+type RankChange struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	OldRank  int    `json:"oldRank"`
+	NewRank  int    `json:"newRank"`
+}
+
+// Band is an inclusive score range.
+type Band struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// AllScores matches every entrant.
+var AllScores = Band{Min: math.MinInt, Max: math.MaxInt}
+
+func (s *Store[T, P]) tieKey(u *T) utils.TieKey {
+	m := P(u).member()
+	return utils.TieKey{ID: P(u).LeaderboardID(), UsernameLower: m.UsernameLower, ReachedAt: m.reachedAt}
+}
+
+// LessLocked reports whether a ranks ahead of b by current scores.
+func (s *Store[T, P]) LessLocked(a, b *T) bool {
+	as, bs := P(a).LeaderboardScore(), P(b).LeaderboardScore()
+	if as != bs {
+		return s.direction.Better(as, bs)
+	}
+	if !s.tieBreak.Static() || s.tieOrderDirty {
+		return s.tieBreak.Less(s.tieKey(a), s.tieKey(b))
+	}
+	return P(a).member().tieOrd < P(b).member().tieOrd
+}
+
+// StaleLocked reports whether writes are waiting for a sort.
+func (s *Store[T, P]) StaleLocked() bool { return s.stale }
+
+// SortLocked sorts the dirty band now rather than on the next read.
+func (s *Store[T, P]) SortLocked() {
+	s.sortLocked()
+}
+
+// ResortLocked re-sorts and re-ranks everyone, whether or not anything
+// changed since the last sort.
+func (s *Store[T, P]) ResortLocked() {
+	s.dirtyAll = true
+	s.sortLocked()
+	if s.pages != nil {
+		s.pages.Clear()
+	}
+}
+
+func (s *Store[T, P]) sortLocked() {
+	startTime := time.Now()
+	start, end := s.rerankRangeLocked()
+	s.sortByKeyLocked(start, end)
+
+	// Everyone before start is rated above the band and keeps their rank,
+	// so numbering resumes from the entrant just before it. Past the band
+	// only dense ranks can move, and once one agrees with the stored rank
+	// the rest do too
+	var changes []RankChange
+	prevRank := 0
+	if start > 0 {
+		prevRank = P(s.ranked[start-1]).member().Rank
+	}
+	for i := start; i < len(s.ranked); i++ {
+		p := P(s.ranked[i])
+		m := p.member()
+		tied := i > 0 && P(s.ranked[i-1]).LeaderboardScore() == p.LeaderboardScore()
+		rank := s.mode.Next(prevRank, i, tied)
+		if i >= end && (s.mode != utils.RankDense || rank == m.Rank) {
+			break
+		}
+		if m.Rank != 0 && m.Rank != rank {
+			changes = append(changes, RankChange{
+				UserID:   p.LeaderboardID(),
+				Username: p.LeaderboardName(),
+				OldRank:  m.Rank,
+				NewRank:  rank,
+			})
+		}
+		m.Rank = rank
+		prevRank = rank
+	}
+
+	s.stale = false
+	s.dirtyLo, s.dirtyHi, s.dirtyAll = math.MaxInt, math.MinInt, false
+	info := SortInfo{
+		Users:       len(s.ranked),
+		Reranked:    end - start,
+		Duration:    time.Since(startTime),
+		RankChanges: changes,
+	}
+	s.hooks.sorted(info)
+	if s.onSort != nil {
+		s.onSort(info)
+	}
+}
+
+// markDirtyLocked widens the re-rank band to cover a score change.
+func (s *Store[T, P]) markDirtyLocked(oldScore, newScore int) {
+	lo, hi := s.direction.Key(oldScore), s.direction.Key(newScore)
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	s.dirtyLo = min(s.dirtyLo, lo)
+	s.dirtyHi = max(s.dirtyHi, hi)
+	s.markStaleLocked()
+}
+
+// rerankRangeLocked returns the slice of ranked that must be re-sorted and
+// re-ranked. Outside the dirty band the slice is still sorted (keys above
+// dirtyHi, then the band, then below dirtyLo), so both ends can be found
+// by binary search even though the band itself is out of order.
+func (s *Store[T, P]) rerankRangeLocked() (int, int) {
+	n := len(s.ranked)
+	if s.dirtyAll || s.tieOrderDirty || len(s.byTieOrd) != n {
+		return 0, n
+	}
+	key := func(i int) int { return s.direction.Key(P(s.ranked[i]).LeaderboardScore()) }
+	start := sort.Search(n, func(i int) bool { return key(i) <= s.dirtyHi })
+	end := sort.Search(n, func(i int) bool { return key(i) < s.dirtyLo })
+	if end < start {
+		end = start // Empty band: nothing changed
+	}
+	return start, end
+}
+
+// sortKeySlice sorts packed keys without sort.Slice's reflection swapper
+type sortKeySlice []uint64
+
+func (k sortKeySlice) Len() int           { return len(k) }
+func (k sortKeySlice) Less(i, j int) bool { return k[i] < k[j] }
+func (k sortKeySlice) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+// sortByKeyLocked orders ranked[start:end] best score first, then by the
+// tie break.
+func (s *Store[T, P]) sortByKeyLocked(start, end int) {
+	if s.tieOrderDirty || len(s.byTieOrd) != len(s.ranked) {
+		s.assignTieOrdinalsLocked()
+	}
+
+	users := s.ranked[start:end]
+	best, worst := math.MinInt, math.MaxInt
+	for _, u := range users {
+		k := s.direction.Key(P(u).LeaderboardScore())
+		best, worst = max(best, k), min(worst, k)
+	}
+	if len(users) > 0 && (uint64(best)-uint64(worst) > math.MaxUint32 || !s.tieBreak.Static()) {
+		sort.Slice(users, func(i, j int) bool { return s.LessLocked(users[i], users[j]) })
+		return
+	}
+
+	if cap(s.sortKeys) < len(s.ranked) {
+		s.sortKeys = make(sortKeySlice, len(s.ranked))
+	}
+	keys := s.sortKeys[:end-start]
+	for i, u := range users {
+		keys[i] = (uint64(best)-uint64(s.direction.Key(P(u).LeaderboardScore())))<<32 | uint64(P(u).member().tieOrd)
+	}
+
+	sort.Sort(keys)
+
+	for i, key := range keys {
+		s.ranked[start+i] = s.byTieOrd[uint32(key)]
+	}
+}
+
+// assignTieOrdinalsLocked numbers entrants in tie-break order (ID order
+// for earliest, which sorts on reachedAt directly). It only reruns when
+// the entrants change, or on renames when ties go by username.
+func (s *Store[T, P]) assignTieOrdinalsLocked() {
+	ordered := make([]*T, len(s.ranked))
+	copy(ordered, s.ranked)
+	sort.Slice(ordered, func(i, j int) bool {
+		if !s.tieBreak.Static() {
+			return P(ordered[i]).LeaderboardID() < P(ordered[j]).LeaderboardID()
+		}
+		return s.tieBreak.Less(s.tieKey(ordered[i]), s.tieKey(ordered[j]))
+	})
+	for i, u := range ordered {
+		P(u).member().tieOrd = uint32(i)
+	}
+	s.byTieOrd = ordered
+	s.tieOrderDirty = false
+}
+
+// RLockSorted takes the read lock with the ranking up to date, running a
+// pending sort first. A caller whose context is done by the time it holds
+// the write lock gets ctx.Err() instead of starting the sort, and holds no
+// lock. Sorts are never stopped partway: the re-rank band of a half-sorted
+// list would no longer say what is out of order.
+func (s *Store[T, P]) RLockSorted(ctx context.Context) error {
+	s.mu.RLock()
+	if !s.stale {
+		return nil
+	}
+	s.mu.RUnlock()
+	s.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if s.stale {
+		s.sortLocked()
+	}
+	s.mu.Unlock()
+	s.mu.RLock()
+	return nil
+}
+
+// BandBounds locates band in sorted, best first in direction d, by binary
+// search.
+func BandBounds[T any, P Entrant[T]](sorted []*T, band Band, d Direction) (int, int) {
+	first, last := band.Max, band.Min // The band's best and worst score
+	if d == Ascending {
+		first, last = band.Min, band.Max
+	}
+	start := sort.Search(len(sorted), func(i int) bool {
+		return !d.Better(P(sorted[i]).LeaderboardScore(), first)
+	})
+	end := sort.Search(len(sorted), func(i int) bool {
+		return d.Better(last, P(sorted[i]).LeaderboardScore())
+	})
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// BandLocked locates band in RankedLocked; the caller holds RLockSorted.
+func (s *Store[T, P]) BandLocked(band Band) (int, int) {
+	return BandBounds[T, P](s.ranked, band, s.direction)
+}
+
+// CountLocked counts entrants ranked above, tied with and below score
+// using current scores, not the possibly stale Rank. Outside the dirty
+// band the ranking is still ordered, so those parts are binary searched;
+// only the band is scanned.
+func (s *Store[T, P]) CountLocked(score int) (above, equal, below int) {
+	start, end := s.rerankRangeLocked() // Empty when nothing is pending
+	users := s.ranked
+	d := s.direction
+	countSorted := func(lo, hi int) {
+		gt := lo + sort.Search(hi-lo, func(i int) bool { return !d.Better(P(users[lo+i]).LeaderboardScore(), score) })
+		ge := lo + sort.Search(hi-lo, func(i int) bool { return d.Better(score, P(users[lo+i]).LeaderboardScore()) })
+		above += gt - lo
+		equal += ge - gt
+		below += hi - ge
+	}
+	countSorted(0, start)
+	countSorted(end, len(users))
+	for _, u := range users[start:end] {
+		switch v := P(u).LeaderboardScore(); {
+		case d.Better(v, score):
+			above++
+		case v == score:
+			equal++
+		default:
+			below++
+		}
+	}
+	return above, equal, below
+}
+
+// LiveRankLocked is u's rank from current scores in the store's rank
+// mode; above is CountLocked's first result for u's score.
+func (s *Store[T, P]) LiveRankLocked(u *T, above int) int {
+	switch s.mode {
+	case utils.RankOrdinal:
+		ahead := 0
+		key := s.tieKey(u)
+		s.tiedLocked(P(u).LeaderboardScore(), func(other *T) {
+			if other != u && s.tieBreak.Less(s.tieKey(other), key) {
+				ahead++
+			}
+		})
+		return above + ahead + 1
+	case utils.RankDense:
+		if !s.stale {
+			return P(u).member().Rank
+		}
+		return s.distinctAboveLocked(P(u).LeaderboardScore()) + 1
+	}
+	return above + 1
+}
+
+// tiedLocked calls fn for every entrant scored exactly score.
+func (s *Store[T, P]) tiedLocked(score int, fn func(*T)) {
+	start, end := s.rerankRangeLocked()
+	users := s.ranked
+	d := s.direction
+	visit := func(lo, hi int) {
+		i := lo + sort.Search(hi-lo, func(i int) bool { return !d.Better(P(users[lo+i]).LeaderboardScore(), score) })
+		for ; i < hi && P(users[i]).LeaderboardScore() == score; i++ {
+			fn(users[i])
+		}
+	}
+	visit(0, start)
+	visit(end, len(users))
+	for _, u := range users[start:end] {
+		if P(u).LeaderboardScore() == score {
+			fn(u)
+		}
+	}
+}
+
+// distinctAboveLocked counts the distinct scores better than score.
+func (s *Store[T, P]) distinctAboveLocked(score int) int {
+	start, end := s.rerankRangeLocked()
+	users := s.ranked
+	seen := make(map[int]bool)
+	count := func(part []*T, sorted bool) {
+		for _, u := range part {
+			if v := P(u).LeaderboardScore(); s.direction.Better(v, score) {
+				seen[v] = true
+			} else if sorted {
+				return // Nothing better follows in a sorted part
+			}
+		}
+	}
+	count(users[:start], true)
+	count(users[start:end], false)
+	count(users[end:], true)
+	return len(seen)
+}
+
+// Page returns one page of the ranking (pages count from 1) with the
+// number of entrants and pages.
+func (s *Store[T, P]) Page(ctx context.Context, page, limit int) (entrants []T, total, totalPages int, err error) {
+	entrants, total, totalPages, _, err = s.PageBand(ctx, AllScores, page, limit)
+	return entrants, total, totalPages, err
+}
+
+// PageBand pages through the entrants scored within band. Ranks stay
+// global; total and page counts cover the band only. Pages come from the
+// page cache when there is one, and the outcome says how.
+func (s *Store[T, P]) PageBand(ctx context.Context, band Band, page, limit int) ([]T, int, int, CacheOutcome, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, CacheBypass, err
+	}
+	page, limit = max(page, 1), max(limit, 1)
+
+	// Cached pages carry their band's total, since locating the band needs
+	// the store lock
+	key := fmt.Sprintf("lb:%d:%d:%d:%d", band.Min, band.Max, page, limit)
+	outcome := CacheBypass
+	var cached CachedPage[T]
+	if s.pages != nil {
+		if cached, outcome = s.pages.Get(ctx, key); outcome == CacheHit {
+			return cached.Entrants, cached.Total, pageCount(page, limit, cached.Total), outcome, nil
+		}
+	}
+
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, 0, outcome, err
+	}
+	bandStart, bandEnd := s.BandLocked(band)
+	total := bandEnd - bandStart
+	start := min((page-1)*limit, total)
+	end := min(start+limit, total)
+	entrants := make([]T, end-start)
+	for i, u := range s.ranked[bandStart+start : bandStart+end] {
+		entrants[i] = *u
+	}
+	s.mu.RUnlock()
+
+	// Stored with the generation the miss reported, from before the page
+	// was built, so a write since retires it
+	if s.pages != nil {
+		cached.Entrants, cached.Total, cached.Built = entrants, total, time.Now()
+		s.pages.Set(ctx, key, cached)
+	}
+	return entrants, total, pageCount(page, limit, total), outcome, nil
+}
+
+// pageCount is the number of pages of limit in total, or 0 when page is
+// past the end.
+func pageCount(page, limit, total int) int {
+	if (page-1)*limit >= total {
+		return 0
+	}
+	return (total + limit - 1) / limit
+}
+
+// Top returns the n best-ranked entrants.
+func (s *Store[T, P]) Top(ctx context.Context, n int) ([]T, error) {
+	entrants, _, _, err := s.Page(ctx, 1, n)
+	return entrants, err
+}
+
+// Rank returns an entrant by ID with its current rank.
+func (s *Store[T, P]) Rank(ctx context.Context, id string) (T, error) {
+	var zero T
+	if err := s.RLockSorted(ctx); err != nil {
+		return zero, err
+	}
+	defer s.mu.RUnlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return zero, fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	return *u, nil
+}
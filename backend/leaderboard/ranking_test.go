@@ -0,0 +1,272 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/utils"
+)
+
+// fourUsers has one tie, between b and c.
+var fourUsers = []User{
+	{ID: "c", Username: "carol", Rating: 200},
+	{ID: "a", Username: "alice", Rating: 300},
+	{ID: "d", Username: "dave", Rating: 100},
+	{ID: "b", Username: "bob", Rating: 200},
+}
+
+// standings lists a page as IDs and ranks.
+func standings(users []User) (ids []string, ranks []int) {
+	for _, u := range users {
+		ids = append(ids, u.ID)
+		ranks = append(ranks, u.Rank)
+	}
+	return ids, ranks
+}
+
+func TestPageRankModes(t *testing.T) {
+	tests := []struct {
+		mode      utils.RankMode
+		direction Direction
+		wantIDs   []string
+		wantRanks []int
+	}{
+		{utils.RankStandard, Descending, []string{"a", "b", "c", "d"}, []int{1, 2, 2, 4}},
+		{utils.RankDense, Descending, []string{"a", "b", "c", "d"}, []int{1, 2, 2, 3}},
+		{utils.RankOrdinal, Descending, []string{"a", "b", "c", "d"}, []int{1, 2, 3, 4}},
+		{utils.RankStandard, Ascending, []string{"d", "b", "c", "a"}, []int{1, 2, 2, 4}},
+		{utils.RankDense, Ascending, []string{"d", "b", "c", "a"}, []int{1, 2, 2, 3}},
+		{utils.RankOrdinal, Ascending, []string{"d", "b", "c", "a"}, []int{1, 2, 3, 4}},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+"/"+string(tt.direction), func(t *testing.T) {
+			s := newBoard(t, fourUsers, WithRankMode(tt.mode), WithDirection(tt.direction))
+			users, total, pages, err := s.Page(ctx, 1, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids, ranks := standings(users)
+			if !reflect.DeepEqual(ids, tt.wantIDs) || !reflect.DeepEqual(ranks, tt.wantRanks) {
+				t.Errorf("Page = %v ranked %v, want %v ranked %v", ids, ranks, tt.wantIDs, tt.wantRanks)
+			}
+			if total != 4 || pages != 1 {
+				t.Errorf("Page total %d, pages %d; want 4, 1", total, pages)
+			}
+
+			top, err := s.Top(ctx, 2)
+			if ids, _ := standings(top); err != nil || !reflect.DeepEqual(ids, tt.wantIDs[:2]) {
+				t.Errorf("Top(2) = %v, %v; want %v", ids, err, tt.wantIDs[:2])
+			}
+			for i, id := range tt.wantIDs {
+				if u, err := s.Rank(ctx, id); err != nil || u.Rank != tt.wantRanks[i] {
+					t.Errorf("Rank(%s) = %d, %v; want %d", id, u.Rank, err, tt.wantRanks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPagePaging(t *testing.T) {
+	ctx := context.Background()
+	s := newBoard(t, fourUsers)
+	tests := []struct {
+		page, limit int
+		want        []string
+		wantPages   int
+	}{
+		{1, 3, []string{"a", "b", "c"}, 2},
+		{2, 3, []string{"d"}, 2},
+		{3, 3, nil, 0},
+		{0, 2, []string{"a", "b"}, 2}, // Pages count from 1
+	}
+	for _, tt := range tests {
+		users, total, pages, err := s.Page(ctx, tt.page, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids, _ := standings(users); !reflect.DeepEqual(ids, tt.want) || total != 4 || pages != tt.wantPages {
+			t.Errorf("Page(%d, %d) = %v of %d in %d pages; want %v of 4 in %d", tt.page, tt.limit, ids, total, pages, tt.want, tt.wantPages)
+		}
+	}
+
+	users, total, _, _, err := s.PageBand(ctx, Band{Min: 150, Max: 250}, 1, 10)
+	if ids, ranks := standings(users); err != nil || total != 2 || !reflect.DeepEqual(ids, []string{"b", "c"}) || !reflect.DeepEqual(ranks, []int{2, 2}) {
+		t.Errorf("PageBand(150-250) = %v ranked %v of %d, %v; want [b c] ranked [2 2] of 2", ids, ranks, total, err)
+	}
+}
+
+func TestTieBreakOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// Earliest to reach the rating first, with a clock the test moves
+	var now int64
+	clock := func() int64 { now++; return now }
+	random := utils.TieBreak{Mode: utils.TieByRandom, Seed: 7}
+	randomWant := []string{"a", "b", "c", "d"}
+	if random.Less(utils.TieKey{ID: "c"}, utils.TieKey{ID: "b"}) {
+		randomWant = []string{"a", "c", "b", "d"}
+	}
+	tests := []struct {
+		name  string
+		tie   utils.TieBreak
+		setup func(s *Store[User, *User])
+		want  []string
+	}{
+		{"id", utils.TieBreak{Mode: utils.TieByID}, nil, []string{"a", "b", "c", "d"}},
+		{"username", utils.TieBreak{Mode: utils.TieByUsername}, func(s *Store[User, *User]) {
+			s.Rename("b", "zed")
+		}, []string{"a", "c", "b", "d"}},
+		{"earliest", utils.TieBreak{Mode: utils.TieByEarliest}, nil, []string{"a", "c", "b", "d"}},
+		{"earliest after updates", utils.TieBreak{Mode: utils.TieByEarliest}, func(s *Store[User, *User]) {
+			s.Update("c", 250)
+			s.Update("c", 200) // Back to 200 after b reached it
+		}, []string{"a", "b", "c", "d"}},
+		{"random", random, nil, randomWant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newBoard(t, fourUsers, WithTieBreak(tt.tie), WithClock(clock))
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+			users, _, _, err := s.Page(ctx, 1, 10)
+			if ids, _ := standings(users); err != nil || !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Page = %v, %v; want %v", ids, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	pages := NewMemoryPageCache[User](time.Hour)
+	s := newBoard(t, fourUsers, WithPageCache[User](pages))
+
+	page := func() ([]string, CacheOutcome) {
+		t.Helper()
+		users, _, _, outcome, err := s.PageBand(ctx, AllScores, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, _ := standings(users)
+		return ids, outcome
+	}
+	if _, outcome := page(); outcome != CacheMiss {
+		t.Errorf("first read: outcome %v, want a miss", outcome)
+	}
+	if _, outcome := page(); outcome != CacheHit {
+		t.Errorf("second read: outcome %v, want a hit", outcome)
+	}
+
+	writes := []struct {
+		name  string
+		write func() error
+		want  []string
+	}{
+		{"update", func() error { return s.Update("d", 400) }, []string{"d", "a", "b", "c"}},
+		{"remove", func() error { return s.Remove("a") }, []string{"d", "b", "c"}},
+		{"add", func() error { return s.Add(User{ID: "e", Username: "eve", Rating: 500}) }, []string{"e", "d", "b", "c"}},
+	}
+	for _, w := range writes {
+		if err := w.write(); err != nil {
+			t.Fatal(err)
+		}
+		ids, outcome := page()
+		if outcome == CacheHit || !reflect.DeepEqual(ids, w.want) {
+			t.Errorf("after %s: %v (outcome %v), want %v rebuilt", w.name, ids, outcome, w.want)
+		}
+	}
+
+	if err := s.Rename("b", "Bobby"); err != nil {
+		t.Fatal(err)
+	}
+	users, _, _, outcome, _ := s.PageBand(ctx, AllScores, 1, 10)
+	if outcome == CacheHit || users[2].Username != "Bobby" {
+		t.Errorf("after rename: %q (outcome %v), want Bobby rebuilt", users[2].Username, outcome)
+	}
+}
+
+func TestRLockSortedCancelled(t *testing.T) {
+	s := newBoard(t, fourUsers)
+	if err := s.Update("d", 400); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.RLockSorted(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RLockSorted = %v, want %v", err, context.Canceled)
+	}
+	if !s.mu.TryLock() {
+		t.Fatal("RLockSorted returned an error holding the lock")
+	}
+	s.mu.Unlock()
+	if _, _, _, err := s.Page(ctx, 1, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("Page = %v, want %v", err, context.Canceled)
+	}
+	if !s.StaleLocked() {
+		t.Error("a cancelled read ran the sort")
+	}
+
+	u, err := s.Rank(context.Background(), "d")
+	if err != nil || u.Rank != 1 {
+		t.Errorf("Rank(d) = %d, %v; want 1", u.Rank, err)
+	}
+}
+
+// TestDirtyBandRerank checks that re-ranking only the band a batch of
+// writes touched leaves the same order and ranks as re-ranking everyone.
+func TestDirtyBandRerank(t *testing.T) {
+	for _, mode := range []utils.RankMode{utils.RankStandard, utils.RankDense, utils.RankOrdinal} {
+		for _, direction := range []Direction{Descending, Ascending} {
+			t.Run(string(mode)+"/"+string(direction), func(t *testing.T) {
+				rng := rand.New(rand.NewSource(1))
+				var users []User
+				for i := 0; i < 400; i++ {
+					users = append(users, User{ID: fmt.Sprintf("u%03d", i), Username: fmt.Sprintf("user%03d", i), Rating: 10 * rng.Intn(50)})
+				}
+				opts := []Option{WithRankMode(mode), WithDirection(direction), WithTieBreak(utils.TieBreak{Mode: utils.TieByEarliest})}
+				band, full := newBoard(t, users, opts...), newBoard(t, users, opts...)
+				band.Lock()
+				defer band.Unlock()
+				full.Lock()
+				defer full.Unlock()
+				band.ResortLocked()
+				full.ResortLocked()
+
+				for round := 0; round < 200; round++ {
+					// Nudges within a tie block up to jumps across the board,
+					// sometimes onto a rating already held
+					spread := []int{1, 10, 100, 500}[rng.Intn(4)]
+					at := int64(round + 1)
+					for i := 0; i < 1+rng.Intn(8); i++ {
+						id := users[rng.Intn(len(users))].ID
+						b, _ := band.GetLocked(id)
+						f, _ := full.GetLocked(id)
+						score := b.Rating + rng.Intn(2*spread+1) - spread
+						if rng.Intn(3) == 0 {
+							score = 10 * rng.Intn(50)
+						}
+						band.SetScoreLocked(b, score, at)
+						full.SetScoreLocked(f, score, at)
+					}
+					band.SortLocked()
+					full.ResortLocked()
+
+					for i, b := range band.RankedLocked() {
+						f := full.RankedLocked()[i]
+						if b.ID != f.ID || b.Rank != f.Rank {
+							t.Fatalf("round %d, position %d: band re-rank has %s ranked %d, full re-rank %s ranked %d",
+								round, i, b.ID, b.Rank, f.ID, f.Rank)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package leaderboard
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Search finds entrants whose folded name starts with the folded query,
+// through one of two strategies that return the same entrants in the
+// same order:
+//
+//   - SearchBucket: binary search in the query's first-rune bucket, or in
+//     the whole name-sorted list without one.
+//   - SearchTrie: a byte trie of folded names, walked from the query's
+//     node. It costs a node per distinct folded-name byte; WithTrie(false)
+//     skips it.
+//
+// Names also match on their later components: split on _ . - and digits,
+// "alex_sharma5" has the token "sharma", so "shar" finds it. Name matches
+// come first, then token matches in name order, up to the search limit.
+// The token index holds one entry per token that doesn't start the name
+// (the name prefix search covers that one) and is at least two characters
+// wide, so names without separators cost nothing.
+//
+// Entrant IDs have an index of their own for prefix lookups (SearchIDs),
+// matched exactly as written.
+
+// SearchStrategy picks how MatchLocked finds name matches.
+type SearchStrategy int
+
+const (
+	SearchBucket SearchStrategy = iota
+	SearchTrie
+)
+
+func (a SearchStrategy) Name() string {
+	if a == SearchTrie {
+		return "trie"
+	}
+	return "bucket"
+}
+
+// ctxCheckInterval is how many entrants a search visits between checks
+// for a cancelled context.
+const ctxCheckInterval = 128
+
+// buildSearchLocked indexes every entrant in names and ids, which hold
+// them in any order.
+func (s *Store[T, P]) buildSearchLocked() {
+	sort.Slice(s.names, func(i, j int) bool { return lower[T, P](s.names[i]) < lower[T, P](s.names[j]) })
+	sort.Slice(s.ids, func(i, j int) bool { return P(s.ids[i]).LeaderboardID() < P(s.ids[j]).LeaderboardID() })
+	for _, u := range s.names {
+		if name := lower[T, P](u); name != "" {
+			first := UsernameBucket(name)
+			s.buckets[first] = append(s.buckets[first], u)
+		}
+		s.trieIdx.insert(u, lower[T, P](u))
+		for _, token := range s.nameTokens(lower[T, P](u)) {
+			s.tokens = append(s.tokens, nameToken[T]{token: token, user: u})
+		}
+	}
+	sort.Slice(s.tokens, func(i, j int) bool { return lessToken[T, P](s.tokens[i], s.tokens[j]) })
+}
+
+func lower[T any, P Entrant[T]](u *T) string { return P(u).member().UsernameLower }
+
+// insertNameLocked adds u to the name indexes.
+func (s *Store[T, P]) insertNameLocked(u *T) {
+	name := lower[T, P](u)
+	s.byName[name] = u
+	s.names = insertByName[T, P](s.names, u)
+	s.trieIdx.insert(u, name)
+	if name != "" {
+		first := UsernameBucket(name)
+		s.buckets[first] = insertByName[T, P](s.buckets[first], u)
+	}
+	for _, token := range s.nameTokens(name) {
+		entry := nameToken[T]{token: token, user: u}
+		i := sort.Search(len(s.tokens), func(i int) bool { return lessToken[T, P](entry, s.tokens[i]) })
+		s.tokens = append(s.tokens, nameToken[T]{})
+		copy(s.tokens[i+1:], s.tokens[i:])
+		s.tokens[i] = entry
+	}
+}
+
+// removeNameLocked takes u out of the name indexes.
+func (s *Store[T, P]) removeNameLocked(u *T) {
+	name := lower[T, P](u)
+	if s.byName[name] == u {
+		delete(s.byName, name)
+	}
+	s.names = removeByName[T, P](s.names, u)
+	s.trieIdx.remove(u, name)
+	if name != "" {
+		first := UsernameBucket(name)
+		if bucket := removeByName[T, P](s.buckets[first], u); len(bucket) > 0 {
+			s.buckets[first] = bucket
+		} else {
+			delete(s.buckets, first)
+		}
+	}
+	for _, token := range s.nameTokens(name) {
+		i := sort.Search(len(s.tokens), func(i int) bool { return s.tokens[i].token >= token })
+		for ; i < len(s.tokens) && s.tokens[i].token == token; i++ {
+			if s.tokens[i].user == u {
+				s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// removeByName deletes u from a slice sorted by folded name.
+func removeByName[T any, P Entrant[T]](users []*T, u *T) []*T {
+	name := lower[T, P](u)
+	i := sort.Search(len(users), func(i int) bool { return lower[T, P](users[i]) >= name })
+	for ; i < len(users) && lower[T, P](users[i]) == name; i++ {
+		if users[i] == u {
+			return append(users[:i], users[i+1:]...)
+		}
+	}
+	return users
+}
+
+// insertByName adds u to a slice sorted by folded name.
+func insertByName[T any, P Entrant[T]](users []*T, u *T) []*T {
+	name := lower[T, P](u)
+	i := sort.Search(len(users), func(i int) bool { return lower[T, P](users[i]) > name })
+	users = append(users, nil)
+	copy(users[i+1:], users[i:])
+	users[i] = u
+	return users
+}
+
+// removeByID deletes u from a slice sorted by ID.
+func removeByID[T any, P Entrant[T]](users []*T, u *T) []*T {
+	id := P(u).LeaderboardID()
+	i := sort.Search(len(users), func(i int) bool { return P(users[i]).LeaderboardID() >= id })
+	if i < len(users) && users[i] == u {
+		return append(users[:i], users[i+1:]...)
+	}
+	return users
+}
+
+// insertByID adds u to a slice sorted by ID.
+func insertByID[T any, P Entrant[T]](users []*T, u *T) []*T {
+	id := P(u).LeaderboardID()
+	i := sort.Search(len(users), func(i int) bool { return P(users[i]).LeaderboardID() > id })
+	users = append(users, nil)
+	copy(users[i+1:], users[i:])
+	users[i] = u
+	return users
+}
+
+// BucketLocked is the entrants whose folded name starts with r, by name.
+func (s *Store[T, P]) BucketLocked(r rune) []*T { return s.buckets[r] }
+
+// BucketSizesLocked counts the entrants in each first-rune bucket.
+func (s *Store[T, P]) BucketSizesLocked() map[rune]int {
+	sizes := make(map[rune]int, len(s.buckets))
+	for r, bucket := range s.buckets {
+		sizes[r] = len(bucket)
+	}
+	return sizes
+}
+
+// IndexSizesLocked reports the entry count of every index, for the admin
+// memory report.
+func (s *Store[T, P]) IndexSizesLocked() map[string]int {
+	largestBucket := 0
+	for _, bucket := range s.buckets {
+		largestBucket = max(largestBucket, len(bucket))
+	}
+	return map[string]int{
+		"usersByID":     len(s.byID),
+		"usersByName":   len(s.byName),
+		"sortedUsers":   len(s.ranked),
+		"sortedByName":  len(s.names),
+		"sortedByID":    len(s.ids),
+		"sortedTokens":  len(s.tokens),
+		"bucketCount":   len(s.buckets),
+		"largestBucket": largestBucket,
+		"sortKeysCap":   cap(s.sortKeys),
+	}
+}
+
+// Search pages through the entrants whose name, or a later component of
+// it, starts with query, case-insensitively, with their current ranks. It
+// returns the page, the number of matches (capped at the search limit) and
+// the number of pages. Queries narrower than two characters, or one CJK
+// character, match nothing.
+func (s *Store[T, P]) Search(ctx context.Context, query string, page, limit int) (entrants []T, total, totalPages int, err error) {
+	page, limit = max(page, 1), max(limit, 1)
+	query = FoldUsername(NormalizeUsername(query))
+	if UsernameWidth(query) < 2 {
+		return []T{}, 0, 0, nil
+	}
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, 0, err
+	}
+	defer s.mu.RUnlock()
+	results, err := s.MatchLocked(ctx, SearchBucket, query, nil)
+	if err == nil {
+		results, err = s.MatchTokensLocked(ctx, query, results)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	entrants, total, totalPages = PageOf(results, page, limit)
+	return entrants, total, totalPages, nil
+}
+
+// PageOf slices one page out of all, with the number of entrants and
+// pages; past the end it is empty with 0 pages.
+func PageOf[T any](all []T, page, limit int) ([]T, int, int) {
+	total := len(all)
+	start := (page - 1) * limit
+	if start >= total {
+		return []T{}, total, 0
+	}
+	end := min(start+limit, total)
+	return append([]T(nil), all[start:end]...), total, (total + limit - 1) / limit
+}
+
+// MatchLocked appends copies of the entrants whose folded name starts with
+// the folded query to results, in name order, up to the search limit.
+func (s *Store[T, P]) MatchLocked(ctx context.Context, algo SearchStrategy, query string, results []T) ([]T, error) {
+	if algo == SearchTrie {
+		return s.matchTrieLocked(ctx, query, results)
+	}
+	names := s.names
+	if bucket, exists := s.buckets[UsernameBucket(query)]; exists {
+		names = bucket
+	}
+	start := sort.Search(len(names), func(i int) bool { return lower[T, P](names[i]) >= query })
+	for i := start; i < len(names) && len(results) < s.searchLimit; i++ {
+		// Stop collecting for a client that has gone away
+		if (i-start)%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !strings.HasPrefix(lower[T, P](names[i]), query) {
+			break // Sorted, so no more matches
+		}
+		results = append(results, *names[i])
+	}
+	return results, nil
+}
+
+func (s *Store[T, P]) matchTrieLocked(ctx context.Context, query string, results []T) ([]T, error) {
+	seen := 0
+	var err error
+	s.trieIdx.walk(query, func(u *T) bool {
+		if seen%ctxCheckInterval == 0 && ctx.Err() != nil {
+			err = ctx.Err()
+			return false
+		}
+		seen++
+		results = append(results, *u)
+		return len(results) < s.searchLimit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// MatchTokensLocked appends copies of the entrants with a later token
+// starting with the folded query, and not already matched by name, to
+// results.
+func (s *Store[T, P]) MatchTokensLocked(ctx context.Context, query string, results []T) ([]T, error) {
+	if len(s.tokens) == 0 || len(results) >= s.searchLimit {
+		return results, nil
+	}
+	byName := len(results)
+	seen := make(map[*T]bool)
+	start := sort.Search(len(s.tokens), func(i int) bool { return s.tokens[i].token >= query })
+	for i := start; i < len(s.tokens) && len(results) < s.searchLimit; i++ {
+		if (i-start)%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		entry := s.tokens[i]
+		if !strings.HasPrefix(entry.token, query) {
+			break
+		}
+		if seen[entry.user] || strings.HasPrefix(lower[T, P](entry.user), query) {
+			continue
+		}
+		seen[entry.user] = true
+		results = append(results, *entry.user)
+	}
+	tokenMatches := results[byName:]
+	sort.Slice(tokenMatches, func(i, j int) bool {
+		return lower[T, P](&tokenMatches[i]) < lower[T, P](&tokenMatches[j])
+	})
+	return results, nil
+}
+
+// SearchIDs pages through the entrants whose ID starts with prefix, in ID
+// order. Prefixes shorter than two bytes match nothing.
+func (s *Store[T, P]) SearchIDs(ctx context.Context, prefix string, page, limit int) ([]T, int, int, error) {
+	page, limit = max(page, 1), max(limit, 1)
+	if len(prefix) < 2 {
+		return []T{}, 0, 0, nil
+	}
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, 0, err
+	}
+	defer s.mu.RUnlock()
+	start := sort.Search(len(s.ids), func(i int) bool { return P(s.ids[i]).LeaderboardID() >= prefix })
+	total := sort.Search(len(s.ids)-start, func(i int) bool {
+		return !strings.HasPrefix(P(s.ids[start+i]).LeaderboardID(), prefix)
+	})
+	from := (page - 1) * limit
+	if from >= total {
+		return []T{}, total, 0, nil
+	}
+	to := min(from+limit, total)
+	entrants := make([]T, to-from)
+	for i, u := range s.ids[start+from : start+to] {
+		entrants[i] = *u
+	}
+	return entrants, total, (total + limit - 1) / limit, nil
+}
+
+type nameToken[T any] struct {
+	token string
+	user  *T
+}
+
+// nameTokens lists the tokens of a folded name that are indexed.
+func (s *Store[T, P]) nameTokens(folded string) []string {
+	if !s.tokenIndex {
+		return nil
+	}
+	var tokens []string
+	start := -1
+	for i, r := range folded + "_" {
+		if r == '_' || r == '.' || r == '-' || unicode.IsDigit(r) {
+			if start > 0 && UsernameWidth(folded[start:i]) >= 2 {
+				tokens = append(tokens, folded[start:i])
+			}
+			start = -1
+		} else if start < 0 {
+			start = i
+		}
+	}
+	return tokens
+}
+
+func lessToken[T any, P Entrant[T]](a, b nameToken[T]) bool {
+	if a.token != b.token {
+		return a.token < b.token
+	}
+	return lower[T, P](a.user) < lower[T, P](b.user)
+}
+
+// nameTrie indexes entrants by folded name, one node per byte. A node's
+// entrants end there and come before its children, so a depth-first walk
+// in byte order visits them in name order. A nil trie holds nothing.
+type nameTrie[T any] struct {
+	root trieNode[T]
+}
+
+type trieNode[T any] struct {
+	edges []trieEdge[T] // By byte
+	users []*T
+}
+
+type trieEdge[T any] struct {
+	b    byte
+	node *trieNode[T]
+}
+
+func (n *trieNode[T]) child(b byte) (int, bool) {
+	i := sort.Search(len(n.edges), func(i int) bool { return n.edges[i].b >= b })
+	return i, i < len(n.edges) && n.edges[i].b == b
+}
+
+func (t *nameTrie[T]) insert(u *T, name string) {
+	if t == nil || name == "" {
+		return
+	}
+	n := &t.root
+	for k := 0; k < len(name); k++ {
+		i, ok := n.child(name[k])
+		if !ok {
+			n.edges = append(n.edges, trieEdge[T]{})
+			copy(n.edges[i+1:], n.edges[i:])
+			n.edges[i] = trieEdge[T]{b: name[k], node: &trieNode[T]{}}
+		}
+		n = n.edges[i].node
+	}
+	n.users = append(n.users, u)
+}
+
+func (t *nameTrie[T]) remove(u *T, name string) {
+	if t == nil || name == "" {
+		return
+	}
+	t.root.remove(u, name)
+}
+
+// remove takes u out below n and reports whether n is left empty.
+func (n *trieNode[T]) remove(u *T, rest string) bool {
+	if rest == "" {
+		for i, other := range n.users {
+			if other == u {
+				n.users = append(n.users[:i], n.users[i+1:]...)
+				break
+			}
+		}
+	} else if i, ok := n.child(rest[0]); ok && n.edges[i].node.remove(u, rest[1:]) {
+		n.edges = append(n.edges[:i], n.edges[i+1:]...)
+	}
+	return len(n.users) == 0 && len(n.edges) == 0
+}
+
+// walk calls fn for each entrant under prefix, in order, while it returns
+// true.
+func (t *nameTrie[T]) walk(prefix string, fn func(*T) bool) {
+	if t == nil {
+		return
+	}
+	n := &t.root
+	for k := 0; k < len(prefix); k++ {
+		i, ok := n.child(prefix[k])
+		if !ok {
+			return
+		}
+		n = n.edges[i].node
+	}
+	n.walk(fn)
+}
+
+func (n *trieNode[T]) walk(fn func(*T) bool) bool {
+	for _, u := range n.users {
+		if !fn(u) {
+			return false
+		}
+	}
+	for _, e := range n.edges {
+		if !e.node.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
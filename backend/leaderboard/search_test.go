@@ -0,0 +1,113 @@
+package leaderboard
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var searchUsers = []User{
+	{ID: "u1", Username: "alina", Rating: 1500},
+	{ID: "u2", Username: "Alice_Smith", Rating: 1400},
+	{ID: "u3", Username: "alfred", Rating: 1300},
+	{ID: "u4", Username: "bob_alice", Rating: 1200},
+	{ID: "u5", Username: "ALICIA", Rating: 1100},
+}
+
+// names lists a page's usernames.
+func names(users []User) []string {
+	var out []string
+	for _, u := range users {
+		out = append(out, u.Username)
+	}
+	return out
+}
+
+func TestSearchPaging(t *testing.T) {
+	ctx := context.Background()
+	s := newBoard(t, searchUsers)
+
+	// Name matches in name order, then bob_alice for its "alice" component
+	tests := []struct {
+		query       string
+		page, limit int
+		want        []string
+		wantTotal   int
+		wantPages   int
+	}{
+		{"ali", 1, 2, []string{"Alice_Smith", "ALICIA"}, 4, 2},
+		{"ali", 2, 2, []string{"alina", "bob_alice"}, 4, 2},
+		{"ali", 3, 2, nil, 4, 0},
+		{"ALI", 1, 10, []string{"Alice_Smith", "ALICIA", "alina", "bob_alice"}, 4, 1},
+		{"smi", 1, 10, []string{"Alice_Smith"}, 1, 1},
+		{"a", 1, 10, nil, 0, 0}, // Too short
+		{"zz", 1, 10, nil, 0, 0},
+	}
+	for _, tt := range tests {
+		users, total, pages, err := s.Search(ctx, tt.query, tt.page, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(users); !reflect.DeepEqual(got, tt.want) || total != tt.wantTotal || pages != tt.wantPages {
+			t.Errorf("Search(%q, %d, %d) = %q of %d in %d pages; want %q of %d in %d",
+				tt.query, tt.page, tt.limit, got, total, pages, tt.want, tt.wantTotal, tt.wantPages)
+		}
+	}
+
+	// Matches carry their current rank
+	if err := s.Update("u5", 2000); err != nil {
+		t.Fatal(err)
+	}
+	users, _, _, _ := s.Search(ctx, "alicia", 1, 10)
+	if len(users) != 1 || users[0].Rank != 1 {
+		t.Errorf("Search(alicia) after an update = %+v, want rank 1", users)
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		opts      []Option
+		want      []string
+		wantTotal int
+	}{
+		{"capped before tokens", []Option{WithSearchLimit(3)}, []string{"Alice_Smith", "ALICIA"}, 3},
+		{"capped inside names", []Option{WithSearchLimit(2)}, []string{"Alice_Smith", "ALICIA"}, 2},
+		{"no tokens", []Option{WithTokens(false)}, []string{"Alice_Smith", "ALICIA"}, 3},
+	}
+	for _, tt := range tests {
+		s := newBoard(t, searchUsers, tt.opts...)
+		users, total, pages, err := s.Search(ctx, "ali", 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(users); !reflect.DeepEqual(got, tt.want) || total != tt.wantTotal || pages != (tt.wantTotal+1)/2 {
+			t.Errorf("%s: Search = %q of %d in %d pages; want %q of %d", tt.name, got, total, pages, tt.want, tt.wantTotal)
+		}
+		users, _, _, _ = s.Search(ctx, "ali", 3, 2)
+		if len(users) != 0 {
+			t.Errorf("%s: a page past the cap = %q", tt.name, names(users))
+		}
+	}
+}
+
+func TestMatchStrategies(t *testing.T) {
+	ctx := context.Background()
+	s := newBoard(t, searchUsers)
+	s.RLock()
+	defer s.RUnlock()
+	for _, query := range []string{"al", "ali", "alic", "alfred", "b", "zz"} {
+		bucket, err := s.MatchLocked(ctx, SearchBucket, query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trie, err := s.MatchLocked(ctx, SearchTrie, query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names(bucket), names(trie)) {
+			t.Errorf("%q: bucket found %q, trie %q", query, names(bucket), names(trie))
+		}
+	}
+}
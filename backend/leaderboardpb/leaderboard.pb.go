@@ -0,0 +1,377 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.31.0
+// 	protoc        (unknown)
+// source: leaderboardpb/leaderboard.proto
+
+package leaderboardpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RankDelta_Kind int32
+
+const (
+	RankDelta_KIND_UNSPECIFIED RankDelta_Kind = 0
+	RankDelta_RANK_CHANGED     RankDelta_Kind = 1
+	RankDelta_RATING_CHANGED   RankDelta_Kind = 2
+)
+
+// Enum value maps for RankDelta_Kind.
+var (
+	RankDelta_Kind_name = map[int32]string{
+		0: "KIND_UNSPECIFIED",
+		1: "RANK_CHANGED",
+		2: "RATING_CHANGED",
+	}
+	RankDelta_Kind_value = map[string]int32{
+		"KIND_UNSPECIFIED": 0,
+		"RANK_CHANGED":     1,
+		"RATING_CHANGED":   2,
+	}
+)
+
+func (x RankDelta_Kind) Enum() *RankDelta_Kind {
+	p := new(RankDelta_Kind)
+	*p = x
+	return p
+}
+
+func (x RankDelta_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RankDelta_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_leaderboardpb_leaderboard_proto_enumTypes[0].Descriptor()
+}
+
+func (RankDelta_Kind) Type() protoreflect.EnumType {
+	return &file_leaderboardpb_leaderboard_proto_enumTypes[0]
+}
+
+func (x RankDelta_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RankDelta_Kind.Descriptor instead.
+func (RankDelta_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_leaderboardpb_leaderboard_proto_rawDescGZIP(), []int{1, 0}
+}
+
+type StreamRankChangesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Only changes where the old or new rank is within the top max_rank.
+	// 0 means no rank filter.
+	MaxRank int32 `protobuf:"varint,1,opt,name=max_rank,json=maxRank,proto3" json:"max_rank,omitempty"`
+	// Only changes for these users (case-insensitive). Combined with
+	// max_rank, a change matching either is sent.
+	Usernames []string `protobuf:"bytes,2,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	// Also send individual rating changes. With usernames set, watched
+	// users' rating changes are always sent.
+	IncludeRatingChanges bool `protobuf:"varint,3,opt,name=include_rating_changes,json=includeRatingChanges,proto3" json:"include_rating_changes,omitempty"`
+}
+
+func (x *StreamRankChangesRequest) Reset() {
+	*x = StreamRankChangesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_leaderboardpb_leaderboard_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamRankChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRankChangesRequest) ProtoMessage() {}
+
+func (x *StreamRankChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_leaderboardpb_leaderboard_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRankChangesRequest.ProtoReflect.Descriptor instead.
+func (*StreamRankChangesRequest) Descriptor() ([]byte, []int) {
+	return file_leaderboardpb_leaderboard_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamRankChangesRequest) GetMaxRank() int32 {
+	if x != nil {
+		return x.MaxRank
+	}
+	return 0
+}
+
+func (x *StreamRankChangesRequest) GetUsernames() []string {
+	if x != nil {
+		return x.Usernames
+	}
+	return nil
+}
+
+func (x *StreamRankChangesRequest) GetIncludeRatingChanges() bool {
+	if x != nil {
+		return x.IncludeRatingChanges
+	}
+	return false
+}
+
+type RankDelta struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Kind     RankDelta_Kind `protobuf:"varint,1,opt,name=kind,proto3,enum=matiks.leaderboard.v1.RankDelta_Kind" json:"kind,omitempty"`
+	UserId   string         `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Username string         `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	// Set for RANK_CHANGED
+	OldRank int32 `protobuf:"varint,4,opt,name=old_rank,json=oldRank,proto3" json:"old_rank,omitempty"`
+	NewRank int32 `protobuf:"varint,5,opt,name=new_rank,json=newRank,proto3" json:"new_rank,omitempty"`
+	// Set for RATING_CHANGED
+	OldRating int32 `protobuf:"varint,6,opt,name=old_rating,json=oldRating,proto3" json:"old_rating,omitempty"`
+	NewRating int32 `protobuf:"varint,7,opt,name=new_rating,json=newRating,proto3" json:"new_rating,omitempty"`
+	// When the change was published, in Unix milliseconds
+	TimestampMs int64 `protobuf:"varint,8,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+}
+
+func (x *RankDelta) Reset() {
+	*x = RankDelta{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_leaderboardpb_leaderboard_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RankDelta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RankDelta) ProtoMessage() {}
+
+func (x *RankDelta) ProtoReflect() protoreflect.Message {
+	mi := &file_leaderboardpb_leaderboard_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RankDelta.ProtoReflect.Descriptor instead.
+func (*RankDelta) Descriptor() ([]byte, []int) {
+	return file_leaderboardpb_leaderboard_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RankDelta) GetKind() RankDelta_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return RankDelta_KIND_UNSPECIFIED
+}
+
+func (x *RankDelta) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *RankDelta) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *RankDelta) GetOldRank() int32 {
+	if x != nil {
+		return x.OldRank
+	}
+	return 0
+}
+
+func (x *RankDelta) GetNewRank() int32 {
+	if x != nil {
+		return x.NewRank
+	}
+	return 0
+}
+
+func (x *RankDelta) GetOldRating() int32 {
+	if x != nil {
+		return x.OldRating
+	}
+	return 0
+}
+
+func (x *RankDelta) GetNewRating() int32 {
+	if x != nil {
+		return x.NewRating
+	}
+	return 0
+}
+
+func (x *RankDelta) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+var File_leaderboardpb_leaderboard_proto protoreflect.FileDescriptor
+
+var file_leaderboardpb_leaderboard_proto_rawDesc = []byte{
+	0x0a, 0x1f, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x70, 0x62, 0x2f,
+	0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x15, 0x6d, 0x61, 0x74, 0x69, 0x6b, 0x73, 0x2e, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72,
+	0x62, 0x6f, 0x61, 0x72, 0x64, 0x2e, 0x76, 0x31, 0x22, 0x89, 0x01, 0x0a, 0x18, 0x53, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x52, 0x61, 0x6e, 0x6b, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x6d, 0x61, 0x78, 0x5f, 0x72, 0x61, 0x6e,
+	0x6b, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x6d, 0x61, 0x78, 0x52, 0x61, 0x6e, 0x6b,
+	0x12, 0x1c, 0x0a, 0x09, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x09, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x12, 0x34,
+	0x0a, 0x16, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67,
+	0x5f, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x14,
+	0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x52, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x43, 0x68, 0x61,
+	0x6e, 0x67, 0x65, 0x73, 0x22, 0xd6, 0x02, 0x0a, 0x09, 0x52, 0x61, 0x6e, 0x6b, 0x44, 0x65, 0x6c,
+	0x74, 0x61, 0x12, 0x39, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x25, 0x2e, 0x6d, 0x61, 0x74, 0x69, 0x6b, 0x73, 0x2e, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72,
+	0x62, 0x6f, 0x61, 0x72, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x61, 0x6e, 0x6b, 0x44, 0x65, 0x6c,
+	0x74, 0x61, 0x2e, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x12, 0x17, 0x0a,
+	0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61,
+	0x6d, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x6f, 0x6c, 0x64, 0x5f, 0x72, 0x61, 0x6e, 0x6b, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x6f, 0x6c, 0x64, 0x52, 0x61, 0x6e, 0x6b, 0x12, 0x19, 0x0a,
+	0x08, 0x6e, 0x65, 0x77, 0x5f, 0x72, 0x61, 0x6e, 0x6b, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x07, 0x6e, 0x65, 0x77, 0x52, 0x61, 0x6e, 0x6b, 0x12, 0x1d, 0x0a, 0x0a, 0x6f, 0x6c, 0x64, 0x5f,
+	0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x6f, 0x6c,
+	0x64, 0x52, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x1d, 0x0a, 0x0a, 0x6e, 0x65, 0x77, 0x5f, 0x72,
+	0x61, 0x74, 0x69, 0x6e, 0x67, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x6e, 0x65, 0x77,
+	0x52, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x5f, 0x6d, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x4d, 0x73, 0x22, 0x42, 0x0a, 0x04, 0x4b, 0x69, 0x6e,
+	0x64, 0x12, 0x14, 0x0a, 0x10, 0x4b, 0x49, 0x4e, 0x44, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43,
+	0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x10, 0x0a, 0x0c, 0x52, 0x41, 0x4e, 0x4b, 0x5f,
+	0x43, 0x48, 0x41, 0x4e, 0x47, 0x45, 0x44, 0x10, 0x01, 0x12, 0x12, 0x0a, 0x0e, 0x52, 0x41, 0x54,
+	0x49, 0x4e, 0x47, 0x5f, 0x43, 0x48, 0x41, 0x4e, 0x47, 0x45, 0x44, 0x10, 0x02, 0x32, 0x77, 0x0a,
+	0x0b, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x12, 0x68, 0x0a, 0x11,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x61, 0x6e, 0x6b, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65,
+	0x73, 0x12, 0x2f, 0x2e, 0x6d, 0x61, 0x74, 0x69, 0x6b, 0x73, 0x2e, 0x6c, 0x65, 0x61, 0x64, 0x65,
+	0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x52, 0x61, 0x6e, 0x6b, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x20, 0x2e, 0x6d, 0x61, 0x74, 0x69, 0x6b, 0x73, 0x2e, 0x6c, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x61, 0x6e, 0x6b, 0x44,
+	0x65, 0x6c, 0x74, 0x61, 0x30, 0x01, 0x42, 0x22, 0x5a, 0x20, 0x6d, 0x61, 0x74, 0x69, 0x6b, 0x73,
+	0x2d, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x2f, 0x6c, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+}
+
+var (
+	file_leaderboardpb_leaderboard_proto_rawDescOnce sync.Once
+	file_leaderboardpb_leaderboard_proto_rawDescData = file_leaderboardpb_leaderboard_proto_rawDesc
+)
+
+func file_leaderboardpb_leaderboard_proto_rawDescGZIP() []byte {
+	file_leaderboardpb_leaderboard_proto_rawDescOnce.Do(func() {
+		file_leaderboardpb_leaderboard_proto_rawDescData = protoimpl.X.CompressGZIP(file_leaderboardpb_leaderboard_proto_rawDescData)
+	})
+	return file_leaderboardpb_leaderboard_proto_rawDescData
+}
+
+var file_leaderboardpb_leaderboard_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_leaderboardpb_leaderboard_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_leaderboardpb_leaderboard_proto_goTypes = []interface{}{
+	(RankDelta_Kind)(0),              // 0: matiks.leaderboard.v1.RankDelta.Kind
+	(*StreamRankChangesRequest)(nil), // 1: matiks.leaderboard.v1.StreamRankChangesRequest
+	(*RankDelta)(nil),                // 2: matiks.leaderboard.v1.RankDelta
+}
+var file_leaderboardpb_leaderboard_proto_depIdxs = []int32{
+	0, // 0: matiks.leaderboard.v1.RankDelta.kind:type_name -> matiks.leaderboard.v1.RankDelta.Kind
+	1, // 1: matiks.leaderboard.v1.Leaderboard.StreamRankChanges:input_type -> matiks.leaderboard.v1.StreamRankChangesRequest
+	2, // 2: matiks.leaderboard.v1.Leaderboard.StreamRankChanges:output_type -> matiks.leaderboard.v1.RankDelta
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_leaderboardpb_leaderboard_proto_init() }
+func file_leaderboardpb_leaderboard_proto_init() {
+	if File_leaderboardpb_leaderboard_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_leaderboardpb_leaderboard_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamRankChangesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_leaderboardpb_leaderboard_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RankDelta); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_leaderboardpb_leaderboard_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_leaderboardpb_leaderboard_proto_goTypes,
+		DependencyIndexes: file_leaderboardpb_leaderboard_proto_depIdxs,
+		EnumInfos:         file_leaderboardpb_leaderboard_proto_enumTypes,
+		MessageInfos:      file_leaderboardpb_leaderboard_proto_msgTypes,
+	}.Build()
+	File_leaderboardpb_leaderboard_proto = out.File
+	file_leaderboardpb_leaderboard_proto_rawDesc = nil
+	file_leaderboardpb_leaderboard_proto_goTypes = nil
+	file_leaderboardpb_leaderboard_proto_depIdxs = nil
+}
@@ -0,0 +1,140 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: leaderboardpb/leaderboard.proto
+
+package leaderboardpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Leaderboard_StreamRankChanges_FullMethodName = "/matiks.leaderboard.v1.Leaderboard/StreamRankChanges"
+)
+
+// LeaderboardClient is the client API for Leaderboard service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LeaderboardClient interface {
+	// StreamRankChanges streams rank (and optionally rating) deltas as sorts
+	// and updates happen, until the client cancels.
+	StreamRankChanges(ctx context.Context, in *StreamRankChangesRequest, opts ...grpc.CallOption) (Leaderboard_StreamRankChangesClient, error)
+}
+
+type leaderboardClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLeaderboardClient(cc grpc.ClientConnInterface) LeaderboardClient {
+	return &leaderboardClient{cc}
+}
+
+func (c *leaderboardClient) StreamRankChanges(ctx context.Context, in *StreamRankChangesRequest, opts ...grpc.CallOption) (Leaderboard_StreamRankChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Leaderboard_ServiceDesc.Streams[0], Leaderboard_StreamRankChanges_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &leaderboardStreamRankChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Leaderboard_StreamRankChangesClient interface {
+	Recv() (*RankDelta, error)
+	grpc.ClientStream
+}
+
+type leaderboardStreamRankChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *leaderboardStreamRankChangesClient) Recv() (*RankDelta, error) {
+	m := new(RankDelta)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LeaderboardServer is the server API for Leaderboard service.
+// All implementations must embed UnimplementedLeaderboardServer
+// for forward compatibility
+type LeaderboardServer interface {
+	// StreamRankChanges streams rank (and optionally rating) deltas as sorts
+	// and updates happen, until the client cancels.
+	StreamRankChanges(*StreamRankChangesRequest, Leaderboard_StreamRankChangesServer) error
+	mustEmbedUnimplementedLeaderboardServer()
+}
+
+// UnimplementedLeaderboardServer must be embedded to have forward compatible implementations.
+type UnimplementedLeaderboardServer struct {
+}
+
+func (UnimplementedLeaderboardServer) StreamRankChanges(*StreamRankChangesRequest, Leaderboard_StreamRankChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamRankChanges not implemented")
+}
+func (UnimplementedLeaderboardServer) mustEmbedUnimplementedLeaderboardServer() {}
+
+// UnsafeLeaderboardServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LeaderboardServer will
+// result in compilation errors.
+type UnsafeLeaderboardServer interface {
+	mustEmbedUnimplementedLeaderboardServer()
+}
+
+func RegisterLeaderboardServer(s grpc.ServiceRegistrar, srv LeaderboardServer) {
+	s.RegisterService(&Leaderboard_ServiceDesc, srv)
+}
+
+func _Leaderboard_StreamRankChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRankChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LeaderboardServer).StreamRankChanges(m, &leaderboardStreamRankChangesServer{stream})
+}
+
+type Leaderboard_StreamRankChangesServer interface {
+	Send(*RankDelta) error
+	grpc.ServerStream
+}
+
+type leaderboardStreamRankChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *leaderboardStreamRankChangesServer) Send(m *RankDelta) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Leaderboard_ServiceDesc is the grpc.ServiceDesc for Leaderboard service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Leaderboard_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "matiks.leaderboard.v1.Leaderboard",
+	HandlerType: (*LeaderboardServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRankChanges",
+			Handler:       _Leaderboard_StreamRankChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "leaderboardpb/leaderboard.proto",
+}
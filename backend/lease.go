@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// renewScript extends the lease only if this instance still holds it.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes the lease only if this instance holds it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lease is a Redis-backed leadership lock. Exactly one instance holds the
+// key at a time; if the holder dies the key expires after ttl and another
+// instance takes over.
+type Lease struct {
+	rdb  *redis.Client
+	key  string
+	id   string
+	ttl  time.Duration
+	held int32
+}
+
+func NewLease(rdb *redis.Client, key, id string, ttl time.Duration) *Lease {
+	return &Lease{rdb: rdb, key: key, id: id, ttl: ttl}
+}
+
+func (l *Lease) Held() bool {
+	return atomic.LoadInt32(&l.held) == 1
+}
+
+// Run acquires and renews the lease every ttl/3 until ctx is done, then
+// releases it.
+func (l *Lease) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		l.tick(ctx)
+		select {
+		case <-ctx.Done():
+			redisBreaker.Do(func() error {
+				return releaseScript.Run(context.Background(), l.rdb, []string{l.key}, l.id).Err()
+			})
+			atomic.StoreInt32(&l.held, 0)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Lease) tick(ctx context.Context) {
+	// With the breaker open the lease cannot be renewed, so it counts as
+	// lost
+	var held bool
+	if l.Held() {
+		var n int
+		err := redisBreaker.Do(func() (err error) {
+			n, err = renewScript.Run(ctx, l.rdb, []string{l.key}, l.id, l.ttl.Milliseconds()).Int()
+			return err
+		})
+		held = err == nil && n == 1
+		if err != nil && err != errBackendDegraded {
+			log.Printf("Lease %s: renew: %v", l.key, err)
+		}
+	} else {
+		var ok bool
+		err := redisBreaker.Do(func() (err error) {
+			ok, err = l.rdb.SetNX(ctx, l.key, l.id, l.ttl).Result()
+			return err
+		})
+		held = err == nil && ok
+		if err != nil && err != errBackendDegraded {
+			log.Printf("Lease %s: acquire: %v", l.key, err)
+		}
+	}
+
+	if held != l.Held() {
+		if held {
+			log.Printf("Lease %s: acquired by %s", l.key, l.id)
+			atomic.StoreInt32(&l.held, 1)
+		} else {
+			log.Printf("Lease %s: lost by %s", l.key, l.id)
+			atomic.StoreInt32(&l.held, 0)
+		}
+	}
+}
+
+// Holder returns the instance currently holding the lease, if any.
+func (l *Lease) Holder(ctx context.Context) string {
+	var holder string
+	redisBreaker.Do(func() (err error) {
+		holder, err = l.rdb.Get(ctx, l.key).Result()
+		if err == redis.Nil {
+			return nil // Nobody holds it
+		}
+		return err
+	})
+	return holder
+}
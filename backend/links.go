@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"matiks-leaderboard/models"
+)
+
+// Paged lists carry links to the first, previous, next and last pages, in
+// "links" (the v1 envelope's, or the body's on /leaderboard, /search and
+// GET /admin/users). Each is the request's own path and query with only
+// the page changed, so filters, bands, limit and ?project= carry over.
+// They are relative, which keeps them right behind a proxy that rewrites
+// the host. A page past either end is null.
+//
+// Cursor pages only link forward: next carries the next cursor and first
+// an empty one. Snapshot pages pin the snapshot token, so following them keeps
+// reading the same ranking.
+//
+// A single user (/users/{id}, /user/rank) carries links to its own
+// resources, in the API version it was asked for:
+//
+//	"links": {"self": "/v1/users/u1", "rank": "/v1/user/rank?id=u1",
+//	          "digest": "/v1/users/u1/digest", "metadata": "/v1/users/u1/metadata",
+//	          "metrics": "/v1/users/u1/metrics"}
+//
+// Leaderboard and search entries don't: a page is encoded by hand (see
+// jsonfast.go) and would grow by several times. Their ids lead to the same
+// links.
+
+// userLinks links the resources about user id under the version r used.
+func userLinks(r *http.Request, id string) models.UserLinks {
+	prefix := ""
+	if strings.HasPrefix(requestURL(r).Path, "/v1/") {
+		prefix = "/v1"
+	}
+	self := prefix + "/users/" + url.PathEscape(id)
+	return models.UserLinks{
+		Self:     self,
+		Rank:     prefix + "/user/rank?id=" + url.QueryEscape(id),
+		Digest:   self + "/digest",
+		Metadata: self + "/metadata",
+		Metrics:  self + "/metrics",
+	}
+}
+
+// requestURL is the URL the client asked for. Handlers shared with v1
+// strip /v1 from r.URL.Path, but RequestURI keeps it.
+func requestURL(r *http.Request) *url.URL {
+	u := *r.URL
+	if requested, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		u.Path = requested.Path
+	}
+	return &u
+}
+
+// pageLinks links pages by number. A page past the last still links
+// back to it.
+func pageLinks(u *url.URL, p *models.Pagination) *models.Links {
+	last := p.TotalPages
+	if last < 1 {
+		last = 1
+	}
+	links := &models.Links{First: pageURL(u, 1), Last: optional(pageURL(u, last))}
+	if p.Page > 1 {
+		links.Prev = optional(pageURL(u, min(p.Page-1, last)))
+	}
+	if p.Page < last {
+		links.Next = optional(pageURL(u, p.Page+1))
+	}
+	return links
+}
+
+// cursorLinks links a cursor page; next is "" on the last one.
+func cursorLinks(u *url.URL, next string) *models.Links {
+	q := u.Query()
+	q.Set("cursor", "")
+	links := &models.Links{First: withQuery(u, q)}
+	if next != "" {
+		q.Set("cursor", next)
+		links.Next = optional(withQuery(u, q))
+	}
+	return links
+}
+
+// withParam returns a copy of u with name set to value.
+func withParam(u *url.URL, name, value string) *url.URL {
+	q := u.Query()
+	q.Set(name, value)
+	copied := *u
+	copied.RawQuery = q.Encode()
+	return &copied
+}
+
+func pageURL(u *url.URL, page int) string {
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	return withQuery(u, q)
+}
+
+func withQuery(u *url.URL, q url.Values) string {
+	ref := url.URL{Path: u.Path, RawQuery: q.Encode()}
+	return ref.String()
+}
+
+func optional(s string) *string {
+	return &s
+}
@@ -1,339 +1,510 @@
 ﻿package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"sort"
+	"os"
 	"strconv"
-	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
+	
+	"github.com/hashicorp/raft"
+	
+	"matiks-leaderboard/leaderboard"
+	"matiks-leaderboard/models"
+	"matiks-leaderboard/utils"
 )
 
 type User struct {
-	ID            string `json:"id"`
-	Username      string `json:"username"`
-	UsernameLower string `json:"-"` // Pre-computed lowercase
-	Rating        int    `json:"rating"`
-	Rank          int    `json:"rank"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	
+	leaderboard.Member // Rank, UsernameLower and the sort bookkeeping
 }
 
+func (u *User) LeaderboardID() string          { return u.ID }
+func (u *User) LeaderboardName() string        { return u.Username }
+func (u *User) LeaderboardScore() int          { return u.Rating }
+func (u *User) SetLeaderboardName(name string) { u.Username = name }
+func (u *User) SetLeaderboardScore(score int)  { u.Rating = score }
+
+// Store methods fail with these, possibly wrapped with detail, and v1Error
+// is the one place they become statuses. They are the embeddable
+// package's own values, so errors.Is matches either.
+var (
+	ErrUserNotFound      = leaderboard.ErrUserNotFound
+	ErrDuplicateID       = leaderboard.ErrDuplicateID
+	ErrDuplicateUsername = leaderboard.ErrDuplicateUsername
+	ErrRatingOutOfRange  = leaderboard.ErrRatingOutOfRange
+)
+
 type UserStore struct {
-	// 1. TWO DATA STRUCTURES:
-	// - Map for O(1) lookups by ID/username
-	// - Sorted slice for leaderboard (cached)
-	usersByID     map[string]*User
-	usersByName   map[string]*User
-	sortedUsers   []*User // Cached sorted version by rating
-	
-	// 2. OPTIMIZATION: For search ,  alphabetically sorted slice
-	sortedByName  []*User // Sorted by UsernameLower
-	
-	// 3. OPTIMIZATION: First-character bucketing
-	firstCharBuckets map[byte][]*User // Map first char  to users
-	
-	// 4. SYNC.RWMUTEX for concurrent reads
-	mu sync.RWMutex
-	
-	// 5. CACHE for leaderboard pages
-	cache      map[string]cacheEntry
-	cacheMutex sync.RWMutex
+	// 1. THE RANKING ENGINE (see the leaderboard package):
+	// - Maps for O(1) lookups by ID/username
+	// - Lazily sorted leaderboard with partial re-rank
+	// - Name, first-character bucket, trie, token and ID search indexes
+	// - SYNC.RWMUTEX for concurrent reads, guarding this struct too
+	*leaderboard.Store[User, *User]
+	
+	// 2. CACHE for leaderboard pages
+	pages      pageCache // See pagecache.go
 	cacheTTL   time.Duration
 	
-	// 6. LAZY SORTING control
-	needsSorting  bool
+	// 3. LAZY SORTING control
 	updateCount   int64
 	sortThreshold int // Sort after N updates
+	lastTop       []string // Top 10 IDs as of the last sort
 	
-	// 7. PARTIAL UPDATES tracking
+	// 4. PARTIAL UPDATES tracking
 	updatedUsers map[string]bool // Track which users changed
 	
-	// 8. Stats
+	// 5. Stats
 	totalUsers int64
 	lastUpdate time.Time
-}
-
-type cacheEntry struct {
-	data      []User
-	timestamp time.Time
+	
+	// 6. Random source for simulated updates (guarded by the lock)
+	rng *rand.Rand
+	
+	// 7. Rating change / sort events for external consumers
+	events *EventBus
+	
+	// 8. Optional replication of rating updates (nil = standalone)
+	replicator Replicator
+	
+	// 9. Recent applied batches, tailed by read replicas
+	changes *changeLog
+	
+	// 10. Counters for the admin dashboard (atomic)
+	cacheHits, cacheMisses   int64
+	cacheStats               cacheCounters // By page bucket (see cachestats.go)
+	ratingUpdates            int64
+	updates                  *updateAccounting // Per-source totals (see accounting.go)
+	usersAdded, usersRemoved int64             // Membership changes (see analytics.go)
+	
+	// 11. SOFT DELETION: tombstones out of the ranking and every index,
+	// kept until the purge job (see deletion.go)
+	deleted map[string]*deletedUser
+	
+	// 12. RENAMES: folded old names still redirecting, and each user's
+	// previous names (see renames.go)
+	renamed     map[string]*usernameChange
+	nameHistory map[string][]usernameChange
+	
+	// 13. INACTIVE: users the expiry policy demoted out of the ranking,
+	// until reactivated (see expiry.go)
+	inactive map[string]*inactiveUser
+	
+	// 14. AGGREGATES: running rating sums and counts (see aggregates.go)
+	aggregates *ratingAggregates
 }
 
 func NewUserStore() *UserStore {
-	return &UserStore{
-		usersByID:         make(map[string]*User),
-		usersByName:       make(map[string]*User),
-		sortedUsers:       make([]*User, 0),
-		sortedByName:      make([]*User, 0),
-		firstCharBuckets:  make(map[byte][]*User),
-		cache:             make(map[string]cacheEntry),
+	s := &UserStore{
+		pages:             newMemoryPageCache(time.Second),
 		cacheTTL:          1 * time.Second,
 		sortThreshold:     50, // Sort every 50 updates
 		updatedUsers:      make(map[string]bool),
+		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		events:            NewEventBus(),
+		changes:           newChangeLog(),
+		updates:           newUpdateAccounting(),
+		deleted:           make(map[string]*deletedUser),
+		inactive:          make(map[string]*inactiveUser),
+		aggregates:        newRatingAggregates(nil),
+		renamed:           make(map[string]*usernameChange),
+		nameHistory:       make(map[string][]usernameChange),
 	}
+	s.Store = newRanking(
+		leaderboard.WithSortListener(s.sorted),
+		leaderboard.WithPageCache[User](storePages{s}),
+		leaderboard.WithHooks(hooks),
+	)
+	return s
 }
 
-func (s *UserStore) generateUsers(count int) {
+// newRanking builds the engine with the rating leaderboard's direction,
+// ties, rank mode and search settings, and opts.
+func newRanking(opts ...leaderboard.Option) *leaderboard.Store[User, *User] {
+	return leaderboard.New[User](append([]leaderboard.Option{
+		leaderboard.WithDirection(leaderboardDirection),
+		leaderboard.WithTieBreak(leaderboardTieBreak),
+		leaderboard.WithRankMode(leaderboardRankMode),
+		leaderboard.WithSearchLimit(searchResultLimit),
+		leaderboard.WithTrie(searchTrieEnabled),
+		leaderboard.WithTokens(searchTokensEnabled),
+	}, opts...)...)
+}
+
+func (s *UserStore) generateUsers(seeder *utils.Seeder, count int) {
 	log.Printf("Generating %d users...", count)
 	
-	firstNames := []string{"Alex", "Aaron", "Alice", "Amy", "Andrew", "Anna", "Anthony", "Ashley",
-		"Zack", "Zara", "Zane", "Zoe", "Zachary", "Zelda", "Zander", "Zuri",
-		"Rahul", "Priya", "Amit", "Neha", "Vikas", "Sonia", "Raj", "Meera",
-		"John", "Jane", "Mike", "Emma", "David", "Lisa", "Tom", "Sarah"}
+	seeded := seeder.Users(count)
 	
-	lastNames := []string{"Sharma", "Kumar", "Verma", "Patel", "Singh", "Reddy", "Joshi", "Das",
-		"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis"}
+	s.Lock()
+	defer s.Unlock()
 	
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// Simulator draws from the same source so a fixed seed replays identically
+	s.rng = seeder.Rand()
+	s.loadUsersLocked(seeded)
 	
-	// Clear buckets
-	s.firstCharBuckets = make(map[byte][]*User)
+	// Log bucket distribution
+	log.Printf("Generated %d users", count)
+	log.Printf("Bucket distribution:")
+	for char := 'a'; char <= 'z'; char++ {
+		if bucket := s.BucketLocked(char); len(bucket) > 0 {
+			log.Printf("  %c: %d users", char, len(bucket))
+		}
+	}
+}
+
+// loadUsersLocked replaces every user and index with the given users.
+func (s *UserStore) loadUsersLocked(seeded []utils.SeedUser) {
+	s.deleted = make(map[string]*deletedUser)
+	s.inactive = make(map[string]*inactiveUser)
+	s.renamed = make(map[string]*usernameChange)
+	s.nameHistory = make(map[string][]usernameChange)
+	
+	users := make([]*User, len(seeded))
+	for i, seeded := range seeded {
+		users[i] = &User{ID: seeded.ID, Username: seeded.Username, Rating: seeded.Rating}
+	}
+	s.LoadLocked(users) // Indexes and sorts
+	s.aggregates = newRatingAggregates(s.RankedLocked())
 	
-	for i := 0; i < count; i++ {
-		firstName := firstNames[rand.Intn(len(firstNames))]
-		lastName := lastNames[rand.Intn(len(lastNames))]
-		username := fmt.Sprintf("%s_%s%d", strings.ToLower(firstName), strings.ToLower(lastName), i+1)
-		userID := fmt.Sprintf("user_%d", i+1)
-		rating := 100 + rand.Intn(4901)
-		
-		user := &User{
-			ID:            userID,
-			Username:      username,
-			UsernameLower: strings.ToLower(username), // Pre-compute lowercase
-			Rating:        rating,
+	atomic.StoreInt64(&s.totalUsers, int64(len(seeded)))
+	s.lastUpdate = time.Now()
+	s.changes.reset()
+}
+
+// replaceUsers rebuilds every index for the given users in a scratch store,
+// off the main lock, and swaps them in. Readers keep using the old indexes
+// until the swap, which only holds the write lock for a few field copies.
+// Profiles, metadata, metrics and activity of users who didn't survive the
+// swap are dropped right after it.
+func (s *UserStore) replaceUsers(seeded []utils.SeedUser) {
+	next := &UserStore{Store: newRanking(), changes: newChangeLog()}
+	next.loadUsersLocked(seeded) // next is private to this goroutine
+	
+	s.Lock()
+	gone := s.departedLocked(next)
+	s.ReplaceLocked(next.Store)
+	s.deleted = next.deleted
+	s.inactive = next.inactive
+	s.aggregates = next.aggregates
+	s.renamed, s.nameHistory = next.renamed, next.nameHistory
+	s.updatedUsers = make(map[string]bool)
+	s.updateCount = 0
+	s.lastTop = s.topIDsLocked(10)
+	atomic.StoreInt64(&s.totalUsers, int64(len(seeded)))
+	s.lastUpdate = time.Now()
+	s.changes.reset()
+	s.Unlock()
+	
+	dropUserData(gone)
+	s.events.Publish(EventSortCompleted, SortCompletedPayload{Users: len(seeded)})
+}
+
+// Reseed replaces the dataset with count users from seeder. In replicated
+// mode the new dataset goes through the replicator instead. Cancelling ctx
+// abandons the new dataset; once the swap starts it runs to completion.
+func (s *UserStore) Reseed(ctx context.Context, seeder *utils.Seeder, count int) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	users := make([]utils.SeedUser, 0, count)
+	jobStage(ctx, "generating")
+	for len(users) < count {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		
-		s.usersByID[userID] = user
-		s.usersByName[username] = user
-		s.sortedUsers = append(s.sortedUsers, user)
-		s.sortedByName = append(s.sortedByName, user)
-		
-		// Add to first-character bucket
-		if len(username) > 0 {
-			firstChar := username[0]
-			s.firstCharBuckets[firstChar] = append(s.firstCharBuckets[firstChar], user)
+		batch := count - len(users)
+		if batch > reseedBatchSize {
+			batch = reseedBatchSize
 		}
+		users = append(users, seeder.Users(batch)...)
+		jobProgress(ctx, int64(len(users)), int64(count))
+	}
+	jobStage(ctx, "indexing")
+	if s.replicator != nil {
+		return s.replicator.ReplicateLoad(users)
 	}
 	
-	// Initial sort by rating
-	s.sortUsersLocked()
-	
-	// Sort alphabetically
-	sort.Slice(s.sortedByName, func(i, j int) bool {
-		return s.sortedByName[i].UsernameLower < s.sortedByName[j].UsernameLower
-	})
+	s.replaceUsers(users)
 	
-	// Sort each bucket alphabetically
-	for char, bucket := range s.firstCharBuckets {
-		sort.Slice(bucket, func(i, j int) bool {
-			return bucket[i].UsernameLower < bucket[j].UsernameLower
-		})
-		s.firstCharBuckets[char] = bucket
+	s.Lock()
+	s.rng = seeder.Rand()
+	s.Unlock()
+	return nil
+}
+
+// reseedBatchSize is how many users Reseed generates between checks for
+// cancellation.
+const reseedBatchSize = 65536
+
+// snapshotUsers copies every user's identity and rating.
+func (s *UserStore) snapshotUsers() []utils.SeedUser {
+	s.RLock()
+	defer s.RUnlock()
+	
+	ranked := s.RankedLocked()
+	users := make([]utils.SeedUser, len(ranked))
+	for i, u := range ranked {
+		users[i] = utils.SeedUser{ID: u.ID, Username: u.Username, Rating: u.Rating}
 	}
+	return users
+}
+
+// sorted runs after every sort, under the write lock: the lazy sort's
+// counters start over and the sort's effects go out as events.
+func (s *UserStore) sorted(info SortInfo) {
+	s.updatedUsers = make(map[string]bool) // Clear updated users
+	s.updateCount = 0
+	s.updates.sorted(time.Now().Add(-info.Duration))
 	
-	atomic.StoreInt64(&s.totalUsers, int64(count))
-	s.lastUpdate = time.Now()
+	s.events.Publish(EventSortCompleted, SortCompletedPayload{
+		Users:      info.Users,
+		Reranked:   info.Reranked,
+		DurationMs: float64(info.Duration.Microseconds()) / 1000,
+	})
+	if len(info.RankChanges) > 0 {
+		s.events.Publish(EventRanksChanged, RanksChangedPayload{Changes: info.RankChanges})
+		thresholds.ranksChanged(info.RankChanges)
+	}
 	
-	// Log bucket distribution
-	log.Printf("Generated %d users", count)
-	log.Printf("Bucket distribution:")
-	for char := byte('a'); char <= 'z'; char++ {
-		if bucket, exists := s.firstCharBuckets[char]; exists {
-			log.Printf("  %c: %d users", char, len(bucket))
+	// lastTop is empty until the very first sort
+	top := s.topIDsLocked(10)
+	if len(s.lastTop) > 0 && !equalStrings(s.lastTop, top) {
+		users := make([]User, len(top))
+		for i := range top {
+			users[i] = *s.RankedLocked()[i]
 		}
+		s.events.Publish(EventTop10Changed, Top10ChangedPayload{Users: users})
 	}
+	s.lastTop = top
 }
 
-// OPTIMIZATION: Lazy sorting - only sort when needed
-func (s *UserStore) sortUsersLocked() {
-	// Sort by rating descending
-	sort.Slice(s.sortedUsers, func(i, j int) bool {
-		if s.sortedUsers[i].Rating == s.sortedUsers[j].Rating {
-			return s.sortedUsers[i].ID < s.sortedUsers[j].ID
-		}
-		return s.sortedUsers[i].Rating > s.sortedUsers[j].Rating
-	})
-	
-	// Assign ranks with ties
-	currentRank := 1
-	for i := 0; i < len(s.sortedUsers); {
-		currentRating := s.sortedUsers[i].Rating
-		
-		j := i
-		for j < len(s.sortedUsers) && s.sortedUsers[j].Rating == currentRating {
-			s.sortedUsers[j].Rank = currentRank
-			j++
+func (s *UserStore) topIDsLocked(n int) []string {
+	ranked := s.RankedLocked()
+	n = min(n, len(ranked))
+	ids := make([]string, n)
+	for i := 0; i < n; i++ {
+		ids[i] = ranked[i].ID
+	}
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
-		
-		currentRank += (j - i)
-		i = j
 	}
-	
-	s.needsSorting = false
-	s.updatedUsers = make(map[string]bool) // Clear updated users
-	s.updateCount = 0
-	s.clearCache() // Clear cache when sorted
+	return true
 }
 
 // OPTIMIZATION: Clear cache (thread-safe)
 func (s *UserStore) clearCache() {
-	s.cacheMutex.Lock()
-	defer s.cacheMutex.Unlock()
-	s.cache = make(map[string]cacheEntry)
+	s.pages.Clear()
+	s.cacheStats.cleared()
+}
+
+// ratingUpdate sets one user's rating; it is the unit replicated via raft.
+type ratingUpdate struct {
+	UserID string `json:"userId"`
+	Rating int    `json:"rating"`
 }
 
 // OPTIMIZATION: Update only affected users (partial update)
-func (s *UserStore) updateRandomScores(count int) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *UserStore) updateRandomScores(ctx context.Context, count int, source updateSource) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	s.Lock()
+	updates, sameRating, err := s.randomRatingUpdatesLocked(ctx, s.rng, count)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
 	
-	if len(s.sortedUsers) == 0 {
-		return
+	// Replicated mode: the change set is applied through the raft log on
+	// every node, including this one
+	if s.replicator != nil {
+		s.updates.record(source, count, 0)
+		return s.replicator.Replicate(updates)
 	}
 	
-	updated := 0
+	updated, pendingSorts := s.applyRatingUpdates(updates, source)
+	s.updates.record(source, sameRating, 0) // Draws that kept the rating never reach apply
+	if updated == 0 {
+		log.Printf("Update: Attempted=%d, Changed=0, Unchanged=%d (no rating changes)", 
+			count, sameRating)
+	} else if pendingSorts == 0 {
+		log.Printf("Update: Attempted=%d, Changed=%d, Unchanged=%d, Triggered sort", 
+			count, updated, sameRating)
+	} else {
+		log.Printf("Update: Attempted=%d, Changed=%d, Unchanged=%d, Pending sorts=%d", 
+			count, updated, sameRating, pendingSorts)
+	}
+	return nil
+}
+
+// randomRatingUpdatesLocked draws count random rating changes without
+// applying them. A user picked twice builds on its earlier pending change.
+func (s *UserStore) randomRatingUpdatesLocked(ctx context.Context, rng *rand.Rand, count int) ([]ratingUpdate, int, error) {
+	ranked := s.RankedLocked()
+	if len(ranked) == 0 {
+		return nil, 0, nil
+	}
+	
+	pending := make(map[string]int)
+	var updates []ratingUpdate
 	sameRating := 0
 	
 	for i := 0; i < count; i++ {
+		if i%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		
 		// Pick random user
-		idx := rand.Intn(len(s.sortedUsers))
-		user := s.sortedUsers[idx]
-		oldRating := user.Rating
+		idx := rng.Intn(len(ranked))
+		user := ranked[idx]
+		oldRating, ok := pending[user.ID]
+		if !ok {
+			oldRating = user.Rating
+		}
 		
 		// Generate change
-		change := rand.Intn(401) - 200
-		newRating := user.Rating + change
+		change := rng.Intn(scores.Units(400)+1) - scores.Units(200)
 		
-		// Clamp to 100-5000
-		if newRating < 100 {
-			newRating = 100
-		} else if newRating > 5000 {
-			newRating = 5000
-		}
+		// Clamp to the score bounds (100-5000 for int ratings)
+		newRating := scores.Add(oldRating, change)
 		
 		if newRating != oldRating {
-			user.Rating = newRating
-			s.updatedUsers[user.ID] = true
-			s.updateCount++
-			updated++
+			pending[user.ID] = newRating
+			updates = append(updates, ratingUpdate{UserID: user.ID, Rating: newRating})
 		} else {
 			sameRating++
 		}
 	}
+	return updates, sameRating, nil
+}
+
+// applyRatingUpdates writes ratings, counting them under source, and
+// schedules the lazy sort. It returns how many ratings changed and the
+// pending change count afterwards (0 when the batch triggered a sort).
+func (s *UserStore) applyRatingUpdates(updates []ratingUpdate, source updateSource) (int, int64) {
+	s.Lock()
+	defer s.Unlock()
+	
+	updated := 0
+	applied := make([]ratingUpdate, 0, len(updates))
+	now := time.Now().UnixNano()
+	for _, u := range updates {
+		user, exists := s.GetLocked(u.UserID)
+		if !exists || user.Rating == u.Rating {
+			continue
+		}
+		applied = append(applied, u)
+		
+		oldRating := user.Rating
+		s.SetScoreLocked(user, u.Rating, now)
+		s.aggregates.change(oldRating, u.Rating)
+		thresholds.ratingChanged(user, oldRating, u.Rating)
+		s.events.Publish(EventRatingChanged, RatingChangedPayload{
+			UserID:    user.ID,
+			Username:  user.Username,
+			OldRating: oldRating,
+			NewRating: u.Rating,
+		})
+		s.updatedUsers[user.ID] = true
+		s.updateCount++
+		updated++
+	}
+	
+	atomic.AddInt64(&s.ratingUpdates, int64(updated))
+	if source != sourceSchedule {
+		activity.setUpdates(applied, time.Unix(0, now))
+	}
+	if source == sourceReplication {
+		s.updates.record(source, 0, updated) // Attempted at the origin
+	} else {
+		s.updates.record(source, len(updates), updated)
+	}
 	
-	// Mark that we need sorting
+	// The store marked itself for sorting
 	if updated > 0 {
-		s.needsSorting = true
 		s.lastUpdate = time.Now()
+		s.changes.append(applied)
 		
 		// OPTIMIZATION: Only sort if we've reached threshold
 		if s.updateCount >= int64(s.sortThreshold) {
-			s.sortUsersLocked()
-			log.Printf("Update: Attempted=%d, Changed=%d, Unchanged=%d, Triggered sort", 
-				count, updated, sameRating)
-		} else {
-			// Clear cache even without full sort
-			s.clearCache()
-			log.Printf("Update: Attempted=%d, Changed=%d, Unchanged=%d, Pending sorts=%d", 
-				count, updated, sameRating, s.updateCount)
+			s.SortLocked()
 		}
-	} else {
-		log.Printf("Update: Attempted=%d, Changed=0, Unchanged=%d (no rating changes)", 
-			count, sameRating)
 	}
+	return updated, s.updateCount
 }
 
-// OPTIMIZATION: Binary Search + First-Character Bucketing
-func (s *UserStore) SearchUsers(query string, page, limit int) ([]User, int, int) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// searchResultLimit caps matches collected per search
+const searchResultLimit = 1000
+
+// ctxCheckInterval is how many loop iterations long-running store
+// operations run between checks for a cancelled context.
+const ctxCheckInterval = 128
+
+// SearchUsers pages through algo's matches for query (see searchalgos.go)
+// and then its token matches (see tokensearch.go)
+func (s *UserStore) SearchUsers(ctx context.Context, algo searchStrategy, query string, page, limit int) ([]User, int, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, err
+	}
 	
-	query = strings.ToLower(strings.TrimSpace(query))
-	if query == "" || len(query) < 2 {
-		return []User{}, 0, 0
+	query = foldUsername(normalizeUsername(query))
+	if usernameWidth(query) < 2 { // One CJK character is enough
+		if page <= 1 {
+			searchStats.recordTooShort()
+		}
+		return []User{}, 0, 0, nil
 	}
 	
-	// OPTIMIZATION: If needs sorting, we need to upgrade to write lock
-	if s.needsSorting {
-		s.mu.RUnlock()
-		s.mu.Lock()
-		s.sortUsersLocked()
-		s.mu.Unlock()
-		s.mu.RLock()
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, 0, err
 	}
+	defer s.RUnlock()
 	
-	var results []User
+	scratch := searchScratchPool.Get().(*[]User)
+	defer searchScratchPool.Put(scratch)
+	results := (*scratch)[:0]
 	
-	// OPTIMIZATION 1: Use first-character bucketing if possible
-	firstChar := query[0]
-	if bucket, exists := s.firstCharBuckets[firstChar]; exists {
-		// We have a bucket for this first character
-		startTime := time.Now()
-		
-		// OPTIMIZATION 2: Binary search within the bucket
-		startIdx := sort.Search(len(bucket), func(i int) bool {
-			return bucket[i].UsernameLower >= query
-		})
-		
-		// OPTIMIZATION 3: Linear scan only from startIdx within bucket
-		for i := startIdx; i < len(bucket); i++ {
-			user := bucket[i]
-			
-			// Since bucket is sorted, we can break early
-			if !strings.HasPrefix(user.UsernameLower, query) {
-				break
-			}
-			
-			results = append(results, *user)
-			
-			// Limit for performance
-			if len(results) >= 1000 {
-				break
-			}
-		}
-		
-		log.Printf("Search '%s': bucket size=%d, matches=%d, time=%v", 
-			query, len(bucket), len(results), time.Since(startTime))
-	} else {
-		// No bucket for this character - fallback to binary search on full sorted list
-		startTime := time.Now()
-		
-		// Binary search on full sorted list
-		startIdx := sort.Search(len(s.sortedByName), func(i int) bool {
-			return s.sortedByName[i].UsernameLower >= query
-		})
-		
-		// Linear scan only from startIdx
-		for i := startIdx; i < len(s.sortedByName); i++ {
-			user := s.sortedByName[i]
-			
-			// Check if username starts with query (case-insensitive)
-			if strings.HasPrefix(user.UsernameLower, query) {
-				results = append(results, *user)
-			} else {
-				// Since slice is sorted, no more matches possible
-				break
-			}
-			
-			// Limit for performance
-			if len(results) >= 1000 {
-				break
-			}
-		}
-		
-		log.Printf("Search '%s': full scan, matches=%d, time=%v", 
-			query, len(results), time.Since(startTime))
+	lookupStart := time.Now()
+	results, err := s.MatchLocked(ctx, algo, query, results)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	took := time.Since(lookupStart)
+	searchAlgos.record(algo.Name(), len(results), took)
+	log.Printf("Search '%s': %s, matches=%d, time=%v", query, algo.Name(), len(results), took)
+	if results, err = s.MatchTokensLocked(ctx, query, results); err != nil {
+		return nil, 0, 0, err
 	}
 	
 	total := len(results)
+	if page <= 1 {
+		bucketed := len(s.BucketLocked(usernameBucket(query))) > 0
+		searchStats.record(query, total, bucketed)
+	}
 	start := (page - 1) * limit
 	
 	if start >= total {
-		return []User{}, total, 0
+		return []User{}, total, 0, nil
 	}
 	
 	end := start + limit
@@ -341,165 +512,246 @@ func (s *UserStore) SearchUsers(query string, page, limit int) ([]User, int, int
 		end = total
 	}
 	
-	return results[start:end], total, (total + limit - 1) / limit
+	// Copy the page out: results is pooled scratch
+	pageUsers := make([]User, end-start)
+	copy(pageUsers, results[start:end])
+	
+	return pageUsers, total, (total + limit - 1) / limit, nil
 }
 
+// ratingBand is an inclusive rating range.
+type ratingBand = leaderboard.Band
+
+// allRatings matches every user.
+var allRatings = leaderboard.AllScores
+
 // OPTIMIZATION: Cached leaderboard with RLock for concurrent reads
-func (s *UserStore) GetLeaderboard(page, limit int) ([]User, int, int, int64) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("lb:%d:%d", page, limit)
-	s.cacheMutex.RLock()
-	if entry, exists := s.cache[cacheKey]; exists {
-		if time.Since(entry.timestamp) <= s.cacheTTL {
-			total := len(s.sortedUsers)
-			totalPages := (total + limit - 1) / limit
-			s.cacheMutex.RUnlock()
-			return entry.data, total, totalPages, s.updateCount
-		}
-	}
-	s.cacheMutex.RUnlock()
-	
-	// OPTIMIZATION: Use RLock for concurrent reads
-	s.mu.RLock()
-	
-	// If needs sorting, we need to upgrade to write lock
-	if s.needsSorting {
-		s.mu.RUnlock()
-		s.mu.Lock()
-		s.sortUsersLocked()
-		s.mu.Unlock()
-		s.mu.RLock()
+func (s *UserStore) GetLeaderboard(ctx context.Context, page, limit int) ([]User, int, int, int64, error) {
+	return s.GetLeaderboardBand(ctx, allRatings, page, limit)
+}
+
+// GetLeaderboardBand pages through users rated within band. Ranks stay
+// global; total and page counts cover the band only.
+func (s *UserStore) GetLeaderboardBand(ctx context.Context, band ratingBand, page, limit int) ([]User, int, int, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, 0, err
 	}
 	
-	if page < 1 {
-		page = 1
-	}
 	if limit < 1 {
 		limit = 45
 	}
 	
-	total := len(s.sortedUsers)
-	start := (page - 1) * limit
-	
-	if start >= total {
-		s.mu.RUnlock()
-		return []User{}, total, 0, s.updateCount
-	}
-	
-	end := start + limit
-	if end > total {
-		end = total
+	// Served from the page cache when it can be (see pagecache.go)
+	users, total, totalPages, outcome, err := s.PageBand(ctx, band, page, limit)
+	if err != nil {
+		return nil, 0, 0, 0, err
 	}
-	
-	// Copy data while holding read lock
-	users := make([]User, end-start)
-	for i := start; i < end; i++ {
-		users[i-start] = *s.sortedUsers[i]
+	if outcome == cacheHit {
+		atomic.AddInt64(&s.cacheHits, 1)
+	} else {
+		atomic.AddInt64(&s.cacheMisses, 1)
 	}
-	
-	totalPages := (total + limit - 1) / limit
-	updateCount := s.updateCount
-	
-	s.mu.RUnlock()
-	
-	// Cache the result
-	s.cacheMutex.Lock()
-	s.cache[cacheKey] = cacheEntry{
-		data:      users,
-		timestamp: time.Now(),
+	s.cacheStats.record(pageBucket(page), outcome)
+	return users, total, totalPages, s.updateCount, nil
+}
+
+// lookupUserLocked finds a user by ID, or else by username, matched
+// ignoring case.
+func (s *UserStore) lookupUserLocked(id, username string) (*User, bool) {
+	if id != "" {
+		return s.GetLocked(id)
 	}
-	s.cacheMutex.Unlock()
-	
-	return users, total, totalPages, updateCount
+	return s.GetByNameLocked(username)
 }
 
-func (s *UserStore) GetUserRank(username string) (map[string]interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// GetUserRank reports a user's standing, looked up by id or else by
+// username. liveRank and percentile come from current ratings; rank is as
+// of the last sort. A user found by neither is ErrUserNotFound.
+func (s *UserStore) GetUserRank(ctx context.Context, id, username string, mode percentileMode) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
 	
-	user, exists := s.usersByName[username]
+	user, exists := s.lookupUserLocked(id, username)
+	var renamed *usernameChange
+	if !exists && id == "" {
+		user, renamed, exists = s.renamedLocked(username)
+	}
 	if !exists {
-		return nil, false
+		return nil, ErrUserNotFound
 	}
 	
-	// Count ties (users with same rating)
-	tieCount := 0
-	for _, u := range s.sortedUsers {
-		if u.Rating == user.Rating {
-			tieCount++
-		}
-	}
+	// Users above, tied with (including this one) and below this rating
+	above, tieCount, below := s.CountLocked(user.Rating)
+	total := above + tieCount + below
 	
-	return map[string]interface{}{
-		"user":          *user,
-		"tieCount":      tieCount,
-		"totalUsers":    atomic.LoadInt64(&s.totalUsers),
-		"percentile":    float64(user.Rank) / float64(atomic.LoadInt64(&s.totalUsers)) * 100,
-		"lastUpdate":    s.lastUpdate.Unix(),
-		"needsSorting":  s.needsSorting,
-		"pendingSorts":  s.updateCount,
-	}, true
+	info := map[string]interface{}{
+		"user":           *user,
+		"liveRank":       s.LiveRankLocked(user, above),
+		"tieCount":       tieCount,
+		"usersBelow":     below,
+		"totalUsers":     atomic.LoadInt64(&s.totalUsers),
+		"percentile":     mode.percentile(below, tieCount, total),
+		"percentileMode": mode,
+		"lastUpdate":     s.lastUpdate.Unix(),
+		"needsSorting":   s.StaleLocked(),
+		"pendingSorts":   s.updateCount,
+	}
+	if renamed != nil {
+		// Found by a previous name
+		info["renamedFrom"] = renamed.Username
+		info["renamedTo"] = user.Username
+	}
+	return info, nil
 }
 
 func (s *UserStore) GetStats() map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	
-	// Count A/Z names from buckets
-	aCount := 0
-	zCount := 0
-	
-	for char, bucket := range s.firstCharBuckets {
-		if char == 'a' {
-			aCount = len(bucket)
-		}
-		if char == 'z' {
-			zCount = len(bucket)
-		}
-	}
-	
-	// Calculate bucket statistics
-	bucketStats := make(map[string]int)
-	for char, bucket := range s.firstCharBuckets {
-		bucketStats[string(char)] = len(bucket)
+	s.RLock()
+	defer s.RUnlock()
+	
+	// Count A/Z names and calculate bucket statistics
+	buckets := s.BucketSizesLocked()
+	aCount := buckets['a']
+	zCount := buckets['z']
+	bucketStats := make(map[string]int, len(buckets))
+	for char, size := range buckets {
+		bucketStats[string(char)] = size
 	}
+		cacheSize := s.pages.Len()
 	
 	return map[string]interface{}{
 		"totalUsers":     atomic.LoadInt64(&s.totalUsers),
 		"usersWithA":     aCount,
 		"usersWithZ":     zCount,
-		"pendingSorts":   s.updateCount,
-		"needsSorting":   s.needsSorting,
-		"updatedUsers":   len(s.updatedUsers),
-		"cacheSize":      len(s.cache),
+		"needsSorting":   s.StaleLocked(),
+		"updates":        s.updates.report(s.updateCount, len(s.updatedUsers), s.sortThreshold),
+		"cacheSize":      cacheSize,
+		"cacheBackend":   s.pages.Name(),
+		"cacheVersion":   clusterVersion.Stats(),
+		"cache":          s.cacheStats.Stats(s.cacheTTL.Milliseconds(), cacheSize),
 		"lastUpdate":     s.lastUpdate.Unix(),
 		"sortThreshold":  s.sortThreshold,
 		"bucketStats":    bucketStats,
 		"optimizedSearch": "Binary Search + First-Char Bucketing",
-		"bucketCount":    len(s.firstCharBuckets),
+		"bucketCount":    len(buckets),
+		"direction":      s.Direction(),
+		"tieBreak":       s.TieBreak(),
+		"rankMode":       s.RankMode(),
+		"scores":         scores.Describe(),
+	}
+}
+
+// runSimulator applies random rating updates at random intervals until ctx
+// is cancelled. With a lease, only the holder applies them.
+func runSimulator(ctx context.Context) error {
+	for {
+		// Random count between 1 and 200 users
+		updateCount := 1 + rand.Intn(200)
+		if simulatorLease == nil || simulatorLease.Held() {
+			if err := userStore.updateRandomScores(ctx, updateCount, sourceSimulator); err != nil && err != raft.ErrNotLeader && err != errBackendDegraded && ctx.Err() == nil {
+				log.Printf("Update failed: %v", err)
+			}
+		}
+		
+		// Random interval between 1 and 10 seconds
+		sleepSeconds := 1 + rand.Intn(10)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(sleepSeconds) * time.Second):
+		}
 	}
 }
 
 //main
 var userStore *UserStore
+var wsHub *WSHub
+var simulatorLease *Lease
 
 func init() {
-	rand.Seed(time.Now().UnixNano())
+	// MATIKS_SEED makes the generated dataset and simulator reproducible
+	seed := time.Now().UnixNano()
+	if v, err := strconv.ParseInt(os.Getenv("MATIKS_SEED"), 10, 64); err == nil {
+		seed = v
+	}
+	rand.Seed(seed)
+	loadFeatures()
 	userStore = NewUserStore()
-	userStore.generateUsers(20000)
+	userStore.generateUsers(newSeeder(seed), 20000)
+	
+	if brokers := envList("MATIKS_KAFKA_BROKERS"); len(brokers) > 0 {
+		startKafkaPublisher(userStore.events, brokers, envString("MATIKS_KAFKA_TOPIC", "leaderboard-events"))
+	}
+	
+	wsHub = newWSHub()
+	go wsHub.run(userStore.events.Subscribe(256))
+	
+	if primary := envString("MATIKS_REPLICA_OF", ""); primary != "" {
+		startFollower(userStore, primary)
+	} else if dir := envString("MATIKS_RAFT_DIR", ""); dir != "" {
+		if err := startRaft(userStore, raftConfigFromEnv(dir)); err != nil {
+			log.Fatalf("Raft: %v", err)
+		}
+	}
 	
-	// Start auto-updates with random counts and intervals
-	go func() {
-		for {
-			// Random count between 1 and 200 users
-			updateCount := 1 + rand.Intn(200)
-			userStore.updateRandomScores(updateCount)
-			
-			// Random interval between 1 and 10 seconds
-			sleepSeconds := 1 + rand.Intn(10)
-			time.Sleep(time.Duration(sleepSeconds) * time.Second)
+	rdb := newRedisClient()
+	userStore.pages = newPageCache(rdb, userStore.cacheTTL)
+	if rdb != nil {
+		clusterVersion = newCacheVersion(rdb, userStore.pages.TTL())
+		go clusterVersion.run(context.Background())
+		startCacheInvalidation(rdb, envString("MATIKS_REDIS_INVALIDATION_CHANNEL", "matiks:cache-invalidate"))
+		
+		// Replicas sharing Redis elect one simulator so updates aren't duplicated
+		if envBool("MATIKS_SIMULATOR_LEASE", true) {
+			simulatorLease = NewLease(rdb, envString("MATIKS_SIMULATOR_LEASE_KEY", "matiks:simulator-leader"),
+				instanceID, envDuration("MATIKS_SIMULATOR_LEASE_TTL", 10*time.Second))
+			go simulatorLease.Run(context.Background())
+		}
+	}
+	
+	if url := envString("MATIKS_NATS_URL", ""); url != "" {
+		if err := startNATS(url, envString("MATIKS_NATS_SUBJECT", "matiks"), envBool("MATIKS_NATS_SUBSCRIBE", true)); err != nil {
+			log.Printf("NATS: disabled: %v", err)
 		}
-	}()
+	}
+	
+	if addr := envString("MATIKS_GRPC_ADDR", ""); addr != "" {
+		if err := startGRPC(userStore, addr); err != nil {
+			log.Printf("gRPC: disabled: %v", err)
+		}
+	}
+	
+	startDashboard(userStore)
+	startDigests(userStore)
+	startImprovement(userStore)
+	startHooks()
+	startVolatility(userStore)
+	startAnalytics(userStore)
+	startWidget(userStore)
+	
+	if err := startPush(userStore); err != nil {
+		log.Printf("Push: disabled: %v", err)
+	}
+	
+	if err := startAnnouncer(userStore); err != nil {
+		log.Printf("Announcer: disabled: %v", err)
+	}
+	
+	if endpoints := envList("MATIKS_WEBHOOK_URLS"); len(endpoints) > 0 || envBool("MATIKS_WEBHOOK_RULES", false) {
+		if err := startWebhooks(userStore.events, endpoints); err != nil {
+			log.Printf("Webhooks: disabled: %v", err)
+		}
+	}
+	
+	// Start auto-updates with random counts and intervals (primaries only)
+	jobs.Register("simulator", runSimulator)
+	if replica == nil {
+		jobs.Run("simulator")
+	}
+	
+	startScheduler(userStore)
 	
 	log.Printf("✅ Optimized leaderboard initialized")
 	log.Printf(" Users: 20,000")
@@ -514,83 +766,223 @@ func init() {
 	log.Printf("   8. Random update intervals (1-10 seconds)")
 }
 
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Cache-Control", "no-store")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		
-		next(w, r)
-	}
-}
-
 func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
-page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-
-if page <= 0 {
-	page = 1
-}
-if limit <= 0 {
-	limit = 45
-}
-
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	
+	band, err := ratingBandParams(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	cursor, cursored, err := cursorParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	filter, err := filteredBoardParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	snap, err := snapshotParam(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
 	
-	users, total, totalPages, pendingSorts := userStore.GetLeaderboard(page, limit)
+	// Activity moves filtered boards without touching LastModified
+	if snap == nil && filter == nil && writeConditional(w, r, userStore.LastModified()) {
+		return
+	}
 	
-	response := map[string]interface{}{
-		"success":      true,
-		"users":        users,
-		"total":        total,
-		"page":         page,
-		"limit":        limit,
-		"totalPages":   totalPages,
-		"pendingSorts": pendingSorts,
-		"timestamp":    time.Now().Unix(),
+	var response leaderboardResponse
+	if filter != nil {
+		users, total, totalPages, pendingSorts, err := filter.Page(r.Context(), userStore, band, page, limit)
+		if err != nil {
+			return // Client disconnected; nobody to answer
+		}
+		response = leaderboardResponse{
+			Users:        users,
+			Total:        total,
+			Page:         page,
+			Limit:        limit,
+			TotalPages:   totalPages,
+			PendingSorts: pendingSorts,
+			Timestamp:    time.Now().Unix(),
+			WindowParam:  filter.param,
+			Window:       filter.raw,
+		}
+	} else if snap != nil {
+		users, total, totalPages := snap.Page(band, page, limit)
+		response = leaderboardResponse{
+			Users:        users,
+			Total:        total,
+			Page:         page,
+			Limit:        limit,
+			TotalPages:   totalPages,
+			PendingSorts: snap.pendingSorts,
+			Timestamp:    time.Now().Unix(),
+			Snapshot:     snap,
+		}
+	} else if cursored {
+		users, total, next, pendingSorts, err := userStore.GetLeaderboardAfter(r.Context(), band, cursor, limit)
+		if err != nil {
+			return // Client disconnected; nobody to answer
+		}
+		response = leaderboardResponse{
+			Users:        users,
+			Total:        total,
+			Limit:        limit,
+			PendingSorts: pendingSorts,
+			Timestamp:    time.Now().Unix(),
+			Cursored:     true,
+			NextCursor:   next,
+		}
+	} else {
+		users, total, totalPages, pendingSorts, err := userStore.GetLeaderboardBand(r.Context(), band, page, limit)
+		if err != nil {
+			return // Client disconnected; nobody to answer
+		}
+		response = leaderboardResponse{
+			Users:        users,
+			Total:        total,
+			Page:         page,
+			Limit:        limit,
+			TotalPages:   totalPages,
+			PendingSorts: pendingSorts,
+			Timestamp:    time.Now().Unix(),
+		}
+	}
+	switch {
+	case response.Cursored:
+		next := ""
+		if response.NextCursor != nil {
+			next = response.NextCursor.String()
+		}
+		response.Links = cursorLinks(requestURL(r), next)
+	case snap != nil:
+		response.Links = pageLinks(withParam(requestURL(r), "snapshot", snap.id), models.NewPagination(page, limit, response.Total))
+	default:
+		response.Links = pageLinks(requestURL(r), models.NewPagination(page, limit, response.Total))
+	}
+	if include["metadata"] {
+		response.Metadata = metadata.ForUsers(response.Users)
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writePooledJSON(w, response.appendJSON)
 }
 
 func searchHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if utf8.RuneCountInString(query) > limits.MaxQueryLength {
+		writeParamError(w, r, &paramError{Param: "q", Message: fmt.Sprintf("must be at most %d characters", limits.MaxQueryLength)})
+		return
+	}
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
 	
-	users, total, totalPages := userStore.SearchUsers(query, page, limit)
+	by, err := searchByParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	mode, err := searchModeParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	algo, err := searchAlgoParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
 	
-	response := map[string]interface{}{
-		"success":    true,
-		"users":      users,
-		"total":      total,
-		"page":       page,
-		"limit":      limit,
-		"totalPages": totalPages,
-		"timestamp":  time.Now().Unix(),
+	var users []User
+	var total, totalPages int
+	switch {
+	case mode == searchModeRegex:
+		users, total, totalPages, err = searchRegex(r, by, query, page, limit)
+	case by == searchByID:
+		users, total, totalPages, err = userStore.SearchUserIDs(r.Context(), query, page, limit)
+	default:
+		users, total, totalPages, err = userStore.SearchUsers(r.Context(), algo, query, page, limit)
+	}
+	if err != nil && r.Context().Err() == nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	if err != nil {
+		return // Client disconnected; nobody to answer
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response := searchResponse{
+		Users:      users,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+		Timestamp:  time.Now().Unix(),
+		Links:      pageLinks(requestURL(r), models.NewPagination(page, limit, total)),
+	}
+	if include["metadata"] {
+		response.Metadata = metadata.ForUsers(users)
+	}
+	if total == 0 && mode == searchModePrefix && by == searchByUsername {
+		if response.Suggestions, err = userStore.Suggest(r.Context(), query); err != nil {
+			return // Client disconnected; nobody to answer
+		}
+	}
+	
+	writePooledJSON(w, response.appendJSON)
 }
 
 func userRankHandler(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
+	w.Header().Set("Content-Type", "application/json")
+	id, username, err := userRefParams(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	mode, err := percentileParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
 	
-	rankInfo, found := userStore.GetUserRank(username)
-	if !found {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "User not found",
-		})
+	rankInfo, err := userStore.GetUserRank(r.Context(), id, username, mode)
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeLegacyError(w, r, err)
+		}
 		return
 	}
+	user := rankInfo["user"].(User)
+	if include["metadata"] {
+		rankInfo["metadata"] = metadata.Get(user.ID)
+	}
+	rankInfo["links"] = userLinks(r, user.ID)
 	
 	response := map[string]interface{}{
 		"success": true,
@@ -601,24 +993,103 @@ func userRankHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func statsHandler(w http.ResponseWriter, r *http.Request) {
-	stats := userStore.GetStats()
+	w.Header().Set("Content-Type", "application/json")
+	if writeConditional(w, r, userStore.LastModified()) {
+		return
+	}
 	
 	response := map[string]interface{}{
 		"success": true,
-		"stats":   stats,
+		"stats":   collectStats(r.Context()),
 	}
 	
 	json.NewEncoder(w).Encode(response)
 }
 
+// collectStats adds cluster, simulator and replication state to the store's
+// own stats.
+func collectStats(ctx context.Context) map[string]interface{} {
+	stats := userStore.GetStats()
+	if status := raftStatus(); status != nil {
+		stats["raft"] = status
+	}
+	if simulatorLease != nil {
+		stats["simulator"] = map[string]interface{}{
+			"leader": simulatorLease.Held(),
+			"holder": simulatorLease.Holder(ctx),
+		}
+	}
+	stats["latency"] = latencies.Stats()
+	stats["snapshots"] = snapshots.Stats()
+	stats["archives"] = archives.Stats()
+	stats["activeBoards"] = activeBoards.Stats()
+	stats["newcomerBoards"] = newcomerBoards.Stats()
+	stats["improvement"] = improvement.Stats()
+	stats["volatility"] = volatility.Stats()
+	stats["history"] = histories.Stats()
+	stats["analytics"] = analytics.Stats()
+	stats["hooks"] = hooks.Stats()
+	if inactivePolicy.days > 0 {
+		expiry := inactivePolicy.Describe()
+		expiry["inactiveUsers"] = userStore.inactiveCount()
+		stats["expiry"] = expiry
+	}
+	if limiter.limit > 0 {
+		stats["rateLimit"] = limiter.Stats()
+	}
+	if redisBreaker != nil {
+		stats["breakers"] = map[string]interface{}{"redis": redisBreaker.Status()}
+	}
+	if announcer != nil {
+		stats["announcer"] = announcer.Stats()
+	}
+	if avatars.proxy {
+		stats["avatars"] = avatars.Stats()
+	}
+	if replica != nil {
+		stats["replication"] = replica.status()
+	} else {
+		stats["replication"] = map[string]interface{}{
+			"role":    "primary",
+			"headSeq": userStore.changes.head(),
+		}
+	}
+	return stats
+}
+
 func updateHandler(w http.ResponseWriter, r *http.Request) {
-	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
-	if count <= 0 {
-		// Random count if not specified
-		count = 1 + rand.Intn(200)
+	w.Header().Set("Content-Type", "application/json")
+	// Random count if not specified
+	count, err := intParam(r, "count", 1+rand.Intn(200), 1, limits.MaxUpdateCount)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	if dryRun {
+		plan, err := userStore.PlanRandomScores(r.Context(), count)
+		if err != nil {
+			if r.Context().Err() == nil {
+				writeLegacyError(w, r, err)
+			}
+			return
+		}
+		plan["success"] = true
+		plan["timestamp"] = time.Now().Unix()
+		json.NewEncoder(w).Encode(plan)
+		return
 	}
 	
-	userStore.updateRandomScores(count)
+	if err := userStore.updateRandomScores(r.Context(), count, sourceAPI); err != nil {
+		if r.Context().Err() == nil {
+			writeLegacyError(w, r, err)
+		}
+		return
+	}
 	
 	response := map[string]interface{}{
 		"success":   true,
@@ -629,37 +1100,108 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// ForceSort re-sorts and re-ranks every user, whether or not anything
+// changed since the last sort.
+func (s *UserStore) ForceSort(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.ResortLocked()
+	return nil
+}
+
+// startForceSort runs ForceSort as the "force-sort" job, pointing at its
+// status in r's API version.
+func startForceSort(r *http.Request) (map[string]interface{}, error) {
+	id, err := jobs.RunFunc("force-sort", userStore.ForceSort)
+	if err != nil {
+		return nil, err
+	}
+	job, err := jobs.RunStatus(id)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message": "Sort started in the background",
+		"job":     job,
+		"status":  jobStatusPath(r, id),
+	}, nil
+}
+
 func forceSortHandler(w http.ResponseWriter, r *http.Request) {
-	userStore.mu.Lock()
-	userStore.sortUsersLocked()
-	userStore.mu.Unlock()
-	
-	response := map[string]interface{}{
-		"success":   true,
-		"message":   "Forced sort completed",
-		"timestamp": time.Now().Unix(),
+	w.Header().Set("Content-Type", "application/json")
+	started, err := startForceSort(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	started["success"] = true
+	started["timestamp"] = time.Now().Unix()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(started)
+}
+
+func healthReport() map[string]interface{} {
+	return map[string]interface{}{
+		"status":       healthStatus(),
+		"users":        atomic.LoadInt64(&userStore.totalUsers),
+		"optimization": "Binary Search + First-Char Bucketing",
 	}
-	
-	json.NewEncoder(w).Encode(response)
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	health := healthReport()
+	health["timestamp"] = time.Now().Unix()
+	json.NewEncoder(w).Encode(health)
 }
 
 func main() {
-	http.HandleFunc("/leaderboard", corsMiddleware(leaderboardHandler))
-	http.HandleFunc("/search", corsMiddleware(searchHandler))
-	http.HandleFunc("/user/rank", corsMiddleware(userRankHandler))
-	http.HandleFunc("/stats", corsMiddleware(statsHandler))
-	http.HandleFunc("/update", corsMiddleware(updateHandler))
-	http.HandleFunc("/force-sort", corsMiddleware(forceSortHandler))
-	http.HandleFunc("/health", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":       "healthy",
-			"users":        atomic.LoadInt64(&userStore.totalUsers),
-			"optimization": "Binary Search + First-Char Bucketing",
-			"timestamp":    time.Now().Unix(),
-		})
-	}))
-	
-	port := ":8080"
+	handle("/leaderboard", leaderboardHandler)
+	handle("/leaderboard/improvement", improvementHandler)
+	handle("/search", searchHandler)
+	handle("/user/rank", userRankHandler)
+	handle("/stats", statsHandler)
+	handle("/stats/aggregates", aggregatesHandler)
+	handle("/stats/history", historyHandler)
+	handle("/analytics/daily", dailyAnalyticsHandler)
+	handle("/update", updateHandler)
+	handle("/force-sort", forceSortHandler)
+	handle("/admin/runtime", adminRuntimeHandler)
+	handle("/admin/dashboard", adminDashboardHandler)
+	handle("/admin/search-analytics", adminSearchAnalyticsHandler)
+	handle("/admin/seed", adminReseedHandler)
+	handle("/admin/reseed", adminReseedHandler)
+	handle("/admin/schedule", adminScheduleHandler)
+	handle("/admin/jobs", adminJobsHandler)
+	handle("/admin/jobs/", adminJobsHandler)
+	handle("/admin/features", adminFeaturesHandler)
+	handle("/admin/features/", adminFeaturesHandler)
+	handle("/admin/webhooks", adminWebhooksHandler)
+	handle("/admin/webhooks/rules", adminRulesHandler)
+	handle("/admin/webhooks/rules/", adminRulesHandler)
+	handle("/admin/users", adminUsersHandler)
+	handle("/admin/users/", adminUsersHandler)
+	handle("/metrics", metricsExpositionHandler)
+	handle("/users/", usersHandler)
+	handle("/leaderboards", boardsHandler)
+	handle("/leaderboards/", boardsHandler)
+	handle("/archives", archivesHandler)
+	handle("/archives/", archivesHandler)
+	handle("/widget/top10", widgetTop10Handler)
+	handle("/avatars/", avatarHandler)
+	handle("/push/devices", pushHandler)
+	handle("/push/follows", pushHandler)
+	handle("/ws", wsHub.handler)
+	handle("/replication/snapshot", replicationSnapshotHandler)
+	handle("/replication/stream", replicationStreamHandler)
+	handle("/health", healthHandler)
+	handle("/readyz", readyzHandler)
+	registerV1Routes()
+	
+	port := envString("MATIKS_ADDR", ":8080")
 	log.Printf(" Optimized Server started on %s", port)
 	log.Printf(" Total users: %d", atomic.LoadInt64(&userStore.totalUsers))
 	log.Printf("⚡ Optimizations active:")
@@ -672,5 +1214,9 @@ func main() {
 	log.Printf("   7. Random update counts (1-200 users)")
 	log.Printf("   8. Random intervals (1-10 seconds)")
 	
-	log.Fatal(http.ListenAndServe(port, nil))
+	warmup(userStore)
+	public := publicHandler(http.DefaultServeMux)
+	startHTTP3(public)
+	startAdminListener()
+	log.Fatal(serveHTTP(newHTTPServer(port, public)))
 }
\ No newline at end of file
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// POST /admin/users/merge folds a duplicate account into the one being
+// kept. The kept user ends with the better of the two ratings ("best", the
+// default) or its own ("keep"); the duplicate's weekly digest history is
+// summed into the kept user's, profile fields and metadata keys the kept
+// user lacks are copied over, and the duplicate's name redirects to the
+// kept user like an old name after a rename. The duplicate then leaves every index
+// under the same lock as the rating change, so no reader sees both.
+
+// mergeRequestBody is the merge request.
+type mergeRequestBody struct {
+	Keep   string `json:"keep"`
+	Remove string `json:"remove"`
+	Rating string `json:"rating"` // "best" (default) or "keep"
+}
+
+// MergeUsers merges remove into keep and returns the kept user.
+func (s *UserStore) MergeUsers(ctx context.Context, keepID, removeID string, bestRating bool) (User, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return User{}, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	kept, exists := s.GetLocked(keepID)
+	if !exists {
+		return User{}, fmt.Errorf("%w: %s", ErrUserNotFound, keepID)
+	}
+	removed, exists := s.GetLocked(removeID)
+	if !exists {
+		return User{}, fmt.Errorf("%w: %s", ErrUserNotFound, removeID)
+	}
+
+	if bestRating && s.Direction().Better(removed.Rating, kept.Rating) {
+		oldRating := kept.Rating
+		s.SetScoreLocked(kept, removed.Rating, removed.ReachedAt())
+		s.aggregates.change(oldRating, kept.Rating)
+		thresholds.ratingChanged(kept, oldRating, kept.Rating)
+		s.events.Publish(EventRatingChanged, RatingChangedPayload{
+			UserID:    kept.ID,
+			Username:  kept.Username,
+			OldRating: oldRating,
+			NewRating: kept.Rating,
+		})
+		atomic.AddInt64(&s.ratingUpdates, 1) // Re-ranked by the removal below
+		s.updates.record(sourceMerge, 1, 1)
+	}
+	s.removeUserLocked(removed)
+	s.mergeRenamesLocked(kept, removed)
+	activity.keepLater(kept.ID, removed.ID)
+	joins.Delete(removed.ID)
+	return *kept, nil
+}
+
+// Merge sums from's weekly stats into into's, keeping into's standing at
+// the edges of each week.
+func (d *DigestTracker) Merge(into, from string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.weeks {
+		src := w.users[from]
+		if src == nil {
+			continue
+		}
+		delete(w.users, from)
+		dst := w.users[into]
+		if dst == nil {
+			copied := *src
+			w.users[into] = &copied
+			continue
+		}
+		dst.Updates += src.Updates
+		if src.PeakRating > dst.PeakRating {
+			dst.PeakRating = src.PeakRating
+		}
+		if src.BestRank != 0 && (dst.BestRank == 0 || src.BestRank < dst.BestRank) {
+			dst.BestRank = src.BestRank
+		}
+	}
+}
+
+// MergeInto copies the profile fields into lacks from from's profile,
+// then drops from's.
+func (p *ProfileStore) MergeInto(into, from string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	src, exists := p.byID[from]
+	if !exists {
+		return
+	}
+	delete(p.byID, from)
+	dst := p.byID[into]
+	if dst.DisplayName == "" {
+		dst.DisplayName = src.DisplayName
+	}
+	if dst.AvatarURL == "" {
+		dst.AvatarURL = src.AvatarURL
+	}
+	if dst.Country == "" {
+		dst.Country = src.Country
+	}
+	if dst.Bio == "" {
+		dst.Bio = src.Bio
+	}
+	dst.UpdatedAt = time.Now().Unix()
+	p.byID[into] = dst
+}
+
+// MergeInto copies the metadata keys into lacks from from's metadata, as
+// far as the limits allow, then drops from's.
+func (m *MetadataStore) MergeInto(into, from string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src := m.byID[from]
+	delete(m.byID, from)
+	if len(src) == 0 {
+		return
+	}
+	next := make(map[string]json.RawMessage, len(m.byID[into])+len(src))
+	for k, v := range src {
+		next[k] = v
+	}
+	for k, v := range m.byID[into] {
+		next[k] = v
+	}
+	if checkMetadata(next) == nil {
+		m.byID[into] = next
+	}
+}
+
+// mergeUsersRequest serves POST /admin/users/merge.
+func mergeUsersRequest(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("%w: use POST", errMethodNotAllowed)
+	}
+	var body mergeRequestBody
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+	}
+	switch {
+	case body.Keep == "":
+		return nil, &paramError{Param: "keep", Message: "is required"}
+	case body.Remove == "":
+		return nil, &paramError{Param: "remove", Message: "is required"}
+	case body.Keep == body.Remove:
+		return nil, &paramError{Param: "remove", Message: "must differ from keep"}
+	}
+	if body.Rating == "" {
+		body.Rating = "best"
+	}
+	if body.Rating != "best" && body.Rating != "keep" {
+		return nil, &paramError{Param: "rating", Message: fmt.Sprintf("must be best or keep, got %q", body.Rating)}
+	}
+
+	user, err := userStore.MergeUsers(r.Context(), body.Keep, body.Remove, body.Rating == "best")
+	if err != nil {
+		return nil, err
+	}
+	if digests != nil {
+		digests.Merge(body.Keep, body.Remove)
+	}
+	profiles.MergeInto(body.Keep, body.Remove)
+	metadata.MergeInto(body.Keep, body.Remove)
+	metrics.MergeInto(body.Keep, body.Remove)
+	return map[string]interface{}{
+		"user":    user,
+		"removed": body.Remove,
+	}, nil
+}
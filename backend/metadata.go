@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Services may attach a small JSON object to each user (school, grade,
+// client version, ...) at /users/{id}/metadata. Like profiles it is kept
+// outside the ranking structures and held in memory on the instance that
+// accepted the write. Reads return it only when asked with
+// ?include=metadata.
+
+var (
+	maxMetadataBytes = envInt("MATIKS_METADATA_MAX_BYTES", 4096)
+	maxMetadataKeys  = envInt("MATIKS_METADATA_MAX_KEYS", 32)
+)
+
+const maxMetadataKeyLen = 64
+
+// MetadataStore maps user IDs to their metadata object.
+type MetadataStore struct {
+	mu   sync.RWMutex
+	byID map[string]map[string]json.RawMessage
+}
+
+var metadata = &MetadataStore{byID: make(map[string]map[string]json.RawMessage)}
+
+// Get returns a copy of one user's metadata, empty when unset.
+func (m *MetadataStore) Get(userID string) map[string]json.RawMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]json.RawMessage, len(m.byID[userID]))
+	for k, v := range m.byID[userID] {
+		out[k] = v
+	}
+	return out
+}
+
+// ForUsers returns the metadata of those users that have any.
+func (m *MetadataStore) ForUsers(users []User) map[string]map[string]json.RawMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]map[string]json.RawMessage)
+	for _, u := range users {
+		if md := m.byID[u.ID]; len(md) > 0 {
+			out[u.ID] = md
+		}
+	}
+	return out
+}
+
+// Update replaces (merge false) or merges into (merge true) a user's
+// metadata; in a merge, null values delete their key. It fails without
+// changing anything if the result would exceed the limits.
+func (m *MetadataStore) Update(userID string, values map[string]json.RawMessage, merge bool) (map[string]json.RawMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := make(map[string]json.RawMessage)
+	if merge {
+		for k, v := range m.byID[userID] {
+			next[k] = v
+		}
+	}
+	for k, v := range values {
+		if merge && string(v) == "null" {
+			delete(next, k)
+			continue
+		}
+		next[k] = v
+	}
+	if err := checkMetadata(next); err != nil {
+		return nil, err
+	}
+	if len(next) == 0 {
+		delete(m.byID, userID)
+	} else {
+		m.byID[userID] = next
+	}
+	return next, nil
+}
+
+func (m *MetadataStore) Delete(userID string) {
+	m.mu.Lock()
+	delete(m.byID, userID)
+	m.mu.Unlock()
+}
+
+// checkMetadata enforces the key count, key length and encoded size limits.
+func checkMetadata(md map[string]json.RawMessage) error {
+	if len(md) > maxMetadataKeys {
+		return &paramError{Param: "metadata", Message: fmt.Sprintf("must have at most %d keys, got %d", maxMetadataKeys, len(md))}
+	}
+	for k := range md {
+		if k == "" || len(k) > maxMetadataKeyLen {
+			return &paramError{Param: "metadata", Message: fmt.Sprintf("keys must be 1 to %d bytes, got %q", maxMetadataKeyLen, k)}
+		}
+	}
+	encoded, _ := json.Marshal(md)
+	if len(encoded) > maxMetadataBytes {
+		return &paramError{Param: "metadata", Message: fmt.Sprintf("must encode to at most %d bytes, got %d", maxMetadataBytes, len(encoded))}
+	}
+	return nil
+}
+
+// decodeMetadata reads a JSON object body, compacting each value.
+func decodeMetadata(r *http.Request) (map[string]json.RawMessage, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxMetadataBytes)*2))
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(body, &values); err != nil || values == nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object"}
+	}
+	for k, v := range values {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, v); err != nil {
+			return nil, &paramError{Param: "body", Message: err.Error()}
+		}
+		values[k] = compact.Bytes()
+	}
+	return values, nil
+}
+
+// userMetadataRequest serves GET, PUT, PATCH and DELETE
+// /users/{id}/metadata.
+func userMetadataRequest(r *http.Request) (map[string]json.RawMessage, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/users/")
+	id := strings.TrimSuffix(rest, "/metadata")
+	if id == rest || id == "" || strings.Contains(id, "/") {
+		return nil, errRouteNotFound
+	}
+	if _, err := userStore.userByID(id); err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return metadata.Get(id), nil
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if replica != nil {
+			return nil, errReadOnlyReplica
+		}
+	default:
+		return nil, fmt.Errorf("%w: use GET, PUT, PATCH or DELETE", errMethodNotAllowed)
+	}
+
+	if r.Method == http.MethodDelete {
+		metadata.Delete(id)
+		return map[string]json.RawMessage{}, nil
+	}
+	values, err := decodeMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Update(id, values, r.Method == http.MethodPatch)
+}
+
+func userMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	md, err := userMetadataRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"metadata": md,
+	})
+}
+
+// includeParam reads ?include=, a comma-separated list of optional parts.
+func includeParam(r *http.Request, allowed ...string) (map[string]bool, error) {
+	include := make(map[string]bool)
+	raw := r.URL.Query().Get("include")
+	if raw == "" {
+		return include, nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		known := false
+		for _, a := range allowed {
+			known = known || a == part
+		}
+		if !known {
+			return nil, &paramError{Param: "include", Message: fmt.Sprintf("unknown part %q (allowed: %s)", part, strings.Join(allowed, ", "))}
+		}
+		include[part] = true
+	}
+	return include, nil
+}
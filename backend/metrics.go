@@ -0,0 +1,593 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/leaderboard"
+	"matiks-leaderboard/models"
+	"matiks-leaderboard/utils"
+)
+
+// Besides its rating a user may carry named metrics declared in
+// MATIKS_METRICS, e.g. "accuracy,speed:asc" (":asc" when lower is better),
+// written at /users/{id}/metrics. Every metric gets a leaderboard of its
+// own, and MATIKS_METRIC_BOARDS adds weighted composites, separated by
+// semicolons: "overall=rating:1,accuracy:20,speed:-10". A composite is the
+// weighted sum of its inputs' raw values, ranked highest first, so give
+// lower-is-better inputs a negative weight; users missing any input are
+// left off it. MATIKS_LEADERBOARD_DIRECTION_<BOARD> overrides a board's
+// direction (see direction.go) and MATIKS_SCORE_TYPE_<BOARD> rounds its
+// values to a score type (see scores.go). Ties within a board follow
+// MATIKS_TIE_BREAK_<BOARD> and MATIKS_TIE_BREAK_SEED_<BOARD> (see ties.go)
+// and ranks MATIKS_RANK_MODE_<BOARD> (see ranking.go). Each board keeps its
+// own sorted index, rebuilt on the first read after an input changed, like
+// the main leaderboard's lazy sort. The rating board itself stays at
+// /leaderboard.
+
+// ratingMetric names the user's rating as a composite input.
+const ratingMetric = "rating"
+
+var errBoardNotFound = errors.New("leaderboard not found")
+
+// metricDef is one declared metric.
+type metricDef struct {
+	Name      string        `json:"name"`
+	Direction rankDirection `json:"direction"`
+}
+
+// metricBoard is a leaderboard over one metric or a weighted composite.
+type metricBoard struct {
+	Name      string             `json:"name"`
+	Direction rankDirection      `json:"direction"`
+	Weights   map[string]float64 `json:"weights,omitempty"` // Composites only
+	TieBreak  utils.TieBreak     `json:"tieBreak"`
+	RankMode  utils.RankMode     `json:"rankMode"`
+	Scores    *ScoreFormat       `json:"score,omitempty"` // nil ranks raw values
+
+	inputs     []string
+	usesRating bool
+
+	sorted  []metricEntry
+	version uint64     // Bumped when an input changes for any user
+	built   boardBuild // What sorted was built from
+}
+
+// boardBuild identifies the inputs a board's index was built from.
+type boardBuild struct {
+	version uint64 // metricBoard.version
+	resets  uint64 // userStore.changes.resetCount(): membership
+	ratings uint64 // userStore.changes.head(); zero unless the board uses rating
+}
+
+type metricEntry struct {
+	tie   utils.TieKey
+	value float64
+	rank  int
+}
+
+// MetricStore holds every user's metrics and the boards ranked on them.
+// Like profiles and metadata it lives outside the ranking structures, in
+// memory on the instance that accepted the write.
+type MetricStore struct {
+	mu         sync.RWMutex
+	defs       map[string]metricDef
+	names      []string // Declaration order
+	byID       map[string]map[string]float64
+	setAt      map[string]map[string]int64 // UnixNano each value was written
+	boards     map[string]*metricBoard
+	boardNames []string
+}
+
+var metrics = newMetricStore(envList("MATIKS_METRICS"), strings.Split(envString("MATIKS_METRIC_BOARDS", ""), ";"))
+
+func newMetricStore(defs, composites []string) *MetricStore {
+	m := &MetricStore{
+		defs:   make(map[string]metricDef),
+		byID:   make(map[string]map[string]float64),
+		setAt:  make(map[string]map[string]int64),
+		boards: make(map[string]*metricBoard),
+	}
+	for _, raw := range defs {
+		name, dir, _ := strings.Cut(raw, ":")
+		def := metricDef{Name: strings.TrimSpace(name), Direction: rankDescending}
+		if dir != "" {
+			d, err := leaderboard.ParseDirection(strings.TrimSpace(dir))
+			if err != nil {
+				log.Fatalf("MATIKS_METRICS: %s: %v", def.Name, err)
+			}
+			def.Direction = d
+		}
+		if def.Name == ratingMetric || m.defs[def.Name].Name != "" {
+			log.Fatalf("MATIKS_METRICS: %q is reserved or declared twice", def.Name)
+		}
+		m.defs[def.Name] = def
+		m.names = append(m.names, def.Name)
+		m.addBoard(&metricBoard{Name: def.Name, Direction: def.Direction, inputs: []string{def.Name}})
+	}
+	for _, raw := range composites {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		board, err := m.parseComposite(raw)
+		if err != nil {
+			log.Fatalf("MATIKS_METRIC_BOARDS: %v", err)
+		}
+		m.addBoard(board)
+	}
+	return m
+}
+
+func (m *MetricStore) addBoard(b *metricBoard) {
+	if _, exists := m.boards[b.Name]; exists {
+		log.Fatalf("MATIKS_METRIC_BOARDS: board %q declared twice", b.Name)
+	}
+	b.Direction = envDirection("MATIKS_LEADERBOARD_DIRECTION_"+strings.ToUpper(b.Name), b.Direction)
+	b.TieBreak = envTieBreak("MATIKS_TIE_BREAK_"+strings.ToUpper(b.Name), "MATIKS_TIE_BREAK_SEED_"+strings.ToUpper(b.Name), leaderboardTieBreak)
+	b.RankMode = envRankMode("MATIKS_RANK_MODE_"+strings.ToUpper(b.Name), leaderboardRankMode)
+	b.Scores = envScoreFormat("MATIKS_SCORE_TYPE_"+strings.ToUpper(b.Name), "MATIKS_SCORE_DECIMALS_"+strings.ToUpper(b.Name), "")
+	m.boards[b.Name] = b
+	m.boardNames = append(m.boardNames, b.Name)
+}
+
+// parseComposite reads "name=metric:weight,...".
+func (m *MetricStore) parseComposite(raw string) (*metricBoard, error) {
+	name, spec, ok := strings.Cut(raw, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || name == ratingMetric {
+		return nil, fmt.Errorf("%q: want name=metric:weight,...", raw)
+	}
+	b := &metricBoard{Name: name, Direction: rankDescending, Weights: make(map[string]float64)}
+	for _, part := range strings.Split(spec, ",") {
+		metric, w, _ := strings.Cut(part, ":")
+		metric = strings.TrimSpace(metric)
+		weight, err := strconv.ParseFloat(strings.TrimSpace(w), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: weight of %q must be a number", name, metric)
+		}
+		if _, known := m.defs[metric]; !known && metric != ratingMetric {
+			return nil, fmt.Errorf("%s: unknown metric %q", name, metric)
+		}
+		if _, dup := b.Weights[metric]; dup {
+			return nil, fmt.Errorf("%s: metric %q listed twice", name, metric)
+		}
+		b.Weights[metric] = weight
+		b.usesRating = b.usesRating || metric == ratingMetric
+		if metric != ratingMetric {
+			b.inputs = append(b.inputs, metric)
+		}
+	}
+	if len(b.inputs) == 0 {
+		return nil, fmt.Errorf("%s: needs a metric besides rating", name)
+	}
+	return b, nil
+}
+
+// Get returns a copy of one user's metrics, empty when unset.
+func (m *MetricStore) Get(userID string) map[string]float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]float64, len(m.byID[userID]))
+	for k, v := range m.byID[userID] {
+		out[k] = v
+	}
+	return out
+}
+
+// Update replaces (merge false) or merges into (merge true) a user's
+// metrics; in a merge, null values delete their metric.
+func (m *MetricStore) Update(userID string, values map[string]*float64, merge bool) (map[string]float64, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	invalid := &validationError{}
+	for _, name := range names {
+		v := values[name]
+		switch _, known := m.defs[name]; {
+		case !known:
+			invalid.add(&paramError{Param: name, Message: fmt.Sprintf("unknown metric (declared: %s)", strings.Join(m.names, ", "))})
+		case v == nil && !merge:
+			invalid.add(&paramError{Param: name, Message: "must be a number"})
+		case v != nil && (math.IsNaN(*v) || math.IsInf(*v, 0)):
+			invalid.add(&paramError{Param: name, Message: "must be finite"})
+		}
+	}
+	if err := invalid.orNil(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev := m.byID[userID]
+	next := make(map[string]float64)
+	if merge {
+		for k, v := range prev {
+			next[k] = v
+		}
+	}
+	for k, v := range values {
+		if v == nil {
+			delete(next, k)
+			continue
+		}
+		next[k] = *v
+	}
+	m.setLocked(userID, prev, next)
+	return next, nil
+}
+
+// setLocked stores next as userID's metrics and invalidates the boards
+// whose inputs differ from prev.
+func (m *MetricStore) setLocked(userID string, prev, next map[string]float64) {
+	changed := make(map[string]bool)
+	for k, v := range next {
+		if old, had := prev[k]; !had || old != v {
+			changed[k] = true
+		}
+	}
+	for k := range prev {
+		if _, has := next[k]; !has {
+			changed[k] = true
+		}
+	}
+	for _, b := range m.boards {
+		for _, in := range b.inputs {
+			if changed[in] {
+				b.version++
+				break
+			}
+		}
+	}
+
+	if len(next) == 0 {
+		delete(m.byID, userID)
+		delete(m.setAt, userID)
+		return
+	}
+	m.byID[userID] = next
+	setAt := m.setAt[userID]
+	if setAt == nil {
+		setAt = make(map[string]int64)
+		m.setAt[userID] = setAt
+	}
+	now := time.Now().UnixNano()
+	for k := range changed {
+		if _, has := next[k]; has {
+			setAt[k] = now
+		} else {
+			delete(setAt, k)
+		}
+	}
+}
+
+func (m *MetricStore) Delete(userID string) {
+	m.mu.Lock()
+	m.setLocked(userID, m.byID[userID], nil)
+	m.mu.Unlock()
+}
+
+// MergeInto folds from's metrics into into; into's own values win.
+func (m *MetricStore) MergeInto(into, from string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src := m.byID[from]
+	if len(src) == 0 {
+		return
+	}
+	prev := m.byID[into]
+	next := make(map[string]float64, len(prev)+len(src))
+	for k, v := range src {
+		next[k] = v
+	}
+	for k, v := range prev {
+		next[k] = v
+	}
+	m.setLocked(from, src, nil)
+	m.setLocked(into, prev, next)
+}
+
+// Boards lists the configured boards.
+func (m *MetricStore) Boards() []metricBoard {
+	out := make([]metricBoard, 0, len(m.boardNames))
+	for _, name := range m.boardNames {
+		b := m.boards[name]
+		out = append(out, metricBoard{Name: b.Name, Direction: b.Direction, Weights: b.Weights, TieBreak: b.TieBreak, RankMode: b.RankMode, Scores: b.Scores})
+	}
+	return out
+}
+
+// Page returns one page of a board and its total, rebuilding the board's
+// index first if it is stale.
+func (m *MetricStore) Page(name string, page, limit int) ([]metricEntry, int, error) {
+	b, exists := m.boards[name]
+	if !exists {
+		return nil, 0, fmt.Errorf("%w: %q", errBoardNotFound, name)
+	}
+
+	m.mu.RLock()
+	if b.built == m.currentBuild(b) && b.sorted != nil {
+		defer m.mu.RUnlock()
+		entries, total := b.pageLocked(page, limit)
+		return entries, total, nil
+	}
+	m.mu.RUnlock()
+
+	// Snapshot the store before taking the write lock: the store never
+	// calls in here while holding its own lock, and this keeps it that way
+	members, resets, head := userStore.memberSnapshot()
+
+	want := boardBuild{resets: resets}
+	if b.usesRating {
+		want.ratings = head
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if want.version = b.version; b.built != want || b.sorted == nil {
+		m.rebuildLocked(b, members)
+		b.built = want
+	}
+	entries, total := b.pageLocked(page, limit)
+	return entries, total, nil
+}
+
+// currentBuild is the build b would need to be fresh. Only boards using
+// rating follow rating updates.
+func (m *MetricStore) currentBuild(b *metricBoard) boardBuild {
+	want := boardBuild{version: b.version, resets: userStore.changes.resetCount()}
+	if b.usesRating {
+		want.ratings = userStore.changes.head()
+	}
+	return want
+}
+
+// rebuildLocked sorts every live user with b's inputs, best first, then
+// by b's tie break, numbered in b's rank mode.
+func (m *MetricStore) rebuildLocked(b *metricBoard, members map[string]User) {
+	sorted := make([]metricEntry, 0, len(m.byID))
+	for id, values := range m.byID {
+		member, live := members[id]
+		if !live {
+			continue // Soft-deleted, or gone in a reseed
+		}
+		value, ok := b.valueOf(values, member.Rating)
+		if !ok {
+			continue
+		}
+		if b.Scores != nil {
+			value = b.Scores.Round(value)
+		}
+		tie := member.tieKey()
+		tie.ReachedAt = b.reachedAt(m.setAt[id], member.ReachedAt())
+		sorted = append(sorted, metricEntry{tie: tie, value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].value != sorted[j].value {
+			if b.Direction == rankAscending {
+				return sorted[i].value < sorted[j].value
+			}
+			return sorted[i].value > sorted[j].value
+		}
+		return b.TieBreak.Less(sorted[i].tie, sorted[j].tie)
+	})
+	prevRank := 0
+	for i := range sorted {
+		tied := i > 0 && sorted[i].value == sorted[i-1].value
+		sorted[i].rank = b.RankMode.Next(prevRank, i, tied)
+		prevRank = sorted[i].rank
+	}
+	b.sorted = sorted
+}
+
+// valueOf is a user's value on b; ok is false when an input is missing.
+func (b *metricBoard) valueOf(values map[string]float64, rating int) (float64, bool) {
+	if b.Weights == nil {
+		v, ok := values[b.inputs[0]]
+		return v, ok
+	}
+	total := 0.0
+	for metric, weight := range b.Weights {
+		if metric == ratingMetric {
+			total += weight * scores.Float(rating)
+			continue
+		}
+		v, ok := values[metric]
+		if !ok {
+			return 0, false
+		}
+		total += weight * v
+	}
+	return total, true
+}
+
+// reachedAt is when a user's value on b was last set: the latest write to
+// any input, rating included.
+func (b *metricBoard) reachedAt(setAt map[string]int64, ratingAt int64) int64 {
+	var latest int64
+	if b.usesRating {
+		latest = ratingAt
+	}
+	for _, in := range b.inputs {
+		if setAt[in] > latest {
+			latest = setAt[in]
+		}
+	}
+	return latest
+}
+
+func (b *metricBoard) pageLocked(page, limit int) ([]metricEntry, int) {
+	total := len(b.sorted)
+	start := (page - 1) * limit
+	if start >= total {
+		return []metricEntry{}, total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return append([]metricEntry(nil), b.sorted[start:end]...), total
+}
+
+// memberSnapshot copies every live user with the change-log positions the
+// copy corresponds to.
+func (s *UserStore) memberSnapshot() (members map[string]User, resets, head uint64) {
+	s.RLock()
+	defer s.RUnlock()
+	members = make(map[string]User, s.LenLocked())
+	s.EachLocked(func(u *User) {
+		members[u.ID] = *u
+	})
+	return members, s.changes.resetCount(), s.changes.head()
+}
+
+// boardRows renders entries with their users' current names and ratings.
+func boardRows(entries []metricEntry) []map[string]interface{} {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.tie.ID
+	}
+	users := userStore.usersForIDs(ids)
+	rows := make([]map[string]interface{}, 0, len(entries))
+	for i, e := range entries {
+		u, ok := users[i]
+		if !ok {
+			continue // Deleted since the rebuild
+		}
+		rows = append(rows, map[string]interface{}{
+			"rank":     e.rank,
+			"id":       u.ID,
+			"username": u.Username,
+			"rating":   scores.Number(u.Rating),
+			"value":    e.value,
+			"metrics":  metrics.Get(u.ID),
+		})
+	}
+	return rows
+}
+
+// usersForIDs looks up users by ID, keyed by their position in ids.
+func (s *UserStore) usersForIDs(ids []string) map[int]User {
+	s.RLock()
+	defer s.RUnlock()
+	out := make(map[int]User, len(ids))
+	for i, id := range ids {
+		if u, exists := s.GetLocked(id); exists {
+			out[i] = *u
+		}
+	}
+	return out
+}
+
+// boardRequest serves GET /leaderboards and GET /leaderboards/{name}.
+func boardRequest(r *http.Request) (data interface{}, pagination *models.Pagination, err error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/leaderboards"), "/")
+	if name == "" {
+		return map[string]interface{}{"metrics": metrics.defList(), "boards": metrics.Boards()}, nil, nil
+	}
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, total, err := metrics.Page(name, page, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return boardRows(entries), models.NewPagination(page, limit, total), nil
+}
+
+func (m *MetricStore) defList() []metricDef {
+	out := make([]metricDef, 0, len(m.names))
+	for _, name := range m.names {
+		out = append(out, m.defs[name])
+	}
+	return out
+}
+
+func boardsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	data, pagination, err := boardRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	response := map[string]interface{}{"success": true, "timestamp": time.Now().Unix()}
+	if pagination == nil {
+		response["result"] = data
+	} else {
+		response["board"] = strings.Trim(strings.TrimPrefix(r.URL.Path, "/leaderboards"), "/")
+		response["entries"] = data
+		response["page"] = pagination.Page
+		response["limit"] = pagination.Limit
+		response["total"] = pagination.Total
+		response["totalPages"] = pagination.TotalPages
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func v1BoardsHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+	data, pagination, err := boardRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: data, Pagination: pagination}, nil
+}
+
+// userMetricsRequest serves GET, PUT, PATCH and DELETE /users/{id}/metrics.
+func userMetricsRequest(r *http.Request) (map[string]float64, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/users/")
+	id := strings.TrimSuffix(rest, "/metrics")
+	if id == rest || id == "" || strings.Contains(id, "/") {
+		return nil, errRouteNotFound
+	}
+	if _, err := userStore.userByID(id); err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return metrics.Get(id), nil
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if replica != nil {
+			return nil, errReadOnlyReplica
+		}
+	default:
+		return nil, fmt.Errorf("%w: use GET, PUT, PATCH or DELETE", errMethodNotAllowed)
+	}
+
+	if r.Method == http.MethodDelete {
+		metrics.Delete(id)
+		return map[string]float64{}, nil
+	}
+	var values map[string]*float64
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil || values == nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object of metric values"}
+	}
+	return metrics.Update(id, values, r.Method == http.MethodPatch)
+}
+
+func userMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	values, err := userMetricsRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"metrics": values,
+	})
+}
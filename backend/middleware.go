@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// Every route is registered through handle, which wraps it in its group's
+// middleware chain, outermost first:
+//
+//	recovery    a panicking handler gets a 500 instead of a dropped connection
+//	request-id  X-Request-ID from the client (up to 64 safe bytes) or a new one
+//	logging     one access log line per request (MATIKS_ACCESS_LOG=false mutes it)
+//	local-only  the dangerous routes below answer only loopback and allowlisted peers
+//	cors        CORS headers; OPTIONS preflights stop here
+//	rate-limit  the per-client limiter (see ratelimit.go)
+//	auth        Authorization: Bearer MATIKS_ADMIN_TOKEN, when that is set
+//	metrics     activity, dashboard request counts and latencies
+//	projection  ?project= (see projection.go)
+//	repl-auth   the replication token, or a local peer without one (see replication.go)
+//
+// Routes fall in groups by path: admin (/admin/..., /v1/admin/..., /metrics
+// and /debug/pprof/..., see server.go), probe (health and readiness checks),
+// replication (the follower feed, without CORS or limits so streams stay
+// open), internal (the websocket, which never had them either) and public
+// (everything else).
+// MATIKS_MIDDLEWARE_<GROUP> replaces a group's chain with a list of the
+// names above, e.g. MATIKS_MIDDLEWARE_PUBLIC=recovery,request-id,cors.
+//
+// The dangerous routes are /update, /force-sort, seeding and restoring a
+// deleted user, under /v1/ too. local-only refuses them with 403 unless the
+// connection comes from a loopback address, the Unix socket or a network
+// in MATIKS_LOCAL_ONLY_ALLOW (addresses or CIDRs, e.g. "10.0.0.0/8"),
+// whatever the admin token says. It looks at the peer address, never at
+// forwarding headers, so behind a proxy on the same host every request
+// passes and the proxy has to do the filtering.
+
+var errUnauthorized = errors.New("missing or wrong admin token")
+
+type middleware func(next http.HandlerFunc) http.HandlerFunc
+
+// middlewareChain applies its middlewares first to last, outermost first.
+type middlewareChain []middleware
+
+func (c middlewareChain) then(h http.HandlerFunc) http.HandlerFunc {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+var middlewares = map[string]middleware{
+	"recovery":   recoveryMiddleware,
+	"request-id": requestIDMiddleware,
+	"logging":    loggingMiddleware,
+	"local-only": localOnlyMiddleware,
+	"cors":       corsMiddleware,
+	"rate-limit": rateLimitMiddleware,
+	"auth":       authMiddleware,
+	"metrics":    metricsMiddleware,
+	"projection": projectionMiddleware,
+	"repl-auth":  replicationAuthMiddleware,
+}
+
+var routeGroupDefaults = map[string]string{
+	"public":      "recovery,request-id,logging,local-only,cors,rate-limit,metrics,projection",
+	"admin":       "recovery,request-id,logging,local-only,cors,rate-limit,auth,metrics,projection",
+	"probe":       "recovery,request-id,cors,metrics,projection",
+	"internal":    "recovery,request-id,logging",
+	"replication": "recovery,request-id,logging,repl-auth",
+}
+
+var routeChains = loadRouteChains()
+
+func loadRouteChains() map[string]middlewareChain {
+	chains := make(map[string]middlewareChain, len(routeGroupDefaults))
+	for group, fallback := range routeGroupDefaults {
+		names := envListDefault("MATIKS_MIDDLEWARE_"+strings.ToUpper(group), strings.Split(fallback, ",")...)
+		var chain middlewareChain
+		for _, name := range names {
+			m, ok := middlewares[name]
+			if !ok {
+				log.Printf("Middleware: ignoring unknown %q in the %s chain", name, group)
+				continue
+			}
+			chain = append(chain, m)
+		}
+		chains[group] = chain
+	}
+	return chains
+}
+
+var probePaths = map[string]bool{"/health": true, "/v1/health": true, "/readyz": true, "/v1/readyz": true}
+
+func routeGroup(path string) string {
+	switch {
+	case path == "/admin" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/v1/admin/"),
+		path == "/metrics" || strings.HasPrefix(path, "/debug/pprof/"):
+		return "admin"
+	case probePaths[path]:
+		return "probe"
+	case strings.HasPrefix(path, "/replication/"):
+		return "replication"
+	case path == "/ws":
+		return "internal"
+	default:
+		return "public"
+	}
+}
+
+// handle registers h at path behind its group's chain.
+func handle(path string, h http.HandlerFunc) {
+	http.HandleFunc(path, routeChains[routeGroup(path)].then(h))
+}
+
+// writeMiddlewareError answers in the v1 envelope under /v1/ and the
+// legacy shape elsewhere.
+func writeMiddlewareError(w http.ResponseWriter, r *http.Request, status int, apiErr *models.APIError) {
+	localizeError(w, r, apiErr)
+	if writeProblem(w, r, status, apiErr) {
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeEnvelope(w, status, models.Envelope{Error: apiErr})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   apiErr.Message,
+	})
+}
+
+func recoveryMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p) // The server's own way to abort a response
+			}
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r.Context()), p, debug.Stack())
+			writeMiddlewareError(w, r, http.StatusInternalServerError, &models.APIError{Code: "internal", Message: "Internal server error"})
+		}()
+		next(w, r)
+	}
+}
+
+type requestIDKey struct{}
+
+// requestID is the ID requestIDMiddleware gave the request, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func requestIDMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var b [8]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set("X-Request-ID", id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+// statusWriter records the status and size of a response for the access
+// log, passing flushes and hijacks through for streams and websockets.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+var accessLog = envBool("MATIKS_ACCESS_LOG", true)
+
+func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	if !accessLog {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		log.Printf("%s %s %d %dB %v id=%s", r.Method, r.URL.RequestURI(), sw.status, sw.bytes, time.Since(start), requestID(r.Context()))
+	}
+}
+
+var errNotLocal = errors.New("this route only answers local requests")
+
+var localOnlyAllow = parseLocalOnlyAllow(envList("MATIKS_LOCAL_ONLY_ALLOW"))
+
+func parseLocalOnlyAllow(entries []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				log.Printf("Middleware: ignoring MATIKS_LOCAL_ONLY_ALLOW entry %q", entry)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// dangerousRoute reports whether path is one local-only guards.
+func dangerousRoute(path string) bool {
+	path = strings.TrimPrefix(path, "/v1")
+	switch path {
+	case "/update", "/force-sort", "/admin/seed", "/admin/reseed":
+		return true
+	}
+	rest, ok := strings.CutPrefix(path, "/admin/users/")
+	return ok && strings.HasSuffix(rest, "/restore")
+}
+
+// localPeer reports whether the connection comes from this host or an
+// allowlisted network. Unix socket peers have no address.
+func localPeer(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr == "" || r.RemoteAddr == "@"
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() {
+		return true
+	}
+	for _, prefix := range localOnlyAllow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func localOnlyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dangerousRoute(r.URL.Path) && !localPeer(r) {
+			log.Printf("Middleware: refused %s %s from %s (local-only)", r.Method, r.URL.Path, r.RemoteAddr)
+			writeMiddlewareError(w, r, http.StatusForbidden, &models.APIError{Code: "forbidden", Message: errNotLocal.Error()})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Modified-Since, If-None-Match, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "Last-Modified, ETag, Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Request-ID")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter.allow(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+var adminToken = envString("MATIKS_ADMIN_TOKEN", "")
+
+func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	if adminToken == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validAdminToken(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="matiks-admin"`)
+			writeMiddlewareError(w, r, http.StatusUnauthorized, &models.APIError{Code: "unauthorized", Message: errUnauthorized.Error()})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validAdminToken reports whether r carries the admin token.
+func validAdminToken(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func metricsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		activity.record(r)
+		_, pattern := http.DefaultServeMux.Handler(r)
+		dashboard.recordRequest(pattern)
+		defer latencies.begin(pattern, r.Proto)()
+		next(w, r)
+	}
+}
+
+func projectionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveProjected(w, r, next)
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+// APIVersion is reported in every envelope's meta.
+const APIVersion = "v1"
+
+// Envelope is the single response shape for every v1 endpoint. Data holds
+// the payload, Pagination is set on paged lists with Links to the pages
+// around them, Meta carries request-level facts (timestamp, version,
+// endpoint extras) and Error replaces Data on failure.
+type Envelope struct {
+	Data       interface{}            `json:"data"`
+	Pagination *Pagination            `json:"pagination,omitempty"`
+	Links      *Links                 `json:"links,omitempty"`
+	Meta       map[string]interface{} `json:"meta"`
+	Error      *APIError              `json:"error,omitempty"`
+}
+
+type Pagination struct {
+	Page       int `json:"page"`
+	Limit      int `json:"limit"`
+	Total      int `json:"total"`
+	TotalPages int `json:"totalPages"`
+}
+
+// APIError is a machine-readable code plus a human-readable message.
+// Fields lists each offending input when the error is one of validation.
+type APIError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Param   string       `json:"param,omitempty"`
+	Details interface{}  `json:"details,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is one invalid input: the body field or query parameter, a
+// machine-readable code and a message for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details document, for clients that ask
+// for application/problem+json. Code, Param, Fields and Details repeat the
+// APIError's as extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance"`
+	Code      string       `json:"code"`
+	Param     string       `json:"param,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	Details   interface{}  `json:"details,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+}
+
+// Links are URLs for a page's neighbours; one that doesn't exist is null.
+type Links struct {
+	First string  `json:"first"`
+	Prev  *string `json:"prev"`
+	Next  *string `json:"next"`
+	Last  *string `json:"last"`
+}
+
+// UserLinks are URLs for the resources about one user.
+type UserLinks struct {
+	Self     string `json:"self"`
+	Rank     string `json:"rank"`
+	Digest   string `json:"digest"`
+	Metadata string `json:"metadata"`
+	Metrics  string `json:"metrics"`
+}
+
+// NewPagination derives TotalPages from total and limit.
+func NewPagination(page, limit, total int) *Pagination {
+	totalPages := 0
+	if limit > 0 {
+		totalPages = (total + limit - 1) / limit
+	}
+	return &Pagination{Page: page, Limit: limit, Total: total, TotalPages: totalPages}
+}
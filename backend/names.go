@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// Usernames may use any script. They are stored in NFC, so the same name
+// typed with precomposed or combining accents is one name, and compared in
+// their case-folded form (UsernameLower), which the name indexes sort on
+// and search prefixes are matched against. Search buckets are keyed by the
+// first rune of the folded name rather than its first byte, so "é…" and
+// "李…" names get buckets of their own instead of sharing one per lead
+// byte. The folding itself lives in the leaderboard package, so embedders
+// match names exactly as the server does.
+
+// normalizeUsername trims a name and puts it in NFC.
+func normalizeUsername(name string) string {
+	return leaderboard.NormalizeUsername(name)
+}
+
+// foldUsername is the case-insensitive form of a name or search query.
+func foldUsername(name string) string {
+	return leaderboard.FoldUsername(name)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// usernameWidth counts a name's characters, CJK ones twice (see
+// leaderboard.UsernameWidth).
+func usernameWidth(name string) int {
+	return leaderboard.UsernameWidth(name)
+}
+
+// usernameBucket is the search bucket for a folded name or query.
+func usernameBucket(folded string) rune {
+	r, _ := utf8.DecodeRuneInString(folded)
+	return r
+}
+
+// usernameScripts are the scripts mixed-script checks tell apart. Letters
+// outside them count as "other".
+var usernameScripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Greek", unicode.Greek},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Armenian", unicode.Armenian},
+	{"Hebrew", unicode.Hebrew},
+	{"Arabic", unicode.Arabic},
+	{"Devanagari", unicode.Devanagari},
+	{"Bengali", unicode.Bengali},
+	{"Tamil", unicode.Tamil},
+	{"Telugu", unicode.Telugu},
+	{"Thai", unicode.Thai},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+	{"Bopomofo", unicode.Bopomofo},
+}
+
+// letterScripts returns the set of scripts used by name's letters.
+// Letters shared between scripts, like the katakana-hiragana prolonged
+// sound mark, belong to none.
+func letterScripts(name string) map[string]bool {
+	scripts := make(map[string]bool)
+	for _, r := range name {
+		if !unicode.IsLetter(r) || unicode.In(r, unicode.Common, unicode.Inherited) {
+			continue
+		}
+		script := "other"
+		for _, s := range usernameScripts {
+			if unicode.Is(s.table, r) {
+				script = s.name
+				break
+			}
+		}
+		scripts[script] = true
+	}
+	return scripts
+}
+
+// mixedScript reports whether name mixes scripts in a way real names do
+// not. Following Unicode's "highly restrictive" profile, one script is
+// fine, and the only mixes allowed are Latin with the CJK combinations Han
+// with Hiragana/Katakana, Bopomofo or Hangul.
+func mixedScript(name string) bool {
+	scripts := letterScripts(name)
+	if len(scripts) <= 1 {
+		return false
+	}
+	allowed := [][]string{
+		{"Latin", "Han", "Hiragana", "Katakana"},
+		{"Latin", "Han", "Bopomofo"},
+		{"Latin", "Han", "Hangul"},
+	}
+	for _, set := range allowed {
+		covered := 0
+		for _, s := range set {
+			if scripts[s] {
+				covered++
+			}
+		}
+		if covered == len(scripts) {
+			return false
+		}
+	}
+	return true
+}
+
+// homoglyphs maps letters from other scripts to the Latin letters they are
+// drawn like.
+var homoglyphs = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i',
+	'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h',
+}
+
+// deconfuse strips accents (via NFKD, which also folds full-width and
+// other compatibility forms) and maps homoglyphs to Latin, ahead of the
+// ASCII look-alike folding in usernameSkeleton.
+func deconfuse(folded string) string {
+	if isASCII(folded) {
+		return folded
+	}
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(folded) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if latin, ok := homoglyphs[r]; ok {
+			r = latin
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
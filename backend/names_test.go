@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"unicode/utf8"
+
+	"matiks-leaderboard/leaderboard"
+	"matiks-leaderboard/utils"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii untouched", "alex_sharma5", "alex_sharma5"},
+		{"trimmed", "  alex  ", "alex"},
+		{"combining accent composed", "Jose\u0301", "Jos\u00e9"},
+		{"precomposed kept", "Jos\u00e9", "Jos\u00e9"},
+		{"hangul jamo composed", "\u1100\u1161", "\uac00"},
+		{"cjk untouched", "李小龙", "李小龙"},
+		{"emoji untouched", "🔥fire", "🔥fire"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeUsername(tt.in); got != tt.want {
+				t.Errorf("normalizeUsername(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoldUsername(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii", "Alex_Sharma", "alex_sharma"},
+		{"accented", "ÉLODIE", "élodie"},
+		{"decomposed folds like precomposed", "E\u0301LODIE", "élodie"},
+		{"german sharp s", "STRASSE", "strasse"},
+		{"greek final sigma", "ΟΔΥΣΣΕΥΣ", "οδυσσευσ"},
+		{"cyrillic", "ИВАН", "иван"},
+		{"cjk has no case", "李小龙", "李小龙"},
+		{"emoji has no case", "🔥FIRE", "🔥fire"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := foldUsername(tt.in); got != tt.want {
+				t.Errorf("foldUsername(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsernameBucket(t *testing.T) {
+	tests := []struct {
+		folded string
+		want   rune
+	}{
+		{"alex", 'a'},
+		{"élodie", 'é'},
+		{"李小龙", '李'},
+		{"김철수", '김'},
+		{"🔥fire", '🔥'},
+		{"", utf8.RuneError},
+	}
+	for _, tt := range tests {
+		if got := usernameBucket(tt.folded); got != tt.want {
+			t.Errorf("usernameBucket(%q) = %q, want %q", tt.folded, got, tt.want)
+		}
+	}
+	// Names sharing a lead byte must not share a bucket
+	if usernameBucket("李") == usernameBucket("杨") {
+		t.Error("李 and 杨 share a bucket")
+	}
+}
+
+func TestUsernameWidth(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"alex", 4},
+		{"élodie", 6},
+		{"李龙", 4},
+		{"김철수", 6},
+		{"田中さん", 8},
+		{"🔥ab", 3},
+	}
+	for _, tt := range tests {
+		if got := usernameWidth(tt.in); got != tt.want {
+			t.Errorf("usernameWidth(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCharset(t *testing.T) {
+	p := &UsernamePolicy{}
+	tests := []struct {
+		name, in, want string // want is the rejection code, "" to accept
+	}{
+		{"latin", "alex_sharma5", ""},
+		{"separators", "a.b-c_d", ""},
+		{"accents", "élodie", ""},
+		{"combining mark", "Jose\u0301", ""},
+		{"cjk", "李小龙", ""},
+		{"hangul", "김철수", ""},
+		{"devanagari", "राहुल", ""},
+		{"emoji start", "🔥fire", ""},
+		{"emoji zwj sequence", "👨‍👩‍👧fam", ""},
+		{"skin tone modifier", "👍🏽ok", ""},
+		{"space", "alex sharma", "bad_character"},
+		{"punctuation", "alex!", "bad_character"},
+		{"stray zwj", "ab\u200dcd", "bad_character"},
+		{"separator start", "_alex", "bad_start"},
+		{"mark start", "\u0301alex", "bad_start"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if r := p.checkCharset(&usernameCheck{Username: normalizeUsername(tt.in)}); r != nil {
+				got = r.Code
+			}
+			if got != tt.want {
+				t.Errorf("checkCharset(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMixedScript(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     bool
+	}{
+		{"latin", "alex", false},
+		{"cyrillic", "иван", false},
+		{"han", "李小龙", false},
+		{"han and hiragana", "田中さん", false},
+		{"han, katakana and latin", "東京タワーtokyo", false},
+		{"hangul and latin", "김kim", false},
+		{"han and bopomofo", "李ㄅ", false},
+		{"digits and emoji don't count", "иван42🔥", false},
+		{"cyrillic a in latin", "аdmin", true},
+		{"greek and latin", "αlex", true},
+		{"hangul and hiragana", "김さん", true},
+		{"cyrillic and han", "иван李", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mixedScript(tt.in); got != tt.want {
+				t.Errorf("mixedScript(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMultiByteNames(t *testing.T) {
+	store := NewUserStore()
+	var seeded []utils.SeedUser
+	for i, name := range []string{
+		"李小龙", "李娜", "杨过", "김철수", "김민지", "Élodie", "élise", "ÉLAN",
+		"Jose\u0301", "🔥fire", "🔥flame", "alex",
+	} {
+		seeded = append(seeded, utils.SeedUser{ID: "u" + string(rune('a'+i)), Username: name, Rating: 1000 + i})
+	}
+	store.Lock()
+	store.loadUsersLocked(seeded)
+	store.Unlock()
+
+	tests := []struct {
+		query string
+		want  []string // In username order
+	}{
+		{"李", []string{"李娜", "李小龙"}},
+		{"李小", []string{"李小龙"}},
+		{"김", []string{"김민지", "김철수"}},
+		{"él", []string{"ÉLAN", "élise", "Élodie"}},
+		{"EL", nil}, // Accents are not stripped
+		{"ÉLO", []string{"Élodie"}},
+		{"jos\u00e9", []string{"Jos\u00e9"}},
+		{"jose\u0301", []string{"Jos\u00e9"}},
+		{"🔥f", []string{"🔥fire", "🔥flame"}},
+		{"🔥fl", []string{"🔥flame"}},
+		{"杨", []string{"杨过"}},
+	}
+	for _, algo := range []searchStrategy{leaderboard.SearchBucket, leaderboard.SearchTrie} {
+		for _, tt := range tests {
+			users, total, _, err := store.SearchUsers(context.Background(), algo, tt.query, 1, 10)
+			if err != nil {
+				t.Fatalf("%s %q: %v", algo.Name(), tt.query, err)
+			}
+			var got []string
+			for _, u := range users {
+				got = append(got, u.Username)
+			}
+			if total != len(tt.want) || len(got) != len(tt.want) {
+				t.Errorf("%s %q = %q (total %d), want %q", algo.Name(), tt.query, got, total, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s %q = %q, want %q", algo.Name(), tt.query, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nats-io/nats.go"
+)
+
+// instanceID tags messages so a replica ignores its own broadcasts.
+var instanceID = envString("MATIKS_INSTANCE_ID", defaultInstanceID())
+
+func defaultInstanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// natsEnvelope is the wire format on NATS subjects.
+type natsEnvelope struct {
+	Origin string          `json:"origin"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// startNATS publishes rank and top-10 events to <prefix>.<type>. With
+// subscribe set, events from other replicas are relayed to this instance's
+// websocket clients, so a client sees updates whichever replica it hit.
+func startNATS(url, prefix string, subscribe bool) error {
+	nc, err := nats.Connect(url,
+		nats.Name("matiks-"+instanceID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Printf("NATS: disconnected: %v", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Printf("NATS: reconnected to %s", nc.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	addReadinessCheck("nats", nc.FlushWithContext)
+
+	events := userStore.events.Subscribe(1024)
+	go func() {
+		for ev := range events {
+			if ev.Type != EventRanksChanged && ev.Type != EventTop10Changed {
+				continue
+			}
+			raw, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			data, _ := json.Marshal(natsEnvelope{Origin: instanceID, Event: raw})
+			if err := nc.Publish(prefix+"."+ev.Type, data); err != nil {
+				log.Printf("NATS: publish %s: %v", ev.Type, err)
+			}
+		}
+	}()
+
+	if subscribe {
+		_, err := nc.Subscribe(prefix+".*", func(msg *nats.Msg) {
+			var env natsEnvelope
+			if err := json.Unmarshal(msg.Data, &env); err != nil || env.Origin == instanceID {
+				return
+			}
+			var ev struct {
+				Event
+				Payload json.RawMessage `json:"payload"`
+			}
+			if err := json.Unmarshal(env.Event, &ev); err != nil {
+				return
+			}
+			// Typed payloads so filtered websocket clients can trim them
+			switch ev.Type {
+			case EventRanksChanged:
+				var p RanksChangedPayload
+				if json.Unmarshal(ev.Payload, &p) != nil {
+					return
+				}
+				ev.Event.Payload = p
+			case EventTop10Changed:
+				var p Top10ChangedPayload
+				if json.Unmarshal(ev.Payload, &p) != nil {
+					return
+				}
+				ev.Event.Payload = p
+			default:
+				ev.Event.Payload = ev.Payload
+			}
+			wsHub.broadcast(ev.Event, true)
+		})
+		if err != nil {
+			nc.Close()
+			return err
+		}
+	}
+
+	log.Printf("NATS: connected to %s (subjects %s.*, subscribe=%v)", url, prefix, subscribe)
+	return nil
+}
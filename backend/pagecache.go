@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/redis/go-redis/v9"
+	"matiks-leaderboard/leaderboard"
+)
+
+// Leaderboard pages are cached behind pageCache. MATIKS_PAGE_CACHE picks
+// the backend:
+//
+//	memory  per instance, entries live for the store's cache TTL (default)
+//	redis   shared through MATIKS_REDIS_ADDR by every instance, and kept
+//	        across restarts, so a new or restarted replica starts warm
+//
+// Both stamp each page with the cache generation it was computed in, and
+// clearing the cache starts a new one, so a page computed before a change
+// but stored after it is never served. In Redis the generation is a shared
+// counter (MATIKS_PAGE_CACHE_PREFIX + "gen"): a clear on any instance
+// retires every instance's pages, and the invalidation channel is not
+// needed to drop them. Pages expire after MATIKS_PAGE_CACHE_TTL (default
+// 5m). Clears run under the store's lock, so the counter is bumped in the
+// background, and until it is the instance bypasses Redis rather than
+// risk reading its own stale pages. Redis calls go through the breaker and
+// give up after MATIKS_PAGE_CACHE_TIMEOUT (default 100ms); a failed lookup
+// is a miss and the page is built from memory.
+//
+// The ranking engine serves and clears the pages itself (see
+// leaderboard/pagecache.go), through storePages, which adds the cluster
+// version checks (see coherence.go).
+
+type pageCache interface {
+	// Clear may be called under the store's lock and must not block on
+	// the network.
+	leaderboard.PageCache[User]
+	Name() string
+	// Shared reports whether other instances see the same pages.
+	Shared() bool
+	// Len is the number of pages held, or -1 if the backend can't tell.
+	Len() int
+	// TTL is how long a page may be served after it was built.
+	TTL() time.Duration
+}
+
+// cacheEntry's Version is the cluster version it was built at.
+type cacheEntry = leaderboard.CachedPage[User]
+
+// storePages is the cache the ranking engine sees: the current backend,
+// with pages from before the cluster version turned away, and none kept
+// while the version can't be trusted or a replica is catching up.
+type storePages struct{ s *UserStore }
+
+func (p storePages) Get(ctx context.Context, key string) (cacheEntry, cacheOutcome) {
+	version, trusted := clusterVersion.current()
+	entry, outcome := p.s.pages.Get(ctx, key)
+	if outcome == cacheHit {
+		if clusterVersion.admit(entry.Version, version, trusted) {
+			return entry, cacheHit
+		}
+		outcome = cacheExpired
+	}
+	entry = cacheEntry{Generation: entry.Generation, Version: version}
+	if !trusted {
+		entry.Generation = -1
+	}
+	return entry, outcome
+}
+
+func (p storePages) Set(ctx context.Context, key string, entry cacheEntry) {
+	if entry.Generation < 0 || (p.s.pages.Shared() && replicaBehind()) {
+		return
+	}
+	p.s.pages.Set(ctx, key, entry)
+}
+
+func (p storePages) Clear() { p.s.clearCache() }
+
+// newPageCache builds the MATIKS_PAGE_CACHE backend; rdb is nil without
+// Redis.
+func newPageCache(rdb *redis.Client, ttl time.Duration) pageCache {
+	switch backend := envString("MATIKS_PAGE_CACHE", "memory"); backend {
+	case "memory":
+	case "redis":
+		if rdb == nil {
+			log.Printf("Page cache: redis needs MATIKS_REDIS_ADDR, using memory")
+			break
+		}
+		c := &redisPageCache{
+			rdb:     rdb,
+			prefix:  envString("MATIKS_PAGE_CACHE_PREFIX", "matiks:pages:"),
+			ttl:     envDuration("MATIKS_PAGE_CACHE_TTL", 5*time.Minute),
+			timeout: envDuration("MATIKS_PAGE_CACHE_TIMEOUT", 100*time.Millisecond),
+			wake:    make(chan struct{}, 1),
+		}
+		go c.flushClears()
+		log.Printf("Page cache: redis, prefix %q, TTL %v", c.prefix, c.ttl)
+		return c
+	default:
+		log.Printf("Page cache: unknown MATIKS_PAGE_CACHE %q, using memory", backend)
+	}
+	return newMemoryPageCache(ttl)
+}
+
+type memoryPageCache struct {
+	*leaderboard.MemoryPageCache[User]
+}
+
+func newMemoryPageCache(ttl time.Duration) *memoryPageCache {
+	return &memoryPageCache{leaderboard.NewMemoryPageCache[User](ttl)}
+}
+
+func (c *memoryPageCache) Name() string { return "memory" }
+
+func (c *memoryPageCache) Shared() bool { return false }
+
+// footprint estimates the bytes held by cached pages: the User structs
+// plus their string data.
+func (c *memoryPageCache) footprint() (entries, users int, bytes int64) {
+	userSize := int64(unsafe.Sizeof(User{}))
+	c.Each(func(key string, entry cacheEntry) {
+		bytes += int64(len(key)) + int64(unsafe.Sizeof(entry))
+		for i := range entry.Entrants {
+			u := &entry.Entrants[i]
+			bytes += userSize + int64(len(u.ID)+len(u.Username)+len(u.UsernameLower))
+		}
+		entries++
+		users += len(entry.Entrants)
+	})
+	return entries, users, bytes
+}
+
+type redisPageCache struct {
+	rdb     *redis.Client
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+
+	cleared int64 // Atomic: Clear calls so far
+	flushed int64 // Atomic: how many of them the shared counter has seen
+	wake    chan struct{}
+}
+
+// redisPage is a cached page as stored in Redis.
+type redisPage struct {
+	Generation int64  `json:"g"`
+	Version    int64  `json:"v"`
+	Total      int    `json:"t"`
+	At         int64  `json:"at"` // UnixNano
+	Users      []User `json:"u"`
+}
+
+func (c *redisPageCache) Name() string { return "redis" }
+
+func (c *redisPageCache) Shared() bool { return true }
+
+func (c *redisPageCache) TTL() time.Duration { return c.ttl }
+
+func (c *redisPageCache) genKey() string { return c.prefix + "gen" }
+
+// current reports whether every local Clear has reached Redis.
+func (c *redisPageCache) current() bool {
+	return atomic.LoadInt64(&c.flushed) == atomic.LoadInt64(&c.cleared)
+}
+
+func (c *redisPageCache) Get(ctx context.Context, key string) (cacheEntry, cacheOutcome) {
+	if !c.current() {
+		return cacheEntry{Generation: -1}, cacheMiss
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var vals []interface{}
+	err := redisBreaker.Do(func() error {
+		var err error
+		vals, err = c.rdb.MGet(ctx, c.genKey(), c.prefix+key).Result()
+		return err
+	})
+	if err != nil {
+		return cacheEntry{Generation: -1}, cacheMiss
+	}
+	var generation int64
+	if raw, ok := vals[0].(string); ok {
+		json.Unmarshal([]byte(raw), &generation)
+	}
+	raw, ok := vals[1].(string)
+	if !ok {
+		return cacheEntry{Generation: generation}, cacheMiss
+	}
+	var page redisPage
+	if err := json.Unmarshal([]byte(raw), &page); err != nil || page.Generation != generation {
+		return cacheEntry{Generation: generation}, cacheExpired
+	}
+	for i := range page.Users {
+		page.Users[i].UsernameLower = foldUsername(page.Users[i].Username)
+	}
+	return cacheEntry{Entrants: page.Users, Total: page.Total, Built: time.Unix(0, page.At), Generation: generation, Version: page.Version}, cacheHit
+}
+
+func (c *redisPageCache) Set(ctx context.Context, key string, entry cacheEntry) {
+	if entry.Generation < 0 || !c.current() {
+		return
+	}
+	raw, err := json.Marshal(redisPage{Generation: entry.Generation, Version: entry.Version, Total: entry.Total, At: entry.Built.UnixNano(), Users: entry.Entrants})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	redisBreaker.Do(func() error { return c.rdb.Set(ctx, c.prefix+key, raw, c.ttl).Err() })
+}
+
+func (c *redisPageCache) Clear() {
+	atomic.AddInt64(&c.cleared, 1)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flushClears bumps the shared generation for local clears, retrying
+// while Redis is unreachable.
+func (c *redisPageCache) flushClears() {
+	for range c.wake {
+		for !c.current() {
+			target := atomic.LoadInt64(&c.cleared)
+			ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+			err := redisBreaker.Do(func() error { return c.rdb.Incr(ctx, c.genKey()).Err() })
+			cancel()
+			if err != nil {
+				time.Sleep(time.Second)
+				continue
+			}
+			atomic.StoreInt64(&c.flushed, target)
+		}
+	}
+}
+
+func (c *redisPageCache) Len() int { return -1 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// Request limits, echoed back in 400 responses so clients can correct
+// themselves without reading docs.
+type requestLimits struct {
+	DefaultLimit   int `json:"defaultLimit"`
+	MaxLimit       int `json:"maxLimit"`
+	MaxPage        int `json:"maxPage"`
+	MaxUpdateCount int `json:"maxUpdateCount"`
+	MaxQueryLength int `json:"maxQueryLength"`
+	MaxSeedCount   int `json:"maxSeedCount"`
+}
+
+var limits = requestLimits{
+	DefaultLimit:   envInt("MATIKS_DEFAULT_PAGE_LIMIT", 45),
+	MaxLimit:       envInt("MATIKS_MAX_PAGE_LIMIT", 100),
+	MaxPage:        envInt("MATIKS_MAX_PAGE", 1_000_000),
+	MaxUpdateCount: envInt("MATIKS_MAX_UPDATE_COUNT", 10_000),
+	MaxQueryLength: envInt("MATIKS_MAX_QUERY_LENGTH", 64),
+	MaxSeedCount:   envInt("MATIKS_MAX_SEED_COUNT", 5_000_000),
+}
+
+// paramError describes one rejected query parameter.
+type paramError struct {
+	Param   string
+	Message string
+}
+
+func (e *paramError) Error() string {
+	return e.Param + ": " + e.Message
+}
+
+// intParam reads an integer parameter. Absent means def; anything present
+// must parse and fall within [min, max].
+func intParam(r *http.Request, name string, def, min, max int) (int, error) {
+	raw, present := r.URL.Query()[name]
+	if !present {
+		return def, nil
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(raw[0]))
+	if err != nil {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("must be an integer, got %q", raw[0])}
+	}
+	if v < min || v > max {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("must be between %d and %d, got %d", min, max, v)}
+	}
+	return v, nil
+}
+
+// boolParam reads a boolean parameter: absent means def, and present must
+// be 1, 0, true or false.
+func boolParam(r *http.Request, name string, def bool) (bool, error) {
+	raw, present := r.URL.Query()[name]
+	if !present {
+		return def, nil
+	}
+	switch strings.TrimSpace(raw[0]) {
+	case "1", "true":
+		return true, nil
+	case "0", "false":
+		return false, nil
+	}
+	return false, &paramError{Param: name, Message: fmt.Sprintf("must be 1, 0, true or false, got %q", raw[0])}
+}
+
+// timeParam reads a time given as Unix seconds or RFC 3339; ok is false
+// when absent.
+func timeParam(r *http.Request, name string) (t time.Time, ok bool, err error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(name))
+	if raw == "" {
+		return time.Time{}, false, nil
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0), true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, &paramError{Param: name, Message: fmt.Sprintf("must be Unix seconds or an RFC 3339 time, got %q", raw)}
+}
+
+// parsePagination validates page and limit.
+func parsePagination(r *http.Request) (page, limit int, err error) {
+	if page, err = intParam(r, "page", 1, 1, limits.MaxPage); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = intParam(r, "limit", limits.DefaultLimit, 1, limits.MaxLimit); err != nil {
+		return 0, 0, err
+	}
+	return page, limit, nil
+}
+
+// ratingBandParams reads minRating and maxRating. Either may be omitted;
+// with neither the band is allRatings.
+func ratingBandParams(r *http.Request) (ratingBand, error) {
+	q := r.URL.Query()
+	_, hasMin := q["minRating"]
+	_, hasMax := q["maxRating"]
+	if !hasMin && !hasMax {
+		return allRatings, nil
+	}
+	band := ratingBand{Min: scores.Min, Max: scores.Max}
+	if hasMin {
+		v, err := scoreParam(r, "minRating")
+		if err != nil {
+			return band, err
+		}
+		band.Min = v
+	}
+	if hasMax {
+		v, err := scoreParam(r, "maxRating")
+		if err != nil {
+			return band, err
+		}
+		band.Max = v
+	}
+	if band.Min > band.Max {
+		return band, &paramError{Param: "minRating", Message: fmt.Sprintf("must not exceed maxRating (%s > %s)",
+			scores.Number(band.Min), scores.Number(band.Max))}
+	}
+	return band, nil
+}
+
+// scoreParam reads a score in the configured type and bounds.
+func scoreParam(r *http.Request, name string) (int, error) {
+	raw := r.URL.Query().Get(name)
+	v, err := scores.Parse(raw)
+	if err != nil {
+		return 0, &paramError{Param: name, Message: err.Error()}
+	}
+	if v < scores.Min || v > scores.Max {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("must be between %s and %s, got %s",
+			scores.Number(scores.Min), scores.Number(scores.Max), raw)}
+	}
+	return v, nil
+}
+
+// userRefParams reads the user to look up: id= or username=, not both.
+func userRefParams(r *http.Request) (id, username string, err error) {
+	q := r.URL.Query()
+	id, username = strings.TrimSpace(q.Get("id")), strings.TrimSpace(q.Get("username"))
+	switch {
+	case id == "" && username == "":
+		return "", "", &paramError{Param: "username", Message: "id or username is required"}
+	case id != "" && username != "":
+		return "", "", &paramError{Param: "id", Message: "pass either id or username, not both"}
+	}
+	return id, username, nil
+}
+
+// writeParamError answers 400 with the offending parameter and the limits.
+func writeParamError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := &models.APIError{Code: "invalid_parameter", Message: err.Error(), Fields: fieldErrors(err)}
+	if pe, ok := err.(*paramError); ok {
+		apiErr.Param = pe.Param
+	}
+	apiErr.Details = limits
+	localizeError(w, r, apiErr)
+	if writeProblem(w, r, http.StatusBadRequest, apiErr) {
+		return
+	}
+	body := map[string]interface{}{
+		"success": false,
+		"error":   apiErr.Message,
+		"limits":  limits,
+	}
+	if apiErr.Param != "" {
+		body["param"] = apiErr.Param
+	}
+	if apiErr.Fields != nil {
+		body["fields"] = apiErr.Fields
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// percentileMode selects how tied users count toward a percentile.
+type percentileMode string
+
+const (
+	// percentileBelow: share of users rated strictly below. Ties don't help.
+	percentileBelow percentileMode = "below"
+	// percentileMidpoint: ties count as half below (standard percentile rank).
+	percentileMidpoint percentileMode = "midpoint"
+	// percentileAtOrBelow: ties count as below, so the top user reaches 100.
+	percentileAtOrBelow percentileMode = "atOrBelow"
+)
+
+var defaultPercentileMode = percentileMode(envString("MATIKS_PERCENTILE_MODE", string(percentileBelow)))
+
+func (m percentileMode) valid() bool {
+	switch m {
+	case percentileBelow, percentileMidpoint, percentileAtOrBelow:
+		return true
+	}
+	return false
+}
+
+// percentile returns the user's percentile in [0, 100] from rating counts.
+func (m percentileMode) percentile(below, equal, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	share := float64(below)
+	switch m {
+	case percentileMidpoint:
+		share += float64(equal) / 2
+	case percentileAtOrBelow:
+		share += float64(equal)
+	}
+	return share / float64(total) * 100
+}
+
+// percentileParam reads ?percentile=, falling back to the configured mode.
+func percentileParam(r *http.Request) (percentileMode, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("percentile"))
+	if raw == "" {
+		return defaultPercentileMode, nil
+	}
+	if m := percentileMode(raw); m.valid() {
+		return m, nil
+	}
+	return "", &paramError{Param: "percentile", Message: fmt.Sprintf(
+		"must be one of %s, %s, %s; got %q", percentileBelow, percentileMidpoint, percentileAtOrBelow, raw)}
+}
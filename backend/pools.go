@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Pools for per-request scratch memory. Polling clients hit /leaderboard and
+// /search every second or two, so these allocations add up to most of the
+// GC work under load.
+
+// maxPooledBuffer keeps one huge response from pinning memory in the pool.
+const maxPooledBuffer = 1 << 20
+
+var responseBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 8*1024)
+		return &b
+	},
+}
+
+// writePooledJSON encodes via build into a pooled buffer and writes it.
+func writePooledJSON(w http.ResponseWriter, build func([]byte) []byte) {
+	bp := responseBufPool.Get().(*[]byte)
+	buf := build((*bp)[:0])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+
+	if cap(buf) <= maxPooledBuffer {
+		*bp = buf
+		responseBufPool.Put(bp)
+	}
+}
+
+// searchScratchPool holds the match collection slice in SearchUsers, sized
+// for the 1000-result cap. Only the requested page is copied out, so the
+// scratch slice never escapes the call.
+var searchScratchPool = sync.Pool{
+	New: func() interface{} {
+		s := make([]User, 0, searchResultLimit)
+		return &s
+	},
+}
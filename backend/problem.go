@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"matiks-leaderboard/models"
+)
+
+// A client whose Accept header asks for application/problem+json, at
+// least as strongly as for application/json, gets errors as RFC 7807
+// problem details rather than the v1 envelope or the unversioned body:
+//
+//	HTTP/1.1 404 Not Found
+//	Content-Type: application/problem+json
+//
+//	{"type": "urn:matiks:error:user_not_found", "title": "Not Found",
+//	 "status": 404, "detail": "User not found", "instance": "/v1/users/u1",
+//	 "code": "user_not_found", "requestId": "9f2c..."}
+//
+// type is MATIKS_PROBLEM_TYPE_BASE (default "urn:matiks:error:") followed
+// by the error code, so a deployment with error docs can point it at
+// them. detail is the message, translated like any other (see i18n.go),
+// and instance the path and query asked for. param, fields and details
+// come along as extension members. Successful responses are unchanged.
+
+var problemTypeBase = envString("MATIKS_PROBLEM_TYPE_BASE", "urn:matiks:error:")
+
+// wantsProblem reports whether r's Accept header ranks problem+json at or
+// above plain JSON. Wildcards don't count: the client must name it.
+func wantsProblem(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if !strings.Contains(accept, "problem+json") {
+		return false
+	}
+	problemQ, jsonQ := -1.0, -1.0
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(entry)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/problem+json":
+			problemQ = max(problemQ, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return problemQ > 0 && problemQ >= jsonQ
+}
+
+// writeProblem answers with apiErr as problem details if r asked for them,
+// and reports whether it did.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, apiErr *models.APIError) bool {
+	if !wantsProblem(r) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.Problem{
+		Type:      problemTypeBase + apiErr.Code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    apiErr.Message,
+		Instance:  requestURL(r).RequestURI(),
+		Code:      apiErr.Code,
+		Param:     apiErr.Param,
+		Fields:    apiErr.Fields,
+		Details:   apiErr.Details,
+		RequestID: requestID(r.Context()),
+	})
+	return true
+}
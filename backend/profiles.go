@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Optional profile fields live in their own store rather than on User,
+// which every sort and cached page copies; a profile is only read when a
+// single user is looked up. Profiles are held in memory on the instance
+// that accepted the PATCH and are not replicated, so replicas refuse
+// writes.
+
+const (
+	maxDisplayNameLen = 50 // Runes
+	maxAvatarURLLen   = 512
+	maxBioLen         = 280 // Runes
+	maxProfileBody    = 8 << 10
+)
+
+// UserProfile is a user's optional profile. Empty fields are unset.
+type UserProfile struct {
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+	Country     string `json:"country,omitempty"` // ISO 3166-1 alpha-2
+	Bio         string `json:"bio,omitempty"`
+	UpdatedAt   int64  `json:"updatedAt,omitempty"`
+}
+
+// ProfileStore maps user IDs to profiles under its own lock.
+type ProfileStore struct {
+	mu   sync.RWMutex
+	byID map[string]UserProfile
+}
+
+var profiles = &ProfileStore{byID: make(map[string]UserProfile)}
+
+func (p *ProfileStore) Get(userID string) UserProfile {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.byID[userID]
+}
+
+func (p *ProfileStore) Country(userID string) string {
+	return p.Get(userID).Country
+}
+
+func (p *ProfileStore) Delete(userID string) {
+	p.mu.Lock()
+	delete(p.byID, userID)
+	p.mu.Unlock()
+}
+
+var profileFields = map[string]bool{"displayName": true, "avatarUrl": true, "country": true, "bio": true}
+
+// profilePatch holds the fields present in a PATCH body; a nil value
+// clears the field.
+type profilePatch map[string]*string
+
+// Apply merges patch into a user's profile and returns the result.
+func (p *ProfileStore) Apply(userID string, patch profilePatch) UserProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	profile := p.byID[userID]
+	for field, value := range patch {
+		v := ""
+		if value != nil {
+			v = *value
+		}
+		switch field {
+		case "displayName":
+			profile.DisplayName = v
+		case "avatarUrl":
+			profile.AvatarURL = v
+		case "country":
+			profile.Country = v
+		case "bio":
+			profile.Bio = v
+		}
+	}
+	profile.UpdatedAt = time.Now().Unix()
+	if profile == (UserProfile{UpdatedAt: profile.UpdatedAt}) {
+		delete(p.byID, userID)
+		return UserProfile{}
+	}
+	p.byID[userID] = profile
+	return profile
+}
+
+// decodeProfilePatch reads a merge-patch style body: fields that are
+// present are set (or cleared by null or ""), absent fields are untouched.
+func decodeProfilePatch(r *http.Request) (profilePatch, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxProfileBody)).Decode(&raw); err != nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+	}
+	patch := make(profilePatch, len(raw))
+	fields := make([]string, 0, len(raw))
+	for field := range raw {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields) // Report problems in a stable order
+	invalid := &validationError{}
+	for _, field := range fields {
+		value := raw[field]
+		if !profileFields[field] {
+			invalid.add(&paramError{Param: field, Message: "is not a profile field (displayName, avatarUrl, country, bio)"})
+			continue
+		}
+		if string(value) == "null" {
+			patch[field] = nil
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			invalid.add(&paramError{Param: field, Message: "must be a string or null"})
+			continue
+		}
+		s, err := normalizeProfileField(field, s)
+		if err != nil {
+			invalid.add(err)
+			continue
+		}
+		if s == "" {
+			patch[field] = nil
+		} else {
+			patch[field] = &s
+		}
+	}
+	if err := invalid.orNil(); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// normalizeProfileField trims and validates one field value.
+func normalizeProfileField(field, v string) (string, error) {
+	v = strings.TrimSpace(v)
+	invalid := func(format string, args ...interface{}) (string, error) {
+		return "", &paramError{Param: field, Message: fmt.Sprintf(format, args...)}
+	}
+	if v == "" {
+		return "", nil
+	}
+	switch field {
+	case "displayName":
+		if n := utf8.RuneCountInString(v); n > maxDisplayNameLen {
+			return invalid("must be at most %d characters, got %d", maxDisplayNameLen, n)
+		}
+		if strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return invalid("must not contain control characters")
+		}
+	case "avatarUrl":
+		if len(v) > maxAvatarURLLen {
+			return invalid("must be at most %d bytes", maxAvatarURLLen)
+		}
+		// Scheme, host and the image itself are checked by avatars.Validate
+		if u, err := url.Parse(v); err != nil || u.Host == "" {
+			return invalid("must be an absolute URL")
+		}
+	case "country":
+		v = strings.ToUpper(v)
+		if len(v) != 2 || v[0] < 'A' || v[0] > 'Z' || v[1] < 'A' || v[1] > 'Z' {
+			return invalid("must be a two-letter ISO 3166-1 country code, got %q", v)
+		}
+	case "bio":
+		if n := utf8.RuneCountInString(v); n > maxBioLen {
+			return invalid("must be at most %d characters, got %d", maxBioLen, n)
+		}
+	default:
+		return invalid("is not a profile field (displayName, avatarUrl, country, bio)")
+	}
+	return v, nil
+}
+
+// userByID returns a copy of a user as of the last sort.
+func (s *UserStore) userByID(userID string) (User, error) {
+	s.RLock()
+	defer s.RUnlock()
+	user, exists := s.GetLocked(userID)
+	if !exists {
+		return User{}, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+	return *user, nil
+}
+
+// userProfileRequest serves GET and PATCH /users/{id}.
+func userProfileRequest(r *http.Request) (map[string]interface{}, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	if id == "" || strings.Contains(id, "/") {
+		return nil, errRouteNotFound
+	}
+	user, err := userStore.userByID(id)
+	if err != nil {
+		return nil, err
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	var profile UserProfile
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		profile = profiles.Get(id)
+	case http.MethodPatch:
+		if replica != nil {
+			return nil, errReadOnlyReplica
+		}
+		patch, err := decodeProfilePatch(r)
+		if err != nil {
+			return nil, err
+		}
+		if avatarURL := patch["avatarUrl"]; avatarURL != nil {
+			if err := avatars.Validate(r.Context(), *avatarURL); err != nil {
+				return nil, err
+			}
+		}
+		profile = profiles.Apply(id, patch)
+		activity.set(id, time.Now())
+	default:
+		return nil, fmt.Errorf("%w: use GET or PATCH", errMethodNotAllowed)
+	}
+	response := map[string]interface{}{
+		"id":       user.ID,
+		"username": user.Username,
+		"rating":   user.Rating,
+		"rank":     user.Rank,
+		"profile":  profile,
+		"links":    userLinks(r, user.ID),
+	}
+	if last := activity.Get(user.ID); !last.IsZero() {
+		response["lastActive"] = last.Unix()
+	}
+	if joined := joins.Get(user.ID); !joined.IsZero() {
+		response["joinedAt"] = joined.Unix()
+	}
+	if v := volatility.Describe(user.ID); v != nil {
+		response["volatility"] = v
+	}
+	if history := userStore.UsernameHistory(user.ID); len(history) > 0 {
+		response["previousUsernames"] = history
+	}
+	if avatars.proxy && profile.AvatarURL != "" {
+		response["avatarProxyUrl"] = "/avatars/" + user.ID
+	}
+	if include["metadata"] {
+		response["metadata"] = metadata.Get(user.ID)
+	}
+	return response, nil
+}
+
+// usersHandler routes /users/{id}, /users/{id}/digest and
+// /users/{id}/metadata.
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/digest"):
+		userDigestHandler(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/metadata"):
+		userMetadataHandler(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/metrics"):
+		userMetricsHandler(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	user, err := userProfileRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"user":    user,
+	})
+}
+
+// writeLegacyError writes err in the unversioned {success, error} shape,
+// with the status v1 would use.
+func writeLegacyError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := err.(*paramError); ok {
+		writeParamError(w, r, err)
+		return
+	}
+	status, apiErr := v1Error(err)
+	localizeError(w, r, apiErr)
+	if writeProblem(w, r, status, apiErr) {
+		return
+	}
+	body := map[string]interface{}{
+		"success": false,
+		"error":   apiErr.Message,
+	}
+	if ue, ok := err.(*usernameError); ok {
+		body["reasons"] = ue.Reasons
+	}
+	if apiErr.Fields != nil {
+		body["fields"] = apiErr.Fields
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"matiks-leaderboard/models"
+)
+
+// ?project= reshapes any JSON response server-side, so dashboard widgets
+// get exactly the payload they render:
+//
+//	/leaderboard?project=users[*].{name:username,r:rank}
+//	/v1/leaderboard?project=[0].username
+//
+// The syntax is a small subset of JMESPath: field names (a.b), array
+// indexes ([0], [-1] from the end), [*] to apply the rest of the
+// expression to every element (dropping nulls), and {key:expr, ...} to
+// build an object. Legacy routes project the whole body; v1 routes project
+// data and keep the envelope. Errors and non-2xx responses are left as
+// they are. Expressions are limited to maxProjectionLength bytes and
+// maxProjectionDepth nested objects. The response-projection feature flag
+// turns ?project= off (see features.go).
+
+const (
+	maxProjectionLength = 256
+	maxProjectionDepth  = 4
+)
+
+// projectionStep is one field, index, [*] or {...}.
+type projectionStep struct {
+	field    string
+	index    *int
+	wildcard bool
+	keys     []string
+	values   []projection
+}
+
+type projection []projectionStep
+
+func (p projection) apply(v interface{}) interface{} {
+	for i, step := range p {
+		switch {
+		case step.wildcard:
+			items, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			out := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				if r := p[i+1:].apply(item); r != nil {
+					out = append(out, r)
+				}
+			}
+			return out
+		case step.index != nil:
+			items, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			n := *step.index
+			if n < 0 {
+				n += len(items)
+			}
+			if n < 0 || n >= len(items) {
+				return nil
+			}
+			v = items[n]
+		case step.keys != nil:
+			if v == nil {
+				return nil
+			}
+			obj := make(map[string]interface{}, len(step.keys))
+			for j, key := range step.keys {
+				obj[key] = step.values[j].apply(v)
+			}
+			v = obj
+		default:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = obj[step.field]
+		}
+	}
+	return v
+}
+
+type projectionParser struct {
+	src   string
+	pos   int
+	depth int
+}
+
+func projectionError(pos int, format string, args ...interface{}) error {
+	return &paramError{Param: "project", Message: fmt.Sprintf("at %d: ", pos) + fmt.Sprintf(format, args...)}
+}
+
+func parseProjection(src string) (projection, error) {
+	if len(src) > maxProjectionLength {
+		return nil, &paramError{Param: "project", Message: fmt.Sprintf("must be at most %d bytes", maxProjectionLength)}
+	}
+	p := &projectionParser{src: src}
+	expr, err := p.chain()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, projectionError(p.pos, "unexpected %q", p.src[p.pos:])
+	}
+	return expr, nil
+}
+
+func (p *projectionParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// chain parses steps up to the end, a comma or a closing brace.
+func (p *projectionParser) chain() (projection, error) {
+	var expr projection
+	for {
+		switch ch := p.peek(); {
+		case ch == '[':
+			step, err := p.bracket()
+			if err != nil {
+				return nil, err
+			}
+			expr = append(expr, step)
+		case ch == '{':
+			step, err := p.object()
+			if err != nil {
+				return nil, err
+			}
+			expr = append(expr, step)
+		case isProjectionIdentByte(ch, true):
+			expr = append(expr, projectionStep{field: p.ident()})
+		default:
+			if len(expr) == 0 {
+				if ch == 0 {
+					return nil, projectionError(p.pos, "unexpected end")
+				}
+				return nil, projectionError(p.pos, "expected a field, [ or {, got %q", ch)
+			}
+			return nil, projectionError(p.pos, "unexpected %q", ch)
+		}
+		switch p.peek() {
+		case '.':
+			p.pos++
+			if ch := p.peek(); ch == '[' || ch == 0 {
+				return nil, projectionError(p.pos, "expected a field or { after .")
+			}
+		case '[':
+		case 0, ',', '}':
+			return expr, nil
+		default:
+			return nil, projectionError(p.pos, "unexpected %q", p.peek())
+		}
+	}
+}
+
+func isProjectionIdentByte(ch byte, first bool) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || !first && ch >= '0' && ch <= '9'
+}
+
+func (p *projectionParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && isProjectionIdentByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *projectionParser) bracket() (projectionStep, error) {
+	start := p.pos
+	end := strings.IndexByte(p.src[p.pos:], ']')
+	if end < 0 {
+		return projectionStep{}, projectionError(start, "expected ]")
+	}
+	inner := p.src[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	if inner == "*" {
+		return projectionStep{wildcard: true}, nil
+	}
+	n, err := strconv.Atoi(inner)
+	if err != nil {
+		return projectionStep{}, projectionError(start, "expected [*] or an index, got [%s]", inner)
+	}
+	return projectionStep{index: &n}, nil
+}
+
+func (p *projectionParser) object() (projectionStep, error) {
+	if p.depth++; p.depth > maxProjectionDepth {
+		return projectionStep{}, projectionError(p.pos, "nested more than %d deep", maxProjectionDepth)
+	}
+	p.pos++ // {
+	step := projectionStep{keys: []string{}}
+	for {
+		keyAt := p.pos
+		key := p.ident()
+		if key == "" {
+			return projectionStep{}, projectionError(keyAt, "expected a key")
+		}
+		if p.peek() != ':' {
+			return projectionStep{}, projectionError(p.pos, "expected : after %s", key)
+		}
+		p.pos++
+		value, err := p.chain()
+		if err != nil {
+			return projectionStep{}, err
+		}
+		step.keys = append(step.keys, key)
+		step.values = append(step.values, value)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			p.depth--
+			return step, nil
+		default:
+			return projectionStep{}, projectionError(p.pos, "expected , or }")
+		}
+	}
+}
+
+// projectingWriter holds a response back to project it.
+type projectingWriter struct {
+	http.ResponseWriter
+	expr   projection
+	v1     bool
+	status int
+	body   bytes.Buffer
+}
+
+func (w *projectingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *projectingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// finish writes the held response, projected if it is a 2xx JSON body.
+func (w *projectingWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	out := w.body.Bytes()
+	if w.status/100 == 2 && len(out) > 0 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if projected, ok := w.project(out); ok {
+			out = projected
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(out)
+}
+
+func (w *projectingWriter) project(body []byte) ([]byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // Keep scores as they were rendered
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	if env, ok := v.(map[string]interface{}); ok && w.v1 {
+		env["data"] = w.expr.apply(env["data"])
+	} else {
+		v = w.expr.apply(v)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// serveProjected runs next, applying ?project= to its response if given.
+func serveProjected(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	raw, present := r.URL.Query()["project"]
+	if !present {
+		next(w, r)
+		return
+	}
+	v1 := strings.HasPrefix(r.URL.Path, "/v1/")
+	var expr projection
+	err := featureParamError("project", featureResponseProjection)
+	if featureResponseProjection.Enabled() {
+		expr, err = parseProjection(strings.TrimSpace(raw[0]))
+	}
+	if err != nil {
+		if v1 {
+			status, apiErr := v1Error(err)
+			localizeError(w, r, apiErr)
+			if !writeProblem(w, r, status, apiErr) {
+				writeEnvelope(w, status, models.Envelope{Error: apiErr})
+			}
+		} else {
+			writeParamError(w, r, err)
+		}
+		return
+	}
+	pw := &projectingWriter{ResponseWriter: w, expr: expr, v1: v1}
+	next(pw, r)
+	pw.finish()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestProjectionRoutes checks ?project= reshapes each legacy JSON route,
+// not just /leaderboard.
+func TestProjectionRoutes(t *testing.T) {
+	leaders, _, _, _, err := userStore.GetLeaderboard(context.Background(), 1, 1)
+	if err != nil || len(leaders) == 0 {
+		t.Fatalf("no leader: %v", err)
+	}
+	top := leaders[0]
+	tests := []struct {
+		route   string
+		handler http.HandlerFunc
+		query   string
+		check   func(v interface{}) bool
+	}{
+		{"/leaderboard", leaderboardHandler, "page=1&limit=5&project=users[0].username", func(v interface{}) bool {
+			return v == top.Username
+		}},
+		{"/user/rank", userRankHandler, "id=" + top.ID + "&project=data.user.id", func(v interface{}) bool {
+			return v == top.ID
+		}},
+		{"/stats", statsHandler, "project=success", func(v interface{}) bool {
+			return v == true
+		}},
+		{"/update", updateHandler, "count=1&project={ok:success}", func(v interface{}) bool {
+			obj, ok := v.(map[string]interface{})
+			return ok && len(obj) == 1 && obj["ok"] == true
+		}},
+		{"/health", healthHandler, "project=users", func(v interface{}) bool {
+			n, ok := v.(float64)
+			return ok && n > 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.route+"?"+tt.query, nil)
+			serveProjected(rec, req, tt.handler)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			var v interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+				t.Fatalf("body is not JSON: %v: %s", err, rec.Body)
+			}
+			if !tt.check(v) {
+				t.Errorf("projected body %s", rec.Body)
+			}
+		})
+	}
+}
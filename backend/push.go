@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Push notifications are driven by ranks_changed events. A user with a
+// registered device is notified when someone they follow overtakes them,
+// and when they drop out of a rank tier (top 10, top 100, ...).
+// Each device gets them in the language it registered in (see i18n.go).
+// Registrations live in memory and are lost on restart.
+
+// Push notification kinds
+const (
+	pushOvertaken   = "overtaken"
+	pushDroppedTier = "dropped_tier"
+)
+
+const pushQueueSize = 1024
+
+// PushNotification is one message for one user's devices.
+type PushNotification struct {
+	UserID string            `json:"userId"`
+	Kind   string            `json:"kind"`
+	Title  string            `json:"title"`
+	Body   string            `json:"body"`
+	Data   map[string]string `json:"data,omitempty"`
+
+	args map[string]string // For the push.<kind> templates (see i18n.go)
+}
+
+// in renders n in a device's language, keeping the English text for
+// anything its catalog lacks.
+func (n PushNotification) in(lang string) PushNotification {
+	if title, ok := locales.text(lang, "push."+n.Kind+".title", n.args); ok {
+		n.Title = title
+	}
+	if body, ok := locales.text(lang, "push."+n.Kind+".body", n.args); ok {
+		n.Body = body
+	}
+	return n
+}
+
+// PushSender delivers a notification to one device token on one platform.
+type PushSender interface {
+	Send(ctx context.Context, token string, n PushNotification) error
+}
+
+type pushDevice struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+	Language string `json:"language"`
+}
+
+// pushOutgoing is a notification bound for one device.
+type pushOutgoing struct {
+	device pushDevice
+	note   PushNotification
+}
+
+// PushNotifier keeps device and follow registrations and turns rank changes
+// into notifications.
+type PushNotifier struct {
+	store    *UserStore
+	senders  map[string]PushSender // By platform: "fcm", "apns", "log"
+	tiers    []int                 // Ascending rank cut-offs
+	cooldown time.Duration
+	queue    chan pushOutgoing
+
+	mu        sync.RWMutex
+	devices   map[string][]pushDevice        // userID -> devices
+	followers map[string]map[string]struct{} // followed userID -> follower IDs
+	following map[string]map[string]struct{} // follower userID -> followed IDs
+	lastSent  map[string]time.Time           // userID + kind -> last notification
+
+	sent, failed, dropped int64 // Guarded by mu
+}
+
+var pushNotifier *PushNotifier
+
+func NewPushNotifier(store *UserStore, senders map[string]PushSender, tiers []int, cooldown time.Duration) *PushNotifier {
+	return &PushNotifier{
+		store:     store,
+		senders:   senders,
+		tiers:     tiers,
+		cooldown:  cooldown,
+		queue:     make(chan pushOutgoing, pushQueueSize),
+		devices:   make(map[string][]pushDevice),
+		followers: make(map[string]map[string]struct{}),
+		following: make(map[string]map[string]struct{}),
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+// startPush configures senders from the environment and runs the notifier
+// as the "push" job. It is a no-op when no sender is configured.
+func startPush(store *UserStore) error {
+	senders := make(map[string]PushSender)
+	if path := envString("MATIKS_FCM_CREDENTIALS", ""); path != "" {
+		fcm, err := newFCMSender(path)
+		if err != nil {
+			return fmt.Errorf("FCM: %w", err)
+		}
+		senders["fcm"] = fcm
+	}
+	if path := envString("MATIKS_APNS_KEY", ""); path != "" {
+		apns, err := newAPNsSender(path, envString("MATIKS_APNS_KEY_ID", ""), envString("MATIKS_APNS_TEAM_ID", ""),
+			envString("MATIKS_APNS_TOPIC", ""), envBool("MATIKS_APNS_SANDBOX", false))
+		if err != nil {
+			return fmt.Errorf("APNs: %w", err)
+		}
+		senders["apns"] = apns
+	}
+	if envBool("MATIKS_PUSH_LOG", false) {
+		senders["log"] = logPushSender{}
+	}
+	if len(senders) == 0 {
+		return nil
+	}
+
+	var tiers []int
+	for _, raw := range envListDefault("MATIKS_PUSH_TIERS", "10", "100", "1000") {
+		if tier, err := strconv.Atoi(raw); err == nil && tier > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	sort.Ints(tiers)
+	pushNotifier = NewPushNotifier(store, senders, tiers, envDuration("MATIKS_PUSH_COOLDOWN", 5*time.Minute))
+
+	jobs.Register("push", pushNotifier.run)
+	if err := jobs.Run("push"); err != nil {
+		return err
+	}
+	log.Printf("Push: notifications enabled for %d platform(s), tiers %v", len(senders), tiers)
+	return nil
+}
+
+// Register adds a device for a user. Re-registering a token only updates
+// its language.
+func (p *PushNotifier) Register(userID string, device pushDevice) error {
+	if _, ok := p.senders[device.Platform]; !ok {
+		return &paramError{Param: "platform", Message: fmt.Sprintf("no sender configured for %q", device.Platform)}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, d := range p.devices[userID] {
+		if d.Platform == device.Platform && d.Token == device.Token {
+			p.devices[userID][i].Language = device.Language
+			return nil
+		}
+	}
+	p.devices[userID] = append(p.devices[userID], device)
+	return nil
+}
+
+// Unregister removes a device token from every user.
+func (p *PushNotifier) Unregister(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for userID, devices := range p.devices {
+		kept := devices[:0]
+		for _, d := range devices {
+			if d.Token != token {
+				kept = append(kept, d)
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.devices, userID)
+		} else {
+			p.devices[userID] = kept
+		}
+	}
+}
+
+// Follow records that follower wants to hear when target overtakes them.
+func (p *PushNotifier) Follow(follower, target string, follow bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !follow {
+		delete(p.followers[target], follower)
+		delete(p.following[follower], target)
+		return
+	}
+	if p.followers[target] == nil {
+		p.followers[target] = make(map[string]struct{})
+	}
+	if p.following[follower] == nil {
+		p.following[follower] = make(map[string]struct{})
+	}
+	p.followers[target][follower] = struct{}{}
+	p.following[follower][target] = struct{}{}
+}
+
+func (p *PushNotifier) run(ctx context.Context) error {
+	events := p.store.events.Subscribe(256)
+	defer p.store.events.Unsubscribe(events)
+
+	go p.deliverLoop(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if changes, ok := ev.Payload.(RanksChangedPayload); ok {
+				for _, n := range p.notificationsFor(changes.Changes) {
+					p.enqueue(n)
+				}
+			}
+		}
+	}
+}
+
+// notificationsFor finds overtakes between followers and the users they
+// follow, and tier drop-outs, in one sort's rank changes.
+func (p *PushNotifier) notificationsFor(changes []RankChange) []PushNotification {
+	oldRank := make(map[string]int, len(changes))
+	for _, c := range changes {
+		oldRank[c.UserID] = c.OldRank
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var notes []PushNotification
+	for _, c := range changes {
+		// Tier drop-outs, reported once for the best tier lost
+		for _, tier := range p.tiers {
+			if c.OldRank <= tier && c.NewRank > tier {
+				notes = append(notes, PushNotification{
+					UserID: c.UserID,
+					Kind:   pushDroppedTier,
+					Title:  fmt.Sprintf("You dropped out of the top %d", tier),
+					Body:   fmt.Sprintf("You're now ranked #%d.", c.NewRank),
+					Data:   map[string]string{"tier": strconv.Itoa(tier), "rank": strconv.Itoa(c.NewRank)},
+					args:   map[string]string{"tier": strconv.Itoa(tier), "rank": strconv.Itoa(c.NewRank)},
+				})
+				break
+			}
+		}
+
+		// c.UserID moved up: did it pass anyone following it?
+		if c.NewRank >= c.OldRank {
+			continue
+		}
+		for follower := range p.followers[c.UserID] {
+			if len(p.devices[follower]) == 0 {
+				continue
+			}
+			followerNow, exists := p.store.rankOf(follower)
+			if !exists {
+				continue
+			}
+			followerBefore, moved := oldRank[follower]
+			if !moved {
+				followerBefore = followerNow
+			}
+			if c.OldRank > followerBefore && c.NewRank < followerNow {
+				notes = append(notes, PushNotification{
+					UserID: follower,
+					Kind:   pushOvertaken,
+					Title:  fmt.Sprintf("%s overtook you", c.Username),
+					Body:   fmt.Sprintf("%s is now #%d; you're #%d.", c.Username, c.NewRank, followerNow),
+					Data:   map[string]string{"userId": c.UserID, "rank": strconv.Itoa(followerNow)},
+					args: map[string]string{
+						"username": c.Username, "theirRank": strconv.Itoa(c.NewRank), "rank": strconv.Itoa(followerNow),
+					},
+				})
+			}
+		}
+	}
+	return notes
+}
+
+// rankOf returns a user's rank as of the last sort.
+func (s *UserStore) rankOf(userID string) (int, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	user, exists := s.GetLocked(userID)
+	if !exists {
+		return 0, false
+	}
+	return user.Rank, true
+}
+
+// enqueue fans a notification out to the user's devices, at most once per
+// kind per cooldown.
+func (p *PushNotifier) enqueue(n PushNotification) {
+	key := n.UserID + "\x00" + n.Kind
+	p.mu.Lock()
+	devices := p.devices[n.UserID]
+	if len(devices) == 0 || time.Since(p.lastSent[key]) < p.cooldown {
+		p.mu.Unlock()
+		return
+	}
+	p.lastSent[key] = time.Now()
+	p.mu.Unlock()
+
+	for _, d := range devices {
+		select {
+		case p.queue <- pushOutgoing{device: d, note: n.in(d.Language)}:
+		default:
+			p.count(&p.dropped)
+		}
+	}
+}
+
+func (p *PushNotifier) deliverLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case out := <-p.queue:
+			sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err := p.senders[out.device.Platform].Send(sendCtx, out.device.Token, out.note)
+			cancel()
+			if err == nil {
+				p.count(&p.sent)
+				continue
+			}
+			p.count(&p.failed)
+			log.Printf("Push: %s to %s failed: %v", out.note.Kind, out.device.Platform, err)
+			if errors.Is(err, errPushTokenInvalid) {
+				p.Unregister(out.device.Token)
+			}
+		}
+	}
+}
+
+func (p *PushNotifier) count(counter *int64) {
+	p.mu.Lock()
+	*counter++
+	p.mu.Unlock()
+}
+
+// Stats reports registrations and delivery counters.
+func (p *PushNotifier) Stats() map[string]interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	devices, follows := 0, 0
+	for _, d := range p.devices {
+		devices += len(d)
+	}
+	for _, f := range p.following {
+		follows += len(f)
+	}
+	platforms := make([]string, 0, len(p.senders))
+	for name := range p.senders {
+		platforms = append(platforms, name)
+	}
+	return map[string]interface{}{
+		"platforms": platforms,
+		"tiers":     p.tiers,
+		"devices":   devices,
+		"follows":   follows,
+		"queued":    len(p.queue),
+		"sent":      p.sent,
+		"failed":    p.failed,
+		"dropped":   p.dropped,
+	}
+}
+
+// errPushTokenInvalid is returned by senders when the platform says the
+// token will never work again, so it is unregistered.
+var errPushTokenInvalid = errors.New("device token is no longer valid")
+
+var errPushDisabled = errors.New("push notifications are not configured (set MATIKS_FCM_CREDENTIALS, MATIKS_APNS_KEY or MATIKS_PUSH_LOG=true)")
+
+// logPushSender writes notifications to the log, for development.
+type logPushSender struct{}
+
+func (logPushSender) Send(_ context.Context, token string, n PushNotification) error {
+	log.Printf("Push: [%s] to %s (%s): %s - %s", n.Kind, n.UserID, token, n.Title, n.Body)
+	return nil
+}
+
+// fcmSender uses the FCM HTTP v1 API with a service-account key.
+type fcmSender struct {
+	client  *http.Client
+	project string
+}
+
+func newFCMSender(credentialsPath string) (*fcmSender, error) {
+	data, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(context.Background(), data, "https://www.googleapis.com/auth/firebase.messaging")
+	if err != nil {
+		return nil, err
+	}
+	if creds.ProjectID == "" {
+		return nil, errors.New("credentials have no project_id")
+	}
+	client := oauth2.NewClient(context.Background(), creds.TokenSource)
+	client.Timeout = 10 * time.Second
+	return &fcmSender{client: client, project: creds.ProjectID}, nil
+}
+
+func (s *fcmSender) Send(ctx context.Context, token string, n PushNotification) error {
+	data := map[string]string{"kind": n.Kind}
+	for k, v := range n.Data {
+		data[k] = v
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": n.Title, "body": n.Body},
+			"data":         data,
+		},
+	})
+	url := "https://fcm.googleapis.com/v1/projects/" + s.project + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// UNREGISTERED comes back as 404; INVALID_ARGUMENT can also mean a bad payload
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errPushTokenInvalid, msg)
+	}
+	return fmt.Errorf("FCM answered %s: %s", resp.Status, msg)
+}
+
+// apnsSender uses APNs token-based (.p8 key) authentication over HTTP/2.
+type apnsSender struct {
+	client *http.Client
+	key    *ecdsa.PrivateKey
+	keyID  string
+	teamID string
+	topic  string // App bundle ID
+	host   string
+
+	mu       sync.Mutex
+	jwt      string
+	jwtIssue time.Time
+}
+
+func newAPNsSender(keyPath, keyID, teamID, topic string, sandbox bool) (*apnsSender, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("MATIKS_APNS_KEY_ID, MATIKS_APNS_TEAM_ID and MATIKS_APNS_TOPIC are required")
+	}
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key is not an ECDSA key")
+	}
+	host := "https://api.push.apple.com"
+	if sandbox {
+		host = "https://api.sandbox.push.apple.com"
+	}
+	return &apnsSender{
+		client: &http.Client{Timeout: 10 * time.Second}, // TLS negotiates HTTP/2
+		key:    key, keyID: keyID, teamID: teamID, topic: topic, host: host,
+	}, nil
+}
+
+// bearer returns the provider token. Apple rejects tokens older than an
+// hour and throttles ones refreshed more than every 20 minutes.
+func (s *apnsSender) bearer() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jwt != "" && time.Since(s.jwtIssue) < 50*time.Minute {
+		return s.jwt, nil
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": s.keyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": s.teamID, "iat": now.Unix()})
+	enc := base64.RawURLEncoding
+	signing := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signing))
+	r, sg, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the fixed-width r || s encoding, not ASN.1
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	sg.FillBytes(sig[32:])
+
+	s.jwt = signing + "." + enc.EncodeToString(sig)
+	s.jwtIssue = now
+	return s.jwt, nil
+}
+
+func (s *apnsSender) Send(ctx context.Context, token string, n PushNotification) error {
+	bearer, err := s.bearer()
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": n.Title, "body": n.Body},
+			"sound": "default",
+		},
+		"kind": n.Kind,
+	}
+	for k, v := range n.Data {
+		payload[k] = v
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+bearer)
+	req.Header.Set("apns-topic", s.topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1024)).Decode(&reason)
+	switch reason.Reason {
+	case "BadDeviceToken", "Unregistered", "DeviceTokenNotForTopic":
+		return fmt.Errorf("%w: %s", errPushTokenInvalid, reason.Reason)
+	}
+	return fmt.Errorf("APNs answered %s: %s", resp.Status, reason.Reason)
+}
+
+// pushHandler manages registrations:
+//
+//	POST   /push/devices?userId=&platform=fcm|apns&token=   register a device
+//	                     [&lang=]                         in a language (see i18n.go)
+//	DELETE /push/devices?token=                           unregister it
+//	POST   /push/follows?userId=&target=                  follow target
+//	DELETE /push/follows?userId=&target=                  unfollow
+//	GET    /push/devices                                  notifier stats
+func pushHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if pushNotifier == nil {
+		writeLegacyError(w, r, errPushDisabled)
+		return
+	}
+
+	if err := pushAction(r); err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"push":      pushNotifier.Stats(),
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// pushAction applies one registration request; GET changes nothing.
+func pushAction(r *http.Request) error {
+	q := r.URL.Query()
+	required := func(names ...string) error {
+		for _, name := range names {
+			if strings.TrimSpace(q.Get(name)) == "" {
+				return &paramError{Param: name, Message: "is required"}
+			}
+		}
+		return nil
+	}
+	knownUser := func(id string) error {
+		if _, exists := userStore.rankOf(id); !exists {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, id)
+		}
+		return nil
+	}
+
+	devices := strings.HasSuffix(r.URL.Path, "/devices")
+	switch {
+	case r.Method == http.MethodGet:
+		return nil
+	case devices && r.Method == http.MethodPost:
+		if err := required("userId", "platform", "token"); err != nil {
+			return err
+		}
+		if err := knownUser(q.Get("userId")); err != nil {
+			return err
+		}
+		lang := q.Get("lang")
+		if lang == "" {
+			lang = r.Header.Get("Accept-Language")
+		}
+		device := pushDevice{Platform: q.Get("platform"), Token: q.Get("token"), Language: locales.negotiate(lang)}
+		return pushNotifier.Register(q.Get("userId"), device)
+	case devices && r.Method == http.MethodDelete:
+		if err := required("token"); err != nil {
+			return err
+		}
+		pushNotifier.Unregister(q.Get("token"))
+		return nil
+	case !devices && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		if err := required("userId", "target"); err != nil {
+			return err
+		}
+		for _, id := range []string{q.Get("userId"), q.Get("target")} {
+			if err := knownUser(id); err != nil {
+				return err
+			}
+		}
+		pushNotifier.Follow(q.Get("userId"), q.Get("target"), r.Method == http.MethodPost)
+		return nil
+	}
+	return &paramError{Param: "method", Message: "use GET, POST or DELETE"}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+
+	"matiks-leaderboard/utils"
+)
+
+// Replicator ships a batch of rating updates to every replica. Standalone
+// stores have no replicator and apply updates directly.
+type Replicator interface {
+	Replicate(updates []ratingUpdate) error
+	ReplicateLoad(users []utils.SeedUser) error
+}
+
+const (
+	raftOpUpdate = "update"
+	raftOpLoad   = "load"
+)
+
+// raftCommand is one raft log entry.
+type raftCommand struct {
+	Op      string           `json:"op"`
+	Updates []ratingUpdate   `json:"updates,omitempty"`
+	Users   []utils.SeedUser `json:"users,omitempty"`
+}
+
+type raftConfig struct {
+	NodeID    string
+	BindAddr  string
+	Dir       string
+	Bootstrap bool
+	Peers     map[string]string // node ID -> raft address, including self
+}
+
+// raftConfigFromEnv reads MATIKS_RAFT_*. Peers are "id=host:port,...".
+func raftConfigFromEnv(dir string) raftConfig {
+	cfg := raftConfig{
+		NodeID:    envString("MATIKS_RAFT_NODE_ID", instanceID),
+		BindAddr:  envString("MATIKS_RAFT_ADDR", "127.0.0.1:7000"),
+		Dir:       dir,
+		Bootstrap: envBool("MATIKS_RAFT_BOOTSTRAP", false),
+		Peers:     make(map[string]string),
+	}
+	for _, peer := range envList("MATIKS_RAFT_PEERS") {
+		if id, addr, ok := strings.Cut(peer, "="); ok {
+			cfg.Peers[id] = addr
+		}
+	}
+	cfg.Peers[cfg.NodeID] = cfg.BindAddr
+	return cfg
+}
+
+// raftNode is set when the store runs replicated.
+var raftNode *raft.Raft
+
+type raftReplicator struct {
+	r *raft.Raft
+}
+
+func (rr *raftReplicator) Replicate(updates []ratingUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	return rr.apply(raftCommand{Op: raftOpUpdate, Updates: updates})
+}
+
+func (rr *raftReplicator) ReplicateLoad(users []utils.SeedUser) error {
+	return rr.apply(raftCommand{Op: raftOpLoad, Users: users})
+}
+
+func (rr *raftReplicator) apply(cmd raftCommand) error {
+	if rr.r.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	f := rr.r.Apply(data, 10*time.Second)
+	if err := f.Error(); err != nil {
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// storeFSM applies committed log entries to the UserStore.
+type storeFSM struct {
+	store  *UserStore
+	loaded int32 // Set once the cluster dataset has been applied or restored
+}
+
+func (f *storeFSM) Apply(l *raft.Log) interface{} {
+	var cmd raftCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("decoding raft command: %w", err)
+	}
+
+	switch cmd.Op {
+	case raftOpUpdate:
+		updated, pendingSorts := f.store.applyRatingUpdates(cmd.Updates, sourceReplication)
+		log.Printf("Raft apply #%d: Changed=%d, Pending sorts=%d", l.Index, updated, pendingSorts)
+	case raftOpLoad:
+		f.store.replaceUsers(cmd.Users)
+		atomic.StoreInt32(&f.loaded, 1)
+		log.Printf("Raft apply #%d: loaded %d users", l.Index, len(cmd.Users))
+	default:
+		return fmt.Errorf("unknown raft op %q", cmd.Op)
+	}
+	return nil
+}
+
+func (f *storeFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &storeSnapshot{users: f.store.snapshotUsers()}, nil
+}
+
+func (f *storeFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var users []utils.SeedUser
+	if err := json.NewDecoder(rc).Decode(&users); err != nil {
+		return fmt.Errorf("decoding raft snapshot: %w", err)
+	}
+
+	f.store.replaceUsers(users)
+	atomic.StoreInt32(&f.loaded, 1)
+	log.Printf("Raft: restored %d users from snapshot", len(users))
+	return nil
+}
+
+type storeSnapshot struct {
+	users []utils.SeedUser
+}
+
+func (s *storeSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.users); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *storeSnapshot) Release() {}
+
+// startRaft joins or bootstraps the cluster and routes store updates
+// through the log. Ratings live in the raft log and snapshots under
+// cfg.Dir, so a restarted node catches up without losing data.
+func startRaft(store *UserStore, cfg raftConfig) error {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return err
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.NodeID)
+	conf.SnapshotThreshold = 1024
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.BindAddr)
+	if err != nil {
+		return err
+	}
+	transport, err := raft.NewTCPTransport(cfg.BindAddr, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, 2, os.Stderr)
+	if err != nil {
+		return err
+	}
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		return err
+	}
+
+	fsm := &storeFSM{store: store}
+	r, err := raft.NewRaft(conf, fsm, logStore, logStore, snapshots, transport)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Bootstrap {
+		var servers []raft.Server
+		for id, peerAddr := range cfg.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(peerAddr)})
+		}
+		err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			return err
+		}
+	}
+
+	replicator := &raftReplicator{r: r}
+	store.replicator = replicator
+	raftNode = r
+
+	// A fresh cluster has no dataset in its log yet: the first leader
+	// replicates the users it seeded locally
+	go func() {
+		for isLeader := range r.LeaderCh() {
+			if !isLeader {
+				continue
+			}
+			if err := r.Barrier(30 * time.Second).Error(); err != nil {
+				log.Printf("Raft: barrier: %v", err)
+				continue
+			}
+			if atomic.LoadInt32(&fsm.loaded) == 0 {
+				if err := replicator.apply(raftCommand{Op: raftOpLoad, Users: store.snapshotUsers()}); err != nil {
+					log.Printf("Raft: replicating initial dataset: %v", err)
+				}
+			}
+		}
+	}()
+
+	log.Printf("Raft: node %s on %s (%d peers, bootstrap=%v)", cfg.NodeID, cfg.BindAddr, len(cfg.Peers), cfg.Bootstrap)
+	return nil
+}
+
+// raftStatus summarizes cluster state for /stats.
+func raftStatus() map[string]interface{} {
+	if raftNode == nil {
+		return nil
+	}
+	leaderAddr, leaderID := raftNode.LeaderWithID()
+	return map[string]interface{}{
+		"state":        raftNode.State().String(),
+		"leaderId":     string(leaderID),
+		"leaderAddr":   string(leaderAddr),
+		"lastIndex":    raftNode.LastIndex(),
+		"appliedIndex": raftNode.AppliedIndex(),
+	}
+}
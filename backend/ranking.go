@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+
+	"matiks-leaderboard/utils"
+)
+
+// MATIKS_RANK_MODE numbers the leaderboard: standard (default, competition
+// ranking "1224"), dense ("1223") or ordinal (every user a rank of their
+// own, tied users in tie-break order). Every endpoint reporting a rank,
+// liveRank included, follows the mode.
+//
+// It is the rating leaderboard's mode, and the boards built from its users
+// (filtered boards and the improvement board) number in it too. Each
+// metric board takes MATIKS_RANK_MODE_<BOARD>, defaulting to the rating
+// leaderboard's mode. Dry runs always compare competition ranks (see
+// dryrun.go).
+//
+// Partial re-ranks still hold: standard and ordinal ranks below the sorted
+// band cannot move, while dense ranks below it shift when the band gains or
+// loses a distinct rating, so the rank pass carries on past the band until
+// the numbers agree again.
+
+var leaderboardRankMode = envRankMode("MATIKS_RANK_MODE", utils.RankStandard)
+
+func envRankMode(key string, def utils.RankMode) utils.RankMode {
+	m, err := utils.ParseRankMode(envString(key, string(def)))
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return m
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// MATIKS_RATE_LIMIT caps requests per client per MATIKS_RATE_LIMIT_WINDOW
+// (default 1m); 0, the default, turns limiting off. Clients are told apart
+// by remote address, or by the first address in
+// MATIKS_RATE_LIMIT_CLIENT_HEADER (e.g. X-Forwarded-For) behind a proxy
+// that sets it. Health checks are never limited.
+//
+// Every limited response carries X-RateLimit-Limit, X-RateLimit-Remaining
+// and X-RateLimit-Reset (Unix seconds when the window ends). A rejected
+// request gets 429 with Retry-After and a body saying when to come back,
+// in the v1 envelope under /v1/ and the legacy shape elsewhere.
+
+type rateWindowCount struct {
+	count int
+	reset time.Time
+}
+
+type rateLimiter struct {
+	limit        int
+	window       time.Duration
+	clientHeader string
+
+	mu        sync.Mutex
+	clients   map[string]*rateWindowCount
+	lastSweep time.Time
+	rejected  int64
+}
+
+var limiter = &rateLimiter{
+	limit:        envInt("MATIKS_RATE_LIMIT", 0),
+	window:       envDuration("MATIKS_RATE_LIMIT_WINDOW", time.Minute),
+	clientHeader: envString("MATIKS_RATE_LIMIT_CLIENT_HEADER", ""),
+	clients:      make(map[string]*rateWindowCount),
+}
+
+// rateLimitExempt are the paths load balancers poll.
+var rateLimitExempt = map[string]bool{"/health": true, "/v1/health": true, "/readyz": true, "/v1/readyz": true}
+
+func (l *rateLimiter) client(r *http.Request) string {
+	if l.clientHeader != "" {
+		if v := r.Header.Get(l.clientHeader); v != "" {
+			first, _, _ := strings.Cut(v, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// take counts one request from client, returning how many remain in the
+// window, when it resets and whether the request is allowed.
+func (l *rateLimiter) take(client string, now time.Time) (remaining int, reset time.Time, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= l.window {
+		for c, w := range l.clients {
+			if !now.Before(w.reset) {
+				delete(l.clients, c)
+			}
+		}
+		l.lastSweep = now
+	}
+	w := l.clients[client]
+	if w == nil || !now.Before(w.reset) {
+		w = &rateWindowCount{reset: now.Add(l.window)}
+		l.clients[client] = w
+	}
+	if w.count >= l.limit {
+		l.rejected++
+		return 0, w.reset, false
+	}
+	w.count++
+	return l.limit - w.count, w.reset, true
+}
+
+// allow sets the rate limit headers and answers 429 itself when r is over
+// the limit.
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request) bool {
+	if l.limit <= 0 || rateLimitExempt[r.URL.Path] {
+		return true
+	}
+	now := time.Now()
+	remaining, reset, ok := l.take(l.client(r), now)
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if ok {
+		return true
+	}
+
+	retryAfter := int((reset.Sub(now) + time.Second - 1) / time.Second)
+	h.Set("Retry-After", strconv.Itoa(retryAfter))
+	details := map[string]interface{}{
+		"limit":             l.limit,
+		"windowSeconds":     int(l.window.Seconds()),
+		"resetAt":           reset.Unix(),
+		"retryAfterSeconds": retryAfter,
+	}
+	message := translate(w, r, "rate_limited", "Too many requests; retry after "+strconv.Itoa(retryAfter)+"s",
+		map[string]string{"retryAfter": strconv.Itoa(retryAfter)})
+	apiErr := &models.APIError{Code: "rate_limited", Message: message, Details: details}
+	if writeProblem(w, r, http.StatusTooManyRequests, apiErr) {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeEnvelope(w, http.StatusTooManyRequests, models.Envelope{Error: apiErr})
+		return false
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   false,
+		"error":     message,
+		"rateLimit": details,
+	})
+	return false
+}
+
+// Stats is the /stats "rateLimit" object.
+func (l *rateLimiter) Stats() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return map[string]interface{}{
+		"limit":         l.limit,
+		"windowSeconds": int(l.window.Seconds()),
+		"clients":       len(l.clients),
+		"rejected":      l.rejected,
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GET /readyz pings every configured dependency (Redis, NATS, the Kafka
+// brokers, the raft leader, the primary a replica follows) in parallel,
+// each within MATIKS_READYZ_TIMEOUT (default 2s), and reports each one's
+// status and latency. It answers 200 when all are reachable and 503 when
+// any is not, so an orchestrator can tell a failing dependency (503 with a
+// failing check) from a failing instance (no answer at all). /health stays
+// a cheap liveness check that touches nothing external.
+
+var readyzTimeout = envDuration("MATIKS_READYZ_TIMEOUT", 2*time.Second)
+
+type readinessCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+var (
+	readinessMu     sync.Mutex
+	readinessChecks []readinessCheck
+)
+
+// addReadinessCheck registers a dependency for /readyz; check should
+// return nil when the dependency answers.
+func addReadinessCheck(name string, check func(context.Context) error) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	readinessChecks = append(readinessChecks, readinessCheck{name: name, check: check})
+}
+
+type checkResult struct {
+	Status    string  `json:"status"` // ok or failing
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readinessReport runs every check and reports whether all passed.
+func readinessReport(ctx context.Context) (map[string]interface{}, bool) {
+	readinessMu.Lock()
+	checks := append([]readinessCheck(nil), readinessChecks...)
+	readinessMu.Unlock()
+	if raftNode != nil {
+		checks = append(checks, readinessCheck{"raft", checkRaftLeader})
+	}
+	if replica != nil {
+		checks = append(checks, readinessCheck{"primary", replica.checkConnected})
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	results := make(map[string]checkResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, readyzTimeout)
+			defer cancel()
+			start := time.Now()
+			err := c.check(ctx)
+			res := checkResult{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Status = "failing"
+				res.Error = err.Error()
+			}
+			mu.Lock()
+			results[c.name] = res
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, res := range results {
+		if res.Status != "ok" {
+			ready = false
+		}
+	}
+	status := "ready"
+	if !ready {
+		status = "not_ready"
+	}
+	return map[string]interface{}{
+		"status": status,
+		"checks": results,
+		"users":  atomic.LoadInt64(&userStore.totalUsers),
+	}, ready
+}
+
+func checkRaftLeader(context.Context) error {
+	if _, id := raftNode.LeaderWithID(); id == "" {
+		return errors.New("no known leader")
+	}
+	return nil
+}
+
+// checkConnected fails while the follower has no stream from its primary.
+func (f *follower) checkConnected(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.connected {
+		return nil
+	}
+	if f.lastError != "" {
+		return fmt.Errorf("not connected to %s: %s", f.primary, f.lastError)
+	}
+	return fmt.Errorf("not connected to %s", f.primary)
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report, ready := readinessReport(r.Context())
+	report["timestamp"] = time.Now().Unix()
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient builds the shared client from MATIKS_REDIS_* settings, or
+// returns nil when Redis isn't configured.
+func newRedisClient() *redis.Client {
+	addr := envString("MATIKS_REDIS_ADDR", "")
+	if addr == "" {
+		return nil
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: envString("MATIKS_REDIS_PASSWORD", ""),
+		DB:       envInt("MATIKS_REDIS_DB", 0),
+	})
+	redisBreaker = newCircuitBreaker("redis")
+	ping := func(ctx context.Context) error { return rdb.Ping(ctx).Err() }
+	go redisBreaker.watch(context.Background(), ping)
+	addReadinessCheck("redis", ping)
+	return rdb
+}
+
+type invalidationMessage struct {
+	Origin    string `json:"origin"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+	Version   int64  `json:"version,omitempty"` // The cluster version it started
+}
+
+// invalidationDebounce coalesces a burst of rating changes (up to 200 per
+// simulator tick) into a single message.
+const invalidationDebounce = 100 * time.Millisecond
+
+// startCacheInvalidation bumps the cluster cache version and publishes a
+// message on channel whenever this instance mutates ratings or re-sorts,
+// and drops the local page cache when another instance does. A Redis page cache needs no dropping: its shared
+// generation already moved on.
+func startCacheInvalidation(rdb *redis.Client, channel string) {
+	ctx := context.Background()
+
+	events := userStore.events.Subscribe(4096)
+	go func() {
+		var pending string
+		timer := time.NewTimer(invalidationDebounce)
+		timer.Stop()
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if ev.Type != EventRatingChanged && ev.Type != EventSortCompleted {
+					continue
+				}
+				if pending == "" {
+					timer.Reset(invalidationDebounce)
+				}
+				pending = ev.Type
+			case <-timer.C:
+				version, err := clusterVersion.bump(ctx)
+				if err != nil && err != errBackendDegraded {
+					log.Printf("Redis: bump cache version: %v", err)
+				}
+				msg, _ := json.Marshal(invalidationMessage{
+					Origin:    instanceID,
+					Reason:    pending,
+					Timestamp: time.Now().Unix(),
+					Version:   version,
+				})
+				err = redisBreaker.Do(func() error { return rdb.Publish(ctx, channel, msg).Err() })
+				if err != nil && err != errBackendDegraded {
+					log.Printf("Redis: publish invalidation: %v", err)
+				}
+				pending = ""
+			}
+		}
+	}()
+
+	sub := rdb.Subscribe(ctx, channel)
+	go func() {
+		for m := range sub.Channel() {
+			var msg invalidationMessage
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil || msg.Origin == instanceID {
+				continue
+			}
+			clusterVersion.observe(msg.Version)
+			if userStore.pages.Shared() {
+				continue // The sender already retired the shared pages
+			}
+			userStore.clearCache()
+		}
+	}()
+
+	log.Printf("Redis: cache invalidation on channel %q", channel)
+}
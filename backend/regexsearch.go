@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"time"
+)
+
+// /search?mode=regex&q=^rahul_[0-9]{3}$ is for admin investigations that
+// a prefix can't express. q is a Go (RE2) regular expression, matched
+// against the username as displayed, or the ID with ?by=id. Matches come
+// in username (or ID) order, up to the usual result limit.
+//
+// /search is public, so the mode checks the admin token itself: it needs
+// Authorization: Bearer MATIKS_ADMIN_TOKEN, or with no token set, a local
+// peer (see middleware.go). The pattern is refused up front if it doesn't
+// compile or compiles to more than MATIKS_SEARCH_REGEX_MAX_PROGRAM
+// instructions (default 1000). RE2 runs in linear time, but a scan of
+// every user still takes a while on a large board, so one is cut off
+// after MATIKS_SEARCH_REGEX_TIMEOUT (default 2s) with a 503.
+//
+// A pattern anchored with ^ and starting with literal text only scans the
+// users whose folded name starts with it: the first-character bucket, or
+// a range of the ID index, found by binary search. Anything else reads
+// the whole name or ID index.
+
+const (
+	searchModePrefix = "prefix"
+	searchModeRegex  = "regex"
+)
+
+var (
+	searchRegexMaxProgram = envInt("MATIKS_SEARCH_REGEX_MAX_PROGRAM", 1000)
+	searchRegexTimeout    = envDuration("MATIKS_SEARCH_REGEX_TIMEOUT", 2*time.Second)
+)
+
+var errSearchTimeout = errors.New("regex search timed out")
+
+// searchModeParam reads ?mode=, prefix by default.
+func searchModeParam(r *http.Request) (string, error) {
+	switch raw := strings.TrimSpace(r.URL.Query().Get("mode")); raw {
+	case "", searchModePrefix:
+		return searchModePrefix, nil
+	case searchModeRegex:
+		return searchModeRegex, nil
+	default:
+		return "", &paramError{Param: "mode", Message: fmt.Sprintf("must be prefix or regex, got %q", raw)}
+	}
+}
+
+// adminRequest reports why r may not use an admin-only feature, or nil.
+func adminRequest(r *http.Request) error {
+	if adminToken == "" {
+		if !localPeer(r) {
+			return errNotLocal
+		}
+		return nil
+	}
+	if !validAdminToken(r) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// compileSearchRegex validates and compiles q, and returns the literal
+// text every match must start with, if the pattern pins one.
+func compileSearchRegex(q string) (*regexp.Regexp, string, error) {
+	parsed, err := syntax.Parse(q, syntax.Perl)
+	if err != nil {
+		return nil, "", &paramError{Param: "q", Message: err.Error()}
+	}
+	parsed = parsed.Simplify()
+	prog, err := syntax.Compile(parsed)
+	if err != nil {
+		return nil, "", &paramError{Param: "q", Message: err.Error()}
+	}
+	if len(prog.Inst) > searchRegexMaxProgram {
+		return nil, "", &paramError{Param: "q", Message: fmt.Sprintf("pattern is too complex (%d instructions, at most %d)", len(prog.Inst), searchRegexMaxProgram)}
+	}
+	re, err := regexp.Compile(q)
+	if err != nil {
+		return nil, "", &paramError{Param: "q", Message: err.Error()}
+	}
+	return re, anchoredPrefix(parsed), nil
+}
+
+// anchoredPrefix is the case-sensitive literal text after a leading ^.
+func anchoredPrefix(re *syntax.Regexp) string {
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	var prefix []rune
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix = append(prefix, sub.Rune...)
+	}
+	return string(prefix)
+}
+
+// searchRegex serves ?mode=regex for either search handler.
+func searchRegex(r *http.Request, by, q string, page, limit int) ([]User, int, int, error) {
+	if err := adminRequest(r); err != nil {
+		return nil, 0, 0, err
+	}
+	re, prefix, err := compileSearchRegex(q)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), searchRegexTimeout)
+	defer cancel()
+	users, total, totalPages, err := userStore.SearchRegex(ctx, re, prefix, by == searchByID, page, limit)
+	if errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil {
+		return nil, 0, 0, fmt.Errorf("%w after %v", errSearchTimeout, searchRegexTimeout)
+	}
+	return users, total, totalPages, err
+}
+
+// SearchRegex pages through the users whose username (or ID) matches re,
+// scanning only those starting with prefix.
+func (s *UserStore) SearchRegex(ctx context.Context, re *regexp.Regexp, prefix string, byID bool, page, limit int) ([]User, int, int, error) {
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, 0, err
+	}
+	defer s.RUnlock()
+
+	startTime := time.Now()
+	var candidates []*User
+	var key func(*User) string
+	if byID {
+		candidates, key = s.IDsLocked(), func(u *User) string { return u.ID }
+	} else {
+		candidates, key = s.NamesLocked(), func(u *User) string { return u.UsernameLower }
+		if prefix != "" {
+			prefix = foldUsername(prefix)
+			if bucket := s.BucketLocked(usernameBucket(prefix)); bucket != nil {
+				candidates = bucket
+			}
+		}
+	}
+	if prefix != "" {
+		start := sort.Search(len(candidates), func(i int) bool { return key(candidates[i]) >= prefix })
+		end := start + sort.Search(len(candidates)-start, func(i int) bool {
+			return !strings.HasPrefix(key(candidates[start+i]), prefix)
+		})
+		candidates = candidates[start:end]
+	}
+
+	var matches []*User
+	for i, u := range candidates {
+		if i%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, 0, ctx.Err()
+		}
+		subject := u.Username
+		if byID {
+			subject = u.ID
+		}
+		if re.MatchString(subject) {
+			matches = append(matches, u)
+			if len(matches) >= searchResultLimit {
+				break
+			}
+		}
+	}
+	log.Printf("Search regex %q: candidates=%d, matches=%d, time=%v", re.String(), len(candidates), len(matches), time.Since(startTime))
+
+	total := len(matches)
+	from := (page - 1) * limit
+	if from >= total {
+		return []User{}, total, 0, nil
+	}
+	to := min(from+limit, total)
+	pageUsers := make([]User, to-from)
+	for i, u := range matches[from:to] {
+		pageUsers[i] = *u
+	}
+	return pageUsers, total, (total + limit - 1) / limit, nil
+}
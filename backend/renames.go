@@ -0,0 +1,80 @@
+package main
+
+import "time"
+
+// Renames keep the old name pointing at its user for
+// MATIKS_RENAME_GRACE_DAYS (default 30): /user/rank?username=old answers
+// for the renamed user with a renamedTo hint, and nobody else can take the
+// old name until the grace period ends. Each user's previous names are
+// listed on /users/{id}.
+
+var renameGrace = time.Duration(envInt("MATIKS_RENAME_GRACE_DAYS", 30)) * 24 * time.Hour
+
+// usernameChange is one rename in a user's history.
+type usernameChange struct {
+	Username       string    `json:"username"` // The name given up
+	RenamedAt      time.Time `json:"renamedAt"`
+	RedirectsUntil time.Time `json:"redirectsUntil"`
+
+	userID string
+}
+
+// recordRenameLocked remembers user's old name.
+func (s *UserStore) recordRenameLocked(user *User, oldName string) {
+	change := s.redirectLocked(oldName, user.ID)
+	delete(s.renamed, user.UsernameLower) // Renamed back: the name is live again
+	s.nameHistory[user.ID] = append(s.nameHistory[user.ID], *change)
+}
+
+// redirectLocked points oldName at userID for the grace period, dropping
+// redirects that have run out.
+func (s *UserStore) redirectLocked(oldName, userID string) *usernameChange {
+	now := time.Now()
+	for folded, change := range s.renamed {
+		if !now.Before(change.RedirectsUntil) {
+			delete(s.renamed, folded)
+		}
+	}
+	change := &usernameChange{Username: oldName, RenamedAt: now, RedirectsUntil: now.Add(renameGrace), userID: userID}
+	s.renamed[foldUsername(oldName)] = change
+	return change
+}
+
+// mergeRenamesLocked sends the removed user's name and old names to kept.
+func (s *UserStore) mergeRenamesLocked(kept, removed *User) {
+	for _, change := range s.renamed {
+		if change.userID == removed.ID {
+			change.userID = kept.ID
+		}
+	}
+	s.redirectLocked(removed.Username, kept.ID)
+	delete(s.nameHistory, removed.ID)
+}
+
+// renamedLocked follows an old name to the user now holding it, within
+// the grace period.
+func (s *UserStore) renamedLocked(username string) (*User, *usernameChange, bool) {
+	change, exists := s.renamed[foldUsername(normalizeUsername(username))]
+	if !exists || !time.Now().Before(change.RedirectsUntil) {
+		return nil, nil, false
+	}
+	user, exists := s.GetLocked(change.userID)
+	if !exists {
+		return nil, nil, false
+	}
+	return user, change, true
+}
+
+// nameHeldLocked reports whether username is still redirecting for a user
+// other than userID.
+func (s *UserStore) nameHeldLocked(username, userID string) bool {
+	change, exists := s.renamed[foldUsername(username)]
+	return exists && change.userID != userID && time.Now().Before(change.RedirectsUntil)
+}
+
+// UsernameHistory lists a user's previous names, oldest first.
+func (s *UserStore) UsernameHistory(userID string) []usernameChange {
+	s.RLock()
+	defer s.RUnlock()
+	return append([]usernameChange{}, s.nameHistory[userID]...)
+}
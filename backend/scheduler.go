@@ -0,0 +1,296 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Maintenance jobs run on standard 5-field cron expressions (or @daily,
+// @every 1h, ...) in MATIKS_SCHEDULE_TZ. A spec of "off" disables a job.
+
+// scheduledJob triggers a JobManager job on a cron schedule.
+type scheduledJob struct {
+	name  string
+	spec  string
+	entry cron.EntryID
+}
+
+// Scheduler runs jobs from a JobManager on cron schedules. Run history
+// lives in the manager.
+type Scheduler struct {
+	cron    *cron.Cron
+	manager *JobManager
+	jobs    []*scheduledJob
+}
+
+func NewScheduler(manager *JobManager, loc *time.Location) *Scheduler {
+	return &Scheduler{cron: cron.New(cron.WithLocation(loc)), manager: manager}
+}
+
+// Add registers run with the manager and schedules it. An "off" spec
+// records the job as disabled; it can still be run by hand.
+func (s *Scheduler) Add(name, spec string, run func(ctx context.Context) error) error {
+	s.manager.Register(name, run)
+	job := &scheduledJob{name: name, spec: spec}
+	if spec != "off" {
+		id, err := s.cron.AddFunc(spec, func() { s.fire(name) })
+		if err != nil {
+			return fmt.Errorf("job %s: bad schedule %q: %w", name, spec, err)
+		}
+		job.entry = id
+	}
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// fire starts the job unless its previous run is still going.
+func (s *Scheduler) fire(name string) {
+	if err := s.manager.Run(name); err != nil {
+		log.Printf("Scheduler: %v, skipping this tick", err)
+	}
+}
+
+// Status reports every scheduled job's schedule and last run.
+func (s *Scheduler) Status() []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		status, err := s.manager.JobStatus(job.name)
+		if err != nil {
+			continue
+		}
+		status["schedule"] = job.spec
+		status["enabled"] = job.entry != 0
+		if job.entry != 0 {
+			status["nextRun"] = s.cron.Entry(job.entry).Next.Unix()
+		}
+		out = append(out, status)
+	}
+	return out
+}
+
+var scheduler *Scheduler
+
+// scheduleLocation is MATIKS_SCHEDULE_TZ, which also draws day boundaries
+// for daily analytics.
+var scheduleLocation = loadScheduleLocation()
+
+func loadScheduleLocation() *time.Location {
+	loc, err := time.LoadLocation(envString("MATIKS_SCHEDULE_TZ", "UTC"))
+	if err != nil {
+		log.Printf("Scheduler: %v, using UTC", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// startScheduler registers the built-in jobs from MATIKS_SCHEDULE_*.
+func startScheduler(store *UserStore) {
+	loc := scheduleLocation
+	scheduler = NewScheduler(jobs, loc)
+
+	dataDir := envString("MATIKS_DATA_DIR", "data")
+	keep := envInt("MATIKS_SOFT_RESET_KEEP_PERCENT", 50)
+	snapshots := envInt("MATIKS_SNAPSHOT_RETAIN", 7)
+
+	builtin := []struct {
+		name, env, spec string
+		run             func(ctx context.Context) error
+	}{
+		{"soft-reset", "MATIKS_SCHEDULE_SOFT_RESET", "0 0 * * 1", func(ctx context.Context) error {
+			return store.SoftReset(keep)
+		}},
+		{"snapshot", "MATIKS_SCHEDULE_SNAPSHOT", "0 3 * * *", func(ctx context.Context) error {
+			return store.WriteSnapshot(filepath.Join(dataDir, "snapshots"), snapshots)
+		}},
+		{"archive", "MATIKS_SCHEDULE_ARCHIVE", "0 0 1 * *", func(ctx context.Context) error {
+			return store.ArchiveStandings(archives, time.Now().In(loc))
+		}},
+		{"purge", "MATIKS_SCHEDULE_PURGE", "@hourly", func(ctx context.Context) error {
+			store.PurgeDeleted(time.Now())
+			return nil
+		}},
+		{"expire-inactive", "MATIKS_SCHEDULE_EXPIRE_INACTIVE", "@hourly", func(ctx context.Context) error {
+			_, err := store.ExpireInactive(ctx, inactivePolicy, time.Now())
+			return err
+		}},
+		{"distribution-history", "MATIKS_SCHEDULE_DISTRIBUTION_HISTORY", "@hourly", func(ctx context.Context) error {
+			return store.SampleDistribution(histories, time.Now())
+		}},
+		{"daily-analytics", "MATIKS_SCHEDULE_DAILY_ANALYTICS", "5 0 * * *", func(ctx context.Context) error {
+			return analytics.CloseDays(time.Now())
+		}},
+	}
+	for _, job := range builtin {
+		if err := scheduler.Add(job.name, envString(job.env, job.spec), job.run); err != nil {
+			log.Printf("Scheduler: %v", err)
+		}
+	}
+	scheduler.Start()
+}
+
+// SoftReset pulls every rating toward the mean, keeping keepPercent of each
+// user's distance from it. Runs only where the simulator would.
+func (s *UserStore) SoftReset(keepPercent int) error {
+	if replica != nil || (simulatorLease != nil && !simulatorLease.Held()) || checkWritable() != nil {
+		return errJobSkipped
+	}
+
+	s.RLock()
+	var sum int64
+	for _, u := range s.RankedLocked() {
+		sum += int64(u.Rating)
+	}
+	var updates []ratingUpdate
+	if n := len(s.RankedLocked()); n > 0 {
+		mean := float64(sum) / float64(n)
+		for _, u := range s.RankedLocked() {
+			rating := int(math.Round(mean + (float64(u.Rating)-mean)*float64(keepPercent)/100))
+			rating = scores.Clamp(rating)
+			if rating != u.Rating {
+				updates = append(updates, ratingUpdate{UserID: u.ID, Rating: rating})
+			}
+		}
+	}
+	s.RUnlock()
+
+	if len(updates) == 0 {
+		return nil
+	}
+	if s.replicator != nil {
+		s.updates.record(sourceSchedule, len(updates), 0)
+		return s.replicator.Replicate(updates)
+	}
+	s.applyRatingUpdates(updates, sourceSchedule)
+	return nil
+}
+
+// WriteSnapshot saves the dataset to dir and prunes all but the newest
+// retain snapshots.
+func (s *UserStore) WriteSnapshot(dir string, retain int) error {
+	name := fmt.Sprintf("snapshot-%s.json", time.Now().UTC().Format("20060102-150405"))
+	if err := writeJSONFile(dir, name, s.snapshotUsers()); err != nil {
+		return err
+	}
+
+	old, err := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	if err != nil || len(old) <= retain {
+		return err
+	}
+	sort.Strings(old) // Timestamped names sort oldest first
+	for _, path := range old[:len(old)-retain] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveStandings saves the full ranked leaderboard for the month that
+// just ended (the job runs on the 1st) as that season's archive.
+func (s *UserStore) ArchiveStandings(archives *archiveStore, now time.Time) error {
+	s.Lock()
+	if s.StaleLocked() {
+		s.SortLocked()
+	}
+	standings := make([]User, len(s.RankedLocked()))
+	for i, u := range s.RankedLocked() {
+		standings[i] = *u
+	}
+	s.Unlock()
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	period := monthStart.AddDate(0, -1, 0).Format("2006-01")
+	err := writeJSONFile(archives.dir, archiveName(period), map[string]interface{}{
+		"period":     period,
+		"archivedAt": now.Unix(),
+		"users":      standings,
+	})
+	archives.forget(period)
+	return err
+}
+
+// writeJSONFile writes v to dir/name via a temp file, so readers never see
+// a partial file. Names ending in .gz are gzipped.
+func writeJSONFile(dir, name string, v interface{}) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".json")+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after the rename
+
+	if err := tmp.Chmod(0o644); err != nil { // CreateTemp uses 0600
+		tmp.Close()
+		return err
+	}
+	var out io.Writer = tmp
+	var gz *gzip.Writer
+	if strings.HasSuffix(name, ".gz") {
+		gz = gzip.NewWriter(tmp)
+		out = gz
+	}
+	if err := json.NewEncoder(out).Encode(v); err != nil {
+		tmp.Close()
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// readJSONFile decodes dir/name, written by writeJSONFile, into v.
+func readJSONFile(dir, name string, v interface{}) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+	if err := json.NewDecoder(in).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func adminScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"jobs":      scheduler.Status(),
+		"timestamp": time.Now().Unix(),
+	})
+}
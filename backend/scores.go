@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"matiks-leaderboard/utils"
+)
+
+// MATIKS_SCORE_TYPE picks what a user's Rating holds:
+//
+//   - int (default): ratings in [100, 5000], as before.
+//   - int64: 64-bit counters for cumulative scores, in [0, MaxInt64].
+//   - float: decimals, e.g. solve times, with MATIKS_SCORE_DECIMALS (default
+//     3) digits after the point.
+//
+// Rating stays an int in every case. Floats are stored fixed-point, as the
+// score times 10^decimals, so ties are exact at the configured precision
+// and the sort never compares floats. Scores are converted only where they
+// cross the API: rendered as decimals in JSON and parsed from parameters
+// and event payloads. Snapshots and raft entries carry the stored ints.
+//
+// That is the rating board's score type. Metric boards rank raw metric
+// values unless MATIKS_SCORE_TYPE_<BOARD> (and MATIKS_SCORE_DECIMALS_<BOARD>
+// for float) gives the board one of its own, which rounds its values to
+// that precision so ties there are exact too (see metrics.go).
+
+type scoreKind string
+
+const (
+	scoreInt   scoreKind = "int"
+	scoreInt64 scoreKind = "int64"
+	scoreFloat scoreKind = "float"
+)
+
+// ScoreFormat describes the configured score type.
+type ScoreFormat struct {
+	Kind     scoreKind
+	Decimals int // float only
+	unit     int // Stored value of 1.0
+	Min, Max int // Stored bounds for generated and accepted scores
+	Default  int // New users without a score
+}
+
+var scores = envScoreFormat("MATIKS_SCORE_TYPE", "MATIKS_SCORE_DECIMALS", string(scoreInt))
+
+// envScoreFormat is the score type in typeKey and decimalsKey, or nil when
+// typeKey is unset and def is empty.
+func envScoreFormat(typeKey, decimalsKey, def string) *ScoreFormat {
+	kind := envString(typeKey, def)
+	if kind == "" {
+		return nil
+	}
+	f, err := parseScoreFormat(kind, envInt(decimalsKey, 3))
+	if err != nil {
+		log.Fatalf("%s: %v", typeKey, err)
+	}
+	return f
+}
+
+func parseScoreFormat(kind string, decimals int) (*ScoreFormat, error) {
+	f := &ScoreFormat{Kind: scoreKind(kind), unit: 1}
+	switch f.Kind {
+	case scoreInt:
+		f.Min, f.Max = utils.MinRating, utils.MaxRating
+		f.Default = (f.Min + f.Max) / 2
+	case scoreInt64:
+		f.Min, f.Max = 0, math.MaxInt64 // Counters start at zero
+	case scoreFloat:
+		if decimals < 0 || decimals > 9 {
+			return nil, fmt.Errorf("decimals must be between 0 and 9, got %d", decimals)
+		}
+		f.Decimals = decimals
+		for i := 0; i < decimals; i++ {
+			f.unit *= 10
+		}
+		f.Min, f.Max = utils.MinRating*f.unit, utils.MaxRating*f.unit
+		f.Default = (f.Min + f.Max) / 2
+	default:
+		return nil, fmt.Errorf("score type must be %s, %s or %s, got %q", scoreInt, scoreInt64, scoreFloat, kind)
+	}
+	return f, nil
+}
+
+// Append writes a stored score as a JSON number.
+func (f *ScoreFormat) Append(buf []byte, v int) []byte {
+	if f.unit == 1 {
+		return strconv.AppendInt(buf, int64(v), 10)
+	}
+	if v < 0 {
+		buf = append(buf, '-')
+		v = -v // Stored scores are clamped well inside the int range
+	}
+	buf = strconv.AppendInt(buf, int64(v/f.unit), 10)
+	frac := v % f.unit
+	if frac == 0 {
+		return buf
+	}
+	digits := strconv.Itoa(frac)
+	digits = strings.Repeat("0", f.Decimals-len(digits)) + digits
+	buf = append(buf, '.')
+	return append(buf, strings.TrimRight(digits, "0")...)
+}
+
+// Number is a stored score as a json.Number, for map-built responses.
+func (f *ScoreFormat) Number(v int) json.Number {
+	return json.Number(f.Append(nil, v))
+}
+
+// Parse reads a score as a client writes it. Floats round to the
+// configured precision.
+func (f *ScoreFormat) Parse(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if f.unit == 1 {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("must be an integer, got %q", raw)
+		}
+		return int(v), nil
+	}
+	r, ok := new(big.Rat).SetString(raw)
+	if !ok {
+		return 0, fmt.Errorf("must be a number, got %q", raw)
+	}
+	r.Mul(r, new(big.Rat).SetInt64(int64(f.unit)))
+	v, _ := r.Float64()
+	if math.Abs(v) > math.MaxInt64/2 {
+		return 0, fmt.Errorf("is out of range, got %q", raw)
+	}
+	return int(math.Round(v)), nil
+}
+
+// Float is a stored score as the number it stands for.
+func (f *ScoreFormat) Float(v int) float64 {
+	return float64(v) / float64(f.unit)
+}
+
+// Units scales a whole-number amount (such as a simulator step) to stored
+// units.
+func (f *ScoreFormat) Units(n int) int {
+	return n * f.unit
+}
+
+// Add is Clamp(v + delta), saturating rather than overflowing when v is
+// near the bounds, as int64 counters can be.
+func (f *ScoreFormat) Add(v, delta int) int {
+	switch {
+	case delta > 0 && v > f.Max-delta:
+		return f.Max
+	case delta < 0 && v < f.Min-delta:
+		return f.Min
+	}
+	return f.Clamp(v + delta)
+}
+
+// Round rounds a raw value to this score type's precision.
+func (f *ScoreFormat) Round(v float64) float64 {
+	if f.unit == 1 {
+		return math.Round(v)
+	}
+	return math.Round(v*float64(f.unit)) / float64(f.unit)
+}
+
+// Clamp keeps a stored score within the bounds.
+func (f *ScoreFormat) Clamp(v int) int {
+	return utils.ClampRating(v, f.Min, f.Max)
+}
+
+// Describe reports the score type for /stats.
+func (f *ScoreFormat) Describe() map[string]interface{} {
+	out := map[string]interface{}{"type": f.Kind}
+	if f.Kind == scoreFloat {
+		out["decimals"] = f.Decimals
+	}
+	return out
+}
+
+func (f *ScoreFormat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Describe())
+}
+
+// scoreNumber is a stored score that is a decimal number on the wire.
+type scoreNumber int
+
+func (n scoreNumber) MarshalJSON() ([]byte, error) {
+	return scores.Append(nil, int(n)), nil
+}
+
+func (n *scoreNumber) UnmarshalJSON(data []byte) error {
+	v, err := scores.Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("score %s", err)
+	}
+	*n = scoreNumber(v)
+	return nil
+}
+
+// scaledRating draws whole-unit ratings and spreads them across the
+// fractional digits, so generated float boards are not all ties.
+type scaledRating struct {
+	inner utils.RatingDistribution
+	unit  int
+}
+
+func (d scaledRating) Rating(rng *rand.Rand) int {
+	return d.inner.Rating(rng)*d.unit + rng.Intn(d.unit)
+}
+
+// newSeeder is utils.NewSeeder producing scores of the configured type.
+func newSeeder(seed int64) *utils.Seeder {
+	if scores.unit == 1 {
+		return utils.NewSeeder(seed)
+	}
+	inner := utils.UniformRating{Min: utils.MinRating, Max: utils.MaxRating - 1}
+	return utils.NewSeeder(seed, utils.WithRatingDistribution(scaledRating{inner: inner, unit: scores.unit}))
+}
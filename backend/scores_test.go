@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreFormatAdd(t *testing.T) {
+	mustFormat := func(kind string, decimals int) *ScoreFormat {
+		f, err := parseScoreFormat(kind, decimals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	ints, counters := mustFormat("int", 0), mustFormat("int64", 0)
+	tests := []struct {
+		name           string
+		f              *ScoreFormat
+		v, delta, want int
+	}{
+		{"int within", ints, 1500, 200, 1700},
+		{"int above", ints, 4900, 200, 5000},
+		{"int below", ints, 150, -200, 100},
+		{"int64 within", counters, 10, 5, 15},
+		{"int64 at max", counters, math.MaxInt64, 1, math.MaxInt64},
+		{"int64 near max", counters, math.MaxInt64 - 10, 200, math.MaxInt64},
+		{"int64 below zero", counters, 5, -200, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Add(tt.v, tt.delta); got != tt.want {
+			t.Errorf("%s: Add(%d, %d) = %d, want %d", tt.name, tt.v, tt.delta, got, tt.want)
+		}
+	}
+}
+
+func TestScoreFormatRound(t *testing.T) {
+	floats, err := parseScoreFormat("float", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := floats.Round(0.12345); got != 0.12 {
+		t.Errorf("float/2 Round(0.12345) = %v", got)
+	}
+	ints, _ := parseScoreFormat("int", 0)
+	if got := ints.Round(41.6); got != 42 {
+		t.Errorf("int Round(41.6) = %v", got)
+	}
+	if _, err := parseScoreFormat("decimal", 0); err == nil {
+		t.Error("parsed an unknown score type")
+	}
+}
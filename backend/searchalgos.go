@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// Username search has two strategies in the leaderboard package (see its
+// search.go):
+//
+//   - bucket: binary search in the first-character bucket, or in the whole
+//     name-sorted list without one.
+//   - trie: a byte trie of folded usernames, walked from the query's node.
+//
+// Both return the same users in the same order. MATIKS_SEARCH_ALGO picks
+// the default (bucket), and MATIKS_SEARCH_TRIE_PERCENT sends that share of
+// the other searches, chosen at random per request, to the trie instead,
+// unless the default already is the trie. ?algo=bucket|trie picks one for
+// a request. Both need the search-algorithms feature flag (see
+// features.go). Each algorithm's lookups are counted with their result
+// counts and latencies, from the lookup alone and not the lock wait or a
+// pending sort, in the "algorithms" section of GET /admin/search-analytics.
+//
+// The trie costs a node per distinct folded-name byte; large boards can
+// skip it with MATIKS_SEARCH_TRIE=false, which leaves only the buckets.
+
+type searchStrategy = leaderboard.SearchStrategy
+
+var searchStrategies = []searchStrategy{leaderboard.SearchBucket, leaderboard.SearchTrie}
+
+var searchTrieEnabled = envBool("MATIKS_SEARCH_TRIE", true)
+
+// searchRollout picks the strategy for requests without ?algo=.
+var searchRollout = struct {
+	standard    searchStrategy
+	triePercent int
+}{
+	standard:    lookupSearchStrategy(envString("MATIKS_SEARCH_ALGO", "bucket")),
+	triePercent: envInt("MATIKS_SEARCH_TRIE_PERCENT", 0),
+}
+
+func lookupSearchStrategy(name string) searchStrategy {
+	for _, algo := range searchStrategies {
+		if algo.Name() == name && (searchTrieEnabled || name != "trie") {
+			return algo
+		}
+	}
+	log.Printf("Search: unknown or disabled MATIKS_SEARCH_ALGO %q, using bucket", name)
+	return leaderboard.SearchBucket
+}
+
+// searchAlgoParam reads ?algo=, or rolls the request out to a strategy.
+func searchAlgoParam(r *http.Request) (searchStrategy, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("algo"))
+	if raw == "" {
+		if featureSearchAlgorithms.Enabled() && searchTrieEnabled && searchRollout.triePercent > 0 && rand.Intn(100) < searchRollout.triePercent {
+			return leaderboard.SearchTrie, nil
+		}
+		return searchRollout.standard, nil
+	}
+	if !featureSearchAlgorithms.Enabled() {
+		return 0, featureParamError("algo", featureSearchAlgorithms)
+	}
+	for _, algo := range searchStrategies {
+		if algo.Name() == raw {
+			if raw == "trie" && !searchTrieEnabled {
+				return 0, &paramError{Param: "algo", Message: "trie is disabled (MATIKS_SEARCH_TRIE=false)"}
+			}
+			return algo, nil
+		}
+	}
+	return 0, &paramError{Param: "algo", Message: fmt.Sprintf("must be bucket or trie, got %q", raw)}
+}
+
+// searchLatencyBuckets are powers of two microseconds; the last is open.
+const searchLatencyBuckets = 24
+
+type searchAlgoStats struct {
+	lookups, results, zero int64
+	total, max             time.Duration
+	latency                [searchLatencyBuckets]int64
+}
+
+type searchAlgoMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*searchAlgoStats
+}
+
+var searchAlgos = &searchAlgoMetrics{stats: make(map[string]*searchAlgoStats)}
+
+func (m *searchAlgoMetrics) record(algo string, results int, took time.Duration) {
+	bucket := bits.Len64(uint64(took / time.Microsecond))
+	if bucket >= searchLatencyBuckets {
+		bucket = searchLatencyBuckets - 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.stats[algo]
+	if st == nil {
+		st = &searchAlgoStats{}
+		m.stats[algo] = st
+	}
+	st.lookups++
+	st.results += int64(results)
+	if results == 0 {
+		st.zero++
+	}
+	st.total += took
+	if took > st.max {
+		st.max = took
+	}
+	st.latency[bucket]++
+}
+
+// quantileMs is the upper bound of the latency bucket holding quantile q.
+func (st *searchAlgoStats) quantileMs(q float64) float64 {
+	want := int64(q*float64(st.lookups) + 0.5)
+	if want < 1 {
+		want = 1
+	}
+	var seen int64
+	for i, n := range st.latency {
+		if seen += n; seen >= want {
+			return float64(uint64(1)<<uint(i)) / 1000
+		}
+	}
+	return float64(st.max) / float64(time.Millisecond)
+}
+
+func (m *searchAlgoMetrics) Report() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byAlgo := make(map[string]interface{}, len(searchStrategies))
+	for _, algo := range searchStrategies {
+		st := m.stats[algo.Name()]
+		if st == nil {
+			st = &searchAlgoStats{}
+		}
+		report := map[string]interface{}{
+			"lookups":     st.lookups,
+			"zeroResults": st.zero,
+		}
+		if st.lookups > 0 {
+			report["avgResults"] = float64(st.results) / float64(st.lookups)
+			report["avgLatencyMs"] = float64(st.total) / float64(st.lookups) / float64(time.Millisecond)
+			report["maxLatencyMs"] = float64(st.max) / float64(time.Millisecond)
+			report["p50LatencyMs"] = st.quantileMs(0.5)
+			report["p99LatencyMs"] = st.quantileMs(0.99)
+		}
+		byAlgo[algo.Name()] = report
+	}
+	return map[string]interface{}{
+		"default":     searchRollout.standard.Name(),
+		"triePercent": searchRollout.triePercent,
+		"trieEnabled": searchTrieEnabled,
+		"byAlgorithm": byAlgo,
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GET /admin/search-analytics?top=20 reports how search is used, to tune the
+// name pools and first-character bucketing against real traffic: the most
+// frequent queries, the most frequent ones that found nobody, average result
+// counts, how searches spread over the buckets, and how the search
+// algorithms compare (see searchalgos.go).
+//
+// Queries are folded like usernames and cut to their first
+// MATIKS_SEARCH_ANALYTICS_PREFIX characters (default 4), so what is kept is
+// a prefix many users share rather than anyone's full name. At most
+// MATIKS_SEARCH_ANALYTICS_MAX_QUERIES prefixes (default 1000) are tracked;
+// searches for new ones after that only count toward the totals. Only the
+// first page of a search is counted; paging through it is not a new search.
+
+type queryStats struct {
+	Query   string  `json:"query"`
+	Count   int64   `json:"count"`
+	Zero    int64   `json:"zeroResults"`
+	Results int64   `json:"-"`
+	Avg     float64 `json:"avgResults"`
+}
+
+type searchAnalytics struct {
+	prefixLen  int
+	maxQueries int
+
+	mu        sync.Mutex
+	searches  int64
+	results   int64
+	zero      int64
+	tooShort  int64 // Below the two-column minimum, answered without a lookup
+	capped    int64 // Stopped at searchResultLimit
+	fullScans int64 // No bucket for the first character
+	untracked int64 // Prefixes beyond maxQueries
+	byBucket  map[string]int64
+	queries   map[string]*queryStats
+	since     time.Time
+}
+
+var searchStats = &searchAnalytics{
+	prefixLen:  envInt("MATIKS_SEARCH_ANALYTICS_PREFIX", 4),
+	maxQueries: envInt("MATIKS_SEARCH_ANALYTICS_MAX_QUERIES", 1000),
+	byBucket:   make(map[string]int64),
+	queries:    make(map[string]*queryStats),
+	since:      time.Now(),
+}
+
+// truncate keeps the first prefixLen characters of a folded query.
+func (a *searchAnalytics) truncate(folded string) string {
+	n := 0
+	for i := range folded {
+		if n == a.prefixLen {
+			return folded[:i]
+		}
+		n++
+	}
+	return folded
+}
+
+// record counts one search for the folded query that found results
+// matches; bucketed says whether a first-character bucket served it.
+func (a *searchAnalytics) record(folded string, results int, bucketed bool) {
+	key := a.truncate(folded)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.searches++
+	a.results += int64(results)
+	if results == 0 {
+		a.zero++
+	}
+	if results >= searchResultLimit {
+		a.capped++
+	}
+	if bucketed {
+		a.byBucket[string(usernameBucket(folded))]++
+	} else {
+		a.fullScans++
+	}
+
+	q := a.queries[key]
+	if q == nil {
+		if len(a.queries) >= a.maxQueries {
+			a.untracked++
+			return
+		}
+		q = &queryStats{Query: key}
+		a.queries[key] = q
+	}
+	q.Count++
+	q.Results += int64(results)
+	if results == 0 {
+		q.Zero++
+	}
+}
+
+func (a *searchAnalytics) recordTooShort() {
+	a.mu.Lock()
+	a.searches++
+	a.tooShort++
+	a.mu.Unlock()
+}
+
+// Report returns totals with the top most searched and most often empty
+// prefixes.
+func (a *searchAnalytics) Report(top int) map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	all := make([]queryStats, 0, len(a.queries))
+	for _, q := range a.queries {
+		s := *q
+		s.Avg = float64(s.Results) / float64(s.Count)
+		all = append(all, s)
+	}
+	topBy := func(n func(queryStats) int64) []queryStats {
+		sorted := append([]queryStats(nil), all...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if n(sorted[i]) != n(sorted[j]) {
+				return n(sorted[i]) > n(sorted[j])
+			}
+			return sorted[i].Query < sorted[j].Query
+		})
+		for len(sorted) > 0 && n(sorted[len(sorted)-1]) == 0 {
+			sorted = sorted[:len(sorted)-1]
+		}
+		if len(sorted) > top {
+			sorted = sorted[:top]
+		}
+		return sorted
+	}
+
+	looked := a.searches - a.tooShort
+	avg := 0.0
+	if looked > 0 {
+		avg = float64(a.results) / float64(looked)
+	}
+	byBucket := make(map[string]int64, len(a.byBucket))
+	for b, n := range a.byBucket {
+		byBucket[b] = n
+	}
+	return map[string]interface{}{
+		"since":             a.since.Unix(),
+		"searches":          a.searches,
+		"avgResults":        avg,
+		"zeroResults":       a.zero,
+		"tooShort":          a.tooShort,
+		"capped":            a.capped,
+		"fullScans":         a.fullScans,
+		"byBucket":          byBucket,
+		"trackedQueries":    len(a.queries),
+		"untracked":         a.untracked,
+		"prefixLength":      a.prefixLen,
+		"topQueries":        topBy(func(q queryStats) int64 { return q.Count }),
+		"topZeroResults":    topBy(func(q queryStats) int64 { return q.Zero }),
+		"resultLimit":       searchResultLimit,
+		"maxTrackedQueries": a.maxQueries,
+		"algorithms":        searchAlgos.Report(),
+	}
+}
+
+func adminSearchAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	top, err := intParam(r, "top", 20, 1, 100)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+	response := searchStats.Report(top)
+	response["success"] = true
+	response["timestamp"] = time.Now().Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// The API speaks HTTP/1.1 and HTTP/2 on MATIKS_ADDR. The server doesn't
+// terminate TLS, so HTTP/2 is cleartext h2c: a client with prior knowledge
+// (a proxy with h2c upstreams, curl --http2-prior-knowledge) opens with
+// the HTTP/2 preface, and an HTTP/1.1 client may send Upgrade: h2c. A
+// frontend polling the leaderboard, search and rank then multiplexes them
+// over one connection instead of opening one per request. Websockets and
+// streams carry on over HTTP/1.1 as before.
+//
+// MATIKS_H2C=false serves HTTP/1.1 only. MATIKS_H2_MAX_STREAMS (default
+// 250) caps the concurrent requests on one connection, and idle
+// connections close after MATIKS_HTTP_IDLE_TIMEOUT (default 2m).
+//
+// MATIKS_UNIX_SOCKET adds a listener on a Unix socket at that path, for a
+// reverse proxy on the same host, and MATIKS_ADDR=off drops TCP to serve
+// on the socket alone. The socket is created with MATIKS_UNIX_SOCKET_MODE
+// (octal, default 0660), and one left behind by an earlier run is
+// replaced. Requests over it have no remote address, so a rate-limited
+// proxy should pass the client's in MATIKS_RATE_LIMIT_CLIENT_HEADER.
+//
+// MATIKS_ADMIN_ADDR (e.g. "127.0.0.1:9090") moves the admin group, that is
+// /admin/..., /v1/admin/..., /metrics and the Go profiler at /debug/pprof/,
+// to a listener of its own, and the public listeners answer those paths
+// with 404. Bind it to localhost or a private interface to keep operators'
+// routes off the internet; the admin token still applies. The profiler is
+// only ever served there. The admin listener also answers the health
+// probes.
+//
+// Experimental: MATIKS_HTTP3_ADDR (e.g. ":8443") adds an HTTP/3 listener
+// over QUIC on that UDP port, for mobile clients on lossy networks, where
+// a lost packet stalls only its own request. QUIC is always encrypted, so
+// it needs MATIKS_HTTP3_CERT and MATIKS_HTTP3_KEY (PEM files). It serves
+// the same routes and middleware, and responses over TCP advertise it in
+// Alt-Svc. A listener that fails to start is logged and the TCP server
+// carries on. /stats "latency" breaks requests down by protocol.
+
+// http3Server is nil unless MATIKS_HTTP3_ADDR is set.
+var http3Server *http3.Server
+
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	if http3Server != nil {
+		handler = advertiseHTTP3(handler)
+	}
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		IdleTimeout: envDuration("MATIKS_HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+	if !envBool("MATIKS_H2C", true) {
+		return srv
+	}
+	h2 := &http2.Server{
+		MaxConcurrentStreams: uint32(envInt("MATIKS_H2_MAX_STREAMS", 250)),
+		IdleTimeout:          srv.IdleTimeout,
+	}
+	srv.Handler = h2c.NewHandler(handler, h2)
+	log.Printf("HTTP: h2c enabled, up to %d streams per connection", h2.MaxConcurrentStreams)
+	return srv
+}
+
+// adminAddr is empty when admin routes share the public listeners.
+var adminAddr = envString("MATIKS_ADMIN_ADDR", "")
+
+// publicHandler keeps the profiler, and with a separate admin listener the
+// whole admin group, off the public listeners. net/http/pprof registers
+// itself on the default mux, so it has to be hidden rather than left out.
+func publicHandler(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/pprof/") || adminAddr != "" && routeGroup(r.URL.Path) == "admin" {
+			http.NotFound(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// startAdminListener serves the admin group on MATIKS_ADMIN_ADDR.
+func startAdminListener() {
+	if adminAddr == "" {
+		return
+	}
+	admin := routeChains["admin"]
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", admin.then(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", admin.then(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", admin.then(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", admin.then(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", admin.then(pprof.Trace))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch routeGroup(r.URL.Path) {
+		case "admin", "probe":
+			http.DefaultServeMux.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv := &http.Server{
+		Addr:        adminAddr,
+		Handler:     mux,
+		IdleTimeout: envDuration("MATIKS_HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+	ln, err := net.Listen("tcp", adminAddr)
+	if err != nil {
+		log.Fatalf("Admin listener: %v", err)
+	}
+	log.Printf("HTTP: admin routes on %s only", adminAddr)
+	go func() { log.Fatalf("Admin listener: %v", srv.Serve(ln)) }()
+}
+
+// serveHTTP serves srv on its TCP address and the Unix socket, whichever
+// are configured, until one of them fails.
+func serveHTTP(srv *http.Server) error {
+	var listeners []net.Listener
+	if srv.Addr != "off" {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+	if path := envString("MATIKS_UNIX_SOCKET", ""); path != "" {
+		ln, err := listenUnix(path)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, ln)
+		log.Printf("HTTP: listening on unix socket %s", path)
+	}
+	if len(listeners) == 0 {
+		return errors.New("MATIKS_ADDR=off needs MATIKS_UNIX_SOCKET")
+	}
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) { errs <- srv.Serve(ln) }(ln)
+	}
+	return <-errs
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(envString("MATIKS_UNIX_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("MATIKS_UNIX_SOCKET_MODE: %w", err)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("MATIKS_UNIX_SOCKET: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// startHTTP3 starts the MATIKS_HTTP3_ADDR listener, if configured.
+func startHTTP3(handler http.Handler) {
+	addr := envString("MATIKS_HTTP3_ADDR", "")
+	if addr == "" {
+		return
+	}
+	cert, key := envString("MATIKS_HTTP3_CERT", ""), envString("MATIKS_HTTP3_KEY", "")
+	if cert == "" || key == "" {
+		log.Printf("HTTP/3: MATIKS_HTTP3_ADDR needs MATIKS_HTTP3_CERT and MATIKS_HTTP3_KEY, not starting")
+		return
+	}
+	http3Server = &http3.Server{
+		Addr:        addr,
+		Handler:     handler,
+		IdleTimeout: envDuration("MATIKS_HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+	go func() {
+		log.Printf("HTTP/3: listening on %s (udp)", addr)
+		if err := http3Server.ListenAndServeTLS(cert, key); err != nil {
+			log.Printf("HTTP/3: %v", err)
+		}
+	}()
+}
+
+// advertiseHTTP3 adds the Alt-Svc header pointing TCP clients at HTTP/3.
+func advertiseHTTP3(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http3Server.SetQUICHeaders(w.Header()) // Fails only until it listens
+		next.ServeHTTP(w, r)
+	})
+}
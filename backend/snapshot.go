@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/leaderboard"
+)
+
+// /leaderboard?snapshot=1 pins page-by-page scrolling to one version of the
+// leaderboard: the server copies the sorted list, answers the page from
+// the copy, and returns a snapshot token. Passing ?snapshot=<token> on the
+// following pages reads the same copy, so nobody moves between pages while
+// updates pour in. Tokens live for MATIKS_SNAPSHOT_TTL (default 10s) from
+// when the copy was taken; later pages get 410 and the client starts over
+// from snapshot=1. Requests arriving while nothing has changed share one
+// copy, and at most MATIKS_SNAPSHOT_MAX copies (default 8) are kept, the
+// oldest dropped first. Snapshots page by number; cursors never go stale
+// the same way and do not combine with them.
+
+var errSnapshotExpired = errors.New("snapshot has expired; start again with snapshot=1")
+
+type leaderboardSnapshot struct {
+	id           string
+	head, resets uint64 // Change log position the copy was taken at
+	expires      time.Time
+	users        []*User // Copies, in leaderboard order
+	pendingSorts int64
+}
+
+type snapshotStore struct {
+	ttl time.Duration
+	max int
+
+	mu     sync.Mutex
+	byID   map[string]*leaderboardSnapshot
+	order  []*leaderboardSnapshot // Oldest first
+	taken  int64
+	shared int64
+}
+
+var snapshots = &snapshotStore{
+	ttl:  envDuration("MATIKS_SNAPSHOT_TTL", 10*time.Second),
+	max:  envInt("MATIKS_SNAPSHOT_MAX", 8),
+	byID: make(map[string]*leaderboardSnapshot),
+}
+
+// snapshotParam reads ?snapshot=: nil without one, a fresh (or shared)
+// snapshot for 1, and the pinned one for a token.
+func snapshotParam(r *http.Request) (*leaderboardSnapshot, error) {
+	q := r.URL.Query()
+	raw := q.Get("snapshot")
+	if raw == "" || raw == "0" {
+		return nil, nil
+	}
+	if !featureSnapshotReads.Enabled() {
+		return nil, featureParamError("snapshot", featureSnapshotReads)
+	}
+	if _, cursored := q["cursor"]; cursored {
+		return nil, &paramError{Param: "snapshot", Message: "pages by number; pass either snapshot or cursor, not both"}
+	}
+	if raw == "1" {
+		return snapshots.take(r.Context(), userStore)
+	}
+	return snapshots.get(raw)
+}
+
+func (st *snapshotStore) get(id string) (*leaderboardSnapshot, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snap := st.byID[id]
+	if snap == nil || time.Now().After(snap.expires) {
+		return nil, errSnapshotExpired
+	}
+	return snap, nil
+}
+
+// take copies the sorted leaderboard, or returns the newest copy if nothing
+// has changed since it was taken.
+func (st *snapshotStore) take(ctx context.Context, s *UserStore) (*leaderboardSnapshot, error) {
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+	head, resets := s.changes.head(), s.changes.resetCount()
+	now := time.Now()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.dropExpiredLocked(now)
+	if n := len(st.order); n > 0 {
+		if latest := st.order[n-1]; latest.head == head && latest.resets == resets {
+			st.shared++
+			return latest, nil
+		}
+	}
+
+	copies := make([]User, len(s.RankedLocked()))
+	users := make([]*User, len(s.RankedLocked()))
+	for i, u := range s.RankedLocked() {
+		copies[i] = *u
+		users[i] = &copies[i]
+	}
+	var raw [8]byte
+	rand.Read(raw[:])
+	snap := &leaderboardSnapshot{
+		id:           hex.EncodeToString(raw[:]),
+		head:         head,
+		resets:       resets,
+		expires:      now.Add(st.ttl),
+		users:        users,
+		pendingSorts: s.updateCount,
+	}
+	for len(st.order) >= st.max && len(st.order) > 0 {
+		delete(st.byID, st.order[0].id)
+		st.order = st.order[1:]
+	}
+	st.byID[snap.id] = snap
+	st.order = append(st.order, snap)
+	st.taken++
+	return snap, nil
+}
+
+func (st *snapshotStore) dropExpiredLocked(now time.Time) {
+	for len(st.order) > 0 && now.After(st.order[0].expires) {
+		delete(st.byID, st.order[0].id)
+		st.order = st.order[1:]
+	}
+}
+
+// Page returns one page of band from the snapshot, like GetLeaderboardBand.
+func (snap *leaderboardSnapshot) Page(band ratingBand, page, limit int) ([]User, int, int) {
+	return pageOfCopies(snap.users, band, page, limit)
+}
+
+// pageOfCopies pages through band of a sorted list nobody modifies.
+func pageOfCopies(sorted []*User, band ratingBand, page, limit int) ([]User, int, int) {
+	bandStart, bandEnd := leaderboard.BandBounds[User](sorted, band, userStore.Direction())
+	total := bandEnd - bandStart
+	totalPages := (total + limit - 1) / limit
+	start := (page - 1) * limit
+	if start >= total {
+		return []User{}, total, 0
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	users := make([]User, end-start)
+	for i := start; i < end; i++ {
+		users[i-start] = *sorted[bandStart+i]
+	}
+	return users, total, totalPages
+}
+
+func (st *snapshotStore) Stats() map[string]interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.dropExpiredLocked(time.Now())
+	return map[string]interface{}{
+		"retained":   len(st.order),
+		"taken":      st.taken,
+		"shared":     st.shared,
+		"ttlSeconds": st.ttl.Seconds(),
+	}
+}
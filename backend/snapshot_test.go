@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotPinned(t *testing.T) {
+	ctx := context.Background()
+	s := seededStore(newSeeder(5).Users(300))
+	st := &snapshotStore{ttl: time.Hour, max: 8, byID: make(map[string]*leaderboardSnapshot)}
+	snap, err := st.take(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, total, pages := snap.Page(allRatings, 2, 20)
+	if len(before) != 20 || total != 300 || pages != 15 {
+		t.Fatalf("snapshot page 2 = %d users of %d in %d pages", len(before), total, pages)
+	}
+	if again, err := st.take(ctx, s); err != nil || again.id != snap.id {
+		t.Errorf("a take with no changes since = %v, %v; want the same snapshot", again, err)
+	}
+
+	// Move the whole page to the top and rename one of them
+	var updates []ratingUpdate
+	for _, u := range before {
+		updates = append(updates, ratingUpdate{UserID: u.ID, Rating: scores.Clamp(u.Rating + scores.Units(5000))})
+	}
+	s.applyRatingUpdates(updates, sourceSchedule)
+	s.Lock()
+	u, _ := s.GetLocked(before[0].ID)
+	if err := s.renameLocked(u, "renamed_after_snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock()
+	if live, _, _, _, _ := s.GetLeaderboard(ctx, 2, 20); reflect.DeepEqual(live, before) {
+		t.Fatal("the writes did not change the live page")
+	}
+
+	pinned, err := st.get(snap.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, _, _ := pinned.Page(allRatings, 2, 20); !reflect.DeepEqual(after, before) {
+		t.Errorf("snapshot page changed after later writes:\n%v\nwant\n%v", after, before)
+	}
+	if fresh, err := st.take(ctx, s); err != nil || fresh.id == snap.id {
+		t.Errorf("a take after writes = %v, %v; want a new snapshot", fresh, err)
+	}
+}
+
+func TestSnapshotGone(t *testing.T) {
+	ctx := context.Background()
+	s := seededStore(newSeeder(5).Users(50))
+	st := &snapshotStore{ttl: time.Hour, max: 2, byID: make(map[string]*leaderboardSnapshot)}
+
+	// One snapshot per version; the third evicts the first
+	var ids []string
+	for i := 0; i < 3; i++ {
+		snap, err := st.take(ctx, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, snap.id)
+		u := snap.users[0]
+		s.applyRatingUpdates([]ratingUpdate{{UserID: u.ID, Rating: u.Rating - 1}}, sourceSchedule)
+	}
+	if _, err := st.get(ids[0]); !errors.Is(err, errSnapshotExpired) {
+		t.Errorf("evicted snapshot: %v, want %v", err, errSnapshotExpired)
+	}
+	for _, id := range ids[1:] {
+		if _, err := st.get(id); err != nil {
+			t.Errorf("retained snapshot %s: %v", id, err)
+		}
+	}
+
+	st.byID[ids[1]].expires = time.Now().Add(-time.Second)
+	if _, err := st.get(ids[1]); !errors.Is(err, errSnapshotExpired) {
+		t.Errorf("expired snapshot: %v, want %v", err, errSnapshotExpired)
+	}
+	if _, err := st.get("not-a-snapshot"); !errors.Is(err, errSnapshotExpired) {
+		t.Errorf("unknown snapshot: %v, want %v", err, errSnapshotExpired)
+	}
+
+	// Both leaderboard routes answer 410 Gone
+	for route, handler := range map[string]http.HandlerFunc{
+		"/leaderboard":    leaderboardHandler,
+		"/v1/leaderboard": v1(v1LeaderboardHandler),
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, route+"?snapshot=0123456789abcdef", nil))
+		if rec.Code != http.StatusGone {
+			t.Errorf("%s with an unknown snapshot = %d, want 410: %s", route, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+const benchSortUsers = 1_000_000
+
+// benchSortStore returns a sorted store of benchSortUsers seeded users.
+func benchSortStore(b *testing.B) *UserStore {
+	b.Helper()
+	s := NewUserStore()
+	seeded := newSeeder(1).Users(benchSortUsers)
+	s.Lock()
+	s.loadUsersLocked(seeded)
+	s.Unlock()
+	return s
+}
+
+// changeRatingsLocked moves n users, drawn from the span of the ranking
+// starting at a random position, by up to delta stored units each, as a
+// batch of updates would.
+func changeRatingsLocked(s *UserStore, rng *rand.Rand, n, span, delta int) {
+	from := rng.Intn(s.LenLocked() - span + 1)
+	for i := 0; i < n; i++ {
+		u := s.RankedLocked()[from+rng.Intn(span)]
+		s.SetScoreLocked(u, scores.Add(u.Rating, rng.Intn(2*delta+1)-delta), u.ReachedAt())
+	}
+}
+
+func benchmarkSort(b *testing.B, full bool, span, delta int) {
+	s := benchSortStore(b)
+	rng := rand.New(rand.NewSource(1))
+	s.Lock()
+	defer s.Unlock()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		changeRatingsLocked(s, rng, 200, span, delta)
+		if full {
+			s.ResortLocked()
+		} else {
+			s.SortLocked()
+		}
+	}
+}
+
+// BenchmarkSortFull re-sorts and re-ranks everyone after 200 changes.
+func BenchmarkSortFull(b *testing.B) {
+	benchmarkSort(b, true, benchSortUsers, scores.Units(200))
+}
+
+// BenchmarkSortDirtyBand re-sorts only the band touched by 200 small
+// changes among neighbouring users, the common case on a live board.
+func BenchmarkSortDirtyBand(b *testing.B) {
+	benchmarkSort(b, false, 2000, scores.Units(2))
+}
+
+// BenchmarkSortWideBand is the partial re-rank when 200 changes anywhere
+// on the board stretch the band across most of it.
+func BenchmarkSortWideBand(b *testing.B) {
+	benchmarkSort(b, false, benchSortUsers, scores.Units(200))
+}
+
+// BenchmarkSortPointerSlice is the sort the packed keys replaced:
+// sort.Slice over the []*User, chasing both pointers on every comparison,
+// then a full re-rank, after the same 200 changes as BenchmarkSortFull.
+// It only sorts and numbers: no rank-change events or hooks, which
+// BenchmarkSortFull pays for every user whose rank moved.
+func BenchmarkSortPointerSlice(b *testing.B) {
+	s := benchSortStore(b)
+	rng := rand.New(rand.NewSource(1))
+	s.Lock()
+	defer s.Unlock()
+	users := append([]*User(nil), s.RankedLocked()...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		changeRatingsLocked(s, rng, 200, benchSortUsers, scores.Units(200))
+		sort.Slice(users, func(i, j int) bool {
+			if users[i].Rating != users[j].Rating {
+				return users[i].Rating > users[j].Rating
+			}
+			return users[i].ID < users[j].ID
+		})
+		for i, u := range users {
+			if i == 0 || u.Rating != users[i-1].Rating {
+				u.Rank = i + 1
+			} else {
+				u.Rank = users[i-1].Rank
+			}
+		}
+	}
+}
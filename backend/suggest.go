@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// A username search that finds nobody suggests up to
+// MATIKS_SEARCH_SUGGESTIONS (default 3, 0 turns them off) names the query
+// nearly matches, as "suggestions" in the /search body and the v1 meta.
+// A name's distance is the edit distance from the folded query to the
+// closest prefix of its folded name, since searches match prefixes, so
+// "rahl" is one edit from "rahul_sharma12". Names up to
+// MATIKS_SEARCH_SUGGEST_DISTANCE edits away (default 2, and at most half
+// the query's length) qualify; the closest come first, then those nearest
+// in length to the query, then by name. Only the query's first-character
+// bucket is searched, so a wrong first letter gets no suggestions.
+
+var (
+	searchSuggestions     = envInt("MATIKS_SEARCH_SUGGESTIONS", 3)
+	searchSuggestDistance = envInt("MATIKS_SEARCH_SUGGEST_DISTANCE", 2)
+)
+
+type suggestion struct {
+	user     *User
+	distance int
+	length   int // Rune length difference from the query
+}
+
+// Suggest returns the usernames closest to a query that matched nobody.
+func (s *UserStore) Suggest(ctx context.Context, query string) ([]string, error) {
+	query = foldUsername(normalizeUsername(query))
+	if searchSuggestions <= 0 || usernameWidth(query) < 2 {
+		return nil, nil
+	}
+	q := []rune(query)
+	maxDistance := min(searchSuggestDistance, len(q)/2)
+
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+
+	bucket := s.BucketLocked(usernameBucket(query))
+	rows := make([]int, 2*(len(q)+1))
+	var found []suggestion
+	for i, u := range bucket {
+		if i%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		name := []rune(u.UsernameLower)
+		if d := prefixDistance(q, name, maxDistance, rows); d <= maxDistance {
+			found = append(found, suggestion{user: u, distance: d, length: abs(len(name) - len(q))})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.length != b.length {
+			return a.length < b.length
+		}
+		return a.user.UsernameLower < b.user.UsernameLower
+	})
+
+	names := make([]string, 0, min(len(found), searchSuggestions))
+	for _, f := range found[:min(len(found), searchSuggestions)] {
+		names = append(names, f.user.Username)
+	}
+	return names, nil
+}
+
+// prefixDistance is the edit distance from query to the closest prefix of
+// name, or limit+1 once every prefix is further than limit. rows is scratch
+// space for two rows of len(query)+1.
+func prefixDistance(query, name []rune, limit int, rows []int) int {
+	// Columns are query positions, rows name positions: cur[i] is the
+	// distance from query[:i] to name[:j].
+	prev, cur := rows[:len(query)+1], rows[len(query)+1:]
+	for i := range prev {
+		prev[i] = i
+	}
+	best := prev[len(query)]
+	for j := 1; j <= len(name) && j <= len(query)+limit; j++ {
+		cur[0] = j
+		rowMin := cur[0]
+		for i := 1; i <= len(query); i++ {
+			cost := 1
+			if query[i-1] == name[j-1] {
+				cost = 0
+			}
+			cur[i] = min(prev[i]+1, cur[i-1]+1, prev[i-1]+cost)
+			rowMin = min(rowMin, cur[i])
+		}
+		best = min(best, cur[len(query)])
+		if rowMin > limit {
+			break
+		}
+		prev, cur = cur, prev
+	}
+	if best > limit {
+		return limit + 1
+	}
+	return best
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Threshold rules ask for a webhook when one user crosses a rating or a
+// rank:
+//
+//	POST /admin/webhooks/rules {"userId": "u1", "rating": 2000, "direction": "up"}
+//	POST /admin/webhooks/rules {"userId": "u1", "rank": 10, "url": "https://..."}
+//
+// A rating rule fires when the rating moves from below the threshold to at
+// or above it (up) or back (down); a rank rule fires when the user reaches
+// the rank or better (up) or falls past it (down). direction defaults to
+// both. Deliveries go to the rule's url, or to MATIKS_WEBHOOK_URLS without
+// one, as "threshold_crossed" events through the webhook queue, with its
+// retries and signature.
+//
+// Rules are checked where ratings change (updates and merges) and where
+// ranks are assigned, under the store's lock, so a crossing is only handed
+// to a buffered channel there; the webhook job persists it. Crossings that
+// find the channel full are counted as dropped. Rules are kept in the
+// webhook queue file, so they need the webhook job: it runs when
+// MATIKS_WEBHOOK_URLS is set or MATIKS_WEBHOOK_RULES=true. At most
+// MATIKS_MAX_THRESHOLD_RULES rules (default 10000) and
+// MATIKS_MAX_THRESHOLD_RULES_PER_USER per watched user (default 50).
+//
+// GET /admin/webhooks/rules[?userId=] lists rules, and
+// GET or DELETE /admin/webhooks/rules/{id} reads or removes one.
+
+const EventThresholdCrossed = "threshold_crossed"
+
+var (
+	errWebhooksDisabled = errors.New("webhooks are not enabled (set MATIKS_WEBHOOK_URLS or MATIKS_WEBHOOK_RULES=true)")
+	errRuleNotFound     = errors.New("no such threshold rule")
+)
+
+type thresholdRule struct {
+	ID        uint64       `json:"id"`
+	UserID    string       `json:"userId"`
+	Rating    *scoreNumber `json:"rating,omitempty"`
+	Rank      int          `json:"rank,omitempty"`
+	Direction string       `json:"direction"` // up, down or both
+	URL       string       `json:"url,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Fired     int64        `json:"fired"` // Since the server started
+}
+
+// wants reports whether the rule fires on a crossing up (or down).
+func (rule *thresholdRule) wants(up bool) bool {
+	return rule.Direction == "both" || (rule.Direction == "up") == up
+}
+
+// ThresholdCrossedPayload is the body of a threshold_crossed event.
+type ThresholdCrossedPayload struct {
+	RuleID    uint64      `json:"ruleId"`
+	UserID    string      `json:"userId"`
+	Username  string      `json:"username"`
+	Metric    string      `json:"metric"` // rating or rank
+	Threshold json.Number `json:"threshold"`
+	Direction string      `json:"direction"` // up or down, as crossed
+	Old       json.Number `json:"old"`
+	New       json.Number `json:"new"`
+}
+
+type thresholdCrossing struct {
+	url string
+	ev  Event
+}
+
+type thresholdRules struct {
+	maxRules, maxPerUser int
+
+	count     int64 // Lets the update path skip the lock without rules
+	crossings chan thresholdCrossing
+	dropped   int64
+
+	mu     sync.RWMutex
+	db     *bolt.DB
+	byID   map[uint64]*thresholdRule
+	byUser map[string][]*thresholdRule
+}
+
+var thresholds = &thresholdRules{
+	maxRules:   envInt("MATIKS_MAX_THRESHOLD_RULES", 10000),
+	maxPerUser: envInt("MATIKS_MAX_THRESHOLD_RULES_PER_USER", 50),
+	crossings:  make(chan thresholdCrossing, 1024),
+	byID:       make(map[uint64]*thresholdRule),
+	byUser:     make(map[string][]*thresholdRule),
+}
+
+// load reads the stored rules from the webhook queue file.
+func (t *thresholdRules) load(db *bolt.DB) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.db = db
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhookRulesBucket).ForEach(func(k, v []byte) error {
+			var rule thresholdRule
+			if err := json.Unmarshal(v, &rule); err != nil {
+				log.Printf("Webhooks: skipping rule %d: %v", binary.BigEndian.Uint64(k), err)
+				return nil
+			}
+			t.addLocked(&rule)
+			return nil
+		})
+	})
+}
+
+func (t *thresholdRules) addLocked(rule *thresholdRule) {
+	t.byID[rule.ID] = rule
+	t.byUser[rule.UserID] = append(t.byUser[rule.UserID], rule)
+	atomic.StoreInt64(&t.count, int64(len(t.byID)))
+}
+
+func (t *thresholdRules) Add(rule *thresholdRule) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.db == nil {
+		return errWebhooksDisabled
+	}
+	if len(t.byID) >= t.maxRules {
+		return &paramError{Param: "body", Message: fmt.Sprintf("at most %d rules", t.maxRules)}
+	}
+	if len(t.byUser[rule.UserID]) >= t.maxPerUser {
+		return &paramError{Param: "userId", Message: fmt.Sprintf("at most %d rules per user", t.maxPerUser)}
+	}
+	err := t.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhookRulesBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		rule.ID = id
+		body, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		return b.Put(deliveryKey(id), body)
+	})
+	if err != nil {
+		return err
+	}
+	t.addLocked(rule)
+	return nil
+}
+
+func (t *thresholdRules) Remove(id uint64) (thresholdRule, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.db == nil {
+		return thresholdRule{}, errWebhooksDisabled
+	}
+	rule, ok := t.byID[id]
+	if !ok {
+		return thresholdRule{}, fmt.Errorf("%w: %d", errRuleNotFound, id)
+	}
+	err := t.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhookRulesBucket).Delete(deliveryKey(id))
+	})
+	if err != nil {
+		return thresholdRule{}, err
+	}
+	delete(t.byID, id)
+	kept := t.byUser[rule.UserID][:0]
+	for _, other := range t.byUser[rule.UserID] {
+		if other != rule {
+			kept = append(kept, other)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.byUser, rule.UserID)
+	} else {
+		t.byUser[rule.UserID] = kept
+	}
+	atomic.StoreInt64(&t.count, int64(len(t.byID)))
+	return t.snapshot(rule), nil
+}
+
+func (t *thresholdRules) snapshot(rule *thresholdRule) thresholdRule {
+	out := *rule
+	out.Fired = atomic.LoadInt64(&rule.Fired)
+	return out
+}
+
+func (t *thresholdRules) Get(id uint64) (thresholdRule, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.db == nil {
+		return thresholdRule{}, errWebhooksDisabled
+	}
+	rule, ok := t.byID[id]
+	if !ok {
+		return thresholdRule{}, fmt.Errorf("%w: %d", errRuleNotFound, id)
+	}
+	return t.snapshot(rule), nil
+}
+
+// List returns the rules watching userID, or all of them, oldest first.
+func (t *thresholdRules) List(userID string) ([]thresholdRule, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.db == nil {
+		return nil, errWebhooksDisabled
+	}
+	out := []thresholdRule{}
+	if userID != "" {
+		for _, rule := range t.byUser[userID] {
+			out = append(out, t.snapshot(rule))
+		}
+	} else {
+		for _, rule := range t.byID {
+			out = append(out, t.snapshot(rule))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// ratingChanged checks the rating rules watching user. The caller holds
+// the store's lock.
+func (t *thresholdRules) ratingChanged(user *User, oldRating, newRating int) {
+	if atomic.LoadInt64(&t.count) == 0 {
+		return
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, rule := range t.byUser[user.ID] {
+		if rule.Rating == nil {
+			continue
+		}
+		y := int(*rule.Rating)
+		up := oldRating < y && newRating >= y
+		down := oldRating >= y && newRating < y
+		if up || down {
+			t.crossedLocked(rule, user.Username, "rating", up,
+				scores.Number(y), scores.Number(oldRating), scores.Number(newRating))
+		}
+	}
+}
+
+// ranksChanged checks the rank rules watching the users in changes. The
+// caller holds the store's lock.
+func (t *thresholdRules) ranksChanged(changes []RankChange) {
+	if atomic.LoadInt64(&t.count) == 0 {
+		return
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, c := range changes {
+		for _, rule := range t.byUser[c.UserID] {
+			if rule.Rank == 0 {
+				continue
+			}
+			up := c.OldRank > rule.Rank && c.NewRank <= rule.Rank
+			down := c.OldRank <= rule.Rank && c.NewRank > rule.Rank
+			if up || down {
+				t.crossedLocked(rule, c.Username, "rank", up,
+					rankNumber(rule.Rank), rankNumber(c.OldRank), rankNumber(c.NewRank))
+			}
+		}
+	}
+}
+
+func rankNumber(rank int) json.Number {
+	return json.Number(strconv.Itoa(rank))
+}
+
+// crossedLocked hands a crossing to the webhook job if the rule wants it.
+func (t *thresholdRules) crossedLocked(rule *thresholdRule, username, metric string, up bool, threshold, old, new json.Number) {
+	if !rule.wants(up) {
+		return
+	}
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	crossing := thresholdCrossing{url: rule.URL, ev: Event{
+		Type:      EventThresholdCrossed,
+		Timestamp: time.Now(),
+		Payload: ThresholdCrossedPayload{
+			RuleID:    rule.ID,
+			UserID:    rule.UserID,
+			Username:  username,
+			Metric:    metric,
+			Threshold: threshold,
+			Direction: direction,
+			Old:       old,
+			New:       new,
+		},
+	}}
+	select {
+	case t.crossings <- crossing:
+		atomic.AddInt64(&rule.Fired, 1)
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+func (t *thresholdRules) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"rules":   atomic.LoadInt64(&t.count),
+		"pending": len(t.crossings),
+		"dropped": atomic.LoadInt64(&t.dropped),
+	}
+}
+
+// createRuleRequest serves POST /admin/webhooks/rules.
+func createRuleRequest(r *http.Request) (interface{}, error) {
+	var body struct {
+		UserID    string       `json:"userId"`
+		Rating    *scoreNumber `json:"rating"`
+		Rank      *int         `json:"rank"`
+		Direction string       `json:"direction"`
+		URL       string       `json:"url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+	}
+	if body.UserID == "" {
+		return nil, &paramError{Param: "userId", Message: "is required"}
+	}
+	if _, err := userStore.userByID(body.UserID); err != nil {
+		return nil, err
+	}
+	rule := &thresholdRule{UserID: body.UserID, Direction: body.Direction, URL: strings.TrimSpace(body.URL), CreatedAt: time.Now().UTC()}
+	switch {
+	case (body.Rating == nil) == (body.Rank == nil):
+		return nil, &paramError{Param: "body", Message: "give exactly one of rating and rank"}
+	case body.Rating != nil:
+		if rating := int(*body.Rating); rating < scores.Min || rating > scores.Max {
+			return nil, &paramError{Param: "rating", Message: fmt.Sprintf("must be between %s and %s", scores.Number(scores.Min), scores.Number(scores.Max))}
+		}
+		rule.Rating = body.Rating
+	default:
+		if *body.Rank < 1 {
+			return nil, &paramError{Param: "rank", Message: "must be at least 1"}
+		}
+		rule.Rank = *body.Rank
+	}
+	switch rule.Direction {
+	case "":
+		rule.Direction = "both"
+	case "up", "down", "both":
+	default:
+		return nil, &paramError{Param: "direction", Message: fmt.Sprintf("must be up, down or both, got %q", rule.Direction)}
+	}
+	if rule.URL != "" {
+		u, err := url.Parse(rule.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, &paramError{Param: "url", Message: "must be an http or https URL"}
+		}
+	} else if webhooks != nil && len(webhooks.endpoints) == 0 {
+		return nil, &paramError{Param: "url", Message: "is required without MATIKS_WEBHOOK_URLS"}
+	}
+	if err := thresholds.Add(rule); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"rule": thresholds.snapshot(rule)}, nil
+}
+
+// rulesRequest serves /admin/webhooks/rules and /admin/webhooks/rules/{id}.
+func rulesRequest(r *http.Request) (interface{}, error) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/webhooks/rules"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			rules, err := thresholds.List(strings.TrimSpace(r.URL.Query().Get("userId")))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"rules": rules}, nil
+		case http.MethodPost:
+			return createRuleRequest(r)
+		default:
+			return nil, fmt.Errorf("%w: use GET to list or POST to create", errMethodNotAllowed)
+		}
+	}
+	id, err := strconv.ParseUint(rest, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errRuleNotFound, rest)
+	}
+	var rule thresholdRule
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		rule, err = thresholds.Get(id)
+	case http.MethodDelete:
+		rule, err = thresholds.Remove(id)
+	default:
+		return nil, fmt.Errorf("%w: use GET or DELETE", errMethodNotAllowed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"rule": rule}, nil
+}
+
+func adminRulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := rulesRequest(r)
+	if err != nil {
+		writeLegacyError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"result":    result,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+func v1AdminRulesHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+	data, err := rulesRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodPost {
+		return &v1Result{Status: http.StatusCreated, Data: data}, nil
+	}
+	return &v1Result{Data: data}, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+
+	"matiks-leaderboard/utils"
+)
+
+// MATIKS_TIE_BREAK orders users with equal ratings: id (default), username,
+// earliest (first to reach the rating) or random (a hash of the ID seeded
+// with MATIKS_TIE_BREAK_SEED, so the shuffle survives restarts). Tied
+// users still share a rank; the rule only decides who is listed first.
+//
+// This is the rating leaderboard's rule; filtered boards and cursors list
+// its users in its order, while the improvement board breaks equal gains by
+// ID. Each metric board takes
+// MATIKS_TIE_BREAK_<BOARD> and MATIKS_TIE_BREAK_SEED_<BOARD>, defaulting to
+// the rating leaderboard's rule and seed.
+//
+// The id, username and random orders only move when users come, go or are
+// renamed, so they are numbered once into tieOrd and packed into the sort
+// key. earliest changes with every update and is compared directly. When a
+// rating was reached is not in snapshots: after a restart, earliest ties
+// fall back to ID until the users' ratings move again.
+
+var leaderboardTieBreak = envTieBreak("MATIKS_TIE_BREAK", "MATIKS_TIE_BREAK_SEED", utils.TieBreak{Mode: utils.TieByID})
+
+func envTieBreak(key, seedKey string, def utils.TieBreak) utils.TieBreak {
+	mode := envString(key, string(def.Mode))
+	seed := envInt(seedKey, envInt("MATIKS_TIE_BREAK_SEED", 0))
+	t, err := utils.ParseTieBreak(mode, uint64(seed))
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return t
+}
+
+func (u *User) tieKey() utils.TieKey {
+	return utils.TieKey{ID: u.ID, UsernameLower: u.UsernameLower, ReachedAt: u.ReachedAt()}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"matiks-leaderboard/utils"
+)
+
+// TestBoardTieBreak checks each metric board takes its own tie break and
+// seed, falls back to the shared seed, and orders ties by them.
+func TestBoardTieBreak(t *testing.T) {
+	t.Setenv("MATIKS_TIE_BREAK_SEED", "5")
+	t.Setenv("MATIKS_TIE_BREAK_KILLS", "random")
+	t.Setenv("MATIKS_TIE_BREAK_SEED_KILLS", "9")
+	t.Setenv("MATIKS_TIE_BREAK_STREAK", "random")
+	t.Setenv("MATIKS_TIE_BREAK_WINS", "username")
+	m := newMetricStore([]string{"kills", "streak", "wins", "deaths"}, nil)
+
+	want := map[string]utils.TieBreak{
+		"kills":  {Mode: utils.TieByRandom, Seed: 9},
+		"streak": {Mode: utils.TieByRandom, Seed: 5},
+		"wins":   {Mode: utils.TieByUsername},
+		"deaths": leaderboardTieBreak,
+	}
+	for _, b := range m.Boards() {
+		if b.TieBreak != want[b.Name] {
+			t.Errorf("%s: tie break %+v, want %+v", b.Name, b.TieBreak, want[b.Name])
+		}
+	}
+
+	// Every user ties on every board
+	users, _, _, _, err := userStore.GetLeaderboard(context.Background(), 1, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := 1.0
+	for _, u := range users {
+		if _, err := m.Update(u.ID, map[string]*float64{"kills": &one, "streak": &one, "wins": &one, "deaths": &one}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, tie := range want {
+		expected := append([]User(nil), users...)
+		sort.Slice(expected, func(i, j int) bool { return tie.Less(expected[i].tieKey(), expected[j].tieKey()) })
+		var wantIDs []string
+		for _, u := range expected {
+			wantIDs = append(wantIDs, u.ID)
+		}
+		entries, _, err := m.Page(name, 1, len(users))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.tie.ID)
+			if e.rank != 1 {
+				t.Errorf("%s: %s ranked %d among ties, want 1", name, e.tie.ID, e.rank)
+			}
+		}
+		if !reflect.DeepEqual(got, wantIDs) {
+			t.Errorf("%s: ties ordered %v, want %v", name, got, wantIDs)
+		}
+	}
+}
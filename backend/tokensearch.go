@@ -0,0 +1,16 @@
+package main
+
+// Username search also matches the components of a name: splitting the
+// folded name on _ . - and digits, "alex_sharma5" has the tokens "alex"
+// and "sharma", so q=sharma and q=shar find it as well as q=alex. Names
+// that start with q come first, in the order the search strategy returns
+// them; names with a later token starting with q follow, in username
+// order, up to the same result limit.
+//
+// The leaderboard package keeps the token index, one entry per token that
+// doesn't start the name (the name prefix search already covers that one)
+// and is at least two characters wide, sorted by token. Names without
+// separators cost nothing. MATIKS_SEARCH_TOKENS=false drops the index and
+// the matching.
+
+var searchTokensEnabled = envBool("MATIKS_SEARCH_TOKENS", true)
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Usernames chosen through the API (POST /admin/users and
+// POST /admin/users/{id}/rename) pass a chain of rules first. Each rule
+// that objects adds a {rule, code, message} reason, and all reasons are
+// returned together. MATIKS_USERNAME_RULES picks and orders the rules
+// (default: all of length, charset, reserved, profanity, confusable).
+//
+// Names are checked in NFC (see names.go). Seeded names are generated, not
+// chosen, and skip the policy.
+
+// usernameRejection is one rule's objection to a username.
+type usernameRejection struct {
+	Rule    string `json:"rule"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// usernameError carries every rejection for a username.
+type usernameError struct {
+	Username string
+	Reasons  []usernameRejection
+}
+
+func (e *usernameError) Error() string {
+	messages := make([]string, len(e.Reasons))
+	for i, r := range e.Reasons {
+		messages[i] = r.Message
+	}
+	return fmt.Sprintf("username %q rejected: %s", e.Username, strings.Join(messages, "; "))
+}
+
+// usernameCheck is what a rule inspects.
+type usernameCheck struct {
+	Username string
+	UserID   string // The user being renamed; empty on create
+	Store    *UserStore
+}
+
+// usernameRule returns nil to accept.
+type usernameRule func(c *usernameCheck) *usernameRejection
+
+// UsernamePolicy runs its rules in order.
+type UsernamePolicy struct {
+	names []string
+	rules []usernameRule
+
+	minLen, maxLen int // In usernameWidth
+	reserved       map[string]bool
+	blocked        []string // Skeletons, matched as substrings
+	confusableTop  int      // Leaders protected from look-alikes
+}
+
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "sysadmin", "moderator", "mod",
+	"staff", "support", "help", "matiks", "official", "api", "www", "null",
+	"undefined", "anonymous", "deleted", "everyone", "here",
+}
+
+// defaultBlockedWords is deliberately short; deployments extend it with
+// MATIKS_USERNAME_BLOCKLIST_FILE.
+var defaultBlockedWords = []string{"fuck", "shit", "bitch", "cunt", "whore", "slut", "asshole", "wank"}
+
+var usernamePolicy = newUsernamePolicy()
+
+func newUsernamePolicy() *UsernamePolicy {
+	p := &UsernamePolicy{
+		minLen:        envInt("MATIKS_USERNAME_MIN_LENGTH", 3),
+		maxLen:        envInt("MATIKS_USERNAME_MAX_LENGTH", 32),
+		reserved:      make(map[string]bool),
+		confusableTop: envInt("MATIKS_USERNAME_CONFUSABLE_TOP", 100),
+	}
+	for _, name := range append(defaultReservedUsernames, envList("MATIKS_USERNAME_RESERVED")...) {
+		p.reserved[foldUsername(name)] = true
+	}
+	words := defaultBlockedWords
+	if path := os.Getenv("MATIKS_USERNAME_BLOCKLIST_FILE"); path != "" {
+		extra, err := readWordList(path)
+		if err != nil {
+			log.Fatalf("Usernames: %v", err)
+		}
+		words = append(words, extra...)
+	}
+	for _, w := range words {
+		p.blocked = append(p.blocked, usernameSkeleton(w))
+	}
+
+	available := map[string]usernameRule{
+		"length":     p.checkLength,
+		"charset":    p.checkCharset,
+		"reserved":   p.checkReserved,
+		"profanity":  p.checkProfanity,
+		"confusable": p.checkConfusable,
+	}
+	for _, name := range envListDefault("MATIKS_USERNAME_RULES", "length", "charset", "reserved", "profanity", "confusable") {
+		rule, ok := available[name]
+		if !ok {
+			log.Fatalf("Usernames: unknown rule %q in MATIKS_USERNAME_RULES", name)
+		}
+		p.Use(name, rule)
+	}
+	return p
+}
+
+// Use appends a rule to the chain.
+func (p *UsernamePolicy) Use(name string, rule usernameRule) {
+	p.names = append(p.names, name)
+	p.rules = append(p.rules, func(c *usernameCheck) *usernameRejection {
+		r := rule(c)
+		if r != nil {
+			r.Rule = name
+		}
+		return r
+	})
+}
+
+// Check runs every rule and returns a *usernameError listing all
+// objections, or nil.
+func (p *UsernamePolicy) Check(store *UserStore, userID, username string) error {
+	c := &usernameCheck{Username: username, UserID: userID, Store: store}
+	var reasons []usernameRejection
+	for _, rule := range p.rules {
+		if r := rule(c); r != nil {
+			reasons = append(reasons, *r)
+		}
+	}
+	if len(reasons) > 0 {
+		return &usernameError{Username: username, Reasons: reasons}
+	}
+	return nil
+}
+
+func (p *UsernamePolicy) checkLength(c *usernameCheck) *usernameRejection {
+	n := usernameWidth(c.Username)
+	switch {
+	case n < p.minLen:
+		return &usernameRejection{Code: "too_short", Message: fmt.Sprintf("must be at least %d characters (CJK count as 2), got %d", p.minLen, n)}
+	case n > p.maxLen:
+		return &usernameRejection{Code: "too_long", Message: fmt.Sprintf("must be at most %d characters (CJK count as 2), got %d", p.maxLen, n)}
+	}
+	return nil
+}
+
+func isUsernameSeparator(r rune) bool {
+	return r == '_' || r == '.' || r == '-'
+}
+
+// checkCharset allows letters and digits of any script, combining marks,
+// emoji (symbols, modifiers and ZWJ sequences) and the separators _ . -.
+func (p *UsernamePolicy) checkCharset(c *usernameCheck) *usernameRejection {
+	prev := rune(-1)
+	for i, r := range c.Username {
+		alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		emoji := unicode.Is(unicode.So, r) || unicode.Is(unicode.Sk, r)
+		mark := unicode.IsMark(r)
+		// ZWJ only glues emoji together
+		zwj := r == '\u200d' && prev >= 0 && (unicode.Is(unicode.So, prev) || unicode.IsMark(prev) || unicode.Is(unicode.Sk, prev))
+		switch {
+		case i == 0 && !alnum && !emoji:
+			return &usernameRejection{Code: "bad_start", Message: "must start with a letter, digit or emoji"}
+		case !alnum && !emoji && !mark && !zwj && !isUsernameSeparator(r):
+			return &usernameRejection{Code: "bad_character", Message: fmt.Sprintf("may only contain letters, digits, emoji, _ . and -, got %q", r)}
+		}
+		prev = r
+	}
+	return nil
+}
+
+func (p *UsernamePolicy) checkReserved(c *usernameCheck) *usernameRejection {
+	if p.reserved[foldUsername(c.Username)] {
+		return &usernameRejection{Code: "reserved", Message: "is reserved"}
+	}
+	return nil
+}
+
+// checkProfanity matches blocked words anywhere in the skeleton, so
+// separators and digit substitutions do not get around it.
+func (p *UsernamePolicy) checkProfanity(c *usernameCheck) *usernameRejection {
+	skeleton := usernameSkeleton(c.Username)
+	for _, word := range p.blocked {
+		if word != "" && strings.Contains(skeleton, word) {
+			return &usernameRejection{Code: "profanity", Message: "contains a blocked word"}
+		}
+	}
+	return nil
+}
+
+// checkConfusable rejects names that mix scripts, or that look like a
+// reserved name or one of the current leaders without being it, e.g.
+// "adm1n", "r00t" or a Cyrillic "аdmin".
+func (p *UsernamePolicy) checkConfusable(c *usernameCheck) *usernameRejection {
+	if mixedScript(c.Username) {
+		return &usernameRejection{Code: "mixed_script", Message: "must not mix letters from different scripts"}
+	}
+	skeleton := usernameSkeleton(c.Username)
+	lower := foldUsername(c.Username)
+	for name := range p.reserved {
+		if name != lower && usernameSkeleton(name) == skeleton {
+			return &usernameRejection{Code: "confusable", Message: fmt.Sprintf("is confusable with the reserved name %q", name)}
+		}
+	}
+	if c.Store == nil {
+		return nil
+	}
+	for _, leader := range c.Store.topUsers(p.confusableTop) {
+		if leader.ID != c.UserID && leader.UsernameLower != lower && usernameSkeleton(leader.Username) == skeleton {
+			return &usernameRejection{Code: "confusable", Message: fmt.Sprintf("is confusable with the existing user %q", leader.Username)}
+		}
+	}
+	return nil
+}
+
+// usernameSkeleton folds a name to how it reads: case-folded, accents and
+// homoglyphs removed, separators dropped, look-alike digits and letter
+// pairs collapsed.
+func usernameSkeleton(name string) string {
+	var b strings.Builder
+	for _, r := range deconfuse(foldUsername(name)) {
+		switch {
+		case isUsernameSeparator(r):
+			continue
+		case r == '0':
+			r = 'o'
+		case r == '1' || r == 'i' || r == '|' || r == '!':
+			r = 'l'
+		case r == '3':
+			r = 'e'
+		case r == '4' || r == '@':
+			r = 'a'
+		case r == '5' || r == '$':
+			r = 's'
+		case r == '7':
+			r = 't'
+		case r == '8':
+			r = 'b'
+		}
+		b.WriteRune(r)
+	}
+	return letterPairs.Replace(b.String())
+}
+
+// letterPairs collapses pairs that read as one letter. Building a Replacer
+// is costly, so it is shared.
+var letterPairs = strings.NewReplacer("rn", "m", "vv", "w")
+
+// readWordList reads one word per line, skipping blanks and # comments.
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// Describe reports the active rules for /admin/users/policy.
+func (p *UsernamePolicy) Describe() map[string]interface{} {
+	reserved := make([]string, 0, len(p.reserved))
+	for name := range p.reserved {
+		reserved = append(reserved, name)
+	}
+	sort.Strings(reserved)
+	return map[string]interface{}{
+		"rules":         p.names,
+		"minLength":     p.minLen,
+		"maxLength":     p.maxLen,
+		"reserved":      reserved,
+		"blockedWords":  len(p.blocked),
+		"confusableTop": p.confusableTop,
+	}
+}
+
+// CreateUser adds a user once its ID, username and rating check out; the
+// error lists every one that didn't.
+func (s *UserStore) CreateUser(ctx context.Context, id, username string, rating int) (User, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return User{}, err
+	}
+	username = normalizeUsername(username)
+	invalid := &validationError{}
+	invalid.add(checkUserID(id))
+	invalid.add(usernamePolicy.Check(s, "", username))
+	invalid.add(checkRating(rating))
+	if err := invalid.orNil(); err != nil {
+		return User{}, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, exists := s.GetLocked(id); exists {
+		return User{}, ErrDuplicateID
+	}
+	if _, exists := s.deleted[id]; exists {
+		return User{}, ErrDuplicateID
+	}
+	if _, exists := s.inactive[id]; exists {
+		return User{}, ErrDuplicateID
+	}
+	if _, taken := s.lookupUserLocked("", username); taken || s.nameHeldLocked(username, "") {
+		return User{}, ErrDuplicateUsername
+	}
+	now := time.Now()
+	user := &User{ID: id, Username: username, Rating: rating}
+	if err := s.addUserLocked(user); err != nil {
+		return User{}, err
+	}
+	s.membershipChangedLocked(1)
+	joins.set(id, now)
+	return *user, nil
+}
+
+// RenameUser changes a username after the policy accepted it. Ratings and
+// ranks are untouched, so no re-sort is needed.
+func (s *UserStore) RenameUser(ctx context.Context, id, username string) (User, error) {
+	if err := s.checkMembershipChange(ctx); err != nil {
+		return User{}, err
+	}
+	username = normalizeUsername(username)
+	if err := usernamePolicy.Check(s, id, username); err != nil {
+		return User{}, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	user, exists := s.GetLocked(id)
+	if !exists {
+		return User{}, ErrUserNotFound
+	}
+	if other, taken := s.lookupUserLocked("", username); (taken && other != user) || s.nameHeldLocked(username, id) {
+		return User{}, ErrDuplicateUsername
+	}
+	oldName := user.Username
+	if err := s.renameLocked(user, username); err != nil {
+		return User{}, err
+	}
+	if foldUsername(oldName) != user.UsernameLower {
+		s.recordRenameLocked(user, oldName)
+	}
+	return *user, nil
+}
+
+// renameLocked moves user to its new place in the name indexes.
+func (s *UserStore) renameLocked(user *User, username string) error {
+	if err := s.RenameLocked(user, username); err != nil {
+		return err
+	}
+	s.lastUpdate = time.Now()
+	s.changes.reset()
+	return nil
+}
+
+// checkUserID rejects an ID the routes couldn't address.
+func checkUserID(id string) error {
+	if id == "" || len(id) > 64 || strings.ContainsAny(id, "/ \t\r\n") {
+		return &paramError{Param: "id", Message: "must be 1 to 64 bytes without / or whitespace"}
+	}
+	return nil
+}
+
+func checkRating(rating int) error {
+	if rating < scores.Min || rating > scores.Max {
+		return fmt.Errorf("%w: must be between %s and %s", ErrRatingOutOfRange, scores.Number(scores.Min), scores.Number(scores.Max))
+	}
+	return nil
+}
+
+// createUserRequest serves POST /admin/users.
+func createUserRequest(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("%w: use GET to list or POST to create", errMethodNotAllowed)
+	}
+	var body struct {
+		ID       string       `json:"id"`
+		Username string       `json:"username"`
+		Rating   *scoreNumber `json:"rating"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+	}
+	rating := scores.Default
+	if body.Rating != nil {
+		rating = int(*body.Rating)
+	}
+	user, err := userStore.CreateUser(r.Context(), body.ID, body.Username, rating)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"user": user}, nil
+}
+
+// renameUserRequest serves POST /admin/users/{id}/rename.
+func renameUserRequest(r *http.Request, id string) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("%w: use POST", errMethodNotAllowed)
+	}
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+		return nil, &paramError{Param: "body", Message: "must be a JSON object: " + err.Error()}
+	}
+	user, err := userStore.RenameUser(r.Context(), id, body.Username)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"user": user}, nil
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"matiks-leaderboard/models"
+)
+
+// GET /admin/users?filter=... lists the users a filter expression selects,
+// in leaderboard order and paged like the leaderboard, for admin tooling:
+//
+//	rating>2500 AND username~"rahul*"
+//	(rank<=100 OR id=user_42) AND NOT username~"*bot*"
+//
+// Fields are id, username, rating and rank. rating and rank compare with
+// = != > >= < <=; id and username with = and !=, or ~ for a glob where *
+// matches any run of characters. Usernames compare ignoring case. Values
+// are bare words or double-quoted strings (with \" and \\ escapes), and
+// AND binds tighter than OR; keywords are case-insensitive. Filters are
+// limited to maxFilterLength bytes and maxFilterClauses comparisons.
+//
+// The filter runs off an index when its top level ANDs one: an id or
+// username equality looks the user up, a username prefix glob ("rahul*")
+// takes the range from the name index, and rating or rank bounds take the
+// range from the sorted list, the smallest candidate set winning. Only the
+// candidates are checked against the whole filter; anything else scans the
+// leaderboard. The response reports which plan ran.
+
+const (
+	maxFilterLength  = 512
+	maxFilterClauses = 16
+	maxFilterDepth   = 8
+)
+
+type filterNode interface {
+	match(u *User) bool
+	String() string
+}
+
+type filterAnd []filterNode
+
+func (n filterAnd) match(u *User) bool {
+	for _, c := range n {
+		if !c.match(u) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n filterAnd) String() string { return joinFilter(n, " AND ") }
+
+type filterOr []filterNode
+
+func (n filterOr) match(u *User) bool {
+	for _, c := range n {
+		if c.match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n filterOr) String() string { return joinFilter(n, " OR ") }
+
+func joinFilter(nodes []filterNode, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, c := range nodes {
+		parts[i] = c.String()
+		if _, nested := c.(filterOr); nested {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+type filterNot struct{ node filterNode }
+
+func (n filterNot) match(u *User) bool { return !n.node.match(u) }
+
+func (n filterNot) String() string {
+	switch n.node.(type) {
+	case filterAnd, filterOr:
+		return "NOT (" + n.node.String() + ")"
+	}
+	return "NOT " + n.node.String()
+}
+
+// filterCmp is one comparison. Numeric fields use num, string fields str
+// (folded for usernames).
+type filterCmp struct {
+	field, op string
+	num       int
+	str       string
+	raw       string // The value as given
+}
+
+func (c *filterCmp) match(u *User) bool {
+	switch c.field {
+	case "rating", "rank":
+		v := u.Rating
+		if c.field == "rank" {
+			v = u.Rank
+		}
+		switch c.op {
+		case "=":
+			return v == c.num
+		case "!=":
+			return v != c.num
+		case ">":
+			return v > c.num
+		case ">=":
+			return v >= c.num
+		case "<":
+			return v < c.num
+		case "<=":
+			return v <= c.num
+		}
+		return false
+	}
+	v := u.ID
+	if c.field == "username" {
+		v = u.UsernameLower
+	}
+	switch c.op {
+	case "=":
+		return v == c.str
+	case "!=":
+		return v != c.str
+	case "~":
+		return globMatch(c.str, v)
+	}
+	return false
+}
+
+func (c *filterCmp) String() string {
+	value := c.raw
+	for i := 0; i < len(value); i++ {
+		if !isFilterWordByte(value[i]) {
+			value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+			break
+		}
+	}
+	if value == "" {
+		value = `""`
+	}
+	return c.field + c.op + value
+}
+
+// globMatch matches s against pattern, where * matches any run.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// filterParser is a recursive-descent parser over the filter's tokens.
+type filterParser struct {
+	src     string
+	pos     int
+	clauses int
+	depth   int
+}
+
+func filterError(pos int, format string, args ...interface{}) error {
+	return &paramError{Param: "filter", Message: fmt.Sprintf("at %d: ", pos) + fmt.Sprintf(format, args...)}
+}
+
+// parseFilter parses a filter expression.
+func parseFilter(src string) (filterNode, error) {
+	if len(src) > maxFilterLength {
+		return nil, &paramError{Param: "filter", Message: fmt.Sprintf("must be at most %d bytes", maxFilterLength)}
+	}
+	if !utf8.ValidString(src) {
+		return nil, &paramError{Param: "filter", Message: "must be valid UTF-8"}
+	}
+	p := &filterParser{src: src}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.src) {
+		return nil, filterError(p.pos, "unexpected %q", p.src[p.pos:])
+	}
+	return node, nil
+}
+
+func (p *filterParser) space() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// keyword consumes kw, case-insensitively, if it is the next word.
+func (p *filterParser) keyword(kw string) bool {
+	p.space()
+	end := p.pos + len(kw)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.src) && isFilterWordByte(p.src[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func isFilterWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+		b == '_' || b == '-' || b == '.' || b == '*' || b >= utf8.RuneSelf
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	node, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []filterNode{node}
+	for p.keyword("OR") {
+		if node, err = p.and(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return filterOr(nodes), nil
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	node, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []filterNode{node}
+	for p.keyword("AND") {
+		if node, err = p.unary(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return filterAnd(nodes), nil
+}
+
+func (p *filterParser) unary() (filterNode, error) {
+	if p.keyword("NOT") {
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	}
+	p.space()
+	if p.pos < len(p.src) && p.src[p.pos] == '(' {
+		if p.depth++; p.depth > maxFilterDepth {
+			return nil, filterError(p.pos, "nested more than %d deep", maxFilterDepth)
+		}
+		p.pos++
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		p.space()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, filterError(p.pos, "expected )")
+		}
+		p.pos++
+		p.depth--
+		return node, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterNode, error) {
+	if p.clauses++; p.clauses > maxFilterClauses {
+		return nil, filterError(p.pos, "more than %d comparisons", maxFilterClauses)
+	}
+	start := p.pos
+	field, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+	field = strings.ToLower(field)
+	p.space()
+	opAt := p.pos
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", "=", ">", "<", "~"} {
+		if strings.HasPrefix(p.src[p.pos:], candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, filterError(opAt, "expected one of = != > >= < <= ~ after %s", field)
+	}
+	p.pos += len(op)
+	p.space()
+	valueAt := p.pos
+	var raw string
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		raw, err = p.quoted()
+	} else {
+		raw, err = p.word()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &filterCmp{field: field, op: op, raw: raw}
+	switch field {
+	case "rating", "rank":
+		if op == "~" {
+			return nil, filterError(opAt, "%s compares with = != > >= < <=", field)
+		}
+		if field == "rating" {
+			c.num, err = scores.Parse(raw)
+		} else {
+			c.num, err = strconv.Atoi(raw)
+		}
+		if err != nil {
+			return nil, filterError(valueAt, "%s needs a number, got %q", field, raw)
+		}
+	case "id", "username":
+		if op != "=" && op != "!=" && op != "~" {
+			return nil, filterError(opAt, "%s compares with = != ~", field)
+		}
+		c.str = raw
+		if field == "username" {
+			c.str = foldUsername(normalizeUsername(raw))
+		}
+	default:
+		return nil, filterError(start, "unknown field %q; use id, username, rating or rank", field)
+	}
+	return c, nil
+}
+
+func (p *filterParser) word() (string, error) {
+	p.space()
+	start := p.pos
+	for p.pos < len(p.src) && isFilterWordByte(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos >= len(p.src) {
+			return "", filterError(p.pos, "unexpected end")
+		}
+		return "", filterError(p.pos, "unexpected %q", p.src[p.pos:p.pos+1])
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *filterParser) quoted() (string, error) {
+	start := p.pos
+	p.pos++ // Opening quote
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		ch := p.src[p.pos]
+		switch {
+		case ch == '"':
+			p.pos++
+			return b.String(), nil
+		case ch == '\\' && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '"' || p.src[p.pos+1] == '\\'):
+			b.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(ch)
+			p.pos++
+		}
+	}
+	return "", filterError(start, "unterminated string")
+}
+
+// filterCandidatesLocked picks the users to check against n, in
+// leaderboard order, and names the plan. The store must be sorted.
+func (s *UserStore) filterCandidatesLocked(n filterNode) ([]*User, string) {
+	clauses := []filterNode{n}
+	if and, ok := n.(filterAnd); ok {
+		clauses = and
+	}
+	band := allRatings
+	banded := false
+	rankLo, rankHi := math.MinInt, math.MaxInt
+	ranked := false
+	prefix, prefixed := "", false
+	for _, clause := range clauses {
+		c, ok := clause.(*filterCmp)
+		if !ok {
+			continue
+		}
+		switch {
+		case c.field == "id" && c.op == "=":
+			if u, exists := s.GetLocked(c.str); exists {
+				return []*User{u}, "id"
+			}
+			return nil, "id"
+		case c.field == "username" && c.op == "=":
+			if u, exists := s.lookupUserLocked("", c.raw); exists {
+				return []*User{u}, "username"
+			}
+			return nil, "username"
+		case c.field == "username" && c.op == "~":
+			if i := strings.IndexByte(c.str, '*'); i == len(c.str)-1 && (!prefixed || len(c.str)-1 > len(prefix)) {
+				prefix, prefixed = c.str[:i], true
+			}
+		case c.field == "rating":
+			lo, hi := cmpBounds(c)
+			if lo > band.Min {
+				band.Min = lo
+			}
+			if hi < band.Max {
+				band.Max = hi
+			}
+			banded = banded || c.op != "!="
+		case c.field == "rank":
+			lo, hi := cmpBounds(c)
+			if lo > rankLo {
+				rankLo = lo
+			}
+			if hi < rankHi {
+				rankHi = hi
+			}
+			ranked = ranked || c.op != "!="
+		}
+	}
+
+	candidates, plan := s.RankedLocked(), "scan"
+	if banded {
+		start, end := s.BandLocked(band)
+		candidates, plan = s.RankedLocked()[start:end], "rating"
+	}
+	if ranked {
+		start := sort.Search(len(s.RankedLocked()), func(i int) bool { return s.RankedLocked()[i].Rank >= rankLo })
+		end := sort.Search(len(s.RankedLocked()), func(i int) bool { return s.RankedLocked()[i].Rank > rankHi })
+		if end < start {
+			end = start
+		}
+		if end-start < len(candidates) {
+			candidates, plan = s.RankedLocked()[start:end], "rank"
+		}
+	}
+	if prefixed {
+		start := sort.Search(len(s.NamesLocked()), func(i int) bool { return s.NamesLocked()[i].UsernameLower >= prefix })
+		end := start + sort.Search(len(s.NamesLocked())-start, func(i int) bool {
+			return !strings.HasPrefix(s.NamesLocked()[start+i].UsernameLower, prefix)
+		})
+		if end-start < len(candidates) {
+			byName := make([]*User, end-start)
+			copy(byName, s.NamesLocked()[start:end])
+			sort.Slice(byName, func(i, j int) bool { return s.LessLocked(byName[i], byName[j]) })
+			candidates, plan = byName, "usernamePrefix"
+		}
+	}
+	return candidates, plan
+}
+
+// cmpBounds is the inclusive range a numeric comparison allows.
+func cmpBounds(c *filterCmp) (int, int) {
+	switch c.op {
+	case "=":
+		return c.num, c.num
+	case ">":
+		if c.num == math.MaxInt {
+			return math.MaxInt, math.MinInt
+		}
+		return c.num + 1, math.MaxInt
+	case ">=":
+		return c.num, math.MaxInt
+	case "<":
+		if c.num == math.MinInt {
+			return math.MaxInt, math.MinInt
+		}
+		return math.MinInt, c.num - 1
+	case "<=":
+		return math.MinInt, c.num
+	}
+	return math.MinInt, math.MaxInt
+}
+
+// FilterUsers returns one page of the users n selects, how many it
+// selects, and the plan that ran.
+func (s *UserStore) FilterUsers(ctx context.Context, n filterNode, page, limit int) ([]User, int, string, error) {
+	if err := s.RLockSorted(ctx); err != nil {
+		return nil, 0, "", err
+	}
+	defer s.RUnlock()
+	candidates, plan := s.filterCandidatesLocked(n)
+	start := (page - 1) * limit
+	users := []User{}
+	total := 0
+	for i, u := range candidates {
+		if i%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, "", ctx.Err()
+		}
+		if !n.match(u) {
+			continue
+		}
+		if total >= start && len(users) < limit {
+			users = append(users, *u)
+		}
+		total++
+	}
+	return users, total, plan, nil
+}
+
+// listUsersRequest serves GET /admin/users.
+func listUsersRequest(r *http.Request) (interface{}, error) {
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+	raw := strings.TrimSpace(r.URL.Query().Get("filter"))
+	if raw == "" {
+		return nil, &paramError{Param: "filter", Message: "is required, e.g. rating>2500 AND username~\"rahul*\""}
+	}
+	filter, err := parseFilter(raw)
+	if err != nil {
+		return nil, err
+	}
+	users, total, plan, err := userStore.FilterUsers(r.Context(), filter, page, limit)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"filter":     filter.String(),
+		"plan":       plan,
+		"users":      users,
+		"page":       page,
+		"limit":      limit,
+		"total":      total,
+		"totalPages": (total + limit - 1) / limit,
+		"links":      pageLinks(requestURL(r), models.NewPagination(page, limit, total)),
+	}, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"matiks-leaderboard/utils"
+)
+
+// seededStore returns a sorted store holding seeded.
+func seededStore(seeded []utils.SeedUser) *UserStore {
+	s := NewUserStore()
+	s.Lock()
+	s.loadUsersLocked(seeded)
+	s.Unlock()
+	return s
+}
+
+// filterTree prints n's structure, with each comparison's value as parsed.
+func filterTree(n filterNode) string {
+	switch n := n.(type) {
+	case filterAnd:
+		return "and(" + filterTrees(n) + ")"
+	case filterOr:
+		return "or(" + filterTrees(n) + ")"
+	case filterNot:
+		return "not(" + filterTree(n.node) + ")"
+	case *filterCmp:
+		return n.field + n.op + strconv.Quote(n.raw)
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+func filterTrees(nodes []filterNode) string {
+	parts := make([]string, len(nodes))
+	for i, c := range nodes {
+		parts[i] = filterTree(c)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"and binds tighter", `id=a OR id=b AND id=c`, `or(id="a" and(id="b" id="c"))`},
+		{"and before or", `id=a AND id=b OR id=c`, `or(and(id="a" id="b") id="c")`},
+		{"parentheses", `(id=a OR id=b) AND id=c`, `and(or(id="a" id="b") id="c")`},
+		{"not binds tightest", `NOT id=a AND id=b`, `and(not(id="a") id="b")`},
+		{"not a group", `NOT (id=a OR id=b)`, `not(or(id="a" id="b"))`},
+		{"double not", `NOT NOT id=a`, `not(not(id="a"))`},
+		{"keywords ignore case", `id=a or id=b And nOt id=c`, `or(id="a" and(id="b" not(id="c")))`},
+		{"keywords inside words", `id=android OR id=notable`, `or(id="android" id="notable")`},
+		{"fields ignore case", `RATING>=2500`, `rating>="2500"`},
+		{"spaces optional", `(rank<=10)AND(rating>1)`, `and(rank<="10" rating>"1")`},
+		{"quoted", `username="rahul s"`, `username="rahul s"`},
+		{"escaped quote", `username="say \"hi\""`, `username="say \"hi\""`},
+		{"escaped backslash", `id="a\\b"`, `id="a\\b"`},
+		{"other backslashes kept", `id="a\nb"`, `id="a\\nb"`},
+		{"empty string", `id=""`, `id=""`},
+		{"glob", `username~"*bot*"`, `username~"*bot*"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseFilter(tt.src)
+			if err != nil {
+				t.Fatalf("parseFilter(%q): %v", tt.src, err)
+			}
+			if got := filterTree(n); got != tt.want {
+				t.Errorf("parseFilter(%q) = %s, want %s", tt.src, got, tt.want)
+			}
+
+			// String prints a filter that parses back the same
+			again, err := parseFilter(n.String())
+			if err != nil || filterTree(again) != tt.want {
+				t.Errorf("%q printed as %q, which parses to %v, %v", tt.src, n.String(), again, err)
+			}
+		})
+	}
+
+	n, _ := parseFilter(`username="RAHUL"`)
+	if c := n.(*filterCmp); c.str != "rahul" {
+		t.Errorf("username value folded to %q, want rahul", c.str)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	deep := strings.Repeat("(", maxFilterDepth) + "id=a" + strings.Repeat(")", maxFilterDepth)
+	tests := []struct {
+		name, src, want string
+	}{
+		{"empty", ``, `at 0: unexpected end`},
+		{"missing value", `rating>`, `at 7: unexpected end`},
+		{"missing operator", `id`, `at 2: expected one of`},
+		{"dangling and", `id=a AND`, `at 8: unexpected end`},
+		{"unknown field", `score>1`, `at 0: unknown field "score"`},
+		{"rating not a number", `rating>abc`, `at 7: rating needs a number, got "abc"`},
+		{"rank not a number", `id=a AND rank<=x1`, `at 15: rank needs a number`},
+		{"glob on a number", `rank~5`, `at 4: rank compares with = != > >= < <=`},
+		{"order on a string", `id>5`, `at 2: id compares with = != ~`},
+		{"unclosed group", `(id=a`, `at 5: expected )`},
+		{"stray paren", `id=a)`, `at 4: unexpected ")"`},
+		{"unterminated string", `id="abc`, `at 3: unterminated string`},
+		{"bad byte", `id=a & id=b`, `at 5: unexpected "& id=b"`},
+		{"too long", strings.Repeat("x", maxFilterLength+1), `must be at most 512 bytes`},
+		{"bad utf-8", "id=\xff", `must be valid UTF-8`},
+		{"too many comparisons", strings.Repeat("id=a OR ", maxFilterClauses) + "id=a", `at 128: more than 16 comparisons`},
+		{"too deep", "(" + deep + ")", `at 8: nested more than 8 deep`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseFilter(tt.src)
+			var perr *paramError
+			if !errors.As(err, &perr) || perr.Param != "filter" {
+				t.Fatalf("parseFilter(%q) = %v, want a filter paramError", tt.src, err)
+			}
+			if !strings.HasPrefix(perr.Message, tt.want) {
+				t.Errorf("parseFilter(%q) = %q, want %q...", tt.src, perr.Message, tt.want)
+			}
+		})
+	}
+
+	// The limits themselves are allowed
+	for _, src := range []string{
+		strings.Repeat("id=a OR ", maxFilterClauses-1) + "id=a",
+		deep,
+		"id=" + strings.Repeat("x", maxFilterLength-3),
+	} {
+		if _, err := parseFilter(src); err != nil {
+			t.Errorf("parseFilter at a limit (%d bytes): %v", len(src), err)
+		}
+	}
+}
+
+// TestFilterPlans checks each plan selects what a scan of the whole
+// leaderboard would, in leaderboard order.
+func TestFilterPlans(t *testing.T) {
+	s := seededStore(newSeeder(3).Users(2000))
+	ctx := context.Background()
+	if err := s.RLockSorted(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.RUnlock()
+	ranked := s.RankedLocked()
+	u := ranked[100]
+
+	tests := []struct {
+		src, plan string
+	}{
+		{"id=" + u.ID, "id"},
+		{"id=" + u.ID + " AND rating>" + strconv.Itoa(u.Rating), "id"},
+		{"id=nobody", "id"},
+		{fmt.Sprintf("username=%q AND rank<1000", strings.ToUpper(u.Username)), "username"},
+		{"username=nobody", "username"},
+		{"rating>=3000 AND rating<3100", "rating"},
+		{"rating=" + strconv.Itoa(u.Rating), "rating"},
+		{"rating>=4000 AND username~\"*a*\"", "rating"},
+		{"rank<=50", "rank"},
+		{"rank>10 AND rank<=20 AND rating>=0", "rank"},
+		{"rank<=5 AND rating<=5000 AND rating>=0", "rank"},
+		{`username~"al*"`, "usernamePrefix"},
+		{`username~"a*" AND username~"alex_m*"`, "usernamePrefix"},
+		{`username~"alex_m*" AND rating>2000`, "usernamePrefix"},
+		{`username~"al*" AND rank<=3`, "rank"},
+		{`username~"*son*"`, "scan"},
+		{"rating>4000 OR rank<5", "scan"},
+		{"NOT rating>1000", "scan"},
+		{"rating!=2000", "scan"},
+		{"rating>" + strconv.Itoa(u.Rating) + " AND NOT id=" + ranked[0].ID, "rating"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			n, err := parseFilter(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			candidates, plan := s.filterCandidatesLocked(n)
+			if plan != tt.plan {
+				t.Errorf("plan %s, want %s", plan, tt.plan)
+			}
+			var got, want []string
+			for _, c := range candidates {
+				if n.match(c) {
+					got = append(got, c.ID)
+				}
+			}
+			for _, c := range ranked {
+				if n.match(c) {
+					want = append(want, c.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s plan selected %d users, a scan %d", plan, len(got), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeUsername trims a name and puts it in NFC, so the same name typed
+// with precomposed or combining accents is one name.
+func NormalizeUsername(name string) string {
+	name = strings.TrimSpace(name)
+	if isASCII(name) {
+		return name
+	}
+	return norm.NFC.String(name)
+}
+
+// FoldUsername is the case-insensitive form of a name or search query,
+// which usernames are compared and searched in.
+func FoldUsername(name string) string {
+	if isASCII(name) {
+		return strings.ToLower(name)
+	}
+	// A Caser is stateful, so each call gets its own
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(name)))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
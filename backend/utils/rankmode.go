@@ -0,0 +1,35 @@
+package utils
+
+import "fmt"
+
+// RankMode names how ranks are numbered down a sorted leaderboard.
+type RankMode string
+
+const (
+	RankStandard RankMode = "standard" // Competition ranking: 1, 2, 2, 4
+	RankDense    RankMode = "dense"    // 1, 2, 2, 3
+	RankOrdinal  RankMode = "ordinal"  // 1, 2, 3, 4, ties in tie-break order
+)
+
+func ParseRankMode(raw string) (RankMode, error) {
+	switch m := RankMode(raw); m {
+	case RankStandard, RankDense, RankOrdinal:
+		return m, nil
+	}
+	return "", fmt.Errorf("rank mode must be %s, %s or %s, got %q", RankStandard, RankDense, RankOrdinal, raw)
+}
+
+// Next is the rank at position pos (0-based) of a sorted list, given the
+// rank before it and whether it ties with the user before it.
+func (m RankMode) Next(prevRank, pos int, tied bool) int {
+	switch {
+	case m == RankOrdinal:
+		return pos + 1
+	case tied:
+		return prevRank
+	case m == RankDense:
+		return prevRank + 1
+	default:
+		return pos + 1
+	}
+}
@@ -1,18 +1,175 @@
 package utils
 
 import (
-    "math/rand"
-    "time"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
 )
 
-func GenerateUsername(firstNames []string, lastNames []string) string {
-    rand.Seed(time.Now().UnixNano())
-    
-    firstName := firstNames[rand.Intn(len(firstNames))]
-    if rand.Intn(100) < 50 {
-        return firstName
-    }
-    
-    lastName := lastNames[rand.Intn(len(lastNames))]
-    return firstName + "_" + lastName
-}
\ No newline at end of file
+// Default name pools used when a Seeder is created without custom pools.
+var (
+	DefaultFirstNames = []string{"Alex", "Aaron", "Alice", "Amy", "Andrew", "Anna", "Anthony", "Ashley",
+		"Zack", "Zara", "Zane", "Zoe", "Zachary", "Zelda", "Zander", "Zuri",
+		"Rahul", "Priya", "Amit", "Neha", "Vikas", "Sonia", "Raj", "Meera",
+		"John", "Jane", "Mike", "Emma", "David", "Lisa", "Tom", "Sarah"}
+
+	DefaultLastNames = []string{"Sharma", "Kumar", "Verma", "Patel", "Singh", "Reddy", "Joshi", "Das",
+		"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis"}
+)
+
+// Rating bounds shared by the seeder and the simulator.
+const (
+	MinRating = 100
+	MaxRating = 5000
+)
+
+// RatingDistribution draws a rating from the given random source.
+type RatingDistribution interface {
+	Rating(rng *rand.Rand) int
+}
+
+// UniformRating draws ratings uniformly in [Min, Max].
+type UniformRating struct {
+	Min, Max int
+}
+
+func (d UniformRating) Rating(rng *rand.Rand) int {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	return d.Min + rng.Intn(d.Max-d.Min+1)
+}
+
+// NormalRating draws ratings from a normal distribution clamped to [Min, Max].
+type NormalRating struct {
+	Mean, StdDev float64
+	Min, Max     int
+}
+
+func (d NormalRating) Rating(rng *rand.Rand) int {
+	r := int(math.Round(rng.NormFloat64()*d.StdDev + d.Mean))
+	return ClampRating(r, d.Min, d.Max)
+}
+
+// ClampRating keeps r within [min, max].
+func ClampRating(r, min, max int) int {
+	if r < min {
+		return min
+	}
+	if r > max {
+		return max
+	}
+	return r
+}
+
+// SeedUser is a generated user record, independent of any store type.
+type SeedUser struct {
+	ID       string
+	Username string
+	Rating   int
+}
+
+// Seeder generates users from a private random source, so a fixed seed
+// always yields the same dataset. Not safe for concurrent use.
+type Seeder struct {
+	rng        *rand.Rand
+	firstNames []string
+	lastNames  []string
+	ratings    RatingDistribution
+	used       map[string]bool
+	next       int
+}
+
+// SeederOption configures a Seeder.
+type SeederOption func(*Seeder)
+
+// WithNamePools replaces the default first/last name pools.
+func WithNamePools(firstNames, lastNames []string) SeederOption {
+	return func(s *Seeder) {
+		if len(firstNames) > 0 {
+			s.firstNames = firstNames
+		}
+		if len(lastNames) > 0 {
+			s.lastNames = lastNames
+		}
+	}
+}
+
+// WithRatingDistribution sets how ratings are drawn.
+func WithRatingDistribution(d RatingDistribution) SeederOption {
+	return func(s *Seeder) {
+		if d != nil {
+			s.ratings = d
+		}
+	}
+}
+
+// NewSeeder returns a Seeder using the given seed.
+func NewSeeder(seed int64, opts ...SeederOption) *Seeder {
+	s := &Seeder{
+		rng:        rand.New(rand.NewSource(seed)),
+		firstNames: DefaultFirstNames,
+		lastNames:  DefaultLastNames,
+		ratings:    UniformRating{Min: MinRating, Max: MaxRating},
+		used:       make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Rand exposes the seeder's random source so callers (such as the simulator)
+// can stay deterministic under the same seed.
+func (s *Seeder) Rand() *rand.Rand {
+	return s.rng
+}
+
+// Reserve marks usernames as taken so the seeder never generates them.
+func (s *Seeder) Reserve(usernames ...string) {
+	for _, name := range usernames {
+		s.used[FoldUsername(NormalizeUsername(name))] = true
+	}
+}
+
+// Username returns a new username of the form first_lastN. Uniqueness is
+// guaranteed across all names this seeder has produced or reserved, compared
+// folded as the store compares them, so a seeded "straße_lee1" never lands
+// on a reserved "STRASSE_LEE1".
+func (s *Seeder) Username() string {
+	s.next++
+	first := strings.ToLower(s.firstNames[s.rng.Intn(len(s.firstNames))])
+	last := strings.ToLower(s.lastNames[s.rng.Intn(len(s.lastNames))])
+	username := fmt.Sprintf("%s_%s%d", first, last, s.next)
+
+	for suffix := 2; s.used[FoldUsername(username)]; suffix++ {
+		username = fmt.Sprintf("%s_%s%d_%d", first, last, s.next, suffix)
+	}
+	s.used[FoldUsername(username)] = true
+	return username
+}
+
+// Rating draws a rating from the configured distribution.
+func (s *Seeder) Rating() int {
+	return s.ratings.Rating(s.rng)
+}
+
+// User generates the next user. IDs are sequential: user_1, user_2, ...
+func (s *Seeder) User() SeedUser {
+	username := s.Username()
+	return SeedUser{
+		ID:       fmt.Sprintf("user_%d", s.next),
+		Username: username,
+		Rating:   s.Rating(),
+	}
+}
+
+// Users generates count users.
+func (s *Seeder) Users(count int) []SeedUser {
+	users := make([]SeedUser, 0, count)
+	for i := 0; i < count; i++ {
+		users = append(users, s.User())
+	}
+	return users
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeederDeterministic(t *testing.T) {
+	a := NewSeeder(42).Users(1000)
+	b := NewSeeder(42).Users(1000)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("the same seed gave different users")
+	}
+	if reflect.DeepEqual(a, NewSeeder(43).Users(1000)) {
+		t.Error("different seeds gave the same users")
+	}
+
+	// The simulator draws from the seeder's source after seeding
+	s1, s2 := NewSeeder(7), NewSeeder(7)
+	s1.Users(10)
+	s2.Users(10)
+	for i := 0; i < 100; i++ {
+		if x, y := s1.Rand().Int63(), s2.Rand().Int63(); x != y {
+			t.Fatalf("draw %d after seeding: %d != %d", i, x, y)
+		}
+	}
+}
+
+func TestSeederUnique(t *testing.T) {
+	// Small pools force the suffix path
+	s := NewSeeder(1, WithNamePools([]string{"Ann"}, []string{"Lee"}))
+	s.Reserve("ann_lee3")
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, u := range s.Users(20000) {
+		if ids[u.ID] {
+			t.Fatalf("duplicate ID %q", u.ID)
+		}
+		ids[u.ID] = true
+		lower := FoldUsername(u.Username)
+		if names[lower] {
+			t.Fatalf("duplicate username %q", u.Username)
+		}
+		names[lower] = true
+	}
+	if names["ann_lee3"] {
+		t.Error("generated the reserved name ann_lee3")
+	}
+}
+
+func TestSeederUniqueFolded(t *testing.T) {
+	// ß folds to ss but does not lower-case to it
+	s := NewSeeder(1, WithNamePools([]string{"Straße"}, []string{"Lee"}))
+	s.Reserve("STRASSE_LEE1")
+	if got := s.Username(); FoldUsername(got) == "strasse_lee1" {
+		t.Errorf("generated %q, which folds to the reserved STRASSE_LEE1", got)
+	}
+}
+
+func TestSeederRatingBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		dist     RatingDistribution
+		min, max int
+	}{
+		{"default", nil, MinRating, MaxRating},
+		{"uniform", UniformRating{Min: 1200, Max: 1300}, 1200, 1300},
+		{"degenerate uniform", UniformRating{Min: 1500, Max: 1500}, 1500, 1500},
+		{"normal clamped", NormalRating{Mean: 2500, StdDev: 5000, Min: MinRating, Max: MaxRating}, MinRating, MaxRating},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSeeder(3, WithRatingDistribution(tt.dist))
+			sawMin, sawMax := false, false
+			for _, u := range s.Users(10000) {
+				if u.Rating < tt.min || u.Rating > tt.max {
+					t.Fatalf("rating %d outside [%d, %d]", u.Rating, tt.min, tt.max)
+				}
+				sawMin = sawMin || u.Rating == tt.min
+				sawMax = sawMax || u.Rating == tt.max
+			}
+			if !sawMin || !sawMax {
+				t.Errorf("never reached the bounds: min %v, max %v", sawMin, sawMax)
+			}
+		})
+	}
+}
+
+func TestClampRating(t *testing.T) {
+	tests := []struct{ in, want int }{
+		{50, 100}, {100, 100}, {2500, 2500}, {5000, 5000}, {9000, 5000},
+	}
+	for _, tt := range tests {
+		if got := ClampRating(tt.in, 100, 5000); got != tt.want {
+			t.Errorf("ClampRating(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
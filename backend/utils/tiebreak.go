@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// TieMode names how users with equal ratings are ordered.
+type TieMode string
+
+const (
+	TieByID       TieMode = "id"       // ID, ascending
+	TieByUsername TieMode = "username" // Case-folded username, then ID
+	TieByEarliest TieMode = "earliest" // First to reach the rating, then ID
+	TieByRandom   TieMode = "random"   // Shuffled by a seeded hash of the ID
+)
+
+// TieBreak is the secondary sort key of a leaderboard. Every mode falls
+// back to ID, so the order is total.
+type TieBreak struct {
+	Mode TieMode `json:"mode"`
+	Seed uint64  `json:"seed,omitempty"` // TieByRandom only
+}
+
+// TieKey is what a TieBreak compares.
+type TieKey struct {
+	ID            string
+	UsernameLower string
+	ReachedAt     int64 // UnixNano when the ranked value was last set
+}
+
+// ParseTieBreak reads a mode name; seed is used by TieByRandom.
+func ParseTieBreak(mode string, seed uint64) (TieBreak, error) {
+	switch m := TieMode(mode); m {
+	case TieByID, TieByUsername, TieByEarliest:
+		return TieBreak{Mode: m}, nil
+	case TieByRandom:
+		return TieBreak{Mode: m, Seed: seed}, nil
+	}
+	return TieBreak{}, fmt.Errorf("tie break must be %s, %s, %s or %s, got %q",
+		TieByID, TieByUsername, TieByEarliest, TieByRandom, mode)
+}
+
+// Less reports whether a ranks ahead of b among equal ratings.
+func (t TieBreak) Less(a, b TieKey) bool {
+	switch t.Mode {
+	case TieByUsername:
+		if a.UsernameLower != b.UsernameLower {
+			return a.UsernameLower < b.UsernameLower
+		}
+	case TieByEarliest:
+		if a.ReachedAt != b.ReachedAt {
+			return a.ReachedAt < b.ReachedAt
+		}
+	case TieByRandom:
+		if ha, hb := t.hash(a.ID), t.hash(b.ID); ha != hb {
+			return ha < hb
+		}
+	}
+	return a.ID < b.ID
+}
+
+// Static reports whether the order only changes when users are added,
+// removed or renamed, rather than on every rating update.
+func (t TieBreak) Static() bool {
+	return t.Mode != TieByEarliest
+}
+
+func (t TieBreak) hash(id string) uint64 {
+	h := fnv.New64a()
+	h.Write(strconv.AppendUint(nil, t.Seed, 10))
+	h.Write([]byte(id))
+	return h.Sum64()
+}
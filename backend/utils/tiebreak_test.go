@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTieBreakLess(t *testing.T) {
+	tests := []struct {
+		name string
+		mode TieMode
+		a, b TieKey // a ranks ahead
+	}{
+		{"id", TieByID, TieKey{ID: "u1", UsernameLower: "zoe", ReachedAt: 9}, TieKey{ID: "u2", UsernameLower: "amy", ReachedAt: 1}},
+		{"username", TieByUsername, TieKey{ID: "u2", UsernameLower: "amy"}, TieKey{ID: "u1", UsernameLower: "zoe"}},
+		{"username then id", TieByUsername, TieKey{ID: "u1", UsernameLower: "amy"}, TieKey{ID: "u2", UsernameLower: "amy"}},
+		{"earliest", TieByEarliest, TieKey{ID: "u2", ReachedAt: 1}, TieKey{ID: "u1", ReachedAt: 9}},
+		{"earliest then id", TieByEarliest, TieKey{ID: "u1", ReachedAt: 5}, TieKey{ID: "u2", ReachedAt: 5}},
+		{"unknown mode orders by id", TieMode("rating"), TieKey{ID: "u1", ReachedAt: 9}, TieKey{ID: "u2", ReachedAt: 1}},
+	}
+	for _, tt := range tests {
+		tie := TieBreak{Mode: tt.mode}
+		if !tie.Less(tt.a, tt.b) || tie.Less(tt.b, tt.a) {
+			t.Errorf("%s: want %+v ahead of %+v", tt.name, tt.a, tt.b)
+		}
+		if tie.Less(tt.a, tt.a) {
+			t.Errorf("%s: %+v ranks ahead of itself", tt.name, tt.a)
+		}
+	}
+}
+
+// shuffled orders n IDs by tie.
+func shuffled(tie TieBreak, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user_%d", i)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return tie.Less(TieKey{ID: ids[i]}, TieKey{ID: ids[j]})
+	})
+	return ids
+}
+
+func TestTieBreakRandom(t *testing.T) {
+	seven := TieBreak{Mode: TieByRandom, Seed: 7}
+	order := shuffled(seven, 200)
+	if !reflect.DeepEqual(order, shuffled(seven, 200)) {
+		t.Fatal("the same seed gave different orders")
+	}
+	parsed, err := ParseTieBreak("random", 7)
+	if err != nil || !reflect.DeepEqual(order, shuffled(parsed, 200)) {
+		t.Errorf("ParseTieBreak(random, 7) = %+v, %v; want the same order as seed 7", parsed, err)
+	}
+	if reflect.DeepEqual(order, shuffled(TieBreak{Mode: TieByRandom, Seed: 8}, 200)) {
+		t.Error("different seeds gave the same order")
+	}
+	if reflect.DeepEqual(order, shuffled(TieBreak{Mode: TieByID}, 200)) {
+		t.Error("random order is ID order")
+	}
+
+	// Fixed across restarts and releases, since cursors carry tie keys
+	ids := []string{"alice", "bob", "carol", "dave", "erin", "frank"}
+	one := TieBreak{Mode: TieByRandom, Seed: 1}
+	sort.Slice(ids, func(i, j int) bool { return one.Less(TieKey{ID: ids[i]}, TieKey{ID: ids[j]}) })
+	if want := []string{"frank", "erin", "alice", "bob", "dave", "carol"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("seed 1 orders %q, want %q", ids, want)
+	}
+}
+
+func TestParseTieBreak(t *testing.T) {
+	for _, mode := range []TieMode{TieByID, TieByUsername, TieByEarliest} {
+		tie, err := ParseTieBreak(string(mode), 7)
+		if err != nil || tie != (TieBreak{Mode: mode}) {
+			t.Errorf("ParseTieBreak(%s, 7) = %+v, %v; want the mode without a seed", mode, tie, err)
+		}
+	}
+	if tie, err := ParseTieBreak("random", 7); err != nil || tie != (TieBreak{Mode: TieByRandom, Seed: 7}) {
+		t.Errorf("ParseTieBreak(random, 7) = %+v, %v", tie, err)
+	}
+	if _, err := ParseTieBreak("Random", 0); err == nil {
+		t.Error("ParseTieBreak accepted a mode in the wrong case")
+	}
+
+	for mode, want := range map[TieMode]bool{TieByID: true, TieByUsername: true, TieByRandom: true, TieByEarliest: false} {
+		if got := (TieBreak{Mode: mode}).Static(); got != want {
+			t.Errorf("%s: Static() = %v, want %v", mode, got, want)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/raft"
+
+	"matiks-leaderboard/models"
+)
+
+// The v1 API serves every endpoint under /v1 in one envelope (see
+// models.Envelope). Unversioned routes keep their original shapes for
+// existing clients.
+
+// v1Result is what a v1 handler produces; the wrapper adds meta and encodes.
+type v1Result struct {
+	Status     int // Defaults to 200
+	Data       interface{}
+	Pagination *models.Pagination
+	Links      *models.Links // Derived from Pagination if not set
+	Meta       map[string]interface{}
+}
+
+// v1HandlerFunc returns (nil, nil) once it has written the response itself,
+// e.g. a 304 from writeConditional.
+type v1HandlerFunc func(w http.ResponseWriter, r *http.Request) (*v1Result, error)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+func v1(h v1HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := h(w, r)
+		if err != nil {
+			if r.Context().Err() != nil {
+				return // Client is gone
+			}
+			status, apiErr := v1Error(err)
+			localizeError(w, r, apiErr)
+			if !writeProblem(w, r, status, apiErr) {
+				writeEnvelope(w, status, models.Envelope{Error: apiErr})
+			}
+			return
+		}
+		if res == nil {
+			return
+		}
+		status := res.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		links := res.Links
+		if links == nil && res.Pagination != nil {
+			links = pageLinks(requestURL(r), res.Pagination)
+		}
+		writeEnvelope(w, status, models.Envelope{
+			Data:       res.Data,
+			Pagination: res.Pagination,
+			Links:      links,
+			Meta:       res.Meta,
+		})
+	}
+}
+
+// v1Error maps store and request errors to a status and error body,
+// listing the offending inputs when err points at any.
+func v1Error(err error) (int, *models.APIError) {
+	status, apiErr := classifyError(err)
+	apiErr.Fields = fieldErrors(err)
+	return status, apiErr
+}
+
+func classifyError(err error) (int, *models.APIError) {
+	var ve *validationError
+	var pe *paramError
+	var ue *usernameError
+	switch {
+	case errors.As(err, &ve):
+		return http.StatusBadRequest, &models.APIError{Code: "validation_failed", Message: err.Error()}
+	case errors.As(err, &pe):
+		return http.StatusBadRequest, &models.APIError{
+			Code: "invalid_parameter", Message: pe.Message, Param: pe.Param, Details: limits,
+		}
+	case errors.As(err, &ue):
+		return http.StatusBadRequest, &models.APIError{
+			Code: "username_rejected", Message: err.Error(), Param: "username", Details: ue.Reasons,
+		}
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "user_not_found", Message: "User not found"}
+	case errors.Is(err, ErrRatingOutOfRange):
+		return http.StatusBadRequest, &models.APIError{Code: "rating_out_of_range", Message: err.Error(), Param: "rating"}
+	case errors.Is(err, errRouteNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "not_found", Message: "No such endpoint"}
+	case errors.Is(err, errBoardNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "board_not_found", Message: err.Error()}
+	case errors.Is(err, errSnapshotExpired):
+		return http.StatusGone, &models.APIError{Code: "snapshot_expired", Message: err.Error(), Param: "snapshot"}
+	case errors.Is(err, errArchiveNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "season_not_archived", Message: err.Error()}
+	case errors.Is(err, errWeekNotTracked):
+		return http.StatusNotFound, &models.APIError{Code: "week_not_tracked", Message: err.Error()}
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed, &models.APIError{Code: "method_not_allowed", Message: err.Error()}
+	case errors.Is(err, errReadOnlyReplica):
+		return http.StatusForbidden, &models.APIError{Code: "read_only_replica", Message: err.Error()}
+	case errors.Is(err, errJobNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "job_not_found", Message: err.Error()}
+	case errors.Is(err, errJobRunning):
+		return http.StatusConflict, &models.APIError{Code: "job_running", Message: err.Error()}
+	case errors.Is(err, errJobIdle):
+		return http.StatusConflict, &models.APIError{Code: "job_not_running", Message: err.Error()}
+	case errors.Is(err, errJobNoRunner):
+		return http.StatusBadRequest, &models.APIError{Code: "job_needs_parameters", Message: err.Error()}
+	case errors.Is(err, errUserNotDeleted):
+		return http.StatusNotFound, &models.APIError{Code: "user_not_deleted", Message: err.Error()}
+	case errors.Is(err, errUserNotInactive):
+		return http.StatusNotFound, &models.APIError{Code: "user_not_inactive", Message: err.Error()}
+	case errors.Is(err, ErrDuplicateID):
+		return http.StatusConflict, &models.APIError{Code: "user_exists", Message: err.Error()}
+	case errors.Is(err, ErrDuplicateUsername):
+		return http.StatusConflict, &models.APIError{Code: "username_taken", Message: err.Error()}
+	case errors.Is(err, errMembershipNotReplicated):
+		return http.StatusNotImplemented, &models.APIError{Code: "not_supported", Message: err.Error()}
+	case errors.Is(err, errFeatureNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "feature_not_found", Message: err.Error()}
+	case errors.Is(err, errRuleNotFound):
+		return http.StatusNotFound, &models.APIError{Code: "rule_not_found", Message: err.Error()}
+	case errors.Is(err, errWebhooksDisabled):
+		return http.StatusNotImplemented, &models.APIError{Code: "webhooks_disabled", Message: err.Error()}
+	case errors.Is(err, errPushDisabled):
+		return http.StatusServiceUnavailable, &models.APIError{Code: "push_disabled", Message: err.Error()}
+	case errors.Is(err, errBackendDegraded):
+		return http.StatusServiceUnavailable, &models.APIError{Code: "degraded", Message: err.Error()}
+	case errors.Is(err, errUnauthorized):
+		return http.StatusUnauthorized, &models.APIError{Code: "unauthorized", Message: err.Error()}
+	case errors.Is(err, errNotLocal):
+		return http.StatusForbidden, &models.APIError{Code: "forbidden", Message: err.Error()}
+	case errors.Is(err, errSearchTimeout):
+		return http.StatusServiceUnavailable, &models.APIError{Code: "search_timeout", Message: err.Error()}
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost):
+		return http.StatusServiceUnavailable, &models.APIError{Code: "not_leader", Message: err.Error()}
+	default:
+		log.Printf("v1: internal error: %v", err)
+		return http.StatusInternalServerError, &models.APIError{Code: "internal", Message: "Internal server error"}
+	}
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, env models.Envelope) {
+	if env.Meta == nil {
+		env.Meta = make(map[string]interface{})
+	}
+	env.Meta["apiVersion"] = models.APIVersion
+	env.Meta["timestamp"] = time.Now().Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(env)
+}
+
+func registerV1Routes() {
+	handle("/v1/leaderboard", v1(v1LeaderboardHandler))
+	handle("/v1/leaderboard/improvement", v1(v1ImprovementHandler))
+	handle("/v1/search", v1(v1SearchHandler))
+	handle("/v1/user/rank", v1(v1UserRankHandler))
+	handle("/v1/stats", v1(v1StatsHandler))
+	handle("/v1/stats/aggregates", v1(v1AggregatesHandler))
+	handle("/v1/stats/history", v1(v1HistoryHandler))
+	handle("/v1/analytics/daily", v1(v1DailyAnalyticsHandler))
+	handle("/v1/update", v1(v1UpdateHandler))
+	handle("/v1/force-sort", v1(v1ForceSortHandler))
+	handle("/v1/health", v1(v1HealthHandler))
+	handle("/v1/readyz", v1(v1ReadyzHandler))
+	handle("/v1/admin/runtime", v1(v1AdminRuntimeHandler))
+	handle("/v1/admin/dashboard", v1(v1AdminDashboardHandler))
+	handle("/v1/admin/search-analytics", v1(v1AdminSearchAnalyticsHandler))
+	handle("/v1/admin/seed", v1(v1AdminReseedHandler))
+	handle("/v1/admin/reseed", v1(v1AdminReseedHandler))
+	handle("/v1/admin/schedule", v1(v1AdminScheduleHandler))
+	handle("/v1/admin/jobs", v1(v1AdminJobsHandler))
+	handle("/v1/admin/jobs/", v1(v1AdminJobsHandler))
+	handle("/v1/admin/features", v1(v1AdminFeaturesHandler))
+	handle("/v1/admin/features/", v1(v1AdminFeaturesHandler))
+	handle("/v1/admin/webhooks", v1(v1AdminWebhooksHandler))
+	handle("/v1/admin/webhooks/rules", v1(v1AdminRulesHandler))
+	handle("/v1/admin/webhooks/rules/", v1(v1AdminRulesHandler))
+	handle("/v1/admin/users", v1(v1AdminUsersHandler))
+	handle("/v1/admin/users/", v1(v1AdminUsersHandler))
+	handle("/v1/users/", v1(v1UsersHandler))
+	handle("/v1/leaderboards", v1(v1BoardsHandler))
+	handle("/v1/leaderboards/", v1(v1BoardsHandler))
+	handle("/v1/archives", v1(v1ArchivesHandler))
+	handle("/v1/archives/", v1(v1ArchivesHandler))
+}
+
+func v1LeaderboardHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+	band, err := ratingBandParams(r)
+	if err != nil {
+		return nil, err
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		return nil, err
+	}
+	cursor, cursored, err := cursorParam(r)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := filteredBoardParam(r)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := snapshotParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil && filter == nil && writeConditional(w, r, userStore.LastModified()) {
+		return nil, nil
+	}
+
+	var users []User
+	var pagination *models.Pagination
+	var links *models.Links
+	meta := make(map[string]interface{})
+	if filter != nil {
+		list, total, _, pendingSorts, err := filter.Page(r.Context(), userStore, band, page, limit)
+		if err != nil {
+			return nil, err
+		}
+		users = list
+		meta["pendingSorts"] = pendingSorts
+		meta[filter.param] = filter.raw
+		pagination = models.NewPagination(page, limit, total)
+	} else if snap != nil {
+		list, total, _ := snap.Page(band, page, limit)
+		users = list
+		meta["pendingSorts"] = snap.pendingSorts
+		meta["snapshot"], meta["snapshotExpiresAt"] = snap.id, snap.expires.Unix()
+		pagination = models.NewPagination(page, limit, total)
+		links = pageLinks(withParam(requestURL(r), "snapshot", snap.id), pagination)
+	} else if cursored {
+		// Cursor pages have no page numbers: total, limit and nextCursor
+		// travel in meta instead
+		list, total, next, pendingSorts, err := userStore.GetLeaderboardAfter(r.Context(), band, cursor, limit)
+		if err != nil {
+			return nil, err
+		}
+		users = list
+		meta["pendingSorts"], meta["total"], meta["limit"] = pendingSorts, total, limit
+		meta["nextCursor"] = nil
+		nextCursor := ""
+		if next != nil {
+			nextCursor = next.String()
+			meta["nextCursor"] = nextCursor
+		}
+		links = cursorLinks(requestURL(r), nextCursor)
+	} else {
+		list, total, _, pendingSorts, err := userStore.GetLeaderboardBand(r.Context(), band, page, limit)
+		if err != nil {
+			return nil, err
+		}
+		users = list
+		meta["pendingSorts"] = pendingSorts
+		pagination = models.NewPagination(page, limit, total)
+	}
+	if band != allRatings {
+		meta["ratingBand"] = band
+	}
+	if include["metadata"] {
+		meta["metadata"] = metadata.ForUsers(users)
+	}
+	return &v1Result{
+		Data:       users,
+		Pagination: pagination,
+		Links:      links,
+		Meta:       meta,
+	}, nil
+}
+
+func v1SearchHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	query := r.URL.Query().Get("q")
+	if utf8.RuneCountInString(query) > limits.MaxQueryLength {
+		return nil, &paramError{Param: "q", Message: fmt.Sprintf("must be at most %d characters", limits.MaxQueryLength)}
+	}
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	by, err := searchByParam(r)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := searchModeParam(r)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := searchAlgoParam(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	var total int
+	meta := map[string]interface{}{"query": query, "by": by, "mode": mode}
+	if mode == searchModeRegex {
+		users, total, _, err = searchRegex(r, by, query, page, limit)
+	} else if by == searchByID {
+		users, total, _, err = userStore.SearchUserIDs(r.Context(), query, page, limit)
+	} else {
+		users, total, _, err = userStore.SearchUsers(r.Context(), algo, query, page, limit)
+		meta["algo"] = algo.Name()
+		if err == nil && total == 0 {
+			var suggestions []string
+			if suggestions, err = userStore.Suggest(r.Context(), query); suggestions != nil {
+				meta["suggestions"] = suggestions
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if include["metadata"] {
+		meta["metadata"] = metadata.ForUsers(users)
+	}
+	return &v1Result{
+		Data:       users,
+		Pagination: models.NewPagination(page, limit, total),
+		Meta:       meta,
+	}, nil
+}
+
+func v1UserRankHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	id, username, err := userRefParams(r)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := percentileParam(r)
+	if err != nil {
+		return nil, err
+	}
+	include, err := includeParam(r, "metadata")
+	if err != nil {
+		return nil, err
+	}
+	rankInfo, err := userStore.GetUserRank(r.Context(), id, username, mode)
+	if err != nil {
+		return nil, err
+	}
+	user := rankInfo["user"].(User)
+	if include["metadata"] {
+		rankInfo["metadata"] = metadata.Get(user.ID)
+	}
+	rankInfo["links"] = userLinks(r, user.ID)
+	return &v1Result{Data: rankInfo}, nil
+}
+
+func v1StatsHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	if writeConditional(w, r, userStore.LastModified()) {
+		return nil, nil
+	}
+	return &v1Result{Data: collectStats(r.Context())}, nil
+}
+
+func v1UpdateHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	count, err := intParam(r, "count", 1+rand.Intn(200), 1, limits.MaxUpdateCount)
+	if err != nil {
+		return nil, err
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		plan, err := userStore.PlanRandomScores(r.Context(), count)
+		if err != nil {
+			return nil, err
+		}
+		return &v1Result{Data: plan}, nil
+	}
+	if err := userStore.updateRandomScores(r.Context(), count, sourceAPI); err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: map[string]interface{}{"attempted": count}}, nil
+}
+
+func v1ForceSortHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	started, err := startForceSort(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Status: http.StatusAccepted, Data: started}, nil
+}
+
+func v1HealthHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	return &v1Result{Data: healthReport()}, nil
+}
+
+func v1ReadyzHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	report, ready := readinessReport(r.Context())
+	res := &v1Result{Data: report}
+	if !ready {
+		res.Status = http.StatusServiceUnavailable
+	}
+	return res, nil
+}
+
+func v1AdminRuntimeHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	return &v1Result{Data: runtimeReport()}, nil
+}
+
+func v1AdminDashboardHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	movers, err := intParam(r, "movers", 5, 1, 50)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: dashboard.Report(userStore, movers)}, nil
+}
+
+func v1AdminSearchAnalyticsHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	top, err := intParam(r, "top", 20, 1, 100)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: searchStats.Report(top)}, nil
+}
+
+func v1AdminReseedHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return nil, fmt.Errorf("%w: use POST", errMethodNotAllowed)
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		plan, err := planReseed(r)
+		if err != nil {
+			return nil, err
+		}
+		return &v1Result{Data: plan}, nil
+	}
+	started, err := startReseed(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Status: http.StatusAccepted, Data: started}, nil
+}
+
+func v1AdminScheduleHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	return &v1Result{Data: scheduler.Status()}, nil
+}
+
+func v1AdminJobsHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	if id := jobRunID(r); id != "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return nil, fmt.Errorf("%w: use GET", errMethodNotAllowed)
+		}
+		if id == "history" {
+			q := r.URL.Query()
+			return &v1Result{Data: jobs.Runs(q.Get("name"), q.Get("state"))}, nil
+		}
+		status, err := jobs.RunStatus(id)
+		if err != nil {
+			return nil, err
+		}
+		return &v1Result{Data: status}, nil
+	}
+	if r.Method != http.MethodPost {
+		return &v1Result{Data: jobs.Status()}, nil
+	}
+	status, err := jobAction(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: status}, nil
+}
+
+func v1AdminWebhooksHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	if webhooks == nil {
+		return &v1Result{Data: map[string]interface{}{"enabled": false}}, nil
+	}
+	report := webhooks.Report()
+	report["enabled"] = true
+	return &v1Result{Data: report}, nil
+}
+
+func v1AdminUsersHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+	data, err := adminUsersRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: data}, nil
+}
+
+func v1UsersHandler(w http.ResponseWriter, r *http.Request) (*v1Result, error) {
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+	var data interface{}
+	var err error
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/digest"):
+		data, err = digestRequest(r)
+	case strings.HasSuffix(r.URL.Path, "/metadata"):
+		data, err = userMetadataRequest(r)
+	case strings.HasSuffix(r.URL.Path, "/metrics"):
+		data, err = userMetricsRequest(r)
+	default:
+		data, err = userProfileRequest(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v1Result{Data: data}, nil
+}
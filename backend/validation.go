@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"matiks-leaderboard/models"
+)
+
+// Creating a user, patching a profile or metrics and importing rows check
+// every input before giving up, so one response names all the problems.
+// Errors that point at an input (a rejected parameter, username or rating,
+// a taken ID or name) become {field, code, message} entries: "fields" on
+// the v1 error and in the unversioned error body, and on each failed
+// import row.
+
+// validationError gathers the errors from checking several inputs.
+type validationError struct {
+	errs []error
+}
+
+// add records err, flattening another validationError; nil is ignored.
+func (v *validationError) add(err error) {
+	var nested *validationError
+	switch {
+	case err == nil:
+	case errors.As(err, &nested):
+		v.errs = append(v.errs, nested.errs...)
+	default:
+		v.errs = append(v.errs, err)
+	}
+}
+
+// orNil returns v as an error, or nil if nothing was added. A single
+// error is returned as itself, so it keeps its own code and shape.
+func (v *validationError) orNil() error {
+	switch len(v.errs) {
+	case 0:
+		return nil
+	case 1:
+		return v.errs[0]
+	}
+	return v
+}
+
+func (v *validationError) Error() string {
+	messages := make([]string, len(v.errs))
+	for i, err := range v.errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As see each gathered error.
+func (v *validationError) Unwrap() []error {
+	return v.errs
+}
+
+// fieldErrors lists the inputs err points at, or nil.
+func fieldErrors(err error) []models.FieldError {
+	var v *validationError
+	var pe *paramError
+	var ue *usernameError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &v):
+		var fields []models.FieldError
+		for _, e := range v.errs {
+			fields = append(fields, fieldErrors(e)...)
+		}
+		return fields
+	case errors.As(err, &pe):
+		return []models.FieldError{{Field: pe.Param, Code: "invalid", Message: pe.Message}}
+	case errors.As(err, &ue):
+		fields := make([]models.FieldError, len(ue.Reasons))
+		for i, reason := range ue.Reasons {
+			fields[i] = models.FieldError{Field: "username", Code: reason.Code, Message: reason.Message}
+		}
+		return fields
+	case errors.Is(err, ErrRatingOutOfRange):
+		return []models.FieldError{{Field: "rating", Code: "rating_out_of_range", Message: err.Error()}}
+	case errors.Is(err, ErrDuplicateID):
+		return []models.FieldError{{Field: "id", Code: "user_exists", Message: err.Error()}}
+	case errors.Is(err, ErrDuplicateUsername):
+		return []models.FieldError{{Field: "username", Code: "username_taken", Message: err.Error()}}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A user's volatility is the standard deviation of their last
+// MATIKS_VOLATILITY_SAMPLES rating changes (default 20), from the event bus
+// since startup, in rating units: near zero for steady players, large for
+// erratic ones. Profiles show it once a user has two changes, and
+// /leaderboard?maxVolatility=25 ranks only users at or below it, among
+// themselves, as a filtered board (see filteredboards.go). Users with fewer
+// than two changes have no volatility and are left off filtered boards.
+//
+// Each user keeps a ring of changes with running sums, so a change updates
+// their deviation in constant time.
+
+const minVolatilitySamples = 2
+
+type ratingDeltas struct {
+	deltas     []float64 // Ring, in stored units
+	next       int
+	sum, sumSq float64
+}
+
+func (d *ratingDeltas) add(delta float64, size int) {
+	if len(d.deltas) < size {
+		d.deltas = append(d.deltas, delta)
+	} else {
+		old := d.deltas[d.next]
+		d.sum -= old
+		d.sumSq -= old * old
+		d.deltas[d.next] = delta
+		d.next = (d.next + 1) % size
+	}
+	d.sum += delta
+	d.sumSq += delta * delta
+}
+
+// stdDev is the population standard deviation, in rating units.
+func (d *ratingDeltas) stdDev() float64 {
+	n := float64(len(d.deltas))
+	mean := d.sum / n
+	variance := d.sumSq/n - mean*mean
+	if variance < 0 { // Rounding in the running sums
+		variance = 0
+	}
+	return math.Sqrt(variance) / float64(scores.unit)
+}
+
+type VolatilityTracker struct {
+	store   *UserStore
+	samples int
+
+	mu      sync.Mutex
+	users   map[string]*ratingDeltas
+	version uint64
+	boards  *filteredBoards
+}
+
+var volatility *VolatilityTracker
+
+func NewVolatilityTracker(store *UserStore, samples int) *VolatilityTracker {
+	if samples < minVolatilitySamples {
+		samples = minVolatilitySamples
+	}
+	t := &VolatilityTracker{store: store, samples: samples, users: make(map[string]*ratingDeltas)}
+	t.boards = newFilteredBoards("maxVolatility", t.Version)
+	return t
+}
+
+func (t *VolatilityTracker) run(ctx context.Context) error {
+	events := t.store.events.Subscribe(4096)
+	defer t.store.events.Unsubscribe(events)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if p, ok := ev.Payload.(RatingChangedPayload); ok {
+				t.record(p)
+			}
+		}
+	}
+}
+
+func (t *VolatilityTracker) record(p RatingChangedPayload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.users[p.UserID]
+	if d == nil {
+		d = &ratingDeltas{}
+		t.users[p.UserID] = d
+	}
+	d.add(float64(p.NewRating-p.OldRating), t.samples)
+	t.version++
+}
+
+func (t *VolatilityTracker) Version() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.version
+}
+
+// Get is the user's volatility and how many changes it is over; ok is false
+// with fewer than two.
+func (t *VolatilityTracker) Get(userID string) (stdDev float64, samples int, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.users[userID]
+	if d == nil || len(d.deltas) < minVolatilitySamples {
+		return 0, 0, false
+	}
+	return d.stdDev(), len(d.deltas), true
+}
+
+// Describe is the profile field for the user, or nil without a volatility.
+func (t *VolatilityTracker) Describe(userID string) map[string]interface{} {
+	stdDev, samples, ok := t.Get(userID)
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"stdDev":  math.Round(stdDev*100) / 100,
+		"samples": samples,
+	}
+}
+
+// filter is the board of users at or below max.
+func (t *VolatilityTracker) filter(raw string, max float64) *boardFilter {
+	return &boardFilter{
+		filteredBoards: t.boards,
+		raw:            raw,
+		key:            strconv.FormatFloat(max, 'g', -1, 64),
+		build: func(s *UserStore, now time.Time) ([]User, time.Time) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			var copies []User
+			for _, u := range s.RankedLocked() {
+				d := t.users[u.ID]
+				if d == nil || len(d.deltas) < minVolatilitySamples || d.stdDev() > max {
+					continue
+				}
+				copies = append(copies, *u)
+			}
+			return copies, time.Time{}
+		},
+	}
+}
+
+// volatilityParam reads ?maxVolatility=.
+func volatilityParam(r *http.Request) (*boardFilter, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("maxVolatility"))
+	max, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(max) || math.IsInf(max, 0) || max < 0 {
+		return nil, &paramError{Param: "maxVolatility", Message: fmt.Sprintf("must be a non-negative number, got %q", raw)}
+	}
+	return volatility.filter(raw, max), nil
+}
+
+func (t *VolatilityTracker) Stats() map[string]interface{} {
+	t.mu.Lock()
+	tracked := len(t.users)
+	t.mu.Unlock()
+	stats := t.boards.Stats()
+	stats["samples"] = t.samples
+	stats["users"] = tracked
+	return stats
+}
+
+// startVolatility tracks rating changes as the "volatility" job.
+func startVolatility(store *UserStore) {
+	volatility = NewVolatilityTracker(store, envInt("MATIKS_VOLATILITY_SAMPLES", 20))
+	jobs.Register("volatility", volatility.run)
+	jobs.Run("volatility")
+}
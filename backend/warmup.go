@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// warmup runs once the store is seeded or loaded and before the listener
+// opens: it sorts, then fills the cache with the first MATIKS_WARMUP_PAGES
+// leaderboard pages (default 5, 0 to skip) at the default page size, the
+// band total cached with them, and builds every metric board once. A deploy
+// then starts answering from sorted data instead of every early request
+// queueing behind the same first sort.
+func warmup(store *UserStore) {
+	pages := envInt("MATIKS_WARMUP_PAGES", 5)
+	if pages <= 0 {
+		return
+	}
+	start := time.Now()
+
+	store.Lock()
+	if store.StaleLocked() {
+		store.SortLocked()
+	}
+	store.Unlock()
+
+	var total int
+	for page := 1; page <= pages; page++ {
+		_, total, _, _, _ = store.GetLeaderboardBand(context.Background(), allRatings, page, limits.DefaultLimit)
+	}
+	for _, b := range metrics.Boards() {
+		metrics.Page(b.Name, 1, limits.DefaultLimit)
+	}
+	log.Printf("Warmup: sorted %d users, cached %d pages, built %d boards in %v",
+		total, pages, len(metrics.Boards()), time.Since(start))
+}
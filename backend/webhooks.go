@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Outgoing webhooks. Every subscribed event becomes one delivery per
+// endpoint, persisted in a bolt file before it is attempted, so queued and
+// retrying deliveries survive a restart. Failures back off exponentially;
+// after webhookMaxAttempts a delivery moves to the dead-letter bucket.
+//
+// Each request carries:
+//
+//	X-Matiks-Event:     event type
+//	X-Matiks-Delivery:  delivery ID (stable across retries)
+//	X-Matiks-Timestamp: unix seconds when this attempt was signed
+//	X-Matiks-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+
+var (
+	webhookQueueBucket = []byte("queue")
+	webhookDeadBucket  = []byte("dead")
+	webhookRulesBucket = []byte("rules") // See thresholds.go
+)
+
+const (
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 2 * time.Second
+	webhookMaxBackoff  = 10 * time.Minute
+	webhookLogSize     = 200
+)
+
+// webhookDelivery is one event bound for one endpoint.
+type webhookDelivery struct {
+	ID          uint64          `json:"id"`
+	Endpoint    string          `json:"endpoint"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// webhookAttempt is one entry of the in-memory delivery log.
+type webhookAttempt struct {
+	DeliveryID uint64    `json:"deliveryId"`
+	Endpoint   string    `json:"endpoint"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	Status     int       `json:"status,omitempty"` // HTTP status, 0 if no response
+	DurationMs float64   `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	Outcome    string    `json:"outcome"` // delivered, retrying or dead
+}
+
+// WebhookDispatcher queues and delivers webhooks.
+type WebhookDispatcher struct {
+	db        *bolt.DB
+	endpoints []string
+	secret    []byte
+	events    map[string]bool // Empty means every event type
+	client    *http.Client
+	wake      chan struct{}
+
+	mu  sync.Mutex
+	log []webhookAttempt // Ring of the latest attempts
+	pos int
+}
+
+var webhooks *WebhookDispatcher
+
+// startWebhooks opens the queue and runs delivery as the "webhooks" job.
+func startWebhooks(bus *EventBus, endpoints []string) error {
+	path := envString("MATIKS_WEBHOOK_QUEUE", filepath.Join(envString("MATIKS_DATA_DIR", "data"), "webhooks.db"))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("open queue %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{webhookQueueBucket, webhookDeadBucket, webhookRulesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = thresholds.load(db)
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	d := &WebhookDispatcher{
+		db:        db,
+		endpoints: endpoints,
+		secret:    []byte(os.Getenv("MATIKS_WEBHOOK_SECRET")),
+		events:    make(map[string]bool),
+		client:    &http.Client{Timeout: envDuration("MATIKS_WEBHOOK_TIMEOUT", 5*time.Second)},
+		wake:      make(chan struct{}, 1),
+	}
+	// Rating changes are too chatty to post one by one, so by default only
+	// the per-sort summaries go out
+	for _, ev := range envListDefault("MATIKS_WEBHOOK_EVENTS", EventRanksChanged, EventTop10Changed) {
+		if ev != "*" {
+			d.events[ev] = true
+		}
+	}
+	webhooks = d
+
+	jobs.Register("webhooks", func(ctx context.Context) error {
+		return d.run(ctx, bus)
+	})
+	if err := jobs.Run("webhooks"); err != nil {
+		return err
+	}
+	log.Printf("Webhooks: delivering to %d endpoint(s) and %d threshold rule(s), queue %s", len(endpoints), atomic.LoadInt64(&thresholds.count), path)
+	return nil
+}
+
+// envListDefault is envList with a fallback for an unset variable.
+func envListDefault(key string, fallback ...string) []string {
+	if list := envList(key); len(list) > 0 {
+		return list
+	}
+	return fallback
+}
+
+func (d *WebhookDispatcher) run(ctx context.Context, bus *EventBus) error {
+	events := bus.Subscribe(1024)
+	defer bus.Unsubscribe(events)
+
+	go d.deliverLoop(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if len(d.events) > 0 && !d.events[ev.Type] {
+				continue
+			}
+			if err := d.enqueue(ev); err != nil {
+				log.Printf("Webhooks: enqueue %s event: %v", ev.Type, err)
+			}
+		case c := <-thresholds.crossings:
+			endpoints := d.endpoints
+			if c.url != "" {
+				endpoints = []string{c.url}
+			}
+			if err := d.enqueueTo(endpoints, c.ev); err != nil {
+				log.Printf("Webhooks: enqueue %s event: %v", c.ev.Type, err)
+			}
+		}
+	}
+}
+
+// enqueue persists one delivery per endpoint and wakes the sender.
+func (d *WebhookDispatcher) enqueue(ev Event) error {
+	return d.enqueueTo(d.endpoints, ev)
+}
+
+// enqueueTo persists one delivery of ev to each of endpoints.
+func (d *WebhookDispatcher) enqueueTo(endpoints []string, ev Event) error {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		queue := tx.Bucket(webhookQueueBucket)
+		for _, endpoint := range endpoints {
+			id, err := queue.NextSequence()
+			if err != nil {
+				return err
+			}
+			delivery := webhookDelivery{
+				ID: id, Endpoint: endpoint, Event: ev.Type, Body: body,
+				CreatedAt: now, NextAttempt: now,
+			}
+			if err := putDelivery(queue, &delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func deliveryKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func putDelivery(b *bolt.Bucket, delivery *webhookDelivery) error {
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return b.Put(deliveryKey(delivery.ID), value)
+}
+
+// deliverLoop sends due deliveries oldest first, then sleeps until the next
+// one is due or a new one arrives.
+func (d *WebhookDispatcher) deliverLoop(ctx context.Context) {
+	for {
+		due, next, err := d.due(time.Now())
+		if err != nil {
+			log.Printf("Webhooks: reading queue: %v", err)
+			next = time.Now().Add(webhookBaseBackoff)
+		}
+		for _, delivery := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			d.attempt(ctx, delivery)
+		}
+		if len(due) > 0 {
+			continue // Retries scheduled meanwhile may be due already
+		}
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// due returns deliveries ready to send and when the earliest other one is.
+func (d *WebhookDispatcher) due(now time.Time) ([]*webhookDelivery, time.Time, error) {
+	var due []*webhookDelivery
+	var next time.Time
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhookQueueBucket).ForEach(func(_, value []byte) error {
+			delivery := new(webhookDelivery)
+			if err := json.Unmarshal(value, delivery); err != nil {
+				return err
+			}
+			if !delivery.NextAttempt.After(now) {
+				due = append(due, delivery)
+			} else if next.IsZero() || delivery.NextAttempt.Before(next) {
+				next = delivery.NextAttempt
+			}
+			return nil
+		})
+	})
+	return due, next, err
+}
+
+// attempt sends one delivery and records the outcome in the queue and log.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *webhookDelivery) {
+	delivery.Attempts++
+	startTime := time.Now()
+	status, err := d.send(ctx, delivery)
+	entry := webhookAttempt{
+		DeliveryID: delivery.ID,
+		Endpoint:   delivery.Endpoint,
+		Event:      delivery.Event,
+		Attempt:    delivery.Attempts,
+		At:         startTime,
+		Status:     status,
+		DurationMs: float64(time.Since(startTime).Microseconds()) / 1000,
+	}
+
+	dbErr := d.db.Update(func(tx *bolt.Tx) error {
+		queue := tx.Bucket(webhookQueueBucket)
+		if err == nil {
+			entry.Outcome = "delivered"
+			return queue.Delete(deliveryKey(delivery.ID))
+		}
+
+		entry.Error = err.Error()
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= webhookMaxAttempts {
+			entry.Outcome = "dead"
+			if err := putDelivery(tx.Bucket(webhookDeadBucket), delivery); err != nil {
+				return err
+			}
+			return queue.Delete(deliveryKey(delivery.ID))
+		}
+		entry.Outcome = "retrying"
+		delivery.NextAttempt = time.Now().Add(webhookBackoff(delivery.Attempts))
+		return putDelivery(queue, delivery)
+	})
+	if dbErr != nil {
+		log.Printf("Webhooks: updating delivery %d: %v", delivery.ID, dbErr)
+	}
+	if entry.Outcome == "dead" {
+		log.Printf("Webhooks: giving up on delivery %d to %s after %d attempts: %v",
+			delivery.ID, delivery.Endpoint, delivery.Attempts, err)
+	}
+	d.record(entry)
+}
+
+// webhookBackoff doubles from webhookBaseBackoff per attempt, capped, with
+// up to 20% jitter so failing endpoints aren't retried in lockstep.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookMaxBackoff
+	if attempts < 20 {
+		if b := webhookBaseBackoff << (attempts - 1); b < webhookMaxBackoff {
+			backoff = b
+		}
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, delivery *webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Endpoint, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "matiks-leaderboard-webhooks")
+	req.Header.Set("X-Matiks-Event", delivery.Event)
+	req.Header.Set("X-Matiks-Delivery", strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set("X-Matiks-Timestamp", timestamp)
+	if len(d.secret) > 0 {
+		req.Header.Set("X-Matiks-Signature", "sha256="+signWebhook(d.secret, timestamp, delivery.Body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook is the hex HMAC-SHA256 of timestamp + "." + body. Signing the
+// timestamp lets receivers reject replays of old deliveries.
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) record(entry webhookAttempt) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.log) < webhookLogSize {
+		d.log = append(d.log, entry)
+		return
+	}
+	d.log[d.pos] = entry
+	d.pos = (d.pos + 1) % webhookLogSize
+}
+
+// Report returns the latest attempts (newest first) and queue depths.
+func (d *WebhookDispatcher) Report() map[string]interface{} {
+	d.mu.Lock()
+	attempts := make([]webhookAttempt, 0, len(d.log))
+	for i := len(d.log) - 1; i >= 0; i-- {
+		attempts = append(attempts, d.log[(d.pos+i)%len(d.log)])
+	}
+	d.mu.Unlock()
+
+	var queued, dead int
+	var deadLetters []webhookDelivery
+	d.db.View(func(tx *bolt.Tx) error {
+		queued = tx.Bucket(webhookQueueBucket).Stats().KeyN
+		deadBucket := tx.Bucket(webhookDeadBucket)
+		dead = deadBucket.Stats().KeyN
+
+		// Most recent dead letters, for replaying by hand
+		c := deadBucket.Cursor()
+		for k, v := c.Last(); k != nil && len(deadLetters) < 20; k, v = c.Prev() {
+			var delivery webhookDelivery
+			if json.Unmarshal(v, &delivery) == nil {
+				deadLetters = append(deadLetters, delivery)
+			}
+		}
+		return nil
+	})
+
+	return map[string]interface{}{
+		"endpoints":   d.endpoints,
+		"signed":      len(d.secret) > 0,
+		"queued":      queued,
+		"dead":        dead,
+		"deadLetters": deadLetters,
+		"attempts":    attempts,
+		"thresholds":  thresholds.Stats(),
+	}
+}
+
+func adminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if webhooks == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"enabled": false,
+		})
+		return
+	}
+	response := webhooks.Report()
+	response["success"] = true
+	response["enabled"] = true
+	response["timestamp"] = time.Now().Unix()
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET /widget/top10 serves a small, pre-rendered top 10 for embedding on
+// other sites. The body, its gzipped form and its ETag are rebuilt after
+// each sort but only replaced when the top 10 actually changed, so the ETag
+// is stable between changes and caches and clients revalidate cheaply.
+
+type widgetEntry struct {
+	Rank     int         `json:"rank"`
+	Username string      `json:"username"`
+	Rating   json.Number `json:"rating"`
+}
+
+// widgetTop10 is one rendered version of the widget.
+type widgetTop10 struct {
+	body      []byte
+	gzipped   []byte
+	etag      string
+	updatedAt time.Time
+}
+
+// Widget holds the current render.
+type Widget struct {
+	store  *UserStore
+	maxAge time.Duration
+
+	mu      sync.RWMutex
+	current *widgetTop10
+	entries []widgetEntry // What current was rendered from
+}
+
+var widget *Widget
+
+// topUsers copies the first n users as of the last sort, without sorting.
+func (s *UserStore) topUsers(n int) []User {
+	s.RLock()
+	defer s.RUnlock()
+	if n > len(s.RankedLocked()) {
+		n = len(s.RankedLocked())
+	}
+	users := make([]User, n)
+	for i := range users {
+		users[i] = *s.RankedLocked()[i]
+	}
+	return users
+}
+
+// refresh re-renders the widget if the top 10 differs from the last render.
+func (wd *Widget) refresh() {
+	users := wd.store.topUsers(10)
+	entries := make([]widgetEntry, len(users))
+	for i, u := range users {
+		entries[i] = widgetEntry{Rank: u.Rank, Username: u.Username, Rating: scores.Number(u.Rating)}
+	}
+
+	wd.mu.RLock()
+	unchanged := wd.current != nil && equalWidgetEntries(wd.entries, entries)
+	wd.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	now := time.Now()
+	body, err := json.Marshal(map[string]interface{}{
+		"updatedAt": now.Unix(),
+		"users":     entries,
+	})
+	if err != nil {
+		return
+	}
+	var gz bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	zw.Write(body)
+	zw.Close()
+	sum := sha256.Sum256(body)
+
+	wd.mu.Lock()
+	wd.current = &widgetTop10{
+		body:      body,
+		gzipped:   gz.Bytes(),
+		etag:      `"` + hex.EncodeToString(sum[:8]) + `"`,
+		updatedAt: now,
+	}
+	wd.entries = entries
+	wd.mu.Unlock()
+}
+
+func equalWidgetEntries(a, b []widgetEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (wd *Widget) run(ctx context.Context) error {
+	events := wd.store.events.Subscribe(16)
+	defer wd.store.events.Unsubscribe(events)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if ev.Type == EventSortCompleted {
+				wd.refresh()
+			}
+		}
+	}
+}
+
+// startWidget renders the widget and keeps it current as the "widget" job.
+func startWidget(store *UserStore) {
+	widget = &Widget{store: store, maxAge: envDuration("MATIKS_WIDGET_MAX_AGE", 10*time.Second)}
+	widget.refresh()
+
+	jobs.Register("widget", widget.run)
+	jobs.Run("widget")
+}
+
+// etagMatches reports whether an If-None-Match header lists etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// widgetTop10Handler serves GET /widget/top10.
+func widgetTop10Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	widget.mu.RLock()
+	cur := widget.current
+	widget.mu.RUnlock()
+
+	h := w.Header()
+	h.Set("ETag", cur.etag)
+	h.Set("Last-Modified", cur.updatedAt.UTC().Format(http.TimeFormat))
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d",
+		int(widget.maxAge.Seconds()), int(6*widget.maxAge.Seconds())))
+	h.Set("Vary", "Accept-Encoding")
+
+	if etagMatches(r.Header.Get("If-None-Match"), cur.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body := cur.body
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		body = cur.gzipped
+		h.Set("Content-Encoding", "gzip")
+	}
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /leaderboard?activeWithin=7d ranks only users active (see activity.go)
+// within the window, and ?joinedWithin=7d only users created within it, so
+// newcomers get a board they can top. Either way users are numbered among
+// themselves in the rank mode. A window is a number of days (7d) or a Go
+// duration (36h, 90m), up to 365 days. Users created through
+// POST /admin/users record when they joined; seeded users never count as
+// newcomers.
+//
+// Each window is a filtered board (see filteredboards.go), also rebuilt
+// when its oldest member falls out of the window.
+
+const maxBoardWindow = 365 * 24 * time.Hour
+
+// joins records when users created through the API joined.
+var joins = newUserTimes()
+
+func windowParam(r *http.Request, name string) (time.Duration, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(name))
+	var window time.Duration
+	if strings.HasSuffix(raw, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(raw, "d"))
+		if err != nil {
+			return 0, &paramError{Param: name, Message: fmt.Sprintf("must be a number of days (7d) or a duration (36h), got %q", raw)}
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, &paramError{Param: name, Message: fmt.Sprintf("must be a number of days (7d) or a duration (36h), got %q", raw)}
+		}
+		window = d
+	}
+	if window <= 0 || window > maxBoardWindow {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("must be positive and at most 365d, got %q", raw)}
+	}
+	return window, nil
+}
+
+// windowBoardSet is one filter's boards, by window.
+type windowBoardSet struct {
+	*filteredBoards
+	times *userTimes
+}
+
+func newWindowBoardSet(param string, times *userTimes) *windowBoardSet {
+	return &windowBoardSet{filteredBoards: newFilteredBoards(param, times.Version), times: times}
+}
+
+var (
+	activeBoards   = newWindowBoardSet("activeWithin", activity.userTimes)
+	newcomerBoards = newWindowBoardSet("joinedWithin", joins)
+)
+
+// filterParam reads the set's window parameter.
+func (set *windowBoardSet) filterParam(r *http.Request) (*boardFilter, error) {
+	window, err := windowParam(r, set.param)
+	if err != nil {
+		return nil, err
+	}
+	return &boardFilter{
+		filteredBoards: set.filteredBoards,
+		raw:            r.URL.Query().Get(set.param),
+		key:            window.String(),
+		build: func(s *UserStore, now time.Time) ([]User, time.Time) {
+			return s.windowUsersLocked(set.times, window, now)
+		},
+	}, nil
+}
+
+// windowUsersLocked copies the sorted users whose time is after now minus
+// window, and returns when the first of them leaves it.
+func (s *UserStore) windowUsersLocked(times *userTimes, window time.Duration, now time.Time) ([]User, time.Time) {
+	cutoff := now.Add(-window).UnixNano()
+	expiresAt := now.Add(window)
+	var copies []User
+	times.withAll(func(all map[string]int64) {
+		for _, u := range s.RankedLocked() {
+			at, ok := all[u.ID]
+			if !ok || at < cutoff {
+				continue
+			}
+			copies = append(copies, *u)
+			if leaves := time.Unix(0, at).Add(window); leaves.Before(expiresAt) {
+				expiresAt = leaves
+			}
+		}
+	})
+	return copies, expiresAt
+}
+
+func (set *windowBoardSet) Stats() map[string]interface{} {
+	stats := set.filteredBoards.Stats()
+	stats["windows"] = stats["boards"]
+	delete(stats, "boards")
+	return stats
+}
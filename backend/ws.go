@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// Same policy as corsMiddleware: any origin may read
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPingPeriod = 30 * time.Second
+	wsSendBuffer = 64
+)
+
+// WSHub pushes leaderboard events to connected websocket clients.
+type WSHub struct {
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	sub  wsSubscription
+}
+
+func newWSHub() *WSHub {
+	return &WSHub{clients: make(map[*wsClient]struct{})}
+}
+
+// run forwards local bus events that clients care about.
+func (h *WSHub) run(events <-chan Event) {
+	for ev := range events {
+		switch ev.Type {
+		case EventRanksChanged, EventTop10Changed:
+			h.broadcast(ev, true)
+		case EventRatingChanged:
+			h.broadcast(ev, false) // Only for clients watching the user
+		}
+	}
+}
+
+// broadcast encodes once for unfiltered clients and per client for
+// filtered ones; clients whose buffer is full are disconnected instead of
+// blocking the others.
+func (h *WSHub) broadcast(ev Event, toAll bool) {
+	var shared []byte
+	if toAll {
+		var err error
+		if shared, err = json.Marshal(ev); err != nil {
+			log.Printf("WS: encoding %s event: %v", ev.Type, err)
+			return
+		}
+	}
+
+	h.mu.RLock()
+	var slow []*wsClient
+	for c := range h.clients {
+		msg := shared
+		if f := c.sub.get(); !f.empty() {
+			filtered, ok := f.apply(ev)
+			if !ok {
+				continue
+			}
+			msg, _ = json.Marshal(filtered)
+		}
+		if msg == nil {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		h.remove(c)
+	}
+}
+
+func (h *WSHub) remove(c *wsClient) {
+	h.mu.Lock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+	h.mu.Unlock()
+}
+
+func (h *WSHub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+func (h *WSHub) handler(w http.ResponseWriter, r *http.Request) {
+	filter, err := wsFilterFromQuery(r)
+	if err != nil {
+		writeParamError(w, r, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already wrote the error response
+	}
+
+	c := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer)}
+	c.sub.set(filter)
+
+	// Greet with the first page so the client can render immediately
+	users, total, _, _, err := userStore.GetLeaderboard(r.Context(), 1, 45)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	initial, _ := json.Marshal(Event{
+		Type:      "initial_data",
+		Timestamp: time.Now(),
+		Payload:   map[string]interface{}{"users": users, "total": total},
+	})
+	c.send <- initial
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	go c.writeLoop()
+	c.readLoop(h)
+}
+
+// readLoop applies subscribe messages and detects disconnects.
+func (c *wsClient) readLoop(h *WSHub) {
+	defer func() {
+		h.remove(c)
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(4096)
+	c.conn.SetReadDeadline(time.Now().Add(2 * wsPingPeriod))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(2 * wsPingPeriod))
+		return nil
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.handleMessage(h, data)
+	}
+}
+
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
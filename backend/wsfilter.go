@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Websocket clients may narrow what they receive, either with query
+// parameters on /ws (?maxRank=100&usernames=a,b&country=IN) or at any time
+// by sending
+//
+//	{"type": "subscribe", "filter": {"maxRank": 100, "usernames": ["a", "b"]}}
+//
+// An empty filter receives every broadcast. With a filter, rank changes are
+// trimmed to the ones involving the top maxRank, a watched user or a user
+// whose profile country matches (any criterion matches), and watched
+// users' individual rating changes are sent too.
+
+const wsMaxWatched = 100
+
+// wsFilter is one connection's subscription.
+type wsFilter struct {
+	MaxRank   int      `json:"maxRank,omitempty"`
+	Usernames []string `json:"usernames,omitempty"`
+	Country   string   `json:"country,omitempty"`
+
+	watched map[string]bool // Lowercased Usernames
+}
+
+func (f *wsFilter) empty() bool {
+	return f == nil || (f.MaxRank == 0 && len(f.watched) == 0 && f.Country == "")
+}
+
+// normalize validates the filter and builds the username set.
+func (f *wsFilter) normalize() error {
+	if f.Country != "" {
+		country, err := normalizeProfileField("country", f.Country)
+		if err != nil {
+			return errors.New("country " + err.(*paramError).Message)
+		}
+		f.Country = country
+	}
+	if f.MaxRank < 0 {
+		return fmt.Errorf("maxRank must not be negative, got %d", f.MaxRank)
+	}
+	if len(f.Usernames) > wsMaxWatched {
+		return fmt.Errorf("at most %d usernames may be watched, got %d", wsMaxWatched, len(f.Usernames))
+	}
+	f.watched = make(map[string]bool, len(f.Usernames))
+	for _, name := range f.Usernames {
+		if name = foldUsername(normalizeUsername(name)); name != "" {
+			f.watched[name] = true
+		}
+	}
+	return nil
+}
+
+func (f *wsFilter) watches(username string) bool {
+	return f.watched[foldUsername(username)]
+}
+
+func (f *wsFilter) countryMatches(userID string) bool {
+	return f.Country != "" && profiles.Country(userID) == f.Country
+}
+
+func (f *wsFilter) rankMatches(rank int) bool {
+	return f.MaxRank > 0 && rank > 0 && rank <= f.MaxRank
+}
+
+// apply returns the part of ev this filter wants, or false to skip it.
+func (f *wsFilter) apply(ev Event) (Event, bool) {
+	switch p := ev.Payload.(type) {
+	case RanksChangedPayload:
+		var changes []RankChange
+		for _, c := range p.Changes {
+			if f.rankMatches(c.OldRank) || f.rankMatches(c.NewRank) || f.watches(c.Username) || f.countryMatches(c.UserID) {
+				changes = append(changes, c)
+			}
+		}
+		if len(changes) == 0 {
+			return ev, false
+		}
+		ev.Payload = RanksChangedPayload{Changes: changes}
+		return ev, true
+	case Top10ChangedPayload:
+		if f.MaxRank > 0 {
+			return ev, true
+		}
+		for _, u := range p.Users {
+			if f.watches(u.Username) || f.countryMatches(u.ID) {
+				return ev, true
+			}
+		}
+		return ev, false
+	case RatingChangedPayload:
+		return ev, f.watches(p.Username)
+	}
+	return ev, false
+}
+
+// wsFilterFromQuery reads the initial filter from /ws query parameters.
+func wsFilterFromQuery(r *http.Request) (*wsFilter, error) {
+	q := r.URL.Query()
+	f := &wsFilter{Country: q.Get("country")}
+	if raw := q.Get("maxRank"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, &paramError{Param: "maxRank", Message: fmt.Sprintf("must be an integer, got %q", raw)}
+		}
+		f.MaxRank = v
+	}
+	if raw := q.Get("usernames"); raw != "" {
+		f.Usernames = strings.Split(raw, ",")
+	}
+	if err := f.normalize(); err != nil {
+		return nil, &paramError{Param: "filter", Message: err.Error()}
+	}
+	return f, nil
+}
+
+// wsSubscription guards a client's filter, which its read loop may replace
+// while the hub is broadcasting.
+type wsSubscription struct {
+	mu     sync.RWMutex
+	filter *wsFilter
+}
+
+func (s *wsSubscription) get() *wsFilter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter
+}
+
+func (s *wsSubscription) set(f *wsFilter) {
+	s.mu.Lock()
+	s.filter = f
+	s.mu.Unlock()
+}
+
+// wsClientMessage is what clients may send.
+type wsClientMessage struct {
+	Type   string    `json:"type"`
+	Filter *wsFilter `json:"filter"`
+}
+
+// handleMessage applies a subscribe message and acknowledges it.
+func (c *wsClient) handleMessage(h *WSHub, data []byte) {
+	var msg wsClientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.reply(c, "error", map[string]interface{}{"message": "invalid JSON: " + err.Error()})
+		return
+	}
+	if msg.Type != "subscribe" {
+		h.reply(c, "error", map[string]interface{}{"message": fmt.Sprintf("unknown message type %q", msg.Type)})
+		return
+	}
+
+	filter := msg.Filter
+	if filter == nil {
+		filter = &wsFilter{}
+	}
+	if err := filter.normalize(); err != nil {
+		h.reply(c, "error", map[string]interface{}{"message": err.Error()})
+		return
+	}
+	c.sub.set(filter)
+	h.reply(c, "subscribed", filter)
+}
+
+// reply queues a control message without blocking the read loop. Like
+// broadcast it sends under the hub lock, so it never races remove closing
+// the channel.
+func (h *WSHub) reply(c *wsClient, typ string, payload interface{}) {
+	msg, err := json.Marshal(Event{Type: typ, Timestamp: time.Now(), Payload: payload})
+	if err != nil {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+	}
+}